@@ -0,0 +1,291 @@
+// Package client is a Go client for the gRPC API of a GoVaultFS node.
+// It wraps the generated stubs of the rpc package with plain Go types and io.Reader based uploads and
+// downloads, so programs can use the vault without linking the node itself.
+//
+//	c, err := client.Dial("localhost:7070")
+//	if err != nil { ... }
+//	defer c.Close()
+//	info, err := c.Put(ctx, "reports/q1.pdf", f)
+//
+// Nodes with access control need a capability token on every call: Dial(addr, client.WithToken(token)).
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// chunkSize is the number of content bytes sent per upload message
+const chunkSize = 64 * 1024
+
+// Errors returned by the client, matching the gRPC status of the node's response
+var (
+	ErrNotFound         = errors.New("object not found")
+	ErrUnavailable      = errors.New("vault unavailable")
+	ErrUnauthenticated  = errors.New("missing or invalid token")
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ETag    string // Hex MD5 of the content
+	ModTime time.Time
+}
+
+// EventType identifies what an event reports
+type EventType int
+
+// Event types, as sent by the node
+const (
+	EventStored           EventType = EventType(rpc.Event_TYPE_STORED)
+	EventDeleted          EventType = EventType(rpc.Event_TYPE_DELETED)
+	EventPeerConnected    EventType = EventType(rpc.Event_TYPE_PEER_CONNECTED)
+	EventPeerDisconnected EventType = EventType(rpc.Event_TYPE_PEER_DISCONNECTED)
+)
+
+// Event is a change seen by the node
+type Event struct {
+	Type EventType
+	Key  string // Object events only
+	Size int64  // Stored events only
+	ETag string // Stored events only
+	Peer string // Peer events only, the peer's address
+	Time time.Time
+}
+
+// Client talks to one node over gRPC
+type Client struct {
+	conn *grpc.ClientConn // Nil if the connection is owned by the caller
+	rpc  rpc.GoVaultClient
+}
+
+// Dial connects to a node. Unless the options set transport credentials the connection is unencrypted.
+func Dial(addr string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, rpc: rpc.NewGoVaultClient(conn)}, nil
+}
+
+// New creates a client on a connection the caller manages
+func New(conn grpc.ClientConnInterface) *Client {
+	return &Client{rpc: rpc.NewGoVaultClient(conn)}
+}
+
+// Close closes the connection opened by Dial
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Put stores the content of r under key
+func (c *Client) Put(ctx context.Context, key string, r io.Reader) (ObjectInfo, error) {
+	stream, err := c.rpc.Put(ctx)
+	if err != nil {
+		return ObjectInfo{}, wrap(err)
+	}
+
+	buf := make([]byte, chunkSize)
+	req := &rpc.PutRequest{Key: key}
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || req.Key != "" {
+			req.Chunk = buf[:n]
+			if err := stream.Send(req); err != nil {
+				break // The node's status is returned by CloseAndRecv
+			}
+			req = &rpc.PutRequest{}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			stream.CloseSend()
+			return ObjectInfo{}, err
+		}
+	}
+
+	info, err := stream.CloseAndRecv()
+	if err != nil {
+		return ObjectInfo{}, wrap(err)
+	}
+	return objectInfo(info), nil
+}
+
+// Get downloads an object. The caller must close the returned reader.
+func (c *Client) Get(ctx context.Context, key string) (*Reader, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.rpc.Get(ctx, &rpc.GetRequest{Key: key})
+	if err != nil {
+		cancel()
+		return nil, wrap(err)
+	}
+
+	// The first message carries the object info, or the error if the node could not find the object
+	first, err := stream.Recv()
+	if err != nil {
+		cancel()
+		return nil, wrap(err)
+	}
+
+	return &Reader{Info: objectInfo(first.GetInfo()), stream: stream, cancel: cancel}, nil
+}
+
+// Reader streams the content of a downloaded object
+type Reader struct {
+	Info   ObjectInfo // Size, ETag and ModTime are only set if the node stored the object itself
+	stream grpc.ServerStreamingClient[rpc.GetResponse]
+	cancel context.CancelFunc
+	buf    []byte
+}
+
+// Read reads the content of the object
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		msg, err := r.stream.Recv()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, wrap(err)
+		}
+		r.buf = msg.GetChunk()
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close ends the download
+func (r *Reader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// Stat describes an object
+func (c *Client) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := c.rpc.Stat(ctx, &rpc.StatRequest{Key: key})
+	if err != nil {
+		return ObjectInfo{}, wrap(err)
+	}
+	return objectInfo(info), nil
+}
+
+// List returns up to limit objects whose key starts with prefix and sorts after the key after, in key order.
+// The returned next key is the after of the following page, empty on the last page. A limit of 0 uses the
+// node's default page size.
+func (c *Client) List(ctx context.Context, prefix string, after string, limit int) ([]ObjectInfo, string, error) {
+	resp, err := c.rpc.List(ctx, &rpc.ListRequest{Prefix: prefix, After: after, Limit: int32(limit)})
+	if err != nil {
+		return nil, "", wrap(err)
+	}
+
+	out := make([]ObjectInfo, 0, len(resp.GetObjects()))
+	for _, info := range resp.GetObjects() {
+		out = append(out, objectInfo(info))
+	}
+	return out, resp.GetNext(), nil
+}
+
+// Delete deletes an object and its replicas
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.rpc.Delete(ctx, &rpc.DeleteRequest{Key: key})
+	return wrap(err)
+}
+
+// Watch streams the node's events until ctx is cancelled. Object events are filtered by key prefix,
+// peer events are always sent.
+func (c *Client) Watch(ctx context.Context, prefix string) (*Watcher, error) {
+	stream, err := c.rpc.WatchEvents(ctx, &rpc.WatchRequest{Prefix: prefix})
+	if err != nil {
+		return nil, wrap(err)
+	}
+	return &Watcher{stream: stream}, nil
+}
+
+// Watcher receives the events of a watch
+type Watcher struct {
+	stream grpc.ServerStreamingClient[rpc.Event]
+}
+
+// Next blocks until the next event arrives
+func (w *Watcher) Next() (Event, error) {
+	e, err := w.stream.Recv()
+	if err != nil {
+		return Event{}, wrap(err)
+	}
+	return Event{
+		Type: EventType(e.GetType()),
+		Key:  e.GetKey(),
+		Size: e.GetSize(),
+		ETag: e.GetEtag(),
+		Peer: e.GetPeer(),
+		Time: e.GetTime().AsTime(),
+	}, nil
+}
+
+// objectInfo converts object info from its protobuf form
+func objectInfo(info *rpc.ObjectInfo) ObjectInfo {
+	out := ObjectInfo{
+		Key:  info.GetKey(),
+		Size: info.GetSize(),
+		ETag: info.GetEtag(),
+	}
+	if info.GetModTime() != nil {
+		out.ModTime = info.GetModTime().AsTime()
+	}
+	return out
+}
+
+// tokenCredentials sends a capability token with every call
+type tokenCredentials string
+
+// GetRequestMetadata returns the authorization metadata (credentials.PerRPCCredentials interface).
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity allows tokens on unencrypted connections (credentials.PerRPCCredentials interface).
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// WithToken makes every call of a connection carry a capability token
+func WithToken(token string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(tokenCredentials(token))
+}
+
+// wrap maps gRPC statuses to the client's errors, keeping the node's message
+func wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch status.Code(err) {
+	case codes.NotFound:
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	case codes.Unavailable:
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	case codes.Unauthenticated:
+		return fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	case codes.PermissionDenied:
+		return fmt.Errorf("%w: %w", ErrPermissionDenied, err)
+	}
+	return err
+}
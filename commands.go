@@ -0,0 +1,465 @@
+// Client commands of govault
+// Each command sends one request to the HTTP gateway a running node serves on its admin socket
+// and prints the JSON it gets back, so the output can be piped into tools like jq.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/server"
+)
+
+// adminClient sends requests to a node over its admin socket
+type adminClient struct {
+	socket string
+	http   *http.Client
+}
+
+// newAdminClient creates a client for the admin socket at path
+func newAdminClient(path string) *adminClient {
+	return &adminClient{
+		socket: path,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// do sends a request for path with the given query and returns the response if it succeeded
+func (c *adminClient) do(method string, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	return c.doHeader(method, path, query, nil, body)
+}
+
+// doHeader is do with extra request headers
+func (c *adminClient) doHeader(method string, path string, query url.Values, header http.Header, body io.Reader) (*http.Response, error) {
+	u := url.URL{Scheme: "http", Host: "govault", Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach the node at %s, is `govault serve` running? (%w)", c.socket, err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || len(e.Error) == 0 {
+			e.Error = http.StatusText(resp.StatusCode)
+		}
+		return nil, errors.New(e.Error)
+	}
+	return resp, nil
+}
+
+// getJSON sends a request and decodes its JSON response into v
+func (c *adminClient) getJSON(method string, path string, query url.Values, body io.Reader, v any) error {
+	resp, err := c.do(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// printJSON writes v to w as indented JSON
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// clientFlags parses the flags shared by all client commands and returns the client and positional arguments.
+// setup adds command specific flags.
+func clientFlags(name string, args []string, min int, max int, setup func(*flag.FlagSet)) (*adminClient, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	socket := fs.String("socket", defaultSocket(), "path of the admin socket of the node")
+	if setup != nil {
+		setup(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() < min || fs.NArg() > max {
+		return nil, nil, fmt.Errorf("%s: wrong number of arguments\n\n%s", name, usage)
+	}
+	return newAdminClient(*socket), fs.Args(), nil
+}
+
+// runPut stores a file, or standard input, and prints the object info.
+// -if-match and -if-none-match make it a conditional write.
+func runPut(args []string, stdin io.Reader, stdout io.Writer) error {
+	var ifMatch string
+	var ifNoneMatch bool
+	c, args, err := clientFlags("put", args, 1, 2, func(fs *flag.FlagSet) {
+		fs.StringVar(&ifMatch, "if-match", "", "store only if the latest version has this ETag or version ID")
+		fs.BoolVar(&ifNoneMatch, "if-none-match", false, "store only if the key does not exist")
+	})
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if len(ifMatch) > 0 {
+		header.Set("If-Match", ifMatch)
+	}
+	if ifNoneMatch {
+		header.Set("If-None-Match", "*")
+	}
+
+	r := stdin
+	if len(args) == 2 && args[1] != "-" {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	resp, err := c.doHeader(http.MethodPut, "/objects/"+args[0], nil, header, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var info server.ObjectInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return err
+	}
+	return printJSON(stdout, info)
+}
+
+// runGet writes an object to a file, or standard output
+func runGet(args []string, _ io.Reader, stdout io.Writer) error {
+	var version string
+	c, args, err := clientFlags("get", args, 1, 2, func(fs *flag.FlagSet) {
+		fs.StringVar(&version, "version", "", "ID of the version to get, the latest if empty")
+	})
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if len(version) > 0 {
+		query.Set("version", version)
+	}
+	resp, err := c.do(http.MethodGet, "/objects/"+args[0], query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if len(args) == 1 || args[1] == "-" {
+		_, err = io.Copy(stdout, resp.Body)
+		return err
+	}
+
+	f, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runRm deletes an object
+func runRm(args []string, _ io.Reader, _ io.Writer) error {
+	var recursive bool
+	c, args, err := clientFlags("rm", args, 1, 1, func(fs *flag.FlagSet) {
+		fs.BoolVar(&recursive, "r", false, "delete a directory with everything in it")
+	})
+	if err != nil {
+		return err
+	}
+
+	path := "/objects/" + args[0]
+	if recursive {
+		path = "/dirs/" + args[0]
+	}
+	resp, err := c.do(http.MethodDelete, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// runMkdir creates a directory
+func runMkdir(args []string, _ io.Reader, stdout io.Writer) error {
+	c, args, err := clientFlags("mkdir", args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+
+	var dir server.DirEntry
+	if err := c.getJSON(http.MethodPut, "/dirs/"+args[0], nil, nil, &dir); err != nil {
+		return err
+	}
+	return printJSON(stdout, dir)
+}
+
+// runMv renames a file or directory
+func runMv(args []string, _ io.Reader, stdout io.Writer) error {
+	c, args, err := clientFlags("mv", args, 2, 2, nil)
+	if err != nil {
+		return err
+	}
+
+	var e server.DirEntry
+	if err := c.getJSON(http.MethodPost, "/move/"+args[0], url.Values{"to": {args[1]}}, nil, &e); err != nil {
+		return err
+	}
+	return printJSON(stdout, e)
+}
+
+// runDir prints the entries of a directory, the root if none is given
+func runDir(args []string, _ io.Reader, stdout io.Writer) error {
+	c, args, err := clientFlags("dir", args, 0, 1, nil)
+	if err != nil {
+		return err
+	}
+
+	path := "/dirs/"
+	if len(args) == 1 {
+		path += args[0]
+	}
+	var dir struct {
+		Entries []server.DirEntry `json:"entries"`
+	}
+	if err := c.getJSON(http.MethodGet, path, nil, nil, &dir); err != nil {
+		return err
+	}
+	return printJSON(stdout, dir.Entries)
+}
+
+// runLs prints the objects under a prefix, following pages until the limit is reached
+func runLs(args []string, _ io.Reader, stdout io.Writer) error {
+	var limit int
+	var after string
+	c, args, err := clientFlags("ls", args, 0, 1, func(fs *flag.FlagSet) {
+		fs.IntVar(&limit, "limit", 0, "maximum number of objects, 0 for all")
+		fs.StringVar(&after, "after", "", "list only keys that sort after this one")
+	})
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if len(args) == 1 {
+		query.Set("prefix", args[0])
+	}
+
+	objects := []server.ObjectInfo{}
+	for {
+		query.Set("after", after)
+		if limit > 0 {
+			query.Set("limit", strconv.Itoa(limit-len(objects)))
+		}
+
+		var page struct {
+			Objects []server.ObjectInfo `json:"objects"`
+			Next    string              `json:"next"`
+		}
+		if err := c.getJSON(http.MethodGet, "/objects", query, nil, &page); err != nil {
+			return err
+		}
+		objects = append(objects, page.Objects...)
+
+		if len(page.Next) == 0 || (limit > 0 && len(objects) >= limit) {
+			break
+		}
+		after = page.Next
+	}
+
+	return printJSON(stdout, objects)
+}
+
+// runStat prints the metadata of an object
+func runStat(args []string, _ io.Reader, stdout io.Writer) error {
+	c, args, err := clientFlags("stat", args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+
+	// The gateway answers HEAD with headers only, so ask for the single listing entry with this key
+	var page struct {
+		Objects []server.ObjectInfo `json:"objects"`
+	}
+	query := url.Values{"prefix": {args[0]}, "limit": {"1"}}
+	if err := c.getJSON(http.MethodGet, "/objects", query, nil, &page); err != nil {
+		return err
+	}
+	if len(page.Objects) == 0 || page.Objects[0].Key != args[0] {
+		return fmt.Errorf("%s: %w", args[0], server.ErrNotFound)
+	}
+	return printJSON(stdout, page.Objects[0])
+}
+
+// runVersions prints the versions kept of an object, the latest first
+func runVersions(args []string, _ io.Reader, stdout io.Writer) error {
+	c, args, err := clientFlags("versions", args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Versions []server.ObjectInfo `json:"versions"`
+	}
+	if err := c.getJSON(http.MethodGet, "/versions/"+args[0], nil, nil, &resp); err != nil {
+		return err
+	}
+	return printJSON(stdout, resp.Versions)
+}
+
+// runRestore makes a version of an object the latest again and prints the new object info
+func runRestore(args []string, _ io.Reader, stdout io.Writer) error {
+	c, args, err := clientFlags("restore", args, 2, 2, nil)
+	if err != nil {
+		return err
+	}
+
+	var info server.ObjectInfo
+	if err := c.getJSON(http.MethodPost, "/versions/"+args[0], url.Values{"version": {args[1]}}, nil, &info); err != nil {
+		return err
+	}
+	return printJSON(stdout, info)
+}
+
+// runPin keeps a copy of an object on the node, or prints the pinned keys if no key is given
+func runPin(args []string, _ io.Reader, stdout io.Writer) error {
+	c, args, err := clientFlags("pin", args, 0, 1, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		var pins struct {
+			Pins []string `json:"pins"`
+		}
+		if err := c.getJSON(http.MethodGet, "/pins", nil, nil, &pins); err != nil {
+			return err
+		}
+		for _, key := range pins.Pins {
+			fmt.Fprintln(stdout, key)
+		}
+		return nil
+	}
+
+	resp, err := c.do(http.MethodPut, "/pins/"+args[0], nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// runUnpin lets the cached copy of an object be evicted
+func runUnpin(args []string, _ io.Reader, _ io.Writer) error {
+	c, args, err := clientFlags("unpin", args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(http.MethodDelete, "/pins/"+args[0], nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// runPeers prints the cluster members known to the node
+func runPeers(args []string, _ io.Reader, stdout io.Writer) error {
+	c, _, err := clientFlags("peers", args, 0, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	var members []json.RawMessage
+	if err := c.getJSON(http.MethodGet, "/peers", nil, nil, &members); err != nil {
+		return err
+	}
+	return printJSON(stdout, members)
+}
+
+// runStatus prints the state of the node
+func runStatus(args []string, _ io.Reader, stdout io.Writer) error {
+	c, _, err := clientFlags("status", args, 0, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	var st server.NodeStatus
+	if err := c.getJSON(http.MethodGet, "/status", nil, nil, &st); err != nil {
+		return err
+	}
+	return printJSON(stdout, st)
+}
+
+// runToken issues a capability token for a subject and prints it with its capability
+func runToken(args []string, _ io.Reader, stdout io.Writer) error {
+	var ops, tenant, prefix string
+	var ttl time.Duration
+	c, args, err := clientFlags("token", args, 1, 1, func(fs *flag.FlagSet) {
+		fs.StringVar(&ops, "ops", "read", "comma separated operations allowed: read, write, delete or admin")
+		fs.StringVar(&tenant, "tenant", "", "tenant whose keys the token covers, empty for the node's own keys")
+		fs.StringVar(&prefix, "prefix", "", "prefix of the keys the token covers, empty for every key")
+		fs.DurationVar(&ttl, "ttl", 24*time.Hour, "how long the token is accepted")
+	})
+	if err != nil {
+		return err
+	}
+
+	req := map[string]any{"subject": args[0], "ops": strings.Split(ops, ","), "tenant": tenant, "prefix": prefix, "ttl": ttl.String()}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	var resp json.RawMessage
+	if err := c.getJSON(http.MethodPost, "/tokens", nil, strings.NewReader(string(body)), &resp); err != nil {
+		return err
+	}
+	return printJSON(stdout, resp)
+}
+
+// runRevoke revokes a token, given whole or by ID. A whole token is only kept revoked until it expires.
+func runRevoke(args []string, _ io.Reader, _ io.Writer) error {
+	c, args, err := clientFlags("revoke", args, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+
+	id, query := args[0], url.Values{}
+	if capability, err := server.ParseToken(args[0]); err == nil {
+		id = capability.ID
+		query.Set("until", capability.Expires.Format(time.RFC3339Nano))
+	}
+	resp, err := c.do(http.MethodDelete, "/tokens/"+url.PathEscape(id), query, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
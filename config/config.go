@@ -0,0 +1,832 @@
+// Package config loads the configuration of a GoVaultFS node.
+// A node is described by a YAML, TOML or JSON file whose keys are the json tags of Config, with environment
+// variables overriding single settings on top. Every error names the field it is about, e.g. "api.s3.listen".
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/AnshSinghSonkhia/GoVaultFS/server"
+	"github.com/AnshSinghSonkhia/GoVaultFS/store"
+	"github.com/AnshSinghSonkhia/GoVaultFS/trace"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Errors returned while loading a configuration
+var (
+	ErrUnknownFormat = errors.New("unknown config format, want .yaml, .yml, .toml or .json")
+	ErrUnknownField  = errors.New("unknown field")
+	ErrInvalid       = errors.New("invalid value")
+)
+
+// FieldError is an error about a single setting
+type FieldError struct {
+	Field string // Dotted path of the setting, or the environment variable it came from
+	Err   error
+}
+
+// Error implements the error interface
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError creates a FieldError for the setting at path
+func fieldError(path string, format string, args ...any) error {
+	return &FieldError{Field: path, Err: fmt.Errorf(format, args...)}
+}
+
+// Duration is a time.Duration written as a string such as "500ms" or "5s"
+type Duration time.Duration
+
+// UnmarshalText parses a duration string (encoding.TextUnmarshaler interface)
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText formats the duration as a string (encoding.TextMarshaler interface)
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// ByteRate is a bandwidth in bytes per second written as a string such as "512KiB", "10MB" or "1GiB/s".
+// Units are B, KB, MB and GB in powers of 1000 and KiB, MiB and GiB in powers of 1024; 0 or empty means no limit.
+type ByteRate float64
+
+// byteUnits are the multipliers of the units a ByteRate may use, longest suffixes first
+var byteUnits = []struct {
+	suffix string
+	n      float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"B", 1},
+}
+
+// parseBytes parses a number of bytes with an optional unit, empty meaning 0
+func parseBytes(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return 0, true
+	}
+	mult := 1.0
+	for _, u := range byteUnits {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, mult = strings.TrimSpace(num), u.n
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, false
+	}
+	return v * mult, true
+}
+
+// UnmarshalText parses a byte rate string (encoding.TextUnmarshaler interface)
+func (r *ByteRate) UnmarshalText(b []byte) error {
+	v, ok := parseBytes(strings.TrimSuffix(strings.TrimSpace(string(b)), "/s"))
+	if !ok {
+		return fmt.Errorf("want a byte rate such as 10MiB, got %q", b)
+	}
+	*r = ByteRate(v)
+	return nil
+}
+
+// MarshalText formats the rate in bytes per second (encoding.TextMarshaler interface)
+func (r ByteRate) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatFloat(float64(r), 'f', -1, 64) + "B"), nil
+}
+
+// ByteSize is an amount of storage written as a string such as "500MB" or "2GiB", with the units of ByteRate.
+// 0 or empty means no limit.
+type ByteSize int64
+
+// UnmarshalText parses a size string (encoding.TextUnmarshaler interface)
+func (n *ByteSize) UnmarshalText(b []byte) error {
+	v, ok := parseBytes(string(b))
+	if !ok {
+		return fmt.Errorf("want a size such as 10GiB, got %q", b)
+	}
+	*n = ByteSize(v)
+	return nil
+}
+
+// MarshalText formats the size in bytes (encoding.TextMarshaler interface)
+func (n ByteSize) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatInt(int64(n), 10) + "B"), nil
+}
+
+// Config is the configuration of a node
+type Config struct {
+	Node        NodeConfig              `json:"node"`
+	Transport   TransportConfig         `json:"transport"`
+	Storage     StorageConfig           `json:"storage"`
+	Crypto      CryptoConfig            `json:"crypto"`
+	Replication ReplicationConfig       `json:"replication"`
+	Gossip      GossipConfig            `json:"gossip"`
+	API         APIConfig               `json:"api"`
+	Log         LogConfig               `json:"log"`
+	Tracing     TracingConfig           `json:"tracing"`
+	Bandwidth   BandwidthConfig         `json:"bandwidth"`
+	Cache       CacheConfig             `json:"cache"`
+	Versions    VersionsConfig          `json:"versions"`
+	Tenants     map[string]TenantConfig `json:"tenants"`
+	Auth        AuthConfig              `json:"auth"`
+}
+
+// NodeConfig identifies the node
+type NodeConfig struct {
+	ID string `json:"id"` // Node ID, empty to keep the one stored with the node
+}
+
+// TransportConfig configures peer connections
+type TransportConfig struct {
+	Listen    string   `json:"listen"`    // TCP address for peer connections
+	Bootstrap []string `json:"bootstrap"` // Addresses of nodes to join (reloadable)
+	Discovery bool     `json:"discovery"` // Find nodes on the local network
+}
+
+// StorageConfig configures local storage
+type StorageConfig struct {
+	Root        string              `json:"root"`         // Storage directory, derived from the listen address if empty
+	Capacity    ByteSize            `json:"capacity"`     // Most bytes stored for this node and its peers (reloadable)
+	OwnerQuota  ByteSize            `json:"owner_quota"`  // Most bytes stored for each owner node (reloadable)
+	OwnerQuotas map[string]ByteSize `json:"owner_quotas"` // Quotas of specific owners by node ID (reloadable)
+}
+
+// CryptoConfig configures file encryption
+type CryptoConfig struct {
+	Key     string `json:"key"`      // Hex encoded AES key, empty to keep the one stored with the node
+	KeyFile string `json:"key_file"` // File holding the hex encoded key, instead of key
+}
+
+// PolicyConfig is an erasure coding policy
+type PolicyConfig struct {
+	DataShards   int `json:"data_shards"`   // Shards a file is split into, 0 uses full replication
+	ParityShards int `json:"parity_shards"` // Extra shards that can be lost
+}
+
+// ReplicationConfig configures how files are protected, all of it reloadable
+type ReplicationConfig struct {
+	Factor     int                     `json:"factor"`     // Copies of each file including the local one, 0 for every peer
+	Erasure    PolicyConfig            `json:"erasure"`    // Default erasure coding policy
+	Namespaces map[string]PolicyConfig `json:"namespaces"` // Erasure coding policy per key prefix
+}
+
+// GossipConfig configures the membership protocol
+type GossipConfig struct {
+	ProbeInterval    Duration `json:"probe_interval"`
+	ProbeTimeout     Duration `json:"probe_timeout"`
+	SuspicionTimeout Duration `json:"suspicion_timeout"`
+	IndirectProbes   int      `json:"indirect_probes"`
+}
+
+// S3Config configures the S3-compatible API
+type S3Config struct {
+	Listen     string            `json:"listen"`
+	Region     string            `json:"region"`
+	AccessKeys map[string]string `json:"access_keys"` // Secret key by access key ID (reloadable)
+}
+
+// APIConfig configures the APIs the node serves; an empty address disables an API
+type APIConfig struct {
+	HTTP    string   `json:"http"`
+	S3      S3Config `json:"s3"`
+	WebDAV  string   `json:"webdav"`
+	GRPC    string   `json:"grpc"`
+	Admin   string   `json:"admin"`   // Path of the admin socket
+	Metrics string   `json:"metrics"` // Address serving only /metrics
+}
+
+// LogConfig configures the log of the node, written to standard error
+type LogConfig struct {
+	Level  string `json:"level"`  // debug, info, warn or error (reloadable), info if empty
+	Format string `json:"format"` // text or json, text if empty
+}
+
+// TracingConfig configures tracing of file operations across the cluster
+type TracingConfig struct {
+	File    string `json:"file"`    // File that spans are appended to as JSON lines, empty disables tracing
+	Service string `json:"service"` // Service name of the spans, govault if empty
+}
+
+// BandwidthConfig bounds the bandwidth of the node; a rate of 0 or an empty one means no limit
+type BandwidthConfig struct {
+	In         ByteRate `json:"in"`         // Received from all peers together
+	Out        ByteRate `json:"out"`        // Sent to all peers together
+	PeerIn     ByteRate `json:"peer_in"`    // Received from each peer
+	PeerOut    ByteRate `json:"peer_out"`   // Sent to each peer
+	Foreground ByteRate `json:"foreground"` // Peer transfers for client requests (reloadable)
+	Background ByteRate `json:"background"` // Replication traffic (reloadable)
+	DiskRead   ByteRate `json:"disk_read"`  // Reads from local storage (reloadable)
+	DiskWrite  ByteRate `json:"disk_write"` // Writes to local storage (reloadable)
+}
+
+// CacheConfig bounds the cache of files fetched from peers, all of it reloadable
+type CacheConfig struct {
+	Size     ByteSize `json:"size"`     // Most bytes cached, empty or 0 for no limit
+	Eviction string   `json:"eviction"` // lru or lfu, lru if empty
+}
+
+// VersionsConfig sets how many older versions of each object are kept and how concurrent writes are resolved
+type VersionsConfig struct {
+	Keep      int      `json:"keep"`      // Versions kept per key including the latest, 10 if 0, -1 for every version, 1 for no history (reloadable)
+	MaxAge    Duration `json:"max_age"`   // Older versions are dropped once this old, 0 or empty to keep them (reloadable)
+	Conflicts string   `json:"conflicts"` // lww or keep-both, lww if empty
+}
+
+// TenantConfig configures a tenant, a namespace isolated from the node's own objects. Tenants only come from files,
+// keyed by tenant name, and every node of a cluster should list the same tenants.
+type TenantConfig struct {
+	Key        string            `json:"key"`         // Hex encoded AES key of the tenant's replicas, the node's key if empty
+	Quota      ByteSize          `json:"quota"`       // Most bytes stored for the tenant on each node, empty for no limit
+	Factor     int               `json:"factor"`      // Copies of each file including the local one, the node's factor if 0
+	Erasure    PolicyConfig      `json:"erasure"`     // Erasure coding policy of every key, the node's policies if unset
+	AccessKeys map[string]string `json:"access_keys"` // Secret key by access key ID, for the S3 API
+}
+
+// AuthConfig configures access control with capability tokens
+type AuthConfig struct {
+	Enabled bool              `json:"enabled"`  // Require a token from every client and peer
+	Key     string            `json:"key"`      // Hex encoded Ed25519 seed signing this node's tokens, empty to keep the one stored with the node
+	KeyFile string            `json:"key_file"` // File holding the hex encoded seed, instead of key
+	Issuers map[string]string `json:"issuers"`  // Hex encoded public keys of the other nodes whose tokens are accepted, by node ID
+	Token   string            `json:"token"`    // Token presented to peers, empty to issue one to itself
+}
+
+// Default returns the configuration of a node on :3000 with no APIs
+func Default() Config {
+	return Config{Transport: TransportConfig{Listen: ":3000"}}
+}
+
+// reloadable are the settings a running node picks up on reload, see server.Settings
+var reloadable = []string{
+	"transport.bootstrap", "replication.factor", "replication.erasure", "replication.namespaces", "api.s3.access_keys", "log.level",
+	"bandwidth.foreground", "bandwidth.background", "bandwidth.disk_read", "bandwidth.disk_write",
+	"storage.capacity", "storage.owner_quota", "storage.owner_quotas", "cache",
+	"versions.keep", "versions.max_age",
+}
+
+// Load reads the file at path over base, then applies environment overrides and then overrides,
+// which map setting paths to values as command line flags give them. The result is validated.
+// An empty path skips the file.
+func Load(path string, base Config, overrides map[string]string) (Config, error) {
+	cfg := base
+	if len(path) > 0 {
+		if err := decodeFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+	if err := ApplyEnv(&cfg, os.Environ()); err != nil {
+		return cfg, err
+	}
+	for _, p := range slices.Sorted(maps.Keys(overrides)) {
+		if err := cfg.Set(p, overrides[p]); err != nil {
+			return cfg, err
+		}
+	}
+	cfg.derive()
+	return cfg, cfg.Validate()
+}
+
+// Set changes the setting at path, parsing value like an environment override
+func (c *Config) Set(path string, value string) error {
+	var err error
+	found := false
+	walkEnv(reflect.ValueOf(c).Elem(), "", func(v reflect.Value, p string) {
+		if p == path {
+			found = true
+			err = setString(v, value, path)
+		}
+	})
+	if !found {
+		return &FieldError{Field: path, Err: ErrUnknownField}
+	}
+	return err
+}
+
+// RestartRequired returns the settings that differ between old and new and only take effect after a restart
+func RestartRequired(old Config, new Config) []string {
+	changed := []string{}
+	o, n := reflect.ValueOf(old), reflect.ValueOf(new)
+	var walk func(o, n reflect.Value, path string)
+	walk = func(o, n reflect.Value, path string) {
+		if slices.Contains(reloadable, path) {
+			return
+		}
+		if o.Kind() == reflect.Struct {
+			for i := 0; i < o.NumField(); i++ {
+				sub := join(path, tagName(o.Type().Field(i)))
+				walk(o.Field(i), n.Field(i), sub)
+			}
+			return
+		}
+		if !reflect.DeepEqual(o.Interface(), n.Interface()) {
+			changed = append(changed, path)
+		}
+	}
+	walk(o, n, "")
+	return changed
+}
+
+// derive fills in settings computed from others
+func (c *Config) derive() {
+	// Windows compatibility: replace ':' in port with 'port' for valid directory names
+	if len(c.Storage.Root) == 0 {
+		c.Storage.Root = strings.ReplaceAll(c.Transport.Listen, ":", "port") + "_network"
+	}
+}
+
+// EncKey returns the configured encryption key, or nil if the node should keep its stored key
+func (c Config) EncKey() ([]byte, error) {
+	field, key := "crypto.key", c.Crypto.Key
+	if len(c.Crypto.KeyFile) > 0 {
+		b, err := os.ReadFile(c.Crypto.KeyFile)
+		if err != nil {
+			return nil, fieldError("crypto.key_file", "%w", err)
+		}
+		field, key = "crypto.key_file", strings.TrimSpace(string(b))
+	}
+	return parseKey(field, key)
+}
+
+// parseKey decodes the hex encoded AES key of the setting at field, nil if it is empty
+func parseKey(field string, key string) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	b, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, fieldError(field, "%w: not hex encoded", ErrInvalid)
+	}
+	if n := len(b); n != 16 && n != 24 && n != 32 {
+		return nil, fieldError(field, "%w: AES keys are 16, 24 or 32 bytes, got %d", ErrInvalid, n)
+	}
+	return b, nil
+}
+
+// SigningKey returns the configured token signing key, or nil if the node should keep its stored key
+func (c Config) SigningKey() (ed25519.PrivateKey, error) {
+	field, key := "auth.key", c.Auth.Key
+	if len(c.Auth.KeyFile) > 0 {
+		b, err := os.ReadFile(c.Auth.KeyFile)
+		if err != nil {
+			return nil, fieldError("auth.key_file", "%w", err)
+		}
+		field, key = "auth.key_file", strings.TrimSpace(string(b))
+	}
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	b, err := hex.DecodeString(key)
+	if err != nil || len(b) != ed25519.SeedSize {
+		return nil, fieldError(field, "%w: want a hex encoded %d byte Ed25519 seed", ErrInvalid, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(b), nil
+}
+
+// issuers returns the public keys of the trusted issuers by node ID
+func (c Config) issuers() (map[string]ed25519.PublicKey, error) {
+	issuers := make(map[string]ed25519.PublicKey)
+	for _, id := range slices.Sorted(maps.Keys(c.Auth.Issuers)) {
+		b, err := hex.DecodeString(c.Auth.Issuers[id])
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, fieldError("auth.issuers."+id, "%w: want a hex encoded %d byte Ed25519 public key", ErrInvalid, ed25519.PublicKeySize)
+		}
+		issuers[id] = ed25519.PublicKey(b)
+	}
+	return issuers, nil
+}
+
+// Settings returns the runtime settings of a node with this configuration
+func (c Config) Settings() server.Settings {
+	st := server.Settings{
+		BootstrapNodes:    slices.Clone(c.Transport.Bootstrap),
+		ReplicationFactor: c.Replication.Factor,
+		Policy:            c.Replication.Erasure.policy(),
+	}
+	if len(c.Replication.Namespaces) > 0 {
+		st.NamespacePolicies = make(map[string]server.StoragePolicy)
+		for prefix, p := range c.Replication.Namespaces {
+			st.NamespacePolicies[prefix] = p.policy()
+		}
+	}
+	if len(c.API.S3.Listen) > 0 {
+		st.S3AccessKeys = c.API.S3.AccessKeys
+	}
+	st.Bandwidth = server.BandwidthOpts{
+		Foreground: float64(c.Bandwidth.Foreground),
+		Background: float64(c.Bandwidth.Background),
+		DiskRead:   float64(c.Bandwidth.DiskRead),
+		DiskWrite:  float64(c.Bandwidth.DiskWrite),
+	}
+	st.Cache = server.CacheOpts{Size: int64(c.Cache.Size), Eviction: server.CacheEviction(c.Cache.Eviction)}
+	st.Versions = server.VersionOpts{Keep: c.Versions.Keep, MaxAge: time.Duration(c.Versions.MaxAge)}
+	st.Quota = server.QuotaOpts{Capacity: int64(c.Storage.Capacity), PerOwner: int64(c.Storage.OwnerQuota)}
+	if len(c.Storage.OwnerQuotas) > 0 {
+		st.Quota.Owners = make(map[string]int64)
+		for id, n := range c.Storage.OwnerQuotas {
+			st.Quota.Owners[id] = int64(n)
+		}
+	}
+	return st
+}
+
+// tenants returns the tenants in their server form, by name
+func (c Config) tenants() []server.Tenant {
+	tenants := []server.Tenant{}
+	for _, name := range slices.Sorted(maps.Keys(c.Tenants)) {
+		tc := c.Tenants[name]
+		key, _ := parseKey("tenants."+name+".key", tc.Key) // Checked by Validate
+		t := server.Tenant{
+			Name:              name,
+			EncKey:            key,
+			Quota:             int64(tc.Quota),
+			ReplicationFactor: tc.Factor,
+			AccessKeys:        maps.Clone(tc.AccessKeys),
+		}
+		if tc.Erasure.DataShards > 0 {
+			policy := tc.Erasure.policy()
+			t.Policy = &policy
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants
+}
+
+// resolver returns the server's conflict resolver for the configured name
+func (v VersionsConfig) resolver() server.ConflictResolver {
+	if v.Conflicts == "keep-both" {
+		return server.KeepSiblings
+	}
+	return server.LastWriterWins
+}
+
+// policy converts the policy to its server form
+func (p PolicyConfig) policy() server.StoragePolicy {
+	return server.StoragePolicy{DataShards: p.DataShards, ParityShards: p.ParityShards}
+}
+
+// NewTransport creates the TCP transport of a node with this configuration
+func (c Config) NewTransport() *p2p.TCPTransport {
+	return p2p.NewTCPTransport(p2p.TCPTransportOpts{
+		ListenAddr:    c.Transport.Listen,
+		HandshakeFunc: p2p.NOPHandshakeFunc,
+		Decoder:       p2p.DefaultDecoder{},
+		RateLimits: p2p.RateLimits{
+			In:      float64(c.Bandwidth.In),
+			Out:     float64(c.Bandwidth.Out),
+			PeerIn:  float64(c.Bandwidth.PeerIn),
+			PeerOut: float64(c.Bandwidth.PeerOut),
+		},
+	})
+}
+
+// FileServerOpts returns the options of a node with this configuration on the given transport.
+// id and encKey are the node's identity, which the caller keeps across restarts.
+func (c Config) FileServerOpts(tr p2p.Transport, id string, encKey []byte) server.FileServerOpts {
+	st := c.Settings()
+	opts := server.FileServerOpts{
+		ID:                id,
+		EncKey:            encKey,
+		StorageRoot:       c.Storage.Root,
+		PathTransformFunc: store.CASPathTransformFunc,
+		Transport:         tr,
+		BootstrapNodes:    st.BootstrapNodes,
+		ReplicationFactor: st.ReplicationFactor,
+		Policy:            st.Policy,
+		NamespacePolicies: st.NamespacePolicies,
+		Bandwidth:         st.Bandwidth,
+		Quota:             st.Quota,
+		Cache:             st.Cache,
+		Versions:          st.Versions,
+		Resolver:          c.Versions.resolver(),
+		Tenants:           c.tenants(),
+		Gossip: server.GossipOpts{
+			ProbeInterval:    time.Duration(c.Gossip.ProbeInterval),
+			ProbeTimeout:     time.Duration(c.Gossip.ProbeTimeout),
+			SuspicionTimeout: time.Duration(c.Gossip.SuspicionTimeout),
+			IndirectProbes:   c.Gossip.IndirectProbes,
+		},
+		HTTPAddr:    c.API.HTTP,
+		WebDAVAddr:  c.API.WebDAV,
+		GRPCAddr:    c.API.GRPC,
+		AdminSocket: c.API.Admin,
+		MetricsAddr: c.API.Metrics,
+	}
+	if c.Transport.Discovery {
+		opts.Discovery = &p2p.DiscoveryOpts{}
+	}
+	if len(c.API.S3.Listen) > 0 {
+		opts.S3 = &server.S3Opts{ListenAddr: c.API.S3.Listen, Region: c.API.S3.Region, AccessKeys: st.S3AccessKeys}
+	}
+	if c.Auth.Enabled {
+		// Both checked by Validate; without a configured key the caller sets the node's stored one
+		key, _ := c.SigningKey()
+		issuers, _ := c.issuers()
+		opts.Auth = &server.AuthOpts{SigningKey: key, Issuers: issuers, Token: c.Auth.Token}
+	}
+	return opts
+}
+
+// LogLevel returns the least severe level that is logged
+func (c Config) LogLevel() slog.Level {
+	var level slog.Level
+	if len(c.Log.Level) > 0 {
+		level.UnmarshalText([]byte(c.Log.Level)) // Checked by Validate
+	}
+	return level
+}
+
+// NewLogger creates the logger of a node with this configuration writing to w.
+// level filters records, so that a slog.LevelVar can change it while the node runs.
+func (c Config) NewLogger(w io.Writer, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if c.Log.Format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// decodeFile reads a config file over cfg, picking the format from the file extension
+func decodeFile(path string, cfg *Config) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	default:
+		return fmt.Errorf("%s: %w", path, ErrUnknownFormat)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := assign(reflect.ValueOf(cfg).Elem(), raw, ""); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// textUnmarshalerType is used to find fields that parse themselves from strings
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+// assign stores decoded data in v, which is the setting at path.
+// data is what the YAML, TOML and JSON decoders produce for an untyped value.
+func assign(v reflect.Value, data any, path string) error {
+	if data == nil {
+		return nil // Keep the current value
+	}
+
+	if reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		s, ok := data.(string)
+		if !ok {
+			return fieldError(path, "%w: want a string, got %v", ErrInvalid, data)
+		}
+		return setString(v, s, path)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		m, ok := data.(map[string]any)
+		if !ok {
+			return fieldError(path, "%w: want a table, got %v", ErrInvalid, data)
+		}
+		var errs []error
+		for _, k := range sortedKeys(m) {
+			sub := join(path, k)
+			f, ok := fieldByTag(v, k)
+			if !ok {
+				errs = append(errs, &FieldError{Field: sub, Err: ErrUnknownField})
+				continue
+			}
+			if err := assign(f, m[k], sub); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+
+	case reflect.Map:
+		m, ok := data.(map[string]any)
+		if !ok {
+			return fieldError(path, "%w: want a table, got %v", ErrInvalid, data)
+		}
+		out := reflect.MakeMapWithSize(v.Type(), len(m))
+		var errs []error
+		for _, k := range sortedKeys(m) {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := assign(elem, m[k], join(path, k)); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			out.SetMapIndex(reflect.ValueOf(k), elem)
+		}
+		v.Set(out)
+		return errors.Join(errs...)
+
+	case reflect.Slice:
+		items, ok := data.([]any)
+		if !ok {
+			return fieldError(path, "%w: want a list, got %v", ErrInvalid, data)
+		}
+		out := reflect.MakeSlice(v.Type(), len(items), len(items))
+		var errs []error
+		for i, item := range items {
+			if err := assign(out.Index(i), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		v.Set(out)
+		return errors.Join(errs...)
+
+	case reflect.String:
+		s, ok := data.(string)
+		if !ok {
+			return fieldError(path, "%w: want a string, got %v", ErrInvalid, data)
+		}
+		v.SetString(s)
+
+	case reflect.Bool:
+		b, ok := data.(bool)
+		if !ok {
+			return fieldError(path, "%w: want true or false, got %v", ErrInvalid, data)
+		}
+		v.SetBool(b)
+
+	case reflect.Int:
+		n, ok := toInt(data)
+		if !ok {
+			return fieldError(path, "%w: want an integer, got %v", ErrInvalid, data)
+		}
+		v.SetInt(n)
+
+	default:
+		return fieldError(path, "%w: unsupported setting type %s", ErrInvalid, v.Type())
+	}
+	return nil
+}
+
+// setString parses s into v, which is the setting at path.
+// Lists are comma separated and maps are comma separated key=value pairs.
+func setString(v reflect.Value, s string, path string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(s)); err != nil {
+			return fieldError(path, "%w: %s", ErrInvalid, err)
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fieldError(path, "%w: want true or false, got %q", ErrInvalid, s)
+		}
+		v.SetBool(b)
+
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fieldError(path, "%w: want an integer, got %q", ErrInvalid, s)
+		}
+		v.SetInt(int64(n))
+
+	case reflect.Slice:
+		items := []any{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				items = append(items, item)
+			}
+		}
+		return assign(v, items, path)
+
+	case reflect.Map:
+		m := make(map[string]any)
+		for _, pair := range strings.Split(s, ",") {
+			if pair = strings.TrimSpace(pair); len(pair) == 0 {
+				continue
+			}
+			k, val, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fieldError(path, "%w: want key=value pairs, got %q", ErrInvalid, pair)
+			}
+			m[k] = val
+		}
+		return assign(v, m, path)
+
+	default:
+		return fieldError(path, "%w: cannot be set from a string", ErrInvalid)
+	}
+	return nil
+}
+
+// toInt converts a decoded number to an integer, rejecting fractions
+func toInt(data any) (int64, bool) {
+	switch n := data.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), n <= 1<<63-1
+	case float64:
+		return int64(n), n == float64(int64(n))
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// fieldByTag returns the field of struct v whose json tag is name
+func fieldByTag(v reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if tagName(v.Type().Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// tagName returns the name of a field in config files
+func tagName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
+
+// join appends a key to a dotted setting path
+func join(path string, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
+
+// sortedKeys returns the keys of m in order, so errors come out in a stable order
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// NewTracer creates the tracer of a node with this configuration, with the exporter to close when the node stops.
+// It returns a nil tracer, which traces nothing, if tracing is disabled.
+func (c Config) NewTracer() (*trace.Tracer, io.Closer, error) {
+	if len(c.Tracing.File) == 0 {
+		return nil, io.NopCloser(nil), nil
+	}
+	exp, err := trace.NewFileExporter(c.Tracing.File)
+	if err != nil {
+		return nil, nil, fieldError("tracing.file", "%w", err)
+	}
+	service := c.Tracing.Service
+	if len(service) == 0 {
+		service = "govault"
+	}
+	return trace.NewTracer(service, exp), exp, nil
+}
@@ -0,0 +1,287 @@
+// Tests for GoVaultFS configuration
+// These load the same node from every file format and check that bad settings are reported by field.
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// testFiles describe the same node in each supported format
+var testFiles = map[string]string{
+	"node.yaml": `
+transport:
+  listen: ":4000"
+  bootstrap: [":3000", ":5000"]
+storage:
+  capacity: 10GiB
+  owner_quotas: {node1: 1GiB}
+replication:
+  factor: 2
+  namespaces:
+    archive/: {data_shards: 4, parity_shards: 2}
+gossip:
+  probe_interval: 200ms
+api:
+  http: ":8080"
+  s3:
+    listen: ":9000"
+    access_keys: {AKID: secret}
+tenants:
+  acme:
+    quota: 1GiB
+    erasure: {data_shards: 4, parity_shards: 2}
+    access_keys: {ACME: acme-secret}
+auth:
+  enabled: true
+  key: "0000000000000000000000000000000000000000000000000000000000000001"
+  issuers: {node1: "abababababababababababababababababababababababababababababababab"}
+`,
+	"node.toml": `
+[transport]
+listen = ":4000"
+bootstrap = [":3000", ":5000"]
+
+[storage]
+capacity = "10GiB"
+owner_quotas = { node1 = "1GiB" }
+
+[replication]
+factor = 2
+namespaces = { "archive/" = { data_shards = 4, parity_shards = 2 } }
+
+[gossip]
+probe_interval = "200ms"
+
+[api]
+http = ":8080"
+s3 = { listen = ":9000", access_keys = { AKID = "secret" } }
+
+[tenants.acme]
+quota = "1GiB"
+erasure = { data_shards = 4, parity_shards = 2 }
+access_keys = { ACME = "acme-secret" }
+
+[auth]
+enabled = true
+key = "0000000000000000000000000000000000000000000000000000000000000001"
+issuers = { node1 = "abababababababababababababababababababababababababababababababab" }
+`,
+	"node.json": `{
+  "transport": {"listen": ":4000", "bootstrap": [":3000", ":5000"]},
+  "storage": {"capacity": "10GiB", "owner_quotas": {"node1": "1GiB"}},
+  "replication": {"factor": 2, "namespaces": {"archive/": {"data_shards": 4, "parity_shards": 2}}},
+  "gossip": {"probe_interval": "200ms"},
+  "api": {"http": ":8080", "s3": {"listen": ":9000", "access_keys": {"AKID": "secret"}}},
+  "tenants": {"acme": {"quota": "1GiB", "erasure": {"data_shards": 4, "parity_shards": 2}, "access_keys": {"ACME": "acme-secret"}}},
+  "auth": {"enabled": true, "key": "0000000000000000000000000000000000000000000000000000000000000001", "issuers": {"node1": "abababababababababababababababababababababababababababababababab"}}
+}`,
+}
+
+// writeFile writes a config file into a temporary directory and returns its path
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+// TestLoadFormats loads the same node from YAML, TOML and JSON
+func TestLoadFormats(t *testing.T) {
+	t.Parallel()
+
+	for name, content := range testFiles {
+		cfg, err := Load(writeFile(t, name, content), Default(), nil)
+		assert.Nil(t, err, name)
+
+		assert.Equal(t, ":4000", cfg.Transport.Listen, name)
+		assert.Equal(t, "port4000_network", cfg.Storage.Root, name)
+		assert.Equal(t, []string{":3000", ":5000"}, cfg.Transport.Bootstrap, name)
+		assert.Equal(t, Duration(200*time.Millisecond), cfg.Gossip.ProbeInterval, name)
+		assert.Equal(t, ":8080", cfg.API.HTTP, name)
+
+		st := cfg.Settings()
+		assert.Equal(t, 2, st.ReplicationFactor, name)
+		assert.Equal(t, server.StoragePolicy{DataShards: 4, ParityShards: 2}, st.NamespacePolicies["archive/"], name)
+		assert.Equal(t, map[string]string{"AKID": "secret"}, st.S3AccessKeys, name)
+		assert.Equal(t, server.QuotaOpts{Capacity: 10 << 30, Owners: map[string]int64{"node1": 1 << 30}}, st.Quota, name)
+		assert.Equal(t, []server.Tenant{{
+			Name:       "acme",
+			Quota:      1 << 30,
+			Policy:     &server.StoragePolicy{DataShards: 4, ParityShards: 2},
+			AccessKeys: map[string]string{"ACME": "acme-secret"},
+		}}, cfg.tenants(), name)
+
+		auth := cfg.FileServerOpts(nil, "", nil).Auth
+		if assert.NotNil(t, auth, name) {
+			assert.Len(t, auth.SigningKey, ed25519.PrivateKeySize, name)
+			assert.Equal(t, ed25519.PublicKey(bytes.Repeat([]byte{0xab}, 32)), auth.Issuers["node1"], name)
+		}
+	}
+
+	_, err := Load(writeFile(t, "node.ini", ""), Default(), nil)
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+// TestLoadErrors checks that decoding and validation errors name the bad field
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	path := writeFile(t, "node.yaml", `
+transport:
+  listn: ":4000"
+  bootstrap: [":3000", "nowhere"]
+replication:
+  factor: two
+  erasure: {parity_shards: 2}
+`)
+	_, err := Load(path, Default(), nil)
+	assert.ErrorIs(t, err, ErrUnknownField)
+	assert.ErrorContains(t, err, "transport.listn: unknown field")
+	assert.ErrorContains(t, err, "replication.factor: invalid value: want an integer")
+
+	path = writeFile(t, "node.yaml", `
+transport:
+  listen: "4000"
+  bootstrap: [":3000", "nowhere"]
+crypto:
+  key: "abcd"
+replication:
+  erasure: {parity_shards: 2}
+gossip:
+  probe_interval: 1s
+  probe_timeout: 2s
+log:
+  level: verbose
+  format: xml
+cache:
+  eviction: random
+versions:
+  keep: -2
+  conflicts: newest
+api:
+  s3: {access_keys: {AKID: secret}}
+tenants:
+  Acme: {}
+  globex: {key: "xyz", access_keys: {AKID: secret}}
+auth:
+  key: "abcd"
+  issuers: {node1: "zz"}
+  token: "not-a-token"
+`)
+	_, err = Load(path, Default(), nil)
+	assert.ErrorIs(t, err, ErrInvalid)
+	for _, field := range []string{"transport.listen", "transport.bootstrap[1]", "crypto.key", "replication.erasure.parity_shards", "gossip.probe_timeout", "log.level", "log.format", "cache.eviction", "versions.keep", "versions.conflicts", "tenants.Acme", "tenants.globex.key", "tenants.globex.access_keys.AKID", "auth.key", "auth.issuers.node1", "auth.token"} {
+		assert.ErrorContains(t, err, field+": invalid value")
+	}
+
+	// Node IDs name directories next to those of tenants, the cache and multipart uploads
+	for _, id := range []string{"cache", "multipart", "node.acme", "a/b", "a b"} {
+		c := Default()
+		c.Node.ID = id
+		assert.ErrorContains(t, c.Validate(), "node.id: invalid value", id)
+	}
+}
+
+// TestEnvOverrides checks environment variables and flag overrides on top of a file
+func TestEnvOverrides(t *testing.T) {
+	cfg := Default()
+	err := ApplyEnv(&cfg, []string{
+		"HOME=/root",
+		"GOVAULT_TRANSPORT_BOOTSTRAP=:3000, :5000",
+		"GOVAULT_REPLICATION_FACTOR=3",
+		"GOVAULT_GOSSIP_PROBE_TIMEOUT=250ms",
+		"GOVAULT_API_S3_ACCESS_KEYS=a=1,b=2",
+		"GOVAULT_SOCKET=/tmp/x.sock",
+		"GOVAULT_LOG_LEVEL=warn",
+		"GOVAULT_TRACING_FILE=/tmp/spans.jsonl",
+		"GOVAULT_BANDWIDTH_PEER_OUT=10MiB/s",
+		"GOVAULT_BANDWIDTH_BACKGROUND=1.5MB",
+		"GOVAULT_STORAGE_OWNER_QUOTA=500MB",
+		"GOVAULT_CACHE_SIZE=1GiB",
+		"GOVAULT_CACHE_EVICTION=lfu",
+		"GOVAULT_VERSIONS_KEEP=5",
+		"GOVAULT_VERSIONS_MAX_AGE=720h",
+		"GOVAULT_VERSIONS_CONFLICTS=keep-both",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, slog.LevelWarn, cfg.LogLevel())
+	assert.Equal(t, "/tmp/spans.jsonl", cfg.Tracing.File)
+	assert.Equal(t, float64(10<<20), cfg.NewTransport().RateLimits.PeerOut)
+	assert.Equal(t, 1.5e6, cfg.Settings().Bandwidth.Background)
+	assert.Equal(t, int64(500e6), cfg.Settings().Quota.PerOwner)
+	assert.Equal(t, server.CacheOpts{Size: 1 << 30, Eviction: server.EvictLFU}, cfg.Settings().Cache)
+	assert.Equal(t, server.VersionOpts{Keep: 5, MaxAge: 720 * time.Hour}, cfg.Settings().Versions)
+	assert.Equal(t, server.KeepBoth, cfg.FileServerOpts(nil, "", nil).Resolver("k", server.ObjectInfo{}, server.ObjectInfo{}))
+	assert.Equal(t, []string{":3000", ":5000"}, cfg.Transport.Bootstrap)
+	assert.Equal(t, 3, cfg.Replication.Factor)
+	assert.Equal(t, Duration(250*time.Millisecond), cfg.Gossip.ProbeTimeout)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, cfg.API.S3.AccessKeys)
+
+	err = ApplyEnv(&cfg, []string{"GOVAULT_REPLICATION_FACTOR=many", "GOVAULT_TRANSPORT_LISTN=:1", "GOVAULT_BANDWIDTH_IN=fast"})
+	assert.ErrorContains(t, err, "GOVAULT_REPLICATION_FACTOR: invalid value")
+	assert.ErrorContains(t, err, "GOVAULT_BANDWIDTH_IN: invalid value")
+	assert.ErrorContains(t, err, "GOVAULT_TRANSPORT_LISTN: unknown field")
+
+	t.Setenv("GOVAULT_API_HTTP", ":8081")
+	path := writeFile(t, "node.json", testFiles["node.json"])
+	cfg, err = Load(path, Default(), map[string]string{"transport.listen": ":4100"})
+	assert.Nil(t, err)
+	assert.Equal(t, ":8081", cfg.API.HTTP)
+	assert.Equal(t, ":4100", cfg.Transport.Listen)
+	assert.Equal(t, "port4100_network", cfg.Storage.Root)
+
+	assert.ErrorIs(t, cfg.Set("transport.nope", "x"), ErrUnknownField)
+}
+
+// TestRestartRequired separates settings applied on reload from those needing a restart
+func TestRestartRequired(t *testing.T) {
+	t.Parallel()
+
+	old, _ := Load(writeFile(t, "node.json", testFiles["node.json"]), Default(), nil)
+
+	next := old
+	next.Transport.Bootstrap = []string{":6000"}
+	next.Replication.Factor = 3
+	next.API.S3.AccessKeys = map[string]string{"other": "key"}
+	next.Log.Level = "debug"
+	next.Bandwidth.Background = 1 << 20
+	next.Storage.Capacity = 1 << 30
+	next.Cache.Eviction = "lfu"
+	next.Versions.Keep = 3
+	assert.Empty(t, RestartRequired(old, next))
+
+	next.Transport.Listen = ":4001"
+	next.Gossip.ProbeInterval = Duration(time.Second)
+	next.Log.Format = "json"
+	next.Bandwidth.PeerOut = 1 << 20
+	next.Versions.Conflicts = "keep-both"
+	assert.Equal(t, []string{"transport.listen", "gossip.probe_interval", "log.format", "bandwidth.peer_out", "versions.conflicts"}, RestartRequired(old, next))
+}
+
+// TestWatch calls back when the file changes
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	path := writeFile(t, "node.yaml", "transport: {listen: ':3000'}\n")
+
+	var calls atomic.Int32
+	stop := make(chan struct{})
+	defer close(stop)
+	go Watch(path, 10*time.Millisecond, stop, func() { calls.Add(1) })
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), calls.Load())
+
+	later := time.Now().Add(time.Second)
+	assert.Nil(t, os.Chtimes(path, later, later))
+	assert.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, 10*time.Millisecond)
+}
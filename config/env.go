@@ -0,0 +1,77 @@
+// Environment overrides for GoVaultFS configuration
+// Every setting can be overridden by a variable named after its path: transport.listen is GOVAULT_TRANSPORT_LISTEN
+// and api.s3.access_keys is GOVAULT_API_S3_ACCESS_KEYS. Lists are comma separated, maps are key=value pairs.
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// EnvPrefix starts the names of environment overrides
+const EnvPrefix = "GOVAULT_"
+
+// ApplyEnv overrides settings in cfg from environ, a list of KEY=value strings as returned by os.Environ.
+// Variables that start with EnvPrefix but name no setting are errors, so typos do not go unnoticed.
+func ApplyEnv(cfg *Config, environ []string) error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(k, EnvPrefix) {
+			env[k] = v
+		}
+	}
+
+	var errs []error
+	walkEnv(reflect.ValueOf(cfg).Elem(), "", func(v reflect.Value, path string) {
+		name := EnvName(path)
+		s, ok := env[name]
+		if !ok {
+			return
+		}
+		delete(env, name)
+		if err := setString(v, s, name); err != nil {
+			errs = append(errs, err)
+		}
+	})
+
+	// The socket path is shared with the client commands, so it is not a config override
+	delete(env, EnvPrefix+"SOCKET")
+	for _, k := range sortedKeys(toAny(env)) {
+		errs = append(errs, &FieldError{Field: k, Err: ErrUnknownField})
+	}
+	return errors.Join(errs...)
+}
+
+// EnvName returns the environment variable that overrides the setting at path
+func EnvName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// walkEnv calls fn for every setting of struct v that can be set from a single string,
+// which are the settings environment variables and Set can change
+func walkEnv(v reflect.Value, path string, fn func(v reflect.Value, path string)) {
+	for i := 0; i < v.NumField(); i++ {
+		f, sub := v.Field(i), join(path, tagName(v.Type().Field(i)))
+		switch {
+		case reflect.PointerTo(f.Type()).Implements(textUnmarshalerType):
+			fn(f, sub)
+		case f.Kind() == reflect.Struct:
+			walkEnv(f, sub, fn)
+		case f.Kind() == reflect.Map && f.Type().Elem().Kind() != reflect.String:
+			// Maps of tables only come from files
+		default:
+			fn(f, sub)
+		}
+	}
+}
+
+// toAny converts a string map for sortedKeys
+func toAny(m map[string]string) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
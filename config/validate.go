@@ -0,0 +1,194 @@
+// Validation of GoVaultFS configuration
+// Validate checks every setting and reports all problems at once, each naming its field.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/server"
+)
+
+// maxShards is the most shards a Reed-Solomon code over GF(2^8) supports
+const maxShards = 256
+
+// tenantNameRe matches valid tenant names, which become part of directory names
+var tenantNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Validate checks that the configuration describes a node that can start
+func (c Config) Validate() error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// The ID names the directory of the node's files, whose tenants follow a dot, next to the directories
+	// of the cache and of multipart uploads
+	if strings.ContainsAny(c.Node.ID, " \t\n/\\.") {
+		check(fieldError("node.id", "%w: must not contain spaces, slashes or dots", ErrInvalid))
+	} else if c.Node.ID == "cache" || c.Node.ID == "multipart" {
+		check(fieldError("node.id", "%w: %s is reserved", ErrInvalid, c.Node.ID))
+	}
+
+	check(checkAddr("transport.listen", c.Transport.Listen, true))
+	for i, addr := range c.Transport.Bootstrap {
+		check(checkAddr(fmt.Sprintf("transport.bootstrap[%d]", i), addr, true))
+	}
+
+	if len(c.Crypto.Key) > 0 && len(c.Crypto.KeyFile) > 0 {
+		check(fieldError("crypto.key_file", "%w: set either crypto.key or crypto.key_file", ErrInvalid))
+	} else if _, err := c.EncKey(); err != nil {
+		check(err)
+	}
+
+	if c.Replication.Factor < 0 {
+		check(fieldError("replication.factor", "%w: must not be negative", ErrInvalid))
+	}
+	check(c.Replication.Erasure.validate("replication.erasure"))
+	prefixes := make([]string, 0, len(c.Replication.Namespaces))
+	for prefix := range c.Replication.Namespaces {
+		prefixes = append(prefixes, prefix)
+	}
+	slices.Sort(prefixes)
+	for _, prefix := range prefixes {
+		check(c.Replication.Namespaces[prefix].validate("replication.namespaces." + prefix))
+	}
+
+	check(checkDuration("gossip.probe_interval", c.Gossip.ProbeInterval))
+	check(checkDuration("gossip.probe_timeout", c.Gossip.ProbeTimeout))
+	check(checkDuration("gossip.suspicion_timeout", c.Gossip.SuspicionTimeout))
+	if c.Gossip.ProbeInterval > 0 && c.Gossip.ProbeTimeout >= c.Gossip.ProbeInterval {
+		check(fieldError("gossip.probe_timeout", "%w: must be shorter than gossip.probe_interval", ErrInvalid))
+	}
+	if c.Gossip.IndirectProbes < 0 {
+		check(fieldError("gossip.indirect_probes", "%w: must not be negative", ErrInvalid))
+	}
+
+	check(checkAddr("api.http", c.API.HTTP, false))
+	check(checkAddr("api.s3.listen", c.API.S3.Listen, false))
+	check(checkAddr("api.webdav", c.API.WebDAV, false))
+	check(checkAddr("api.grpc", c.API.GRPC, false))
+	check(checkAddr("api.metrics", c.API.Metrics, false))
+	for id, secret := range c.API.S3.AccessKeys {
+		if len(secret) == 0 {
+			check(fieldError("api.s3.access_keys."+id, "%w: empty secret key", ErrInvalid))
+		}
+	}
+
+	var level slog.Level
+	if len(c.Log.Level) > 0 && level.UnmarshalText([]byte(c.Log.Level)) != nil {
+		check(fieldError("log.level", "%w: want debug, info, warn or error, got %q", ErrInvalid, c.Log.Level))
+	}
+	if c.Log.Format != "" && c.Log.Format != "text" && c.Log.Format != "json" {
+		check(fieldError("log.format", "%w: want text or json, got %q", ErrInvalid, c.Log.Format))
+	}
+
+	if c.Cache.Eviction != "" && c.Cache.Eviction != "lru" && c.Cache.Eviction != "lfu" {
+		check(fieldError("cache.eviction", "%w: want lru or lfu, got %q", ErrInvalid, c.Cache.Eviction))
+	}
+	if c.Versions.Keep < server.KeepAllVersions {
+		check(fieldError("versions.keep", "%w: must be -1 for every version or more", ErrInvalid))
+	}
+	if c.Versions.MaxAge < 0 {
+		check(fieldError("versions.max_age", "%w: must not be negative", ErrInvalid))
+	}
+	if c.Versions.Conflicts != "" && c.Versions.Conflicts != "lww" && c.Versions.Conflicts != "keep-both" {
+		check(fieldError("versions.conflicts", "%w: want lww or keep-both, got %q", ErrInvalid, c.Versions.Conflicts))
+	}
+
+	if len(c.Auth.Key) > 0 && len(c.Auth.KeyFile) > 0 {
+		check(fieldError("auth.key_file", "%w: set either auth.key or auth.key_file", ErrInvalid))
+	} else if _, err := c.SigningKey(); err != nil {
+		check(err)
+	}
+	_, err := c.issuers()
+	check(err)
+	if len(c.Auth.Token) > 0 {
+		if _, err := server.ParseToken(c.Auth.Token); err != nil {
+			check(fieldError("auth.token", "%w: %w", ErrInvalid, err))
+		}
+	}
+
+	names := slices.Sorted(maps.Keys(c.Tenants))
+	owners := make(map[string]string) // Tenant by access key ID
+	for _, name := range names {
+		path, t := "tenants."+name, c.Tenants[name]
+		if !tenantNameRe.MatchString(name) {
+			check(fieldError(path, "%w: tenant names are lower case letters, digits and dashes", ErrInvalid))
+		}
+		_, err := parseKey(path+".key", t.Key)
+		check(err)
+		if t.Quota < 0 {
+			check(fieldError(path+".quota", "%w: must not be negative", ErrInvalid))
+		}
+		if t.Factor < 0 {
+			check(fieldError(path+".factor", "%w: must not be negative", ErrInvalid))
+		}
+		check(t.Erasure.validate(path + ".erasure"))
+		for _, id := range slices.Sorted(maps.Keys(t.AccessKeys)) {
+			switch other, taken := owners[id]; {
+			case len(t.AccessKeys[id]) == 0:
+				check(fieldError(path+".access_keys."+id, "%w: empty secret key", ErrInvalid))
+			case taken:
+				check(fieldError(path+".access_keys."+id, "%w: also the access key of tenant %s", ErrInvalid, other))
+			case len(c.API.S3.AccessKeys[id]) > 0:
+				check(fieldError(path+".access_keys."+id, "%w: also an access key of the node", ErrInvalid))
+			}
+			owners[id] = name
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate checks an erasure coding policy
+func (p PolicyConfig) validate(path string) error {
+	switch {
+	case p.DataShards < 0:
+		return fieldError(path+".data_shards", "%w: must not be negative", ErrInvalid)
+	case p.ParityShards < 0:
+		return fieldError(path+".parity_shards", "%w: must not be negative", ErrInvalid)
+	case p.DataShards == 0 && p.ParityShards > 0:
+		return fieldError(path+".parity_shards", "%w: needs data_shards", ErrInvalid)
+	case p.DataShards+p.ParityShards > maxShards:
+		return fieldError(path, "%w: at most %d shards in total", ErrInvalid, maxShards)
+	}
+	return nil
+}
+
+// checkAddr checks that addr is a host:port address, allowing it to be empty unless required
+func checkAddr(path string, addr string, required bool) error {
+	if len(addr) == 0 {
+		if required {
+			return fieldError(path, "%w: required", ErrInvalid)
+		}
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fieldError(path, "%w: want host:port, got %q", ErrInvalid, addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fieldError(path, "%w: invalid port %q", ErrInvalid, port)
+	}
+	return nil
+}
+
+// checkDuration checks that a duration is not negative; zero selects the default
+func checkDuration(path string, d Duration) error {
+	if d < 0 {
+		return fieldError(path, "%w: must not be negative, got %s", ErrInvalid, time.Duration(d))
+	}
+	return nil
+}
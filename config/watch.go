@@ -0,0 +1,37 @@
+// Config file watching for GoVaultFS
+// Watch polls the modification time of a file, which works the same on every platform without extra dependencies.
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Watch calls fn whenever the file at path changes, checking every interval until stop is closed
+func Watch(path string, interval time.Duration, stop <-chan struct{}, fn func()) {
+	last := modTime(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if t := modTime(path); !t.Equal(last) {
+				last = t
+				fn()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// modTime returns when the file at path last changed, or the zero time if it cannot be read
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
@@ -10,7 +10,8 @@
 // - Used for efficient, random-access encryption of data streams.
 // So here, AES-CTR mode is used for encrypting and decrypting file streams securely and efficiently.
 
-package main
+// Package crypto provides ID generation, key hashing and AES-CTR stream encryption for GoVaultFS.
+package crypto
 
 import (
 	"crypto/aes"
@@ -21,23 +22,23 @@ import (
 	"io"
 )
 
-// generateID creates a random 32-byte hex string for node or file identification
-func generateID() string {
+// GenerateID creates a random 32-byte hex string for node or file identification
+func GenerateID() string {
 	buf := make([]byte, 32)
 	io.ReadFull(rand.Reader, buf)
 	return hex.EncodeToString(buf)
 }
 
-// hashKey returns an MD5 hash of the given key as a hex string
+// HashKey returns an MD5 hash of the given key as a hex string
 // Used for content-addressable storage and network lookup
-func hashKey(key string) string {
+func HashKey(key string) string {
 	hash := md5.Sum([]byte(key))
 	return hex.EncodeToString(hash[:])
 }
 
-// newEncryptionKey generates a new random 32-byte AES key
+// NewEncryptionKey generates a new random 32-byte AES key
 // Each node uses its own key for file encryption
-func newEncryptionKey() []byte {
+func NewEncryptionKey() []byte {
 	keyBuf := make([]byte, 32)
 	io.ReadFull(rand.Reader, keyBuf)
 	return keyBuf
@@ -70,9 +71,9 @@ func copyStream(stream cipher.Stream, blockSize int, src io.Reader, dst io.Write
 	return nw, nil
 }
 
-// copyDecrypt decrypts data from src to dst using AES-CTR mode
+// CopyDecrypt decrypts data from src to dst using AES-CTR mode
 // Reads the IV from the beginning of src, then streams decryption
-func copyDecrypt(key []byte, src io.Reader, dst io.Writer) (int, error) {
+func CopyDecrypt(key []byte, src io.Reader, dst io.Writer) (int, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return 0, err
@@ -88,9 +89,9 @@ func copyDecrypt(key []byte, src io.Reader, dst io.Writer) (int, error) {
 	return copyStream(stream, block.BlockSize(), src, dst)
 }
 
-// copyEncrypt encrypts data from src to dst using AES-CTR mode
+// CopyEncrypt encrypts data from src to dst using AES-CTR mode
 // Generates a random IV, prepends it to dst, then streams encryption
-func copyEncrypt(key []byte, src io.Reader, dst io.Writer) (int, error) {
+func CopyEncrypt(key []byte, src io.Reader, dst io.Writer) (int, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return 0, err
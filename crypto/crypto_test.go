@@ -1,6 +1,6 @@
 // Unit test for encryption and decryption functions in GoVaultFS
-// This test verifies that data encrypted with copyEncrypt can be correctly decrypted with copyDecrypt using AES-CTR mode.
-package main
+// This test verifies that data encrypted with CopyEncrypt can be correctly decrypted with CopyDecrypt using AES-CTR mode.
+package crypto
 
 import (
 	"bytes"
@@ -14,10 +14,10 @@ func TestCopyEncryptDecrypt(t *testing.T) {
 	payload := "Foo not bar"                // Test data to encrypt
 	src := bytes.NewReader([]byte(payload)) // Source reader for encryption
 	dst := new(bytes.Buffer)                // Destination buffer for encrypted data
-	key := newEncryptionKey()               // Generate a random AES key
+	key := NewEncryptionKey()               // Generate a random AES key
 
 	// Encrypt the payload using AES-CTR
-	_, err := copyEncrypt(key, src, dst)
+	_, err := CopyEncrypt(key, src, dst)
 	if err != nil {
 		t.Error(err)
 	}
@@ -27,7 +27,7 @@ func TestCopyEncryptDecrypt(t *testing.T) {
 	fmt.Println(len(dst.String())) // Length of encrypted data (includes IV)
 
 	out := new(bytes.Buffer) // Buffer for decrypted output
-	nw, err := copyDecrypt(key, dst, out)
+	nw, err := CopyDecrypt(key, dst, out)
 	if err != nil {
 		t.Error(err)
 	}
@@ -0,0 +1,213 @@
+// Tests for the govault command
+// These run the client commands against an in-process node serving its admin socket.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/AnshSinghSonkhia/GoVaultFS/server"
+	"github.com/AnshSinghSonkhia/GoVaultFS/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// startNode runs a single node with an admin socket and returns the socket path
+func startNode(t *testing.T) string {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "admin.sock")
+
+	tr := p2p.NewMemTransport(p2p.MemTransportOpts{ListenAddr: ":3000", Network: p2p.NewMemNetwork()})
+	s := server.NewFileServer(server.FileServerOpts{
+		EncKey:            crypto.NewEncryptionKey(),
+		StorageRoot:       filepath.Join(dir, "data"),
+		PathTransformFunc: store.CASPathTransformFunc,
+		Transport:         tr,
+		AdminSocket:       socket,
+	})
+	tr.OnPeer = s.OnPeer
+	tr.OnPeerDisconnect = s.OnPeerDisconnect
+
+	go s.Start()
+	t.Cleanup(s.Stop)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			return socket
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("admin socket did not come up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// govault runs a command line against the node at socket and returns its output
+func govault(t *testing.T, socket string, stdin string, args ...string) (string, error) {
+	args = append([]string{args[0], "-socket", socket}, args[1:]...)
+	out := new(bytes.Buffer)
+	err := run(args, strings.NewReader(stdin), out)
+	return out.String(), err
+}
+
+// TestClientCommands drives every client command through the admin socket
+func TestClientCommands(t *testing.T) {
+	t.Parallel()
+
+	socket := startNode(t)
+
+	// put from standard input and from a file
+	out, err := govault(t, socket, "hello world", "put", "docs/a.txt")
+	assert.Nil(t, err)
+	var info server.ObjectInfo
+	assert.Nil(t, json.Unmarshal([]byte(out), &info))
+	assert.Equal(t, "docs/a.txt", info.Key)
+	assert.Equal(t, int64(11), info.Size)
+
+	src := filepath.Join(t.TempDir(), "b.txt")
+	assert.Nil(t, os.WriteFile(src, []byte("second file"), 0644))
+	_, err = govault(t, socket, "", "put", "docs/b.txt", src)
+	assert.Nil(t, err)
+	_, err = govault(t, socket, "root", "put", "top.txt", "-")
+	assert.Nil(t, err)
+	_, err = govault(t, socket, "again", "put", "-if-none-match", "top.txt")
+	assert.ErrorContains(t, err, "precondition failed")
+
+	// get to standard output and to a file
+	out, err = govault(t, socket, "", "get", "docs/a.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", out)
+
+	dst := filepath.Join(t.TempDir(), "out.txt")
+	_, err = govault(t, socket, "", "get", "docs/b.txt", dst)
+	assert.Nil(t, err)
+	b, _ := os.ReadFile(dst)
+	assert.Equal(t, "second file", string(b))
+
+	// ls with a prefix and a limit
+	var objects []server.ObjectInfo
+	out, err = govault(t, socket, "", "ls", "docs/")
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal([]byte(out), &objects))
+	assert.Len(t, objects, 2)
+
+	out, err = govault(t, socket, "", "ls", "-limit", "2")
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal([]byte(out), &objects))
+	assert.Equal(t, []string{"docs/a.txt", "docs/b.txt"}, []string{objects[0].Key, objects[1].Key})
+
+	// stat matches the key exactly, not a longer key with the same prefix
+	out, err = govault(t, socket, "", "stat", "docs/b.txt")
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal([]byte(out), &info))
+	assert.Equal(t, int64(11), info.Size)
+	_, err = govault(t, socket, "", "stat", "docs/")
+	assert.ErrorIs(t, err, server.ErrNotFound)
+
+	// rm, then the object is gone
+	_, err = govault(t, socket, "", "rm", "docs/a.txt")
+	assert.Nil(t, err)
+	_, err = govault(t, socket, "", "get", "docs/a.txt")
+	assert.ErrorContains(t, err, "not found")
+
+	// pin, list pins, unpin
+	_, err = govault(t, socket, "", "pin", "docs/b.txt")
+	assert.Nil(t, err)
+	out, err = govault(t, socket, "", "pin")
+	assert.Nil(t, err)
+	assert.Equal(t, "docs/b.txt\n", out)
+	_, err = govault(t, socket, "", "unpin", "docs/b.txt")
+	assert.Nil(t, err)
+	_, err = govault(t, socket, "", "unpin", "docs/b.txt")
+	assert.ErrorContains(t, err, "not pinned")
+
+	// versions, get an older version, restore it
+	_, err = govault(t, socket, "third", "put", "top.txt")
+	assert.Nil(t, err)
+	var versions []server.ObjectInfo
+	out, err = govault(t, socket, "", "versions", "top.txt")
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal([]byte(out), &versions))
+	assert.Len(t, versions, 2)
+	out, err = govault(t, socket, "", "get", "-version", versions[1].Version, "top.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "root", out)
+	_, err = govault(t, socket, "", "restore", "top.txt", versions[1].Version)
+	assert.Nil(t, err)
+	out, err = govault(t, socket, "", "get", "top.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "root", out)
+
+	// mkdir, mv and dir, then rm -r
+	_, err = govault(t, socket, "", "mkdir", "notes")
+	assert.Nil(t, err)
+	_, err = govault(t, socket, "", "mv", "top.txt", "notes/top.txt")
+	assert.Nil(t, err)
+	var entries []server.DirEntry
+	out, err = govault(t, socket, "", "dir", "notes")
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal([]byte(out), &entries))
+	assert.Equal(t, "notes/top.txt", entries[0].Path)
+	_, err = govault(t, socket, "", "rm", "-r", "notes")
+	assert.Nil(t, err)
+
+	// peers and status
+	out, err = govault(t, socket, "", "peers")
+	assert.Nil(t, err)
+	assert.Contains(t, out, `"state": "alive"`)
+
+	var st server.NodeStatus
+	out, err = govault(t, socket, "", "status")
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal([]byte(out), &st))
+	assert.Equal(t, ":3000", st.Addr)
+	assert.Equal(t, 1, st.Objects)
+	assert.Equal(t, socket, st.APIs["admin"])
+	assert.Equal(t, 1, st.Members["alive"])
+}
+
+// TestCommandErrors checks argument validation and an unreachable node
+func TestCommandErrors(t *testing.T) {
+	t.Parallel()
+
+	assert.ErrorIs(t, run(nil, nil, new(bytes.Buffer)), errUsage)
+	assert.ErrorContains(t, run([]string{"frobnicate"}, nil, new(bytes.Buffer)), "unknown command")
+	assert.ErrorContains(t, run([]string{"get"}, nil, new(bytes.Buffer)), "wrong number of arguments")
+
+	missing := filepath.Join(t.TempDir(), "none.sock")
+	_, err := govault(t, missing, "", "status")
+	assert.ErrorContains(t, err, "is `govault serve` running?")
+}
+
+// TestLoadIdentity checks that a node keeps its ID and keys across restarts
+func TestLoadIdentity(t *testing.T) {
+	t.Parallel()
+
+	root := filepath.Join(t.TempDir(), "node")
+	first, err := loadIdentity(root)
+	assert.Nil(t, err)
+	assert.Len(t, first.ID, 64)
+
+	second, err := loadIdentity(root)
+	assert.Nil(t, err)
+	assert.Equal(t, first, second)
+
+	// Nodes from before access control get a signing key once
+	legacy := filepath.Join(t.TempDir(), "legacy")
+	assert.Nil(t, os.MkdirAll(legacy, 0700))
+	assert.Nil(t, os.WriteFile(filepath.Join(legacy, identityFileName), []byte(`{"id": "old", "enc_key": "00"}`), 0600))
+	upgraded, err := loadIdentity(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, "old", upgraded.ID)
+	assert.Len(t, upgraded.SigningKey, 64)
+	again, err := loadIdentity(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, upgraded, again)
+}
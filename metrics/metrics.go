@@ -0,0 +1,384 @@
+// Package metrics provides counters, gauges and histograms exposed in the Prometheus text format.
+// It implements just enough of the exposition format (version 0.0.4) for a node to be scraped, so that
+// nodes need no client library and tests can read metrics by parsing the text of a Registry.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Type is the kind of a metric family
+type Type string
+
+// Metric types of the exposition format
+const (
+	CounterType   Type = "counter"
+	GaugeType     Type = "gauge"
+	HistogramType Type = "histogram"
+)
+
+// DefaultBuckets are histogram bounds in seconds suited to network transfers
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Collector is a metric family that can be written to a registry
+type Collector interface {
+	describe() *desc
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics of a node
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds collectors to the registry. It panics if a name is registered twice.
+func (r *Registry) Register(cs ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range cs {
+		for _, have := range r.collectors {
+			if have.describe().name == c.describe().name {
+				panic("metrics: duplicate metric " + c.describe().name)
+			}
+		}
+		r.collectors = append(r.collectors, c)
+	}
+}
+
+// WriteText writes every metric in the Prometheus text format, families sorted by name
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	cs := slices.Clone(r.collectors)
+	r.mu.Unlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].describe().name < cs[j].describe().name })
+
+	bw := bufio.NewWriter(w)
+	for _, c := range cs {
+		d := c.describe()
+		fmt.Fprintf(bw, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", d.name, d.typ)
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry to Prometheus scrapes (http.Handler)
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// desc describes a metric family
+type desc struct {
+	name   string
+	help   string
+	typ    Type
+	labels []string
+}
+
+// describe returns the description of the family (Collector interface)
+func (d *desc) describe() *desc {
+	return d
+}
+
+// checkLabels panics unless values match the label names of the family
+func (d *desc) checkLabels(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+}
+
+// series are the values of a family, one per combination of label values
+type series[T any] struct {
+	mu     sync.Mutex
+	values map[string]*T
+	labels map[string][]string
+}
+
+// get returns the value for the given label values, creating it with init if needed
+func (s *series[T]) get(values []string, init func() *T) *T {
+	key := strings.Join(values, "\xff")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]*T)
+		s.labels = make(map[string][]string)
+	}
+	v, ok := s.values[key]
+	if !ok {
+		v = init()
+		s.values[key] = v
+		s.labels[key] = slices.Clone(values)
+	}
+	return v
+}
+
+// lookup returns the value for the given label values, or nil if there is none
+func (s *series[T]) lookup(values []string) *T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[strings.Join(values, "\xff")]
+}
+
+// each calls fn for every series in label order. The caller must not keep v.
+func (s *series[T]) each(fn func(values []string, v *T)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fn(s.labels[k], s.values[k])
+	}
+}
+
+// value is a float updated under a lock
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// Counter is a value that only goes up, optionally split by labels
+type Counter struct {
+	desc
+	series series[value]
+}
+
+// NewCounter creates a counter with the given label names
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{desc: desc{name: name, help: help, typ: CounterType, labels: labels}}
+}
+
+// Add adds v, which must not be negative, to the series with the given label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.name + " cannot decrease")
+	}
+	c.checkLabels(labelValues)
+	val := c.series.get(labelValues, func() *value { return &value{} })
+	val.mu.Lock()
+	val.v += v
+	val.mu.Unlock()
+}
+
+// Inc adds one to the series with the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the current value of a series, 0 if it was never set
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.checkLabels(labelValues)
+	val := c.series.lookup(labelValues)
+	if val == nil {
+		return 0
+	}
+	val.mu.Lock()
+	defer val.mu.Unlock()
+	return val.v
+}
+
+// write writes the samples of the family (Collector interface)
+func (c *Counter) write(w *bufio.Writer) {
+	c.series.each(func(values []string, v *value) {
+		v.mu.Lock()
+		writeSample(w, c.name, c.labels, values, v.v)
+		v.mu.Unlock()
+	})
+}
+
+// Gauge is a value that can go up and down, optionally split by labels
+type Gauge struct {
+	desc
+	series series[value]
+}
+
+// NewGauge creates a gauge with the given label names
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{desc: desc{name: name, help: help, typ: GaugeType, labels: labels}}
+}
+
+// Set sets the series with the given label values to v
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.checkLabels(labelValues)
+	val := g.series.get(labelValues, func() *value { return &value{} })
+	val.mu.Lock()
+	val.v = v
+	val.mu.Unlock()
+}
+
+// Add adds v, which may be negative, to the series with the given label values
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.checkLabels(labelValues)
+	val := g.series.get(labelValues, func() *value { return &value{} })
+	val.mu.Lock()
+	val.v += v
+	val.mu.Unlock()
+}
+
+// write writes the samples of the family (Collector interface)
+func (g *Gauge) write(w *bufio.Writer) {
+	g.series.each(func(values []string, v *value) {
+		v.mu.Lock()
+		writeSample(w, g.name, g.labels, values, v.v)
+		v.mu.Unlock()
+	})
+}
+
+// histogramValue holds the buckets of one histogram series
+type histogramValue struct {
+	mu     sync.Mutex
+	counts []uint64 // Observations per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Histogram counts observations in buckets, optionally split by labels
+type Histogram struct {
+	desc
+	buckets []float64
+	series  series[histogramValue]
+}
+
+// NewHistogram creates a histogram with the given upper bucket bounds and label names.
+// Nil buckets use DefaultBuckets.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &Histogram{desc: desc{name: name, help: help, typ: HistogramType, labels: labels}, buckets: buckets}
+}
+
+// Observe records v in the series with the given label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.checkLabels(labelValues)
+	val := h.series.get(labelValues, func() *histogramValue {
+		return &histogramValue{counts: make([]uint64, len(h.buckets))}
+	})
+
+	val.mu.Lock()
+	defer val.mu.Unlock()
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		val.counts[i]++
+	}
+	val.sum += v
+	val.count++
+}
+
+// write writes the samples of the family (Collector interface)
+func (h *Histogram) write(w *bufio.Writer) {
+	names := append(slices.Clone(h.labels), "le")
+	h.series.each(func(values []string, v *histogramValue) {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+
+		var cum uint64
+		for i, le := range h.buckets {
+			cum += v.counts[i]
+			writeSample(w, h.name+"_bucket", names, append(slices.Clone(values), formatFloat(le)), float64(cum))
+		}
+		writeSample(w, h.name+"_bucket", names, append(slices.Clone(values), "+Inf"), float64(v.count))
+		writeSample(w, h.name+"_sum", h.labels, values, v.sum)
+		writeSample(w, h.name+"_count", h.labels, values, float64(v.count))
+	})
+}
+
+// Func is a counter or gauge whose series are read from a callback at scrape time.
+// It suits values another component already keeps, such as queue lengths or per-connection byte counts.
+type Func struct {
+	desc
+	fn func(emit func(v float64, labelValues ...string))
+}
+
+// NewFunc creates a metric that calls fn on every scrape; fn calls emit once per series
+func NewFunc(name, help string, typ Type, labels []string, fn func(emit func(v float64, labelValues ...string))) *Func {
+	return &Func{desc: desc{name: name, help: help, typ: typ, labels: labels}, fn: fn}
+}
+
+// NewGaugeFunc creates a gauge without labels whose value is read from fn at scrape time
+func NewGaugeFunc(name, help string, fn func() float64) *Func {
+	return NewFunc(name, help, GaugeType, nil, func(emit func(float64, ...string)) { emit(fn()) })
+}
+
+// write writes the samples of the family (Collector interface)
+func (f *Func) write(w *bufio.Writer) {
+	type sample struct {
+		values []string
+		v      float64
+	}
+	samples := []sample{}
+	f.fn(func(v float64, labelValues ...string) {
+		f.checkLabels(labelValues)
+		samples = append(samples, sample{slices.Clone(labelValues), v})
+	})
+	sort.SliceStable(samples, func(i, j int) bool {
+		return slices.Compare(samples[i].values, samples[j].values) < 0
+	})
+	for _, s := range samples {
+		writeSample(w, f.name, f.labels, s.values, s.v)
+	}
+}
+
+// writeSample writes one sample line
+func writeSample(w *bufio.Writer, name string, labels []string, values []string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l)
+			w.WriteString(`="`)
+			w.WriteString(escapeLabel(values[i]))
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// formatFloat formats a sample value the way Prometheus parses it
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeLabel escapes a label value
+var escapeLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
+
+// escapeHelp escapes a help text
+var escapeHelp = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace
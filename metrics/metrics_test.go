@@ -0,0 +1,89 @@
+// Tests for the metrics package
+// These check the exact text a registry writes and that ParseText reads it back.
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWriteText writes every metric type and compares with the expected exposition
+func TestWriteText(t *testing.T) {
+	t.Parallel()
+
+	reqs := NewCounter("requests_total", "Requests served.", "code")
+	reqs.Inc("200")
+	reqs.Add(2, "200")
+	reqs.Inc(`5"x"`)
+
+	temp := NewGauge("temperature", "Current\ntemperature.")
+	temp.Set(21.5)
+	temp.Add(-1)
+
+	lat := NewHistogram("latency_seconds", "Request latency.", []float64{0.5, 0.1}, "op")
+	lat.Observe(0.05, "get")
+	lat.Observe(0.3, "get")
+	lat.Observe(2, "get")
+
+	queue := NewGaugeFunc("queue_depth", "Queued items.", func() float64 { return 7 })
+	peers := NewFunc("peer_bytes_total", "Bytes per peer.", CounterType, []string{"peer"}, func(emit func(float64, ...string)) {
+		emit(20, "b")
+		emit(10, "a")
+	})
+
+	reg := NewRegistry()
+	reg.Register(reqs, temp, lat, queue, peers)
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, reg.WriteText(buf))
+	assert.Equal(t, `# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="get",le="0.1"} 1
+latency_seconds_bucket{op="get",le="0.5"} 2
+latency_seconds_bucket{op="get",le="+Inf"} 3
+latency_seconds_sum{op="get"} 2.35
+latency_seconds_count{op="get"} 3
+# HELP peer_bytes_total Bytes per peer.
+# TYPE peer_bytes_total counter
+peer_bytes_total{peer="a"} 10
+peer_bytes_total{peer="b"} 20
+# HELP queue_depth Queued items.
+# TYPE queue_depth gauge
+queue_depth 7
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{code="200"} 3
+requests_total{code="5\"x\""} 1
+# HELP temperature Current\ntemperature.
+# TYPE temperature gauge
+temperature 20.5
+`, buf.String())
+
+	assert.Equal(t, float64(3), reqs.Value("200"))
+	assert.Equal(t, float64(0), reqs.Value("404"))
+
+	// What the handler serves parses back into samples
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Header().Get("Content-Type"), "version=0.0.4")
+	samples, err := ParseText(rec.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), samples[`latency_seconds_bucket{op="get",le="0.5"}`])
+	assert.Equal(t, float64(7), samples["queue_depth"])
+}
+
+// TestMisuse checks that programming errors panic instead of writing bad output
+func TestMisuse(t *testing.T) {
+	t.Parallel()
+
+	c := NewCounter("c_total", "A counter.", "a")
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Add(-1, "x") })
+
+	reg := NewRegistry()
+	reg.Register(c)
+	assert.Panics(t, func() { reg.Register(NewGauge("c_total", "Again.")) })
+}
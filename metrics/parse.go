@@ -0,0 +1,36 @@
+// Parsing of the Prometheus text format
+// ParseText reads back what WriteText writes, so tests can check metrics without running Prometheus.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ParseText reads samples in the Prometheus text format.
+// Samples are keyed by their name and labels as written, e.g. `govault_get_total{result="hit"}`.
+func ParseText(r io.Reader) (map[string]float64, error) {
+	samples := make(map[string]float64)
+
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		i := strings.LastIndexByte(text, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("metrics: line %d: missing value", line)
+		}
+		v, err := strconv.ParseFloat(text[i+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("metrics: line %d: %w", line, err)
+		}
+		samples[text[:i]] = v
+	}
+	return samples, sc.Err()
+}
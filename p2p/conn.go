@@ -0,0 +1,186 @@
+// Peer connection handling shared by all GoVaultFS transports
+// This file provides the multiplexed peer type and the loop that serves a peer connection,
+// so that TCP and in-memory transports behave exactly the same once a connection exists.
+package p2p
+
+import (
+	"log/slog"
+	"net"
+	"sync/atomic"
+)
+
+// PeerStats count the bytes exchanged with a peer over its connection, including framing
+type PeerStats struct {
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+// TransportStats describe the load on a transport
+type TransportStats struct {
+	QueueDepth   int    // Messages waiting to be consumed
+	DecodeErrors uint64 // Streams dropped because their opening message could not be decoded
+}
+
+// countingConn counts the bytes read from and written to a connection
+type countingConn struct {
+	net.Conn
+	in  atomic.Uint64
+	out atomic.Uint64
+}
+
+// Read reads from the connection and counts the bytes read
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.in.Add(uint64(n))
+	return n, err
+}
+
+// Write writes to the connection and counts the bytes written
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.out.Add(uint64(n))
+	return n, err
+}
+
+// sessionPeer is a Peer backed by a multiplexed Session over a single connection.
+// Transports embed it in their own peer types.
+type sessionPeer struct {
+	conn     *countingConn // Underlying connection
+	outbound bool          // True if connection was dialed (outbound), false if accepted (inbound)
+	session  *Session      // Multiplexes logical streams over conn
+}
+
+// newSessionPeer starts a multiplexed session over conn
+func newSessionPeer(conn net.Conn, outbound bool) sessionPeer {
+	cc := &countingConn{Conn: conn}
+	return sessionPeer{
+		conn:     cc,
+		outbound: outbound,
+		session:  NewSession(cc, outbound),
+	}
+}
+
+// Stats returns the bytes exchanged with the peer so far
+func (p *sessionPeer) Stats() PeerStats {
+	return PeerStats{BytesIn: p.conn.in.Load(), BytesOut: p.conn.out.Load()}
+}
+
+// RemoteAddr returns the address of the remote end of the connection.
+func (p *sessionPeer) RemoteAddr() net.Addr {
+	return p.conn.RemoteAddr()
+}
+
+// Close shuts down the session and the underlying connection.
+func (p *sessionPeer) Close() error {
+	return p.session.Close()
+}
+
+// OpenStream opens a new logical stream to the peer.
+func (p *sessionPeer) OpenStream() (net.Conn, error) {
+	return p.session.Open()
+}
+
+// Send writes a framed message to the peer on a fresh stream.
+func (p *sessionPeer) Send(b []byte) error {
+	st, err := p.session.Open()
+	if err != nil {
+		return err
+	}
+	if _, err := st.Write(b); err != nil {
+		st.Close()
+		return err
+	}
+	return st.Close()
+}
+
+// peerHandlers are the transport callbacks servePeer runs over the lifetime of a connection
+type peerHandlers struct {
+	handshake        HandshakeFunc
+	decoder          Decoder
+	onPeer           func(Peer) error
+	onPeerDisconnect func(Peer)
+	decodeErrors     *atomic.Uint64 // Counts streams that failed to decode
+	logger           *slog.Logger
+}
+
+// loggerOrDefault returns l, or the default logger if l is nil
+func loggerOrDefault(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
+
+// servePeer runs a peer connection until it drops.
+//   - If handshake fails, the connection is dropped.
+//   - If OnPeer callback is set and fails, the connection is dropped.
+//   - Each stream the peer opens is decoded in its own goroutine, so streams never block each other.
+//   - Once the connection drops, OnPeerDisconnect is called for every peer that OnPeer accepted.
+//
+// A connection either side closed deliberately is logged at debug level, one that failed as a warning.
+func servePeer(peer Peer, session *Session, h peerHandlers, rpcch chan<- RPC) {
+	var err error
+	logger := h.logger.With("peer", peer.RemoteAddr().String())
+
+	defer func() {
+		peer.Close()
+		if err == nil {
+			logger.Debug("peer connection closed")
+			return
+		}
+		logger.Warn("dropping peer connection", "err", err)
+	}()
+
+	// Run handshake logic (e.g., authentication, protocol negotiation)
+	if err = h.handshake(peer); err != nil {
+		return
+	}
+
+	// Optional callback for custom peer handling
+	if h.onPeer != nil {
+		if err = h.onPeer(peer); err != nil {
+			return
+		}
+	}
+	if h.onPeerDisconnect != nil {
+		defer h.onPeerDisconnect(peer)
+	}
+
+	// Accept loop: every logical stream starts with a message
+	from := peer.RemoteAddr().String()
+	for {
+		var st *Stream
+		st, err = session.Accept()
+		if err != nil {
+			err = session.Err() // Session closed, nil if it was closed cleanly
+			return
+		}
+
+		go handleStream(from, st, h, rpcch)
+	}
+}
+
+// handleStream decodes the message that opens a stream and forwards it for consumption.
+// Plain messages close the stream right away, data streams are handed over in RPC.Body.
+func handleStream(from string, st *Stream, h peerHandlers, rpcch chan<- RPC) {
+	rpc := RPC{}
+	if err := h.decoder.Decode(st, &rpc); err != nil {
+		if h.decodeErrors != nil {
+			h.decodeErrors.Add(1)
+		}
+		h.logger.Warn("stream decode error", "peer", from, "err", err)
+		st.Close()
+		return
+	}
+
+	rpc.From = from // Set sender address
+
+	if rpc.Stream {
+		rpc.Body = st
+	} else {
+		st.Close()
+	}
+
+	// Forward RPC message to channel for consumption
+	rpcch <- rpc
+}
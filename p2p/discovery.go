@@ -0,0 +1,521 @@
+// Local network discovery for GoVaultFS
+// This file announces the local node on the network segment via UDP multicast and listens for other nodes.
+// Announcements are mDNS-style DNS responses: a PTR record pointing the service name at the node's instance name,
+// and a TXT record on the instance carrying the node ID and listen address. Newly discovered nodes are dialed
+// through the Transport, so a node can join a cluster without any hard-coded bootstrap addresses.
+package p2p
+
+import (
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for local network discovery
+const (
+	DefaultDiscoveryGroup   = "239.255.70.70:5390"     // Site-local multicast group, off the real mDNS port
+	DefaultDiscoveryService = "_govaultfs._tcp.local." // DNS-SD style service name
+)
+
+// DNS record types and classes used by discovery
+const (
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsClassIN = 1
+
+	dnsFlagResponse = 0x8400 // QR and AA bits, as used by mDNS responses
+)
+
+// DiscoveryOpts holds configuration for Discovery.
+//
+//	ID         - Node ID announced to other nodes
+//	ListenAddr - Transport address announced to other nodes; an empty host is filled in by receivers
+//	Group      - Multicast group and port (DefaultDiscoveryGroup if empty)
+//	Service    - Service name shared by all nodes of a cluster (DefaultDiscoveryService if empty)
+//	Interface  - Network interface to announce and listen on (system default if nil)
+//	Interval   - How often the node re-announces itself (10s if zero)
+//	Transport  - Transport used to dial discovered nodes (nodes are only tracked if nil)
+//	Logger     - Logger for discovery events (slog.Default() if nil)
+type DiscoveryOpts struct {
+	ID         string
+	ListenAddr string
+	Group      string
+	Service    string
+	Interface  *net.Interface
+	Interval   time.Duration
+	Transport  Transport
+	Logger     *slog.Logger
+}
+
+// DiscoveredNode is a node found on the local network
+type DiscoveredNode struct {
+	ID      string    // Node ID
+	Addr    string    // Transport address of the node
+	Expires time.Time // When the announcement expires unless refreshed
+}
+
+// Discovery announces the local node and tracks other nodes via UDP multicast
+type Discovery struct {
+	DiscoveryOpts
+
+	group  *net.UDPAddr
+	recv   *net.UDPConn // Joined to the multicast group
+	send   *net.UDPConn // Unbound socket used for outgoing announcements
+	quitch chan struct{}
+
+	mu    sync.Mutex
+	nodes map[string]DiscoveredNode // Discovered nodes by ID
+}
+
+// NewDiscovery creates a Discovery with the given options, filling in defaults
+func NewDiscovery(opts DiscoveryOpts) *Discovery {
+	if len(opts.Group) == 0 {
+		opts.Group = DefaultDiscoveryGroup
+	}
+	if len(opts.Service) == 0 {
+		opts.Service = DefaultDiscoveryService
+	}
+	if !strings.HasSuffix(opts.Service, ".") {
+		opts.Service += "."
+	}
+	if opts.Interval == 0 {
+		opts.Interval = 10 * time.Second
+	}
+	opts.Logger = loggerOrDefault(opts.Logger)
+
+	return &Discovery{
+		DiscoveryOpts: opts,
+		quitch:        make(chan struct{}),
+		nodes:         make(map[string]DiscoveredNode),
+	}
+}
+
+// Start joins the multicast group, queries for existing nodes and starts announcing the local node
+func (d *Discovery) Start() error {
+	var err error
+
+	d.group, err = net.ResolveUDPAddr("udp4", d.Group)
+	if err != nil {
+		return err
+	}
+
+	d.recv, err = net.ListenMulticastUDP("udp4", d.Interface, d.group)
+	if err != nil {
+		return err
+	}
+
+	// Bind the sending socket to the interface address so announcements leave through that interface
+	var laddr *net.UDPAddr
+	if d.Interface != nil {
+		if ip := interfaceIPv4(d.Interface); ip != nil {
+			laddr = &net.UDPAddr{IP: ip}
+		}
+	}
+	d.send, err = net.ListenUDP("udp4", laddr)
+	if err != nil {
+		d.recv.Close()
+		return err
+	}
+
+	go d.readLoop()
+	go d.announceLoop()
+
+	return d.query()
+}
+
+// Close announces that the node is going away and stops discovery
+func (d *Discovery) Close() error {
+	close(d.quitch)
+
+	// A zero TTL tells other nodes to forget us right away
+	d.announce(0)
+
+	d.send.Close()
+	return d.recv.Close()
+}
+
+// Nodes returns the nodes currently known on the local network, sorted by ID
+func (d *Discovery) Nodes() []DiscoveredNode {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expire()
+	nodes := make([]DiscoveredNode, 0, len(d.nodes))
+	for _, n := range d.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// announceLoop re-announces the local node every interval
+func (d *Discovery) announceLoop() {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	d.announce(d.ttl())
+	for {
+		select {
+		case <-ticker.C:
+			d.announce(d.ttl())
+		case <-d.quitch:
+			return
+		}
+	}
+}
+
+// readLoop handles queries and announcements from other nodes
+func (d *Discovery) readLoop() {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := d.recv.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			d.Logger.Warn("discovery read error", "err", err)
+			continue
+		}
+
+		msg, err := parseDNSMessage(buf[:n])
+		if err != nil {
+			continue // Not for us, the group may carry other traffic
+		}
+		d.handleMessage(msg, src)
+	}
+}
+
+// handleMessage answers queries for our service and records announced nodes
+func (d *Discovery) handleMessage(msg *dnsMessage, src *net.UDPAddr) {
+	if !msg.response {
+		for _, q := range msg.questions {
+			if q.rtype == dnsTypePTR && strings.EqualFold(q.name, d.Service) {
+				d.announce(d.ttl())
+				return
+			}
+		}
+		return
+	}
+
+	// Instance names announced for our service
+	instances := map[string]bool{}
+	for _, rr := range msg.answers {
+		if rr.rtype == dnsTypePTR && strings.EqualFold(rr.name, d.Service) {
+			instances[strings.ToLower(rr.target)] = true
+		}
+	}
+
+	for _, rr := range msg.answers {
+		if rr.rtype != dnsTypeTXT || !instances[strings.ToLower(rr.name)] {
+			continue
+		}
+		attrs := parseTXT(rr.txt)
+		id, addr := attrs["id"], attrs["addr"]
+		if len(id) == 0 || len(addr) == 0 || id == d.ID {
+			continue
+		}
+		d.observe(id, completeAddr(addr, src.IP), time.Duration(rr.ttl)*time.Second)
+	}
+}
+
+// observe records an announcement and dials the node if it is new
+func (d *Discovery) observe(id string, addr string, ttl time.Duration) {
+	d.mu.Lock()
+	d.expire()
+	if ttl == 0 {
+		delete(d.nodes, id)
+		d.mu.Unlock()
+		return
+	}
+	prev, known := d.nodes[id]
+	d.nodes[id] = DiscoveredNode{ID: id, Addr: addr, Expires: time.Now().Add(ttl)}
+	d.mu.Unlock()
+
+	if known && prev.Addr == addr {
+		return
+	}
+
+	// Only the node with the smaller ID dials, so two nodes that discover each other open a single connection
+	if d.Transport == nil || d.ID > id {
+		return
+	}
+	d.Logger.Info("discovered node", "peer_id", id, "peer", addr)
+	go func() {
+		if err := d.Transport.Dial(addr); err != nil {
+			d.Logger.Warn("discovery dial error", "peer", addr, "err", err)
+		}
+	}()
+}
+
+// expire forgets nodes whose announcements ran out. Callers must hold mu.
+func (d *Discovery) expire() {
+	now := time.Now()
+	for id, n := range d.nodes {
+		if now.After(n.Expires) {
+			delete(d.nodes, id)
+		}
+	}
+}
+
+// query asks every node on the segment to announce itself
+func (d *Discovery) query() error {
+	msg := &dnsMessage{
+		questions: []dnsQuestion{{name: d.Service, rtype: dnsTypePTR}},
+	}
+	_, err := d.send.WriteToUDP(msg.pack(), d.group)
+	return err
+}
+
+// announce multicasts our service records with the given TTL
+func (d *Discovery) announce(ttl time.Duration) {
+	// DNS labels are limited to 63 bytes, the TXT record still carries the full ID
+	label := d.ID
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	instance := label + "." + d.Service
+	msg := &dnsMessage{
+		response: true,
+		answers: []dnsRecord{
+			{name: d.Service, rtype: dnsTypePTR, ttl: uint32(ttl / time.Second), target: instance},
+			{name: instance, rtype: dnsTypeTXT, ttl: uint32(ttl / time.Second), txt: []string{
+				"id=" + d.ID,
+				"addr=" + d.ListenAddr,
+			}},
+		},
+	}
+	if _, err := d.send.WriteToUDP(msg.pack(), d.group); err != nil && !errors.Is(err, net.ErrClosed) {
+		d.Logger.Warn("discovery announce error", "err", err)
+	}
+}
+
+// ttl is how long other nodes keep our announcement: three missed announcements expire it
+func (d *Discovery) ttl() time.Duration {
+	ttl := 3 * d.Interval
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttl
+}
+
+// completeAddr fills in the host of an address like ":3000" with the IP the announcement came from
+func completeAddr(addr string, ip net.IP) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || len(host) > 0 {
+		return addr
+	}
+	return net.JoinHostPort(ip.String(), port)
+}
+
+// interfaceIPv4 returns the first IPv4 address of an interface
+func interfaceIPv4(ifi *net.Interface) net.IP {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4()
+		}
+	}
+	return nil
+}
+
+// parseTXT splits key=value TXT strings into a map
+func parseTXT(txt []string) map[string]string {
+	attrs := make(map[string]string, len(txt))
+	for _, s := range txt {
+		if k, v, ok := strings.Cut(s, "="); ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+// dnsMessage is the subset of a DNS message used for discovery
+type dnsMessage struct {
+	response  bool
+	questions []dnsQuestion
+	answers   []dnsRecord
+}
+
+// dnsQuestion is a DNS question section entry
+type dnsQuestion struct {
+	name  string
+	rtype uint16
+}
+
+// dnsRecord is a PTR or TXT resource record
+type dnsRecord struct {
+	name   string
+	rtype  uint16
+	ttl    uint32
+	target string   // PTR target
+	txt    []string // TXT strings
+}
+
+// pack encodes the message in DNS wire format without name compression
+func (m *dnsMessage) pack() []byte {
+	var flags uint16
+	if m.response {
+		flags = dnsFlagResponse
+	}
+
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint16(buf[2:], flags)
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(m.answers)))
+
+	for _, q := range m.questions {
+		buf = appendName(buf, q.name)
+		buf = binary.BigEndian.AppendUint16(buf, q.rtype)
+		buf = binary.BigEndian.AppendUint16(buf, dnsClassIN)
+	}
+
+	for _, rr := range m.answers {
+		var rdata []byte
+		switch rr.rtype {
+		case dnsTypePTR:
+			rdata = appendName(nil, rr.target)
+		case dnsTypeTXT:
+			for _, s := range rr.txt {
+				rdata = append(rdata, byte(len(s)))
+				rdata = append(rdata, s...)
+			}
+		}
+		buf = appendName(buf, rr.name)
+		buf = binary.BigEndian.AppendUint16(buf, rr.rtype)
+		buf = binary.BigEndian.AppendUint16(buf, dnsClassIN)
+		buf = binary.BigEndian.AppendUint32(buf, rr.ttl)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(rdata)))
+		buf = append(buf, rdata...)
+	}
+
+	return buf
+}
+
+// appendName appends a dotted domain name as DNS labels
+func appendName(buf []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 {
+			continue
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	return append(buf, 0)
+}
+
+// errMalformedDNS is returned for packets that are not valid DNS messages
+var errMalformedDNS = errors.New("malformed dns message")
+
+// parseDNSMessage decodes questions and PTR/TXT answers from a DNS message.
+// Other record types are skipped, and compressed names are supported.
+func parseDNSMessage(b []byte) (*dnsMessage, error) {
+	if len(b) < 12 {
+		return nil, errMalformedDNS
+	}
+
+	msg := &dnsMessage{
+		response: binary.BigEndian.Uint16(b[2:])&0x8000 != 0,
+	}
+	qdcount := int(binary.BigEndian.Uint16(b[4:]))
+	ancount := int(binary.BigEndian.Uint16(b[6:]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readName(b, off)
+		if err != nil || next+4 > len(b) {
+			return nil, errMalformedDNS
+		}
+		msg.questions = append(msg.questions, dnsQuestion{
+			name:  name,
+			rtype: binary.BigEndian.Uint16(b[next:]),
+		})
+		off = next + 4
+	}
+
+	for i := 0; i < ancount; i++ {
+		name, next, err := readName(b, off)
+		if err != nil || next+10 > len(b) {
+			return nil, errMalformedDNS
+		}
+		rr := dnsRecord{
+			name:  name,
+			rtype: binary.BigEndian.Uint16(b[next:]),
+			ttl:   binary.BigEndian.Uint32(b[next+4:]),
+		}
+		rdlen := int(binary.BigEndian.Uint16(b[next+8:]))
+		start := next + 10
+		end := start + rdlen
+		if end > len(b) {
+			return nil, errMalformedDNS
+		}
+
+		switch rr.rtype {
+		case dnsTypePTR:
+			rr.target, _, err = readName(b, start)
+			if err != nil {
+				return nil, err
+			}
+		case dnsTypeTXT:
+			for p := start; p < end; {
+				l := int(b[p])
+				if p+1+l > end {
+					return nil, errMalformedDNS
+				}
+				rr.txt = append(rr.txt, string(b[p+1:p+1+l]))
+				p += 1 + l
+			}
+		}
+
+		msg.answers = append(msg.answers, rr)
+		off = end
+	}
+
+	return msg, nil
+}
+
+// readName decodes a possibly compressed domain name at off.
+// It returns the dotted name and the offset just past the name in the original position.
+func readName(b []byte, off int) (string, int, error) {
+	var (
+		labels []string
+		next   = -1
+		jumps  = 0
+	)
+	for {
+		if off >= len(b) {
+			return "", 0, errMalformedDNS
+		}
+		l := int(b[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case l&0xC0 == 0xC0:
+			// Compression pointer to an earlier name
+			if off+1 >= len(b) || jumps > 16 {
+				return "", 0, errMalformedDNS
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+l > len(b) {
+				return "", 0, errMalformedDNS
+			}
+			labels = append(labels, string(b[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
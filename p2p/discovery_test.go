@@ -0,0 +1,105 @@
+// Unit tests for local network discovery in GoVaultFS
+// These tests verify the DNS record encoding and that two nodes find each other over loopback multicast.
+package p2p
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDNSMessageRoundTrip checks that announcements survive encoding and decoding
+func TestDNSMessageRoundTrip(t *testing.T) {
+	msg := &dnsMessage{
+		response: true,
+		answers: []dnsRecord{
+			{name: DefaultDiscoveryService, rtype: dnsTypePTR, ttl: 30, target: "abc." + DefaultDiscoveryService},
+			{name: "abc." + DefaultDiscoveryService, rtype: dnsTypeTXT, ttl: 30, txt: []string{"id=abc", "addr=:3000"}},
+		},
+	}
+
+	out, err := parseDNSMessage(msg.pack())
+	assert.Nil(t, err)
+	assert.True(t, out.response)
+	assert.Equal(t, msg.answers, out.answers)
+}
+
+// TestDiscoveryLoopback starts two nodes on the loopback interface and checks that
+// they see each other and that only the node with the smaller ID dials.
+func TestDiscoveryLoopback(t *testing.T) {
+	lo := loopbackInterface(t)
+
+	trA := &dialRecorder{}
+	trB := &dialRecorder{}
+	a := NewDiscovery(DiscoveryOpts{ID: "a", ListenAddr: ":3000", Group: "239.255.70.70:5391", Interface: lo, Interval: 100 * time.Millisecond, Transport: trA})
+	b := NewDiscovery(DiscoveryOpts{ID: "b", ListenAddr: ":4000", Group: "239.255.70.70:5391", Interface: lo, Interval: 100 * time.Millisecond, Transport: trB})
+
+	assert.Nil(t, a.Start())
+	defer a.Close()
+	assert.Nil(t, b.Start())
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) && (len(a.Nodes()) == 0 || len(b.Nodes()) == 0) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if assert.Len(t, a.Nodes(), 1) {
+		assert.Equal(t, "b", a.Nodes()[0].ID)
+		assert.Equal(t, "127.0.0.1:4000", a.Nodes()[0].Addr)
+	}
+	assert.Len(t, b.Nodes(), 1)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"127.0.0.1:4000"}, trA.dialed())
+	assert.Empty(t, trB.dialed())
+
+	// A goodbye announcement removes the node right away
+	b.Close()
+	deadline = time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && len(a.Nodes()) > 0 {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Empty(t, a.Nodes())
+}
+
+// loopbackInterface returns the loopback interface or skips the test
+func loopbackInterface(t *testing.T) *net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			return &ifi
+		}
+	}
+	t.Skip("no loopback interface")
+	return nil
+}
+
+// dialRecorder is a Transport that only records dialed addresses
+type dialRecorder struct {
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (r *dialRecorder) Addr() string           { return "" }
+func (r *dialRecorder) ListenAndAccept() error { return nil }
+func (r *dialRecorder) Consume() <-chan RPC    { return nil }
+func (r *dialRecorder) Close() error           { return nil }
+
+func (r *dialRecorder) Dial(addr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addrs = append(r.addrs, addr)
+	return nil
+}
+
+func (r *dialRecorder) dialed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.addrs...)
+}
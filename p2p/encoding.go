@@ -3,10 +3,16 @@
 package p2p
 
 import (
+	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 	"io"
 )
 
+// maxMessageSize caps the payload length accepted by DefaultDecoder.
+// It protects a node from allocating huge buffers on a corrupt or hostile length prefix.
+const maxMessageSize = 4 << 20
+
 // Decoder is an interface for decoding network messages into RPC structs
 type Decoder interface {
 	Decode(io.Reader, *RPC) error
@@ -20,35 +26,64 @@ func (dec GOBDecoder) Decode(r io.Reader, msg *RPC) error {
 	return gob.NewDecoder(r).Decode(msg)
 }
 
-// DefaultDecoder handles both stream and raw byte messages
-// Used for decoding simple network signals and payloads
+// DefaultDecoder handles both plain messages and messages that open a data stream
+// Used for decoding the header that starts every logical stream
 type DefaultDecoder struct{}
 
 // Decode reads the first byte to check for a stream signal.
-// If it's a stream, sets msg.Stream and returns.
-// Otherwise, reads up to 1028 bytes as the message payload.
+// If it's a stream, sets msg.Stream; the data follows the message on the same stream.
+// Then it reads a little-endian uint32 length followed by exactly that many payload bytes,
+// so messages of any size survive being split or coalesced by TCP.
 func (dec DefaultDecoder) Decode(r io.Reader, msg *RPC) error {
 	peekBuf := make([]byte, 1)
-	if _, err := r.Read(peekBuf); err != nil {
-		return nil // If nothing to read, just return
+	if _, err := io.ReadFull(r, peekBuf); err != nil {
+		return err
 	}
 
-	// If the first byte is IncomingStream, this is a raw stream (not a structured message)
+	// If the first byte is IncomingStream, the message is followed by raw stream data
 	// We set Stream=true so the rest of the system can handle it appropriately
-	stream := peekBuf[0] == IncomingStream
-	if stream {
-		msg.Stream = true
-		return nil
+	msg.Stream = peekBuf[0] == IncomingStream
+	if !msg.Stream && peekBuf[0] != IncomingMessage {
+		return fmt.Errorf("unknown message type 0x%x", peekBuf[0])
+	}
+
+	// Read the length prefix and then the full payload
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return err
+	}
+	if size > maxMessageSize {
+		return fmt.Errorf("message of %d bytes exceeds limit of %d bytes", size, maxMessageSize)
 	}
 
-	// Otherwise, read the next 1028 bytes as the payload
-	buf := make([]byte, 1028)
-	n, err := r.Read(buf)
-	if err != nil {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 
-	msg.Payload = buf[:n]
+	msg.Payload = buf
 
 	return nil
 }
+
+// FrameMessage prepares a payload for sending to a peer.
+// It prefixes the payload with the IncomingMessage marker and its length,
+// producing exactly what DefaultDecoder expects on the other end.
+func FrameMessage(payload []byte) []byte {
+	return frame(IncomingMessage, payload)
+}
+
+// FrameStream prepares the header message of a data stream.
+// The receiver decodes it like a message, then reads the data that follows from RPC.Body.
+func FrameStream(payload []byte) []byte {
+	return frame(IncomingStream, payload)
+}
+
+// frame prefixes a payload with a marker byte and its length
+func frame(marker byte, payload []byte) []byte {
+	buf := make([]byte, 5+len(payload))
+	buf[0] = marker
+	binary.LittleEndian.PutUint32(buf[1:5], uint32(len(payload)))
+	copy(buf[5:], payload)
+	return buf
+}
@@ -0,0 +1,37 @@
+// Unit tests for message framing in GoVaultFS
+// This file verifies that DefaultDecoder reads back exactly what FrameMessage produced.
+package p2p
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFrameMessage checks that framed messages can be decoded back to back from one stream,
+// including payloads larger than a single read buffer.
+func TestFrameMessage(t *testing.T) {
+	small := []byte("hello")
+	large := bytes.Repeat([]byte("x"), 8*1024)
+
+	buf := new(bytes.Buffer)
+	buf.Write(FrameMessage(small))
+	buf.Write(FrameMessage(large))
+	buf.Write(FrameStream(small))
+
+	dec := DefaultDecoder{}
+
+	rpc := RPC{}
+	assert.Nil(t, dec.Decode(buf, &rpc))
+	assert.Equal(t, small, rpc.Payload)
+
+	rpc = RPC{}
+	assert.Nil(t, dec.Decode(buf, &rpc))
+	assert.Equal(t, large, rpc.Payload)
+
+	rpc = RPC{}
+	assert.Nil(t, dec.Decode(buf, &rpc))
+	assert.True(t, rpc.Stream)
+	assert.Equal(t, small, rpc.Payload)
+}
@@ -0,0 +1,270 @@
+// In-memory transport implementation for GoVaultFS P2P networking
+// This file provides a Transport that connects nodes through net.Pipe instead of sockets.
+// Nodes register virtual addresses in a MemNetwork, so many nodes can run in one process,
+// tests can run in parallel without port collisions, and no real network is involved.
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Errors returned by the in-memory network
+var (
+	ErrAddrInUse       = errors.New("mem: address already in use")
+	ErrConnRefused     = errors.New("mem: connection refused")
+	ErrTransportClosed = errors.New("mem: transport closed")
+)
+
+// memAddr is a virtual network address
+type memAddr string
+
+// Network returns the network name of a virtual address.
+func (a memAddr) Network() string { return "mem" }
+
+// String returns the virtual address.
+func (a memAddr) String() string { return string(a) }
+
+// memConn is one end of a pipe that reports virtual addresses
+type memConn struct {
+	net.Conn
+	local  memAddr
+	remote memAddr
+}
+
+// LocalAddr returns the virtual address of this end of the pipe.
+func (c *memConn) LocalAddr() net.Addr { return c.local }
+
+// RemoteAddr returns the virtual address of the other end of the pipe.
+func (c *memConn) RemoteAddr() net.Addr { return c.remote }
+
+// MemNetwork is a registry of virtual addresses that MemTransports listen on and dial.
+// Transports on different networks cannot see each other.
+type MemNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*MemTransport // Listening transports by address
+	conns     int                      // Connections made so far, used for unique remote addresses
+}
+
+// NewMemNetwork creates an empty in-memory network
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{
+		listeners: make(map[string]*MemTransport),
+	}
+}
+
+// defaultMemNetwork is used by transports that do not specify a network
+var defaultMemNetwork = NewMemNetwork()
+
+// listen registers a transport under its address
+func (n *MemNetwork) listen(t *MemTransport) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.listeners[t.ListenAddr]; ok {
+		return fmt.Errorf("%w: %s", ErrAddrInUse, t.ListenAddr)
+	}
+	n.listeners[t.ListenAddr] = t
+	return nil
+}
+
+// Listening reports whether a transport listens on addr
+func (n *MemNetwork) Listening(addr string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	_, ok := n.listeners[addr]
+	return ok
+}
+
+// unlisten removes a transport from the registry
+func (n *MemNetwork) unlisten(t *MemTransport) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.listeners[t.ListenAddr] == t {
+		delete(n.listeners, t.ListenAddr)
+	}
+}
+
+// connect creates a pipe between the dialing transport and the one listening on addr.
+// The accepting side sees the dialer under a unique address, like an ephemeral TCP port.
+func (n *MemNetwork) connect(from *MemTransport, addr string) (*memConn, *memConn, *MemTransport, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	remote, ok := n.listeners[addr]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("%w: %s", ErrConnRefused, addr)
+	}
+
+	n.conns++
+	dialer := memAddr(fmt.Sprintf("%s#%d", from.ListenAddr, n.conns))
+
+	a, b := net.Pipe()
+	local := &memConn{Conn: a, local: dialer, remote: memAddr(addr)}
+	accepted := &memConn{Conn: b, local: memAddr(addr), remote: dialer}
+	return local, accepted, remote, nil
+}
+
+// MemPeer represents a remote node connected through an in-memory pipe.
+// Like TCPPeer it multiplexes logical streams over the connection.
+type MemPeer struct {
+	sessionPeer
+}
+
+// NewMemPeer creates a new MemPeer for a given connection and direction.
+func NewMemPeer(conn net.Conn, outbound bool) *MemPeer {
+	return &MemPeer{
+		sessionPeer: newSessionPeer(conn, outbound),
+	}
+}
+
+// MemTransportOpts holds configuration for MemTransport.
+//
+//	ListenAddr       - Virtual address to listen on (any string, e.g. ":3000" or "node-1")
+//	HandshakeFunc    - Function to run on new peer connections (e.g., authentication)
+//	Decoder          - Message decoder for incoming data
+//	OnPeer           - Optional callback for handling new peers
+//	OnPeerDisconnect - Optional callback when a peer accepted by OnPeer drops
+//	WrapConn         - Optional hook that wraps every connection before use (e.g. fault injection)
+//	Logger           - Logger for connection events, slog.Default() if nil
+//	RateLimits       - Bandwidth limits of all peers together and of each peer, none if zero
+//	Network          - Network to join; a process-wide default network is used if nil
+type MemTransportOpts struct {
+	ListenAddr       string
+	HandshakeFunc    HandshakeFunc
+	Decoder          Decoder
+	OnPeer           func(Peer) error
+	OnPeerDisconnect func(Peer)
+	WrapConn         func(net.Conn) net.Conn
+	Logger           *slog.Logger
+	RateLimits       RateLimits
+	Network          *MemNetwork
+}
+
+// MemTransport connects nodes in the same process without sockets.
+// It implements the Transport interface for GoVaultFS.
+type MemTransport struct {
+	MemTransportOpts
+	rpcch        chan RPC      // Channel for incoming RPC messages
+	decodeErrors atomic.Uint64 // Streams that failed to decode
+	limiter      connLimiter   // Applies RateLimits to every connection
+
+	mu     sync.Mutex
+	peers  map[*MemPeer]bool // Open connections, closed with the transport
+	closed bool
+}
+
+// NewMemTransport creates a new MemTransport with the given options.
+func NewMemTransport(opts MemTransportOpts) *MemTransport {
+	if opts.Network == nil {
+		opts.Network = defaultMemNetwork
+	}
+	if opts.HandshakeFunc == nil {
+		opts.HandshakeFunc = NOPHandshakeFunc
+	}
+	if opts.Decoder == nil {
+		opts.Decoder = DefaultDecoder{}
+	}
+
+	return &MemTransport{
+		MemTransportOpts: opts,
+		rpcch:            make(chan RPC, 1024),
+		peers:            make(map[*MemPeer]bool),
+		limiter:          newConnLimiter(opts.RateLimits),
+	}
+}
+
+// Addr returns the virtual address the transport is listening on (Transport interface).
+func (t *MemTransport) Addr() string {
+	return t.ListenAddr
+}
+
+// Consume returns a read-only channel for incoming RPC messages (Transport interface).
+func (t *MemTransport) Consume() <-chan RPC {
+	return t.rpcch
+}
+
+// Stats returns the queue depth and decode errors of the transport.
+func (t *MemTransport) Stats() TransportStats {
+	return TransportStats{QueueDepth: len(t.rpcch), DecodeErrors: t.decodeErrors.Load()}
+}
+
+// ListenAndAccept registers the transport's address in its network (Transport interface).
+func (t *MemTransport) ListenAndAccept() error {
+	return t.Network.listen(t)
+}
+
+// Dial connects to the transport listening on addr (Transport interface).
+func (t *MemTransport) Dial(addr string) error {
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return ErrTransportClosed
+	}
+
+	local, accepted, remote, err := t.Network.connect(t, addr)
+	if err != nil {
+		return err
+	}
+
+	go remote.handleConn(accepted, false)
+	go t.handleConn(local, true)
+
+	return nil
+}
+
+// Close unregisters the address and drops every connection, like a node going away (Transport interface).
+func (t *MemTransport) Close() error {
+	t.Network.unlisten(t)
+
+	t.mu.Lock()
+	t.closed = true
+	peers := t.peers
+	t.peers = make(map[*MemPeer]bool)
+	t.mu.Unlock()
+
+	for peer := range peers {
+		peer.Close()
+	}
+	return nil
+}
+
+// handleConn serves a single peer connection until it drops.
+func (t *MemTransport) handleConn(conn net.Conn, outbound bool) {
+	if t.WrapConn != nil {
+		conn = t.WrapConn(conn)
+	}
+	conn = t.limiter.wrap(conn)
+	peer := NewMemPeer(conn, outbound)
+
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		peer.Close()
+		return
+	}
+	t.peers[peer] = true
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		delete(t.peers, peer)
+		t.mu.Unlock()
+	}()
+
+	servePeer(peer, peer.session, peerHandlers{
+		handshake:        t.HandshakeFunc,
+		decoder:          t.Decoder,
+		onPeer:           t.OnPeer,
+		onPeerDisconnect: t.OnPeerDisconnect,
+		decodeErrors:     &t.decodeErrors,
+		logger:           loggerOrDefault(t.Logger),
+	}, t.rpcch)
+}
@@ -0,0 +1,104 @@
+// Unit tests for MemTransport in GoVaultFS
+// This file verifies that in-memory transports connect, exchange messages and streams, and shut down cleanly.
+package p2p
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMemTransport connects two transports on a private network, sends a message and a data stream,
+// and checks that closing one transport drops the connection on both sides.
+func TestMemTransport(t *testing.T) {
+	t.Parallel()
+
+	network := NewMemNetwork()
+	peers := make(chan Peer, 2)
+	onPeer := func(p Peer) error { peers <- p; return nil }
+
+	a := NewMemTransport(MemTransportOpts{ListenAddr: ":3000", Network: network, OnPeer: onPeer})
+	b := NewMemTransport(MemTransportOpts{ListenAddr: ":3000", Network: network})
+	assert.False(t, network.Listening(":3000"))
+	assert.Nil(t, a.ListenAndAccept())
+	assert.True(t, network.Listening(":3000"))
+	assert.ErrorIs(t, b.ListenAndAccept(), ErrAddrInUse)
+
+	b = NewMemTransport(MemTransportOpts{ListenAddr: ":4000", Network: network})
+	assert.Nil(t, b.ListenAndAccept())
+	assert.ErrorIs(t, b.Dial(":5000"), ErrConnRefused)
+	assert.Nil(t, b.Dial(":3000"))
+
+	// a sees b under a unique address, like an ephemeral port
+	peer := <-peers
+	assert.Equal(t, "mem", peer.RemoteAddr().Network())
+	assert.Equal(t, ":4000#1", peer.RemoteAddr().String())
+
+	assert.Nil(t, peer.Send(FrameMessage([]byte("hello"))))
+	select {
+	case rpc := <-b.Consume():
+		assert.Equal(t, ":3000", rpc.From)
+		assert.Equal(t, "hello", string(rpc.Payload))
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	st, err := peer.OpenStream()
+	assert.Nil(t, err)
+	st.Write(FrameStream([]byte("header")))
+	st.Write([]byte("body"))
+	st.Close()
+
+	rpc := <-b.Consume()
+	assert.True(t, rpc.Stream)
+	body, _ := io.ReadAll(rpc.Body)
+	assert.Equal(t, "body", string(body))
+	rpc.Body.Close()
+
+	// Closing a transport drops its connections and frees the address
+	assert.Nil(t, b.Close())
+	assert.Eventually(t, func() bool { return peer.Send(FrameMessage(nil)) != nil }, time.Second, 10*time.Millisecond)
+	assert.Nil(t, NewMemTransport(MemTransportOpts{ListenAddr: ":4000", Network: network}).ListenAndAccept())
+}
+
+// TestMemTransportRateLimits checks that a per-peer send limit slows down a stream once its burst is used
+func TestMemTransportRateLimits(t *testing.T) {
+	t.Parallel()
+
+	network := NewMemNetwork()
+	peers := make(chan Peer, 1)
+	a := NewMemTransport(MemTransportOpts{
+		ListenAddr: ":3000",
+		Network:    network,
+		OnPeer:     func(p Peer) error { peers <- p; return nil },
+		RateLimits: RateLimits{PeerOut: 200 * 1024},
+	})
+	b := NewMemTransport(MemTransportOpts{ListenAddr: ":4000", Network: network})
+	assert.Nil(t, a.ListenAndAccept())
+	assert.Nil(t, b.ListenAndAccept())
+	defer a.Close()
+	defer b.Close()
+	assert.Nil(t, b.Dial(":3000"))
+	peer := <-peers
+
+	start := time.Now()
+	st, err := peer.OpenStream()
+	assert.Nil(t, err)
+	go func() {
+		st.Write(FrameStream([]byte("header")))
+		st.Write(make([]byte, 400*1024))
+		st.Close()
+	}()
+
+	rpc := <-b.Consume()
+	n, _ := io.Copy(io.Discard, rpc.Body)
+	rpc.Body.Close()
+	assert.Equal(t, int64(400*1024), n)
+
+	// The first 200 KiB are the burst, the rest take a second
+	elapsed := time.Since(start)
+	assert.Greater(t, elapsed, 800*time.Millisecond)
+	assert.Less(t, elapsed, 3*time.Second)
+}
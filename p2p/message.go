@@ -2,20 +2,25 @@
 // This file provides constants and the RPC struct for network messaging between nodes.
 package p2p
 
+import "net"
+
 // Message type constants used to identify the kind of network message received.
 const (
 	IncomingMessage = 0x1 // Indicates a regular message with payload
-	IncomingStream  = 0x2 // Indicates a stream message (e.g., file transfer)
+	IncomingStream  = 0x2 // Indicates a message followed by a data stream (e.g., file transfer)
 )
 
 // RPC represents a Remote Procedure Call message sent between nodes.
 // It is the main data structure for exchanging information over the transport layer.
+// Every RPC arrives on its own logical stream of the peer connection.
 // Fields:
 //   From    - The sender's node ID or address
 //   Payload - The actual message data or file chunk
-//   Stream  - True if this message is part of a stream (e.g., file transfer)
+//   Stream  - True if this message is followed by a data stream (e.g., file transfer)
+//   Body    - The logical stream carrying the data when Stream is set; the consumer must close it
 type RPC struct {
-	From    string // Sender identifier
-	Payload []byte // Message or file data
-	Stream  bool   // Stream flag for file/data streaming
+	From    string   // Sender identifier
+	Payload []byte   // Message or file data
+	Stream  bool     // Stream flag for file/data streaming
+	Body    net.Conn // Stream to read the data from and write replies to
 }
@@ -0,0 +1,705 @@
+// Stream multiplexing for GoVaultFS P2P connections
+// This file runs many logical streams over a single peer connection, in the spirit of yamux.
+// Every frame carries a 12 byte header: version, type, flags, stream ID and length.
+// Streams are opened with a SYN flag, half-closed with FIN and aborted with RST.
+// Each stream has its own receive window, so a slow or large transfer only ever blocks itself
+// and never the control messages or other transfers sharing the connection.
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Multiplexer protocol constants
+const (
+	muxVersion    = 0
+	muxHeaderSize = 12
+
+	// InitialStreamWindow is the number of bytes a stream may send before the receiver grants more
+	InitialStreamWindow = 256 * 1024
+
+	// maxFramePayload bounds a single data frame so streams take turns on the connection
+	maxFramePayload = 32 * 1024
+
+	// acceptBacklog is how many incoming streams may wait for Accept before new ones are refused
+	acceptBacklog = 256
+)
+
+// Frame types
+const (
+	muxTypeData         uint8 = 0 // Carries stream data, Length is the payload size
+	muxTypeWindowUpdate uint8 = 1 // Grants more send window, Length is the increment
+	muxTypeGoAway       uint8 = 2 // Session is shutting down
+)
+
+// Frame flags
+const (
+	muxFlagSYN uint16 = 1 << 0 // Opens a stream
+	muxFlagFIN uint16 = 1 << 1 // Sender will send no more data on the stream
+	muxFlagRST uint16 = 1 << 2 // Stream is aborted
+)
+
+// Multiplexer errors
+var (
+	ErrSessionClosed = errors.New("mux: session closed")
+	ErrStreamClosed  = errors.New("mux: stream closed")
+	ErrStreamReset   = errors.New("mux: stream reset by peer")
+	errProtocol      = errors.New("mux: protocol error")
+)
+
+// muxFrame is a frame waiting to be written to the connection
+type muxFrame struct {
+	typ    uint8
+	flags  uint16
+	id     uint32
+	length uint32     // Payload size for data frames, increment for window updates
+	body   []byte     // Payload for data frames
+	done   chan error // Signalled once the frame is written, nil for fire-and-forget frames
+}
+
+// Session multiplexes logical streams over a single connection.
+// The side that dialed the connection uses odd stream IDs, the accepting side even ones.
+type Session struct {
+	conn net.Conn
+
+	mu       sync.Mutex
+	nextID   uint32
+	streams  map[uint32]*Stream
+	acceptch chan *Stream
+
+	sendch     chan *muxFrame // Frames from stream owners, written in order
+	ctrlMu     sync.Mutex
+	ctrl       []*muxFrame   // Frames from the receive side that must never block
+	ctrlNotify chan struct{} // Signals that ctrl has frames
+
+	closeOnce sync.Once
+	closech   chan struct{}
+	closeErr  error // Why the session closed, nil if either side closed it deliberately
+}
+
+// NewSession starts multiplexing over conn.
+// outbound must be true on the side that dialed the connection and false on the side that accepted it.
+func NewSession(conn net.Conn, outbound bool) *Session {
+	s := &Session{
+		conn:       conn,
+		nextID:     2,
+		streams:    make(map[uint32]*Stream),
+		acceptch:   make(chan *Stream, acceptBacklog),
+		sendch:     make(chan *muxFrame, 64),
+		ctrlNotify: make(chan struct{}, 1),
+		closech:    make(chan struct{}),
+	}
+	if outbound {
+		s.nextID = 1
+	}
+
+	go s.recvLoop()
+	go s.sendLoop()
+
+	return s
+}
+
+// Open creates a new outgoing stream
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	// The SYN travels through the ordered send queue, so it always precedes the stream's data
+	if err := s.send(&muxFrame{typ: muxTypeWindowUpdate, flags: muxFlagSYN, id: id}); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// Accept waits for the remote side to open a stream.
+// Streams opened before the session closed are still handed out.
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.acceptch:
+		return st, nil
+	default:
+	}
+
+	select {
+	case st := <-s.acceptch:
+		return st, nil
+	case <-s.closech:
+		return nil, ErrSessionClosed
+	}
+}
+
+// NumStreams returns the number of open streams
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// Close tells the remote side we are going away and closes the connection and all streams
+func (s *Session) Close() error {
+	return s.closeWith(nil)
+}
+
+// Err returns why the session closed.
+// It is nil while the session is open and after either side closed it with Close.
+func (s *Session) Err() error {
+	if !s.isClosed() {
+		return nil
+	}
+	return s.closeErr
+}
+
+// closeWith closes the session, recording cause as the reason unless it is already closed
+func (s *Session) closeWith(cause error) error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.closeErr = cause
+
+		// Best effort, the remote side also notices the connection going away
+		s.conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+		s.conn.Write(encodeMuxHeader(muxTypeGoAway, 0, 0, 0))
+
+		close(s.closech)
+		err = s.conn.Close()
+
+		s.mu.Lock()
+		for _, st := range s.streams {
+			st.notify()
+		}
+		s.mu.Unlock()
+	})
+	return err
+}
+
+// CloseChan is closed when the session shuts down
+func (s *Session) CloseChan() <-chan struct{} {
+	return s.closech
+}
+
+// LocalAddr returns the local address of the underlying connection
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying connection
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// isClosed reports whether the session has shut down
+func (s *Session) isClosed() bool {
+	select {
+	case <-s.closech:
+		return true
+	default:
+		return false
+	}
+}
+
+// send queues a frame behind earlier frames and waits until it is written
+func (s *Session) send(f *muxFrame) error {
+	f.done = make(chan error, 1)
+	select {
+	case s.sendch <- f:
+	case <-s.closech:
+		return ErrSessionClosed
+	}
+	select {
+	case err := <-f.done:
+		return err
+	case <-s.closech:
+		return ErrSessionClosed
+	}
+}
+
+// sendControl queues a frame without blocking. Used by the receive side,
+// which must keep reading even while the connection is busy writing.
+func (s *Session) sendControl(f *muxFrame) {
+	s.ctrlMu.Lock()
+	s.ctrl = append(s.ctrl, f)
+	s.ctrlMu.Unlock()
+
+	select {
+	case s.ctrlNotify <- struct{}{}:
+	default:
+	}
+}
+
+// sendLoop is the only writer of the connection
+func (s *Session) sendLoop() {
+	for {
+		if err := s.flushControl(); err != nil {
+			s.closeWith(err)
+			return
+		}
+
+		select {
+		case f := <-s.sendch:
+			if err := s.flushControl(); err != nil {
+				f.done <- err
+				s.closeWith(err)
+				return
+			}
+			err := s.writeFrame(f)
+			f.done <- err
+			if err != nil {
+				s.closeWith(err)
+				return
+			}
+		case <-s.ctrlNotify:
+		case <-s.closech:
+			return
+		}
+	}
+}
+
+// flushControl writes every queued control frame
+func (s *Session) flushControl() error {
+	s.ctrlMu.Lock()
+	frames := s.ctrl
+	s.ctrl = nil
+	s.ctrlMu.Unlock()
+
+	for _, f := range frames {
+		if err := s.writeFrame(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFrame writes a header and payload with a single write
+func (s *Session) writeFrame(f *muxFrame) error {
+	buf := make([]byte, muxHeaderSize+len(f.body))
+	copy(buf, encodeMuxHeader(f.typ, f.flags, f.id, f.length))
+	copy(buf[muxHeaderSize:], f.body)
+	_, err := s.conn.Write(buf)
+	return err
+}
+
+// recvLoop reads frames and dispatches them to streams until the connection fails.
+// The remote side going away is a clean close, anything else is recorded as the reason the session closed.
+func (s *Session) recvLoop() {
+	hdr := make([]byte, muxHeaderSize)
+	for {
+		if _, err := io.ReadFull(s.conn, hdr); err != nil {
+			s.closeWith(err)
+			return
+		}
+		if hdr[0] != muxVersion {
+			s.closeWith(errProtocol)
+			return
+		}
+
+		typ := hdr[1]
+		flags := binary.BigEndian.Uint16(hdr[2:4])
+		id := binary.BigEndian.Uint32(hdr[4:8])
+		length := binary.BigEndian.Uint32(hdr[8:12])
+
+		var err error
+		switch typ {
+		case muxTypeData:
+			err = s.handleData(flags, id, length)
+		case muxTypeWindowUpdate:
+			err = s.handleWindowUpdate(flags, id, length)
+		case muxTypeGoAway:
+			s.Close()
+			return
+		default:
+			err = errProtocol
+		}
+		if err != nil {
+			s.closeWith(err)
+			return
+		}
+	}
+}
+
+// handleData delivers a data frame to its stream
+func (s *Session) handleData(flags uint16, id uint32, length uint32) error {
+	st := s.streamFor(flags, id)
+	if st == nil {
+		// Unknown or locally closed stream: drain the payload and tell the sender to stop
+		if _, err := io.CopyN(io.Discard, s.conn, int64(length)); err != nil {
+			return err
+		}
+		if flags&muxFlagRST == 0 {
+			s.sendControl(&muxFrame{typ: muxTypeWindowUpdate, flags: muxFlagRST, id: id})
+		}
+		return nil
+	}
+
+	if length > 0 {
+		if err := st.receive(s.conn, length); err != nil {
+			return err
+		}
+	}
+	st.processFlags(flags)
+	return nil
+}
+
+// handleWindowUpdate grows a stream's send window
+func (s *Session) handleWindowUpdate(flags uint16, id uint32, length uint32) error {
+	st := s.streamFor(flags, id)
+	if st == nil {
+		return nil
+	}
+	if length > 0 {
+		st.grow(length)
+	}
+	st.processFlags(flags)
+	return nil
+}
+
+// streamFor looks up a stream, registering it first if the frame opens it
+func (s *Session) streamFor(flags uint16, id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.streams[id]; ok {
+		return st
+	}
+	if flags&muxFlagSYN == 0 || s.isClosed() {
+		return nil
+	}
+
+	st := newStream(s, id)
+	select {
+	case s.acceptch <- st:
+		s.streams[id] = st
+		return st
+	default:
+		// Backlog full, refuse the stream
+		s.sendControl(&muxFrame{typ: muxTypeWindowUpdate, flags: muxFlagRST, id: id})
+		return nil
+	}
+}
+
+// removeStream forgets a stream once both directions are finished
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+}
+
+// encodeMuxHeader builds a frame header
+func encodeMuxHeader(typ uint8, flags uint16, id uint32, length uint32) []byte {
+	hdr := make([]byte, muxHeaderSize)
+	hdr[0] = muxVersion
+	hdr[1] = typ
+	binary.BigEndian.PutUint16(hdr[2:4], flags)
+	binary.BigEndian.PutUint32(hdr[4:8], id)
+	binary.BigEndian.PutUint32(hdr[8:12], length)
+	return hdr
+}
+
+// Stream is a logical, bidirectional byte stream inside a Session.
+// It implements net.Conn, so it can be used anywhere a connection is expected.
+type Stream struct {
+	session *Session
+	id      uint32
+
+	mu          sync.Mutex
+	recvBuf     bytes.Buffer // Data received but not read yet
+	recvWindow  uint32       // Bytes the remote side may still send
+	recvPending uint32       // Bytes read but not yet granted back to the sender
+	sendWindow  uint32       // Bytes we may still send
+
+	finSent     bool // We half-closed the stream
+	finReceived bool // The remote side half-closed the stream
+	readClosed  bool // Close was called, incoming data is refused
+	reset       bool // The stream was aborted
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readch  chan struct{} // Signals new data, FIN, RST or deadline changes to readers
+	writech chan struct{} // Signals window growth, RST or deadline changes to writers
+}
+
+// newStream creates a stream with full windows in both directions
+func newStream(s *Session, id uint32) *Stream {
+	return &Stream{
+		session:    s,
+		id:         id,
+		recvWindow: InitialStreamWindow,
+		sendWindow: InitialStreamWindow,
+		readch:     make(chan struct{}, 1),
+		writech:    make(chan struct{}, 1),
+	}
+}
+
+// ID returns the stream ID
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Read reads data sent by the remote side. It returns io.EOF once the remote side half-closed the stream
+// and all data has been read.
+func (st *Stream) Read(b []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.recvBuf.Len() > 0 {
+			n, _ := st.recvBuf.Read(b)
+			st.recvPending += uint32(n)
+			st.updateWindow()
+			st.mu.Unlock()
+			return n, nil
+		}
+		switch {
+		case st.reset:
+			st.mu.Unlock()
+			return 0, ErrStreamReset
+		case st.finReceived:
+			st.mu.Unlock()
+			return 0, io.EOF
+		case st.readClosed:
+			st.mu.Unlock()
+			return 0, ErrStreamClosed
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+
+		if err := st.wait(st.readch, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write sends data to the remote side, blocking while the send window is exhausted
+func (st *Stream) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		st.mu.Lock()
+		switch {
+		case st.reset:
+			st.mu.Unlock()
+			return written, ErrStreamReset
+		case st.finSent:
+			st.mu.Unlock()
+			return written, ErrStreamClosed
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+			if err := st.wait(st.writech, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		n := min(len(b)-written, int(st.sendWindow), maxFramePayload)
+		st.sendWindow -= uint32(n)
+		st.mu.Unlock()
+
+		frame := &muxFrame{typ: muxTypeData, id: st.id, length: uint32(n), body: b[written : written+n]}
+		if err := st.session.send(frame); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// CloseWrite half-closes the stream: the remote side reads io.EOF, but can still send to us
+func (st *Stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.finSent || st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.finSent = true
+	st.mu.Unlock()
+
+	err := st.session.send(&muxFrame{typ: muxTypeData, flags: muxFlagFIN, id: st.id})
+	st.maybeRemove()
+	return err
+}
+
+// Close half-closes the stream and stops reading from it.
+// Data the remote side sends afterwards is refused with a reset.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	st.readClosed = true
+	st.mu.Unlock()
+	st.notify()
+
+	err := st.CloseWrite()
+	if errors.Is(err, ErrSessionClosed) {
+		return nil
+	}
+	return err
+}
+
+// LocalAddr returns the local address of the session
+func (st *Stream) LocalAddr() net.Addr {
+	return st.session.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the session
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.session.RemoteAddr()
+}
+
+// SetDeadline sets both the read and write deadlines
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for pending and future reads
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	signal(st.readch)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for writes waiting on the send window
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	signal(st.writech)
+	return nil
+}
+
+// wait blocks until ch is signalled, the deadline passes or the session closes
+func (st *Stream) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	case <-st.session.closech:
+		// Let readers drain what already arrived before reporting the closed session
+		st.mu.Lock()
+		buffered := st.recvBuf.Len() > 0
+		st.mu.Unlock()
+		if buffered && ch == st.readch {
+			return nil
+		}
+		return ErrSessionClosed
+	}
+}
+
+// receive reads a data frame payload from the connection into the stream buffer
+func (st *Stream) receive(r io.Reader, length uint32) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if length > st.recvWindow {
+		return errProtocol
+	}
+	st.recvWindow -= length
+
+	if st.readClosed || st.reset {
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			return err
+		}
+		if !st.reset {
+			st.reset = true
+			st.session.sendControl(&muxFrame{typ: muxTypeWindowUpdate, flags: muxFlagRST, id: st.id})
+			st.session.removeStream(st.id)
+		}
+		return nil
+	}
+
+	if _, err := io.CopyN(&st.recvBuf, r, int64(length)); err != nil {
+		return err
+	}
+	signal(st.readch)
+	return nil
+}
+
+// grow adds to the send window after the remote side consumed data
+func (st *Stream) grow(delta uint32) {
+	st.mu.Lock()
+	st.sendWindow += delta
+	st.mu.Unlock()
+	signal(st.writech)
+}
+
+// processFlags applies FIN and RST flags of an incoming frame
+func (st *Stream) processFlags(flags uint16) {
+	if flags&(muxFlagFIN|muxFlagRST) == 0 {
+		return
+	}
+
+	st.mu.Lock()
+	if flags&muxFlagFIN != 0 {
+		st.finReceived = true
+	}
+	if flags&muxFlagRST != 0 {
+		st.reset = true
+	}
+	st.mu.Unlock()
+
+	st.notify()
+	st.maybeRemove()
+}
+
+// updateWindow grants consumed bytes back to the sender once enough piled up. Callers must hold mu.
+func (st *Stream) updateWindow() {
+	if st.recvPending < InitialStreamWindow/2 || st.finReceived || st.readClosed {
+		return
+	}
+	delta := st.recvPending
+	st.recvPending = 0
+	st.recvWindow += delta
+	st.session.sendControl(&muxFrame{typ: muxTypeWindowUpdate, id: st.id, length: delta})
+}
+
+// maybeRemove drops the stream from the session once both directions are done
+func (st *Stream) maybeRemove() {
+	st.mu.Lock()
+	done := st.reset || (st.finSent && st.finReceived)
+	st.mu.Unlock()
+
+	if done {
+		st.session.removeStream(st.id)
+	}
+}
+
+// notify wakes up blocked readers and writers
+func (st *Stream) notify() {
+	signal(st.readch)
+	signal(st.writech)
+}
+
+// signal does a non-blocking send on a notification channel
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,150 @@
+// Unit tests for stream multiplexing in GoVaultFS
+// These tests verify concurrent streams, flow control, half-close and resets over a single connection.
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newSessionPair connects two sessions over an in-memory pipe
+func newSessionPair() (*Session, *Session) {
+	a, b := net.Pipe()
+	return NewSession(a, true), NewSession(b, false)
+}
+
+// TestMuxConcurrentStreams sends several transfers larger than the stream window at the same time
+// and checks that each arrives intact on its own stream.
+func TestMuxConcurrentStreams(t *testing.T) {
+	client, server := newSessionPair()
+	defer client.Close()
+	defer server.Close()
+
+	const streams = 8
+	payloads := make([][]byte, streams)
+	for i := range payloads {
+		payloads[i] = make([]byte, 3*InitialStreamWindow+i)
+		rand.Read(payloads[i])
+	}
+
+	// Echo every stream back with its first byte so the client can match it
+	go func() {
+		for {
+			st, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer st.Close()
+				b, _ := io.ReadAll(st)
+				st.Write(b)
+			}()
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(p []byte) {
+			defer wg.Done()
+			st, err := client.Open()
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer st.Close()
+			_, err = st.Write(p)
+			assert.Nil(t, err)
+			assert.Nil(t, st.CloseWrite())
+
+			b, err := io.ReadAll(st)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(p, b))
+		}(payloads[i])
+	}
+	wg.Wait()
+
+	assert.Eventually(t, func() bool { return client.NumStreams() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+// TestMuxNoHeadOfLineBlocking stalls one stream that nobody reads and checks that
+// a second stream on the same connection still goes through.
+func TestMuxNoHeadOfLineBlocking(t *testing.T) {
+	client, server := newSessionPair()
+	defer client.Close()
+	defer server.Close()
+
+	stalled, _ := client.Open()
+	go stalled.Write(make([]byte, 4*InitialStreamWindow)) // Blocks once the window is used up
+
+	_, err := server.Accept()
+	assert.Nil(t, err)
+
+	st, _ := client.Open()
+	_, err = st.Write([]byte("ping"))
+	assert.Nil(t, err)
+	st.CloseWrite()
+
+	other, err := server.Accept()
+	assert.Nil(t, err)
+	other.SetReadDeadline(time.Now().Add(time.Second))
+	b, err := io.ReadAll(other)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(b))
+}
+
+// TestMuxReset checks that writing to a stream the remote side closed fails with a reset
+func TestMuxReset(t *testing.T) {
+	client, server := newSessionPair()
+	defer client.Close()
+	defer server.Close()
+
+	st, _ := client.Open()
+	st.Write([]byte("hello"))
+
+	remote, _ := server.Accept()
+	remote.Close()
+
+	var err error
+	assert.Eventually(t, func() bool {
+		_, err = st.Write([]byte("more"))
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, err, ErrStreamReset)
+}
+
+// TestMuxReadDeadline checks that reads time out
+func TestMuxReadDeadline(t *testing.T) {
+	client, server := newSessionPair()
+	defer client.Close()
+	defer server.Close()
+
+	st, _ := client.Open()
+	st.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := st.Read(make([]byte, 1))
+	assert.True(t, err.(net.Error).Timeout())
+}
+
+// TestMuxCloseReason checks that a session closed by either side reports no error
+// and one whose connection broke reports why
+func TestMuxCloseReason(t *testing.T) {
+	client, server := newSessionPair()
+	assert.Nil(t, client.Err())
+
+	client.Close()
+	<-server.CloseChan()
+	assert.Nil(t, client.Err())
+	assert.Nil(t, server.Err())
+
+	a, b := net.Pipe()
+	client, server = NewSession(a, true), NewSession(b, false)
+	a.Close() // No goodbye, as if the connection broke
+	<-server.CloseChan()
+	assert.ErrorIs(t, server.Err(), io.EOF)
+}
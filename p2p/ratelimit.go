@@ -0,0 +1,69 @@
+// Bandwidth limits for GoVaultFS transports
+// A transport can bound the traffic of all its peers together and of each peer on its own, separately for
+// each direction. Limits apply to the connection below the multiplexer, so they cover every stream,
+// gossip message and frame header exchanged with a peer.
+package p2p
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/ratelimit"
+)
+
+// RateLimits bound the bandwidth of a transport in bytes per second, 0 for no limit
+type RateLimits struct {
+	In      float64 // Received from all peers together
+	Out     float64 // Sent to all peers together
+	PeerIn  float64 // Received from each peer
+	PeerOut float64 // Sent to each peer
+}
+
+// connLimiter applies the rate limits of a transport to its connections
+type connLimiter struct {
+	limits  RateLimits
+	in, out *ratelimit.Limiter // Shared by all connections
+}
+
+// newConnLimiter creates the shared limiters of a transport
+func newConnLimiter(limits RateLimits) connLimiter {
+	return connLimiter{limits: limits, in: newLimiter(limits.In), out: newLimiter(limits.Out)}
+}
+
+// newLimiter creates a limiter allowing one second of traffic in a burst, or nil for no limit
+func newLimiter(rate float64) *ratelimit.Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return ratelimit.NewLimiter(rate, 0)
+}
+
+// wrap limits a new connection by the transport's limits and limits of its own
+func (c connLimiter) wrap(conn net.Conn) net.Conn {
+	if c.limits == (RateLimits{}) {
+		return conn
+	}
+	return &limitedConn{
+		Conn: conn,
+		r:    ratelimit.NewReader(context.Background(), conn, c.in, newLimiter(c.limits.PeerIn)),
+		w:    ratelimit.NewWriter(context.Background(), conn, c.out, newLimiter(c.limits.PeerOut)),
+	}
+}
+
+// limitedConn is a connection whose reads and writes wait for their rate limits
+type limitedConn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+}
+
+// Read reads from the connection within the receive limits
+func (c *limitedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// Write writes to the connection within the send limits
+func (c *limitedConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
@@ -0,0 +1,393 @@
+// Fault-injection network simulator for GoVaultFS
+// This file provides a SimNetwork that sits between transports and their connections and injects
+// latency, packet loss, connection resets, partitions and slow links. Every connection draws its random
+// decisions from its own RNG, seeded from the network seed and the nodes it links, so the faults a link sees
+// follow the seed no matter how the goroutines of other connections are scheduled.
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned by the simulated network
+var (
+	ErrPartitioned = errors.New("sim: network partitioned")
+	ErrConnReset   = errors.New("sim: connection reset")
+)
+
+// DefaultRetransmitTimeout is the delay a dropped packet adds before it is delivered again
+const DefaultRetransmitTimeout = 200 * time.Millisecond
+
+// maxRetransmits caps how many times in a row a single packet can be dropped
+const maxRetransmits = 8
+
+// SimNetwork injects faults into the connections of the transports it wraps.
+//
+// Connections are reliable byte streams, so faults are modelled the way TCP experiences them:
+//   - latency delays every write by a base delay plus random jitter, without limiting throughput
+//   - a dropped packet is retransmitted after RetransmitTimeout, so loss shows up as extra delay
+//   - resets close the connection on both ends
+//   - partitions reset every connection between groups and refuse new dials until healed
+//   - slow nodes have their links capped at a number of bytes per second
+//
+// Faults are applied on the sending side of each connection, so every direction is delayed once.
+// Nodes are identified by their listen address; connections accepted from a dialer are attributed
+// to the dialer's listen address when the transport exposes it (as MemTransport does).
+type SimNetwork struct {
+	RetransmitTimeout time.Duration // Delay added per dropped packet (default 200ms)
+
+	mu        sync.Mutex
+	seed      int64
+	links     map[string]int // Connections opened so far by link, so each one gets its own RNG
+	latency   time.Duration
+	jitter    time.Duration
+	dropRate  float64
+	resetRate float64
+	slow      map[string]int    // Link capacity in bytes per second by node
+	groups    map[string]int    // Partition group by node, nil when the network is whole
+	conns     map[*simConn]bool // Open connections, so faults can reach them
+}
+
+// NewSimNetwork creates a fault-free simulated network whose random decisions follow seed
+func NewSimNetwork(seed int64) *SimNetwork {
+	return &SimNetwork{
+		RetransmitTimeout: DefaultRetransmitTimeout,
+		seed:              seed,
+		links:             make(map[string]int),
+		slow:              make(map[string]int),
+		conns:             make(map[*simConn]bool),
+	}
+}
+
+// SetLatency delays every write by base plus a random duration up to jitter
+func (n *SimNetwork) SetLatency(base, jitter time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.latency, n.jitter = base, jitter
+}
+
+// SetDropRate sets the probability that a packet is lost and has to be retransmitted
+func (n *SimNetwork) SetDropRate(rate float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dropRate = rate
+}
+
+// SetResetRate sets the probability that a write resets its connection
+func (n *SimNetwork) SetResetRate(rate float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.resetRate = rate
+}
+
+// SetSlow caps every link of a node at bytesPerSecond. Zero removes the cap.
+func (n *SimNetwork) SetSlow(addr string, bytesPerSecond int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if bytesPerSecond <= 0 {
+		delete(n.slow, addr)
+		return
+	}
+	n.slow[addr] = bytesPerSecond
+}
+
+// Partition splits the network into groups of node addresses that cannot reach each other.
+// Nodes not listed in any group form one more group together.
+// Existing connections between groups are reset.
+func (n *SimNetwork) Partition(groups ...[]string) {
+	n.mu.Lock()
+	n.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, addr := range group {
+			n.groups[addr] = i + 1
+		}
+	}
+	cut := []*simConn{}
+	for c := range n.conns {
+		if n.partitioned(c.local, c.remote) {
+			cut = append(cut, c)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, c := range cut {
+		c.reset()
+	}
+}
+
+// Heal removes all partitions. Nodes have to reconnect on their own.
+func (n *SimNetwork) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.groups = nil
+}
+
+// Reset closes every connection of a node, as if its links flapped
+func (n *SimNetwork) Reset(addr string) {
+	n.mu.Lock()
+	cut := []*simConn{}
+	for c := range n.conns {
+		if c.local == addr || c.remote == addr {
+			cut = append(cut, c)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, c := range cut {
+		c.reset()
+	}
+}
+
+// Wrap returns a transport whose connections go through the simulated network.
+// Faults on established connections need a transport with a WrapConn hook (TCPTransport, MemTransport);
+// any other transport only sees partitions when dialing.
+func (n *SimNetwork) Wrap(t Transport) *SimTransport {
+	st := &SimTransport{Transport: t, network: n}
+
+	switch tr := t.(type) {
+	case *MemTransport:
+		tr.WrapConn = st.wrapConn
+	case *TCPTransport:
+		tr.WrapConn = st.wrapConn
+	}
+
+	return st
+}
+
+// partitioned reports whether two nodes are in different partition groups. Caller holds n.mu.
+func (n *SimNetwork) partitioned(a, b string) bool {
+	if n.groups == nil {
+		return false
+	}
+	return n.groups[a] != n.groups[b]
+}
+
+// connRand returns the RNG of a new connection from local to remote.
+// The n-th connection of a link always gets the same RNG for a given seed.
+func (n *SimNetwork) connRand(local, remote string) *rand.Rand {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	link := local + ">" + remote
+	n.links[link]++
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s/%d", n.seed, link, n.links[link])
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// fault decides what happens to a write on c:
+// how long it takes to arrive, the link capacity, and whether the connection resets instead
+func (n *SimNetwork) fault(c *simConn) (delay time.Duration, bytesPerSecond int, reset bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.partitioned(c.local, c.remote) {
+		return 0, 0, true
+	}
+	if n.resetRate > 0 && c.rng.Float64() < n.resetRate {
+		return 0, 0, true
+	}
+
+	delay = n.latency
+	if n.jitter > 0 {
+		delay += time.Duration(c.rng.Int63n(int64(n.jitter)))
+	}
+	for i := 0; i < maxRetransmits && n.dropRate > 0 && c.rng.Float64() < n.dropRate; i++ {
+		delay += n.RetransmitTimeout
+	}
+
+	for _, addr := range []string{c.local, c.remote} {
+		if bps, ok := n.slow[addr]; ok && (bytesPerSecond == 0 || bps < bytesPerSecond) {
+			bytesPerSecond = bps
+		}
+	}
+
+	return delay, bytesPerSecond, false
+}
+
+// register tracks an open connection
+func (n *SimNetwork) register(c *simConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.conns[c] = true
+}
+
+// unregister forgets a closed connection
+func (n *SimNetwork) unregister(c *simConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.conns, c)
+}
+
+// SimTransport is a Transport whose connections run over a SimNetwork
+type SimTransport struct {
+	Transport
+	network *SimNetwork
+}
+
+// Dial connects to addr unless a partition separates it from this node (Transport interface).
+func (t *SimTransport) Dial(addr string) error {
+	t.network.mu.Lock()
+	partitioned := t.network.partitioned(t.Addr(), addr)
+	t.network.mu.Unlock()
+	if partitioned {
+		return fmt.Errorf("%w: %s -> %s", ErrPartitioned, t.Addr(), addr)
+	}
+
+	return t.Transport.Dial(addr)
+}
+
+// wrapConn routes a connection of the wrapped transport through the simulated network
+func (t *SimTransport) wrapConn(conn net.Conn) net.Conn {
+	c := &simConn{
+		Conn:    conn,
+		network: t.network,
+		local:   t.Addr(),
+		remote:  simNode(conn.RemoteAddr().String()),
+		done:    make(chan struct{}),
+	}
+	c.rng = t.network.connRand(c.local, c.remote)
+	c.cond = sync.NewCond(&c.mu)
+
+	t.network.register(c)
+	go c.pump()
+
+	return c
+}
+
+// simNode maps a remote address to the node it belongs to.
+// MemNetwork names dialers "<listen addr>#<n>", so the suffix is stripped.
+func simNode(addr string) string {
+	if i := strings.LastIndex(addr, "#"); i >= 0 {
+		return addr[:i]
+	}
+	return addr
+}
+
+// simPacket is a write waiting to be delivered
+type simPacket struct {
+	data []byte
+	at   time.Time // When the packet arrives at the other end
+}
+
+// simConn is a connection whose writes are delivered by a background pump after the simulated delay
+type simConn struct {
+	net.Conn
+	network *SimNetwork
+	local   string     // Node this end belongs to
+	remote  string     // Node at the other end
+	rng     *rand.Rand // Random decisions for this connection, guarded by network.mu
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []simPacket
+	sent   time.Time // When the link finishes transmitting the last queued packet
+	last   time.Time // Arrival time of the last queued packet, keeps packets in order
+	closed bool
+	err    error
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Write queues b for delivery after the simulated delay. It never blocks on the network.
+func (c *simConn) Write(b []byte) (int, error) {
+	delay, bps, reset := c.network.fault(c)
+	if reset {
+		c.reset()
+		return 0, ErrConnReset
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, c.err
+	}
+
+	// Serialise the packet onto the link, then let it travel
+	now := time.Now()
+	if c.sent.Before(now) {
+		c.sent = now
+	}
+	if bps > 0 {
+		c.sent = c.sent.Add(time.Duration(len(b)) * time.Second / time.Duration(bps))
+	}
+	at := c.sent.Add(delay)
+	if at.Before(c.last) {
+		at = c.last
+	}
+	c.last = at
+
+	c.queue = append(c.queue, simPacket{data: append([]byte(nil), b...), at: at})
+	c.cond.Signal()
+
+	return len(b), nil
+}
+
+// Close closes the connection, discarding packets still in flight
+func (c *simConn) Close() error {
+	return c.closeWith(net.ErrClosed)
+}
+
+// reset closes the connection because of an injected fault
+func (c *simConn) reset() {
+	c.closeWith(ErrConnReset)
+}
+
+// closeWith closes the connection once, recording the error later writes return
+func (c *simConn) closeWith(err error) error {
+	var cerr error
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.err = err
+		c.queue = nil
+		c.cond.Broadcast()
+		c.mu.Unlock()
+
+		close(c.done)
+		c.network.unregister(c)
+		cerr = c.Conn.Close()
+	})
+	return cerr
+}
+
+// pump delivers queued packets in order once they are due
+func (c *simConn) pump() {
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 && !c.closed {
+			c.cond.Wait()
+		}
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		p := c.queue[0]
+		c.queue = c.queue[1:]
+		c.mu.Unlock()
+
+		if d := time.Until(p.at); d > 0 {
+			timer := time.NewTimer(d)
+			select {
+			case <-timer.C:
+			case <-c.done:
+				timer.Stop()
+				return
+			}
+		}
+
+		if _, err := c.Conn.Write(p.data); err != nil {
+			c.closeWith(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Unit tests for the fault-injection network simulator in GoVaultFS
+// This file verifies that simulated latency delays delivery and that partitions cut and refuse connections.
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSimNetworkLatency checks that a message takes at least the configured latency to arrive
+func TestSimNetworkLatency(t *testing.T) {
+	t.Parallel()
+
+	network := NewMemNetwork()
+	sim := NewSimNetwork(1)
+	sim.SetLatency(100*time.Millisecond, 0)
+
+	peers := make(chan Peer, 1)
+	a := sim.Wrap(NewMemTransport(MemTransportOpts{ListenAddr: ":3000", Network: network}))
+	b := sim.Wrap(NewMemTransport(MemTransportOpts{
+		ListenAddr: ":4000",
+		Network:    network,
+		OnPeer:     func(p Peer) error { peers <- p; return nil },
+	}))
+	assert.Nil(t, a.ListenAndAccept())
+	assert.Nil(t, b.ListenAndAccept())
+	defer a.Close()
+	defer b.Close()
+
+	assert.Nil(t, b.Dial(":3000"))
+	peer := <-peers
+
+	start := time.Now()
+	assert.Nil(t, peer.Send(FrameMessage([]byte("hello"))))
+	select {
+	case rpc := <-a.Consume():
+		assert.Equal(t, "hello", string(rpc.Payload))
+		assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not delivered")
+	}
+}
+
+// TestSimNetworkPartition checks that a partition drops existing connections on both ends,
+// refuses new dials across it, and that nodes can connect again once it heals
+func TestSimNetworkPartition(t *testing.T) {
+	t.Parallel()
+
+	network := NewMemNetwork()
+	sim := NewSimNetwork(1)
+
+	dropped := make(chan Peer, 2)
+	newTransport := func(addr string) *SimTransport {
+		return sim.Wrap(NewMemTransport(MemTransportOpts{
+			ListenAddr:       addr,
+			Network:          network,
+			OnPeerDisconnect: func(p Peer) { dropped <- p },
+		}))
+	}
+	a := newTransport(":3000")
+	b := newTransport(":4000")
+	assert.Nil(t, a.ListenAndAccept())
+	assert.Nil(t, b.ListenAndAccept())
+	defer a.Close()
+	defer b.Close()
+
+	assert.Nil(t, b.Dial(":3000"))
+	assert.Eventually(t, func() bool {
+		sim.mu.Lock()
+		defer sim.mu.Unlock()
+		return len(sim.conns) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	sim.Partition([]string{":3000"}, []string{":4000"})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-dropped:
+		case <-time.After(time.Second):
+			t.Fatal("partition did not drop the connection")
+		}
+	}
+	assert.ErrorIs(t, b.Dial(":3000"), ErrPartitioned)
+
+	sim.Heal()
+	assert.Nil(t, b.Dial(":3000"))
+}
@@ -4,60 +4,59 @@ package p2p
 
 import (
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 )
 
 // TCPPeer represents a remote node connected via TCP.
-// It wraps the net.Conn and tracks whether the connection is outbound (initiated by us) or inbound (accepted from another node).
-// The WaitGroup is used for synchronizing stream operations (e.g., file transfers).
+// It wraps the net.Conn in a multiplexed Session and tracks whether the connection is outbound (initiated by us)
+// or inbound (accepted from another node). Every message and file transfer uses its own logical stream.
 type TCPPeer struct {
-	net.Conn                 // Underlying TCP connection
-	outbound bool            // True if connection was dialed (outbound), false if accepted (inbound)
-	wg       *sync.WaitGroup // Used to block/unblock stream operations
+	sessionPeer
 }
 
 // NewTCPPeer creates a new TCPPeer instance for a given connection and direction.
 func NewTCPPeer(conn net.Conn, outbound bool) *TCPPeer {
 	return &TCPPeer{
-		Conn:     conn,
-		outbound: outbound,
-		wg:       &sync.WaitGroup{},
+		sessionPeer: newSessionPeer(conn, outbound),
 	}
 }
 
-// CloseStream signals that a stream operation (e.g., file transfer) is complete for this peer.
-func (p *TCPPeer) CloseStream() {
-	p.wg.Done()
-}
-
-// Send writes a byte slice to the peer's TCP connection.
-func (p *TCPPeer) Send(b []byte) error {
-	_, err := p.Conn.Write(b)
-	return err
-}
-
 // TCPTransportOpts holds configuration for TCPTransport.
 //
-//	ListenAddr    - Address to listen for incoming connections
-//	HandshakeFunc - Function to run on new peer connections (e.g., authentication)
-//	Decoder       - Message decoder for incoming data
-//	OnPeer        - Optional callback for handling new peers
+//	ListenAddr       - Address to listen for incoming connections
+//	HandshakeFunc    - Function to run on new peer connections (e.g., authentication)
+//	Decoder          - Message decoder for incoming data
+//	OnPeer           - Optional callback for handling new peers
+//	OnPeerDisconnect - Optional callback when a peer accepted by OnPeer drops
+//	WrapConn         - Optional hook that wraps every connection before use (e.g. fault injection)
+//	Logger           - Logger for connection events, slog.Default() if nil
+//	RateLimits       - Bandwidth limits of all peers together and of each peer, none if zero
 type TCPTransportOpts struct {
-	ListenAddr    string
-	HandshakeFunc HandshakeFunc
-	Decoder       Decoder
-	OnPeer        func(Peer) error
+	ListenAddr       string
+	HandshakeFunc    HandshakeFunc
+	Decoder          Decoder
+	OnPeer           func(Peer) error
+	OnPeerDisconnect func(Peer)
+	WrapConn         func(net.Conn) net.Conn
+	Logger           *slog.Logger
+	RateLimits       RateLimits
 }
 
 // TCPTransport manages TCP connections and message passing between peers.
 // It implements the Transport interface for GoVaultFS.
 type TCPTransport struct {
-	TCPTransportOpts              // Configuration options
-	listener         net.Listener // TCP listener for incoming connections
-	rpcch            chan RPC     // Channel for incoming RPC messages
+	TCPTransportOpts               // Configuration options
+	listener         net.Listener  // TCP listener for incoming connections
+	rpcch            chan RPC      // Channel for incoming RPC messages
+	decodeErrors     atomic.Uint64 // Streams that failed to decode
+	limiter          connLimiter   // Applies RateLimits to every connection
+
+	mu     sync.Mutex
+	peers  map[*TCPPeer]bool // Open connections, closed with the transport
+	closed bool
 }
 
 // NewTCPTransport creates a new TCPTransport with the given options.
@@ -66,6 +65,8 @@ func NewTCPTransport(opts TCPTransportOpts) *TCPTransport {
 	return &TCPTransport{
 		TCPTransportOpts: opts,
 		rpcch:            make(chan RPC, 1024),
+		peers:            make(map[*TCPPeer]bool),
+		limiter:          newConnLimiter(opts.RateLimits),
 	}
 }
 
@@ -79,8 +80,23 @@ func (t *TCPTransport) Consume() <-chan RPC {
 	return t.rpcch
 }
 
-// Close shuts down the TCP listener (Transport interface).
+// Stats returns the queue depth and decode errors of the transport.
+func (t *TCPTransport) Stats() TransportStats {
+	return TransportStats{QueueDepth: len(t.rpcch), DecodeErrors: t.decodeErrors.Load()}
+}
+
+// Close shuts down the TCP listener and every peer connection (Transport interface).
+// Peers are told the connection goes away, so they see a clean shutdown.
 func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	peers := t.peers
+	t.peers = make(map[*TCPPeer]bool)
+	t.mu.Unlock()
+
+	for peer := range peers {
+		peer.Close()
+	}
 	return t.listener.Close()
 }
 
@@ -109,7 +125,7 @@ func (t *TCPTransport) ListenAndAccept() error {
 
 	go t.startAcceptLoop()
 
-	log.Printf("TCP transport listening on port: %s\n", t.ListenAddr)
+	loggerOrDefault(t.Logger).Info("TCP transport listening", "addr", t.ListenAddr)
 
 	return nil
 }
@@ -124,7 +140,8 @@ func (t *TCPTransport) startAcceptLoop() {
 		}
 
 		if err != nil {
-			fmt.Printf("TCP accept error: %s\n", err)
+			loggerOrDefault(t.Logger).Warn("TCP accept error", "err", err)
+			continue
 		}
 
 		// Handle the new inbound connection in a separate goroutine
@@ -132,52 +149,36 @@ func (t *TCPTransport) startAcceptLoop() {
 	}
 }
 
-// handleConn manages a single peer connection, performing handshake, peer callback, and message read loop.
-//   - If handshake fails, the connection is dropped.
-//   - If OnPeer callback is set and fails, the connection is dropped.
-//   - In the read loop, decodes incoming RPC messages and handles stream synchronization.
+// handleConn manages a single peer connection, performing handshake, peer callback, and stream accept loop.
 func (t *TCPTransport) handleConn(conn net.Conn, outbound bool) {
-	var err error
-
-	defer func() {
-		fmt.Printf("dropping peer connection: %s", err)
-		conn.Close()
-	}()
+	if t.WrapConn != nil {
+		conn = t.WrapConn(conn)
+	}
+	conn = t.limiter.wrap(conn)
 
 	peer := NewTCPPeer(conn, outbound)
 
-	// Run handshake logic (e.g., authentication, protocol negotiation)
-	if err = t.HandshakeFunc(peer); err != nil {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		peer.Close()
 		return
 	}
+	t.peers[peer] = true
+	t.mu.Unlock()
 
-	// Optional callback for custom peer handling
-	if t.OnPeer != nil {
-		if err = t.OnPeer(peer); err != nil {
-			return
-		}
-	}
-
-	// Read loop: decode messages and handle streams
-	for {
-		rpc := RPC{}
-		err = t.Decoder.Decode(conn, &rpc)
-		if err != nil {
-			return // On decode error, drop connection
-		}
-
-		rpc.From = conn.RemoteAddr().String() // Set sender address
-
-		if rpc.Stream {
-			// If this is a stream message, block until stream is closed
-			peer.wg.Add(1)
-			fmt.Printf("[%s] incoming stream, waiting...\n", conn.RemoteAddr())
-			peer.wg.Wait()
-			fmt.Printf("[%s] stream closed, resuming read loop\n", conn.RemoteAddr())
-			continue
-		}
+	defer func() {
+		t.mu.Lock()
+		delete(t.peers, peer)
+		t.mu.Unlock()
+	}()
 
-		// Forward regular RPC message to channel for consumption
-		t.rpcch <- rpc
-	}
+	servePeer(peer, peer.session, peerHandlers{
+		handshake:        t.HandshakeFunc,
+		decoder:          t.Decoder,
+		onPeer:           t.OnPeer,
+		onPeerDisconnect: t.OnPeerDisconnect,
+		decodeErrors:     &t.decodeErrors,
+		logger:           loggerOrDefault(t.Logger),
+	}, t.rpcch)
 }
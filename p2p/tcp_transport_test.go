@@ -3,7 +3,12 @@
 package p2p
 
 import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -27,3 +32,66 @@ func TestTCPTransport(t *testing.T) {
 	// Attempt to start listening and accepting connections; should not return an error
 	assert.Nil(t, tr.ListenAndAccept())
 }
+
+// syncBuffer is a buffer that log handlers can write to while a test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestTCPTransportShutdown checks that closing a transport closes its peer connections cleanly,
+// so the peer does not log them as dropped, while a broken connection is logged as a warning
+func TestTCPTransportShutdown(t *testing.T) {
+	t.Parallel()
+
+	logs := new(syncBuffer)
+	peers := make(chan Peer, 4)
+	a := NewTCPTransport(TCPTransportOpts{
+		ListenAddr:    "127.0.0.1:0",
+		HandshakeFunc: NOPHandshakeFunc,
+		Decoder:       DefaultDecoder{},
+		OnPeer:        func(p Peer) error { peers <- p; return nil },
+		Logger:        slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	assert.Nil(t, a.ListenAndAccept())
+	defer a.Close()
+
+	dial := func() *TCPTransport {
+		b := NewTCPTransport(TCPTransportOpts{
+			ListenAddr:    "127.0.0.1:0",
+			HandshakeFunc: NOPHandshakeFunc,
+			Decoder:       DefaultDecoder{},
+			Logger:        slog.New(slog.DiscardHandler),
+		})
+		assert.Nil(t, b.ListenAndAccept())
+		assert.Nil(t, b.Dial(a.listener.Addr().String()))
+		return b
+	}
+
+	// A clean shutdown is only logged at debug level
+	b := dial()
+	<-peers
+	assert.Nil(t, b.Close())
+	assert.Eventually(t, func() bool { return strings.Contains(logs.String(), "peer connection closed") }, time.Second, 10*time.Millisecond)
+	assert.NotContains(t, logs.String(), "dropping peer connection")
+
+	// A connection that breaks without a goodbye is a warning naming the peer
+	b = dial()
+	defer b.Close()
+	peer := <-peers
+	peer.(*TCPPeer).conn.Conn.Close()
+	assert.Eventually(t, func() bool { return strings.Contains(logs.String(), "level=WARN msg=\"dropping peer connection\"") }, time.Second, 10*time.Millisecond)
+	assert.Contains(t, logs.String(), "peer="+peer.RemoteAddr().String())
+}
@@ -5,13 +5,16 @@ package p2p
 import "net"
 
 // Peer abstracts a remote node in the network.
-// It embeds net.Conn for low-level network operations and adds methods for sending data and managing streams.
-//   Send([]byte) error   - Send raw bytes to the peer
-//   CloseStream()        - Signal the end of a stream (e.g., file transfer)
+// A peer multiplexes many logical streams over one connection, so transfers never block each other:
+//   RemoteAddr() net.Addr          - Address of the remote end of the connection
+//   Close() error                  - Close the connection and all of its streams
+//   Send([]byte) error             - Send a framed message on its own stream
+//   OpenStream() (net.Conn, error) - Open a new logical stream (e.g., for a file transfer)
 type Peer interface {
-	net.Conn
+	RemoteAddr() net.Addr
+	Close() error
 	Send([]byte) error
-	CloseStream()
+	OpenStream() (net.Conn, error)
 }
 
 // Transport abstracts any communication channel between nodes (TCP, UDP, WebSockets, etc).
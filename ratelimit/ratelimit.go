@@ -0,0 +1,184 @@
+// Package ratelimit bounds the bandwidth of GoVaultFS traffic with token buckets.
+// A Limiter refills at a rate in bytes per second up to a burst; readers and writers wrapped by NewReader and
+// NewWriter wait on one or more limiters for every chunk they move, so a transfer can be bounded globally,
+// per peer and per class of traffic at once.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxChunk bounds the bytes a wrapped reader or writer moves per wait, so traffic is spread evenly
+const maxChunk = 32 * 1024
+
+// Limiter is a token bucket counting bytes. A limiter with a rate of 0, or a nil limiter, does not limit.
+// Waits take tokens in advance, so a limiter shared by concurrent transfers gives each its turn in order.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64   // Tokens added per second
+	burst  float64   // Most tokens the bucket holds
+	tokens float64   // Tokens available, negative while waits are queued
+	last   time.Time // When tokens was last brought up to date
+}
+
+// NewLimiter creates a limiter of rate bytes per second that allows bursts of burst bytes.
+// A burst of 0 allows one second of traffic.
+func NewLimiter(rate float64, burst int) *Limiter {
+	l := &Limiter{}
+	l.SetLimit(rate, burst)
+	l.tokens = l.burst
+	return l
+}
+
+// SetLimit changes the rate and burst of the limiter, keeping the tokens it has
+func (l *Limiter) SetLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.advance(time.Now())
+	l.rate = max(rate, 0)
+	l.burst = float64(burst)
+	if burst <= 0 {
+		l.burst = l.rate
+	}
+	l.tokens = min(l.tokens, l.burst)
+}
+
+// Limit returns the rate of the limiter in bytes per second, 0 if it does not limit
+func (l *Limiter) Limit() float64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// advance adds the tokens refilled since the last update
+func (l *Limiter) advance(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
+
+// reserve takes n tokens and returns how long to wait until they are covered
+func (l *Limiter) reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+	l.advance(time.Now())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// WaitN blocks until the limiter allows n bytes or ctx is done
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	return Wait(ctx, n, l)
+}
+
+// Wait blocks until every limiter allows n bytes or ctx is done.
+// Tokens taken are not returned when ctx ends the wait.
+func Wait(ctx context.Context, n int, limiters ...*Limiter) error {
+	var wait time.Duration
+	for _, l := range limiters {
+		wait = max(wait, l.reserve(n))
+	}
+	if wait == 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// active returns the limiters that limit, nil if none does
+func active(limiters []*Limiter) []*Limiter {
+	var out []*Limiter
+	for _, l := range limiters {
+		if l != nil {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// reader waits on its limiters for the bytes read
+type reader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*Limiter
+}
+
+// NewReader returns a reader that reads from r no faster than every limiter allows.
+// Waits end early with the error of ctx. It returns r itself if no limiter is given.
+func NewReader(ctx context.Context, r io.Reader, limiters ...*Limiter) io.Reader {
+	limiters = active(limiters)
+	if len(limiters) == 0 {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, limiters: limiters}
+}
+
+// Read reads at most one chunk and waits until the limiters allow it
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:min(len(p), maxChunk)])
+	if n > 0 {
+		if werr := Wait(r.ctx, n, r.limiters...); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// writer waits on its limiters before every chunk it writes
+type writer struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*Limiter
+}
+
+// NewWriter returns a writer that writes to w no faster than every limiter allows.
+// Waits end early with the error of ctx. It returns w itself if no limiter is given.
+func NewWriter(ctx context.Context, w io.Writer, limiters ...*Limiter) io.Writer {
+	limiters = active(limiters)
+	if len(limiters) == 0 {
+		return w
+	}
+	return &writer{ctx: ctx, w: w, limiters: limiters}
+}
+
+// Write writes p in chunks, waiting until the limiters allow each one
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:min(len(p), written+maxChunk)]
+		if err := Wait(w.ctx, len(chunk), w.limiters...); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,69 @@
+// Tests for the ratelimit package
+// Rates are high enough that each test takes a fraction of a second; timings are checked with generous margins.
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLimiter checks that a limiter allows its burst at once and the rest at its rate
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	l := NewLimiter(100*1024, 10*1024)
+	ctx := context.Background()
+
+	start := time.Now()
+	assert.Nil(t, l.WaitN(ctx, 10*1024))
+	assert.Less(t, time.Since(start), 20*time.Millisecond)
+
+	assert.Nil(t, l.WaitN(ctx, 20*1024))
+	assert.InDelta(t, 200*time.Millisecond, time.Since(start), float64(80*time.Millisecond))
+
+	// A cancelled wait returns at once
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, l.WaitN(cctx, 100*1024), context.Canceled)
+
+	// No rate, or no limiter, means no limit
+	l.SetLimit(0, 0)
+	assert.Equal(t, float64(0), l.Limit())
+	assert.Nil(t, l.WaitN(ctx, 1<<30))
+	var none *Limiter
+	assert.Nil(t, none.WaitN(ctx, 1<<30))
+}
+
+// TestReaderWriter copies through wrapped readers and writers bounded by the slowest of several limiters
+func TestReaderWriter(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("x"), 64*1024)
+	fast, slow := NewLimiter(10*1024*1024, 0), NewLimiter(256*1024, 32*1024)
+
+	start := time.Now()
+	buf := new(bytes.Buffer)
+	n, err := io.Copy(buf, NewReader(context.Background(), bytes.NewReader(data), fast, slow))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.InDelta(t, 125*time.Millisecond, time.Since(start), float64(60*time.Millisecond))
+
+	start = time.Now()
+	buf.Reset()
+	w := NewWriter(context.Background(), buf, nil, slow)
+	n2, err := w.Write(data)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), n2)
+	assert.Equal(t, data, buf.Bytes())
+	assert.InDelta(t, 250*time.Millisecond, time.Since(start), float64(60*time.Millisecond))
+
+	// Without limiters the reader and writer are not wrapped
+	r := bytes.NewReader(data)
+	assert.Same(t, io.Reader(r), NewReader(context.Background(), r, nil))
+	assert.Same(t, io.Writer(buf), NewWriter(context.Background(), buf))
+}
@@ -0,0 +1,7 @@
+// Package rpc holds the gRPC API of a GoVaultFS node, generated from govault.proto.
+// Regenerate it with buf, protoc-gen-go and protoc-gen-go-grpc on the PATH:
+//
+//	go generate ./rpc
+package rpc
+
+//go:generate buf generate
@@ -0,0 +1,332 @@
+// gRPC API of a GoVaultFS node
+// Objects are streamed in chunks in both directions, so files of any size can be stored and downloaded
+// without holding them in memory. Errors use the standard gRPC status codes: NOT_FOUND for missing objects,
+// UNAVAILABLE when the cluster cannot meet the storage policy and INVALID_ARGUMENT for bad requests.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: govault.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	GoVault_Put_FullMethodName         = "/govault.v1.GoVault/Put"
+	GoVault_Get_FullMethodName         = "/govault.v1.GoVault/Get"
+	GoVault_Stat_FullMethodName        = "/govault.v1.GoVault/Stat"
+	GoVault_List_FullMethodName        = "/govault.v1.GoVault/List"
+	GoVault_Delete_FullMethodName      = "/govault.v1.GoVault/Delete"
+	GoVault_WatchEvents_FullMethodName = "/govault.v1.GoVault/WatchEvents"
+)
+
+// GoVaultClient is the client API for GoVault service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// GoVault stores and retrieves objects in the vault through one node
+type GoVaultClient interface {
+	// Put stores an object. The first message names the key, every message may carry a chunk of content.
+	Put(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PutRequest, ObjectInfo], error)
+	// Get downloads an object. The first message carries its info, every message may carry a chunk of content.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GetResponse], error)
+	// Stat describes an object
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*ObjectInfo, error)
+	// List lists objects by key prefix, in key order, one page at a time
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// Delete deletes an object and its replicas
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// WatchEvents streams changes seen by the node until the client cancels
+	WatchEvents(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+}
+
+type goVaultClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGoVaultClient(cc grpc.ClientConnInterface) GoVaultClient {
+	return &goVaultClient{cc}
+}
+
+func (c *goVaultClient) Put(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PutRequest, ObjectInfo], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &GoVault_ServiceDesc.Streams[0], GoVault_Put_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PutRequest, ObjectInfo]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type GoVault_PutClient = grpc.ClientStreamingClient[PutRequest, ObjectInfo]
+
+func (c *goVaultClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GetResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &GoVault_ServiceDesc.Streams[1], GoVault_Get_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GetRequest, GetResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type GoVault_GetClient = grpc.ServerStreamingClient[GetResponse]
+
+func (c *goVaultClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*ObjectInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ObjectInfo)
+	err := c.cc.Invoke(ctx, GoVault_Stat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *goVaultClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, GoVault_List_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *goVaultClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, GoVault_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *goVaultClient) WatchEvents(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &GoVault_ServiceDesc.Streams[2], GoVault_WatchEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type GoVault_WatchEventsClient = grpc.ServerStreamingClient[Event]
+
+// GoVaultServer is the server API for GoVault service.
+// All implementations must embed UnimplementedGoVaultServer
+// for forward compatibility.
+//
+// GoVault stores and retrieves objects in the vault through one node
+type GoVaultServer interface {
+	// Put stores an object. The first message names the key, every message may carry a chunk of content.
+	Put(grpc.ClientStreamingServer[PutRequest, ObjectInfo]) error
+	// Get downloads an object. The first message carries its info, every message may carry a chunk of content.
+	Get(*GetRequest, grpc.ServerStreamingServer[GetResponse]) error
+	// Stat describes an object
+	Stat(context.Context, *StatRequest) (*ObjectInfo, error)
+	// List lists objects by key prefix, in key order, one page at a time
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	// Delete deletes an object and its replicas
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// WatchEvents streams changes seen by the node until the client cancels
+	WatchEvents(*WatchRequest, grpc.ServerStreamingServer[Event]) error
+	mustEmbedUnimplementedGoVaultServer()
+}
+
+// UnimplementedGoVaultServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedGoVaultServer struct{}
+
+func (UnimplementedGoVaultServer) Put(grpc.ClientStreamingServer[PutRequest, ObjectInfo]) error {
+	return status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedGoVaultServer) Get(*GetRequest, grpc.ServerStreamingServer[GetResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedGoVaultServer) Stat(context.Context, *StatRequest) (*ObjectInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
+func (UnimplementedGoVaultServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedGoVaultServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedGoVaultServer) WatchEvents(*WatchRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedGoVaultServer) mustEmbedUnimplementedGoVaultServer() {}
+func (UnimplementedGoVaultServer) testEmbeddedByValue()                 {}
+
+// UnsafeGoVaultServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GoVaultServer will
+// result in compilation errors.
+type UnsafeGoVaultServer interface {
+	mustEmbedUnimplementedGoVaultServer()
+}
+
+func RegisterGoVaultServer(s grpc.ServiceRegistrar, srv GoVaultServer) {
+	// If the following call pancis, it indicates UnimplementedGoVaultServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&GoVault_ServiceDesc, srv)
+}
+
+func _GoVault_Put_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(GoVaultServer).Put(&grpc.GenericServerStream[PutRequest, ObjectInfo]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type GoVault_PutServer = grpc.ClientStreamingServer[PutRequest, ObjectInfo]
+
+func _GoVault_Get_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GoVaultServer).Get(m, &grpc.GenericServerStream[GetRequest, GetResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type GoVault_GetServer = grpc.ServerStreamingServer[GetResponse]
+
+func _GoVault_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GoVaultServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GoVault_Stat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GoVaultServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GoVault_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GoVaultServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GoVault_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GoVaultServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GoVault_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GoVaultServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GoVault_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GoVaultServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GoVault_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GoVaultServer).WatchEvents(m, &grpc.GenericServerStream[WatchRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type GoVault_WatchEventsServer = grpc.ServerStreamingServer[Event]
+
+// GoVault_ServiceDesc is the grpc.ServiceDesc for GoVault service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var GoVault_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "govault.v1.GoVault",
+	HandlerType: (*GoVaultServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Stat",
+			Handler:    _GoVault_Stat_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _GoVault_List_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _GoVault_Delete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Put",
+			Handler:       _GoVault_Put_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Get",
+			Handler:       _GoVault_Get_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _GoVault_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "govault.proto",
+}
@@ -7,8 +7,8 @@ Write-Host "Running GoVaultFS..." -ForegroundColor Green
 & .\build.ps1
 
 if ($LASTEXITCODE -eq 0) {
-    # Run the built executable
-    & .\bin\fs.exe
+    # Run a node with the built executable
+    & .\bin\fs.exe serve
 } else {
     Write-Host "Cannot run - build failed!" -ForegroundColor Red
     exit $LASTEXITCODE
@@ -0,0 +1,228 @@
+// The serve command of govault
+// It runs a node on TCP from a config file, environment overrides and command line flags until it is interrupted.
+// The node keeps its ID, encryption key and token signing key in the storage root so that a restarted node can
+// read its files and its tokens stay valid.
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/config"
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/server"
+)
+
+// identityFileName is the file in the storage root holding the node ID and keys
+const identityFileName = "node.json"
+
+// identity is what a node must keep across restarts
+type identity struct {
+	ID         string `json:"id"`
+	EncKey     string `json:"enc_key"`     // Hex encoded AES key
+	SigningKey string `json:"signing_key"` // Hex encoded Ed25519 seed of the tokens the node issues
+}
+
+// loadIdentity reads the identity stored under root, creating a new one on first start.
+// Identities stored before nodes signed tokens get a signing key.
+func loadIdentity(root string) (identity, error) {
+	path := filepath.Join(root, identityFileName)
+
+	var id identity
+	b, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(b, &id); err != nil {
+			return id, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(id.SigningKey) > 0 {
+			return id, nil
+		}
+		id.SigningKey = newSigningKey()
+		b, _ = json.MarshalIndent(id, "", "  ")
+		return id, os.WriteFile(path, b, 0600)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return id, err
+	}
+
+	id = identity{
+		ID:         crypto.GenerateID(),
+		EncKey:     hex.EncodeToString(crypto.NewEncryptionKey()),
+		SigningKey: newSigningKey(),
+	}
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return id, err
+	}
+	b, _ = json.MarshalIndent(id, "", "  ")
+	return id, os.WriteFile(path, b, 0600)
+}
+
+// newSigningKey returns a hex encoded Ed25519 seed
+func newSigningKey() string {
+	seed := make([]byte, ed25519.SeedSize)
+	rand.Read(seed)
+	return hex.EncodeToString(seed)
+}
+
+// serveFlags maps the flags of the serve command to the settings they override
+var serveFlags = []struct {
+	name, path, usage string
+}{
+	{"listen", "transport.listen", "TCP address for peer connections (default :3000)"},
+	{"root", "storage.root", "storage directory (default derived from -listen)"},
+	{"bootstrap", "transport.bootstrap", "comma separated addresses of nodes to join"},
+	{"replicas", "replication.factor", "copies of each file including the local one, 0 for every peer"},
+	{"http", "api.http", "address of the HTTP gateway"},
+	{"s3", "api.s3.listen", "address of the S3-compatible API"},
+	{"s3-keys", "api.s3.access_keys", "comma separated access=secret key pairs for the S3 API"},
+	{"webdav", "api.webdav", "address of the WebDAV server"},
+	{"grpc", "api.grpc", "address of the gRPC API"},
+	{"metrics", "api.metrics", "address serving only /metrics"},
+	{"admin", "api.admin", "path of the admin socket, empty disables it (default " + defaultSocket() + ")"},
+	{"log-level", "log.level", "least severe level logged: debug, info, warn or error (default info)"},
+	{"log-format", "log.format", "log format: text or json (default text)"},
+	{"trace-file", "tracing.file", "file that spans are appended to as JSON lines, empty disables tracing"},
+	{"peer-out", "bandwidth.peer_out", "bandwidth sent to each peer, e.g. 10MiB, empty for no limit"},
+	{"peer-in", "bandwidth.peer_in", "bandwidth received from each peer, e.g. 10MiB, empty for no limit"},
+	{"background", "bandwidth.background", "bandwidth of replication traffic, e.g. 5MiB, empty for no limit"},
+	{"capacity", "storage.capacity", "most bytes the node stores, e.g. 50GiB, empty for no limit"},
+	{"owner-quota", "storage.owner_quota", "most bytes the node stores for each owner node, e.g. 5GiB, empty for no limit"},
+	{"cache-size", "cache.size", "most bytes of fetched files kept in the cache, e.g. 1GiB, empty for no limit"},
+	{"keep-versions", "versions.keep", "versions kept of each object including the latest, 0 for 10, -1 for all, 1 for no history"},
+}
+
+// reloadInterval is how often serve checks the config file for changes
+const reloadInterval = 2 * time.Second
+
+// runServe runs a node until SIGINT or SIGTERM.
+// The config file is reloaded on SIGHUP and whenever it changes.
+func runServe(args []string, _ io.Reader, _ io.Writer) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configPath := fs.String("config", "", "YAML, TOML or JSON config file")
+	overrides := make(map[string]string)
+	for _, f := range serveFlags {
+		fs.Func(f.name, f.usage, func(v string) error {
+			overrides[f.path] = v
+			return nil
+		})
+	}
+	fs.BoolFunc("discovery", "find nodes on the local network", func(v string) error {
+		overrides["transport.discovery"] = v
+		return nil
+	})
+	fs.BoolFunc("auth", "require capability tokens from clients and peers", func(v string) error {
+		overrides["auth.enabled"] = v
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("serve: unexpected argument %q", fs.Arg(0))
+	}
+
+	base := config.Default()
+	base.API.Admin = defaultSocket()
+	cfg, err := config.Load(*configPath, base, overrides)
+	if err != nil {
+		return err
+	}
+
+	id, err := loadIdentity(cfg.Storage.Root)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Node.ID) > 0 {
+		id.ID = cfg.Node.ID
+	}
+	encKey, err := cfg.EncKey()
+	if err != nil {
+		return err
+	}
+	if encKey == nil {
+		if encKey, err = hex.DecodeString(id.EncKey); err != nil {
+			return fmt.Errorf("%s: invalid enc_key: %w", identityFileName, err)
+		}
+	}
+
+	// The level can change on reload, the format needs a restart
+	var level slog.LevelVar
+	level.Set(cfg.LogLevel())
+	logger := cfg.NewLogger(os.Stderr, &level)
+
+	tracer, exporter, err := cfg.NewTracer()
+	if err != nil {
+		return err
+	}
+	defer exporter.Close()
+
+	tr := cfg.NewTransport()
+	tr.Logger = logger
+	opts := cfg.FileServerOpts(tr, id.ID, encKey)
+	if opts.Auth != nil && opts.Auth.SigningKey == nil {
+		seed, err := hex.DecodeString(id.SigningKey)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return fmt.Errorf("%s: invalid signing_key", identityFileName)
+		}
+		opts.Auth.SigningKey = ed25519.NewKeyFromSeed(seed)
+	}
+	opts.Logger = logger
+	opts.Tracer = tracer
+	s := server.NewFileServer(opts)
+	tr.OnPeer = s.OnPeer
+	tr.OnPeerDisconnect = s.OnPeerDisconnect
+
+	// Reload the settings that can change at runtime; the rest is reported and kept until a restart
+	var mu sync.Mutex
+	reload := func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		next, err := config.Load(*configPath, base, overrides)
+		if err != nil {
+			logger.Error("config reload failed, keeping the current settings", "err", err)
+			return
+		}
+		if changed := config.RestartRequired(cfg, next); len(changed) > 0 {
+			logger.Warn("config changed, restart the node to apply", "fields", strings.Join(changed, ", "))
+		}
+		level.Set(next.LogLevel())
+		s.Reload(next.Settings())
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	if len(*configPath) > 0 {
+		go config.Watch(*configPath, reloadInterval, stop, reload)
+	}
+
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigch)
+	go func() {
+		for sig := range sigch {
+			if sig == syscall.SIGHUP {
+				reload()
+				continue
+			}
+			s.Stop()
+			return
+		}
+	}()
+
+	return s.Start()
+}
@@ -0,0 +1,168 @@
+// Admin socket for GoVaultFS
+// A running node serves its HTTP gateway on a local unix socket so that the govault command can manage it.
+// Only processes that can open the socket file can reach it, so it needs no authentication of its own.
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// errNotSocket is returned when the admin socket path names a file that is not a socket
+var errNotSocket = errors.New("file exists and is not a socket")
+
+// errSocketInUse is returned when another process still listens on the admin socket
+var errSocketInUse = errors.New("socket is in use")
+
+// NodeStatus summarises the state of a node
+type NodeStatus struct {
+	ID          string            `json:"id"`
+	Addr        string            `json:"addr"`
+	StorageRoot string            `json:"storage_root"`
+	Started     time.Time         `json:"started"`
+	Peers       int               `json:"peers"`    // Connected peers
+	Members     map[string]int    `json:"members"`  // Cluster members by state
+	Objects     int               `json:"objects"`  // Objects stored through this node
+	Capacity    Capacity          `json:"capacity"` // Storage limit and usage
+	Cache       CacheStats        `json:"cache"`    // Cache of files fetched from peers
+	APIs        map[string]string `json:"apis"`     // Listen address of each enabled API
+	AuthKey     string            `json:"auth_key"` // Hex encoded key verifying the tokens the node issues, empty if access control is off
+}
+
+// Status reports the current state of the node
+func (s *FileServer) Status() NodeStatus {
+	st := NodeStatus{
+		ID:          s.ID,
+		Addr:        s.Transport.Addr(),
+		StorageRoot: s.store.Root,
+		Peers:       len(s.peerList()),
+		Members:     make(map[string]int),
+		Started:     s.started,
+		Objects:     s.index.len(),
+		Capacity:    s.Capacity(),
+		Cache:       s.CacheStats(),
+		APIs:        make(map[string]string),
+	}
+	for _, m := range s.Members() {
+		st.Members[m.State.String()]++
+	}
+	if key := s.PublicKey(); key != nil {
+		st.AuthKey = hex.EncodeToString(key)
+	}
+
+	apis := map[string]string{
+		"http":    s.HTTPAddr,
+		"webdav":  s.WebDAVAddr,
+		"grpc":    s.GRPCAddr,
+		"admin":   s.AdminSocket,
+		"metrics": s.MetricsAddr,
+	}
+	if s.S3 != nil {
+		apis["s3"] = s.S3.ListenAddr
+	}
+	for name, addr := range apis {
+		if len(addr) > 0 {
+			st.APIs[name] = addr
+		}
+	}
+
+	return st
+}
+
+// listenAdmin serves the admin API on the unix socket, if it is enabled.
+// The socket is created in a directory only this user can enter and moved into place once only this user can open it,
+// so no one else can connect in between.
+func (s *FileServer) listenAdmin() error {
+	if s.admin == nil {
+		return nil
+	}
+
+	// A socket file left behind by a node that did not shut down cleanly blocks the listener,
+	// while one that still answers belongs to a running node
+	if err := removeSocket(s.AdminSocket); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(s.AdminSocket), ".admin-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	ul, err := net.Listen("unix", tmp)
+	if err != nil {
+		return err
+	}
+	ul.(*net.UnixListener).SetUnlinkOnClose(false) // The socket moves away from tmp
+	err = os.Chmod(tmp, 0600)
+	if err == nil {
+		err = os.Rename(tmp, s.AdminSocket)
+	}
+	var fi os.FileInfo
+	if err == nil {
+		fi, err = os.Lstat(s.AdminSocket)
+	}
+	if err != nil {
+		ul.Close()
+		return err
+	}
+
+	ln := &socketListener{Listener: ul, path: s.AdminSocket, file: fi}
+	s.admin.Handler = s.logRequests("admin socket", s.admin.Handler)
+	go func() {
+		if err := s.admin.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("admin socket failed", "err", err)
+		}
+	}()
+
+	s.logger.Info("admin socket listening", "addr", s.AdminSocket)
+
+	return nil
+}
+
+// removeSocket removes the unix socket at path if there is one, and fails if path is another kind of file
+// or a process still accepts connections on it
+func removeSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("admin socket %s: %w", path, errNotSocket)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("admin socket %s: %w", path, errSocketInUse)
+	}
+	return os.Remove(path)
+}
+
+// socketListener listens on a unix socket moved to path, and removes it when closed
+type socketListener struct {
+	net.Listener
+	path string
+	file os.FileInfo // The socket file, to tell it from one another node has put at path since
+	once sync.Once
+}
+
+// Close stops listening and removes the socket, unless path names another file by now
+func (l *socketListener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() {
+		if fi, err := os.Lstat(l.path); err == nil && os.SameFile(fi, l.file) {
+			os.Remove(l.path)
+		}
+	})
+	return err
+}
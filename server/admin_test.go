@@ -0,0 +1,94 @@
+// Tests for the admin socket
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAdminSocket checks that the admin socket replaces a stale socket but no other file or live socket, that only its owner
+// can open it, and that it is removed when the node stops unless another node has taken its place
+func TestAdminSocket(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "admin.sock")
+	newServer := func() *FileServer {
+		return newTestServer(t, p2p.NewMemNetwork(), ":3000", func(opts *FileServerOpts) { opts.AdminSocket = path })
+	}
+
+	// A file that is not a socket is left alone
+	assert.Nil(t, os.WriteFile(path, []byte("keep"), 0o644))
+	s := newServer()
+	assert.ErrorIs(t, s.Start(), errNotSocket)
+	s.Stop()
+	b, _ := os.ReadFile(path)
+	assert.Equal(t, "keep", string(b))
+	assert.Nil(t, os.Remove(path))
+
+	// A socket another process still listens on is left alone
+	live, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = newServer()
+	assert.ErrorIs(t, s.Start(), errSocketInUse)
+	s.Stop()
+	conn, err := net.Dial("unix", path)
+	if assert.Nil(t, err) {
+		conn.Close()
+	}
+	live.Close()
+
+	// A socket left behind is replaced
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	s = newServer()
+	go s.Start()
+	waitFor(t, 5*time.Second, func() bool {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	})
+	fi, err := os.Lstat(path)
+	if assert.Nil(t, err) {
+		assert.Equal(t, os.ModeSocket, fi.Mode().Type())
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1) // Without the directory the socket was created in
+
+	s.Stop()
+	_, err = os.Lstat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// A node stopping after another took over the path leaves the other's socket
+	s = newServer()
+	go s.Start()
+	waitFor(t, 5*time.Second, func() bool {
+		_, err := os.Lstat(path)
+		return err == nil
+	})
+	assert.Nil(t, os.Remove(path))
+	other, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	s.Stop()
+	_, err = os.Lstat(path)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,413 @@
+// Access control for GoVaultFS
+// With access control on, every request needs a capability token: a statement signed by a node's Ed25519 key that
+// a subject may perform some operations on the keys under a prefix until an expiry. Nodes accept the tokens they
+// issued themselves and those of the issuers they are configured to trust. The HTTP gateway, WebDAV, S3 and gRPC
+// APIs check the token of each client request, and peers check the token a node presents with every file message,
+// whose subject must be the node itself. Revoked token IDs are kept until the tokens expire, saved in the storage
+// root and pushed to every peer, so a revocation on one node holds on all of them.
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Op is an operation a capability allows
+type Op string
+
+// Operations a capability can allow. OpAdmin allows every operation, and the management of the node.
+const (
+	OpRead   Op = "read"
+	OpWrite  Op = "write"
+	OpDelete Op = "delete"
+	OpAdmin  Op = "admin"
+)
+
+// tokenVersion starts every token
+const tokenVersion = "gv1"
+
+// revokedFileName is the name of the revocation list in the storage root
+const revokedFileName = "revoked.json"
+
+// peerTokenTTL is how long the tokens a node issues itself for its peers last
+const peerTokenTTL = time.Hour
+
+// Errors returned by access control
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrAccessDenied = errors.New("access denied")
+	ErrAuthDisabled = errors.New("access control is disabled")
+)
+
+// AuthOpts turns on access control
+type AuthOpts struct {
+	SigningKey ed25519.PrivateKey           // Signs the tokens this node issues, generated if nil
+	Issuers    map[string]ed25519.PublicKey // Keys of the other nodes whose tokens are accepted, by node ID
+	Token      string                       // Token presented to peers, one this node issues itself if empty
+}
+
+// Capability is what a token allows
+type Capability struct {
+	ID      string    `json:"id"`               // Names the token in revocation lists
+	Issuer  string    `json:"iss"`              // ID of the node that signed the token
+	Subject string    `json:"sub"`              // Who the token was issued to, the node ID for tokens presented to peers
+	Ops     []Op      `json:"ops"`              // Operations allowed
+	Tenant  string    `json:"tenant,omitempty"` // Tenant whose keys the token covers, empty for the node's own keys
+	Prefix  string    `json:"prefix"`           // Keys covered, empty for every key
+	Expires time.Time `json:"exp"`              // When the token stops being accepted
+}
+
+// Allows reports whether the capability allows op on a key inside the node
+func (c Capability) Allows(op Op, key string) bool {
+	if !slices.Contains(c.Ops, op) && !slices.Contains(c.Ops, OpAdmin) {
+		return false
+	}
+	if tenantOf(key) != c.Tenant {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimPrefix(key, tenantKey(c.Tenant, "")), c.Prefix)
+}
+
+// covers reports whether everything another capability allows is also allowed by c
+func (c Capability) covers(o Capability) bool {
+	for _, op := range o.Ops {
+		if !c.Allows(op, tenantKey(o.Tenant, o.Prefix)) {
+			return false
+		}
+	}
+	return !o.Expires.After(c.Expires)
+}
+
+// validOp reports whether op is one of the known operations
+func validOp(op Op) bool {
+	return op == OpRead || op == OpWrite || op == OpDelete || op == OpAdmin
+}
+
+// authority issues and checks the tokens of a node
+type authority struct {
+	key     ed25519.PrivateKey
+	issuers map[string]ed25519.PublicKey
+	path    string // Revocation list file
+
+	mu      sync.Mutex
+	revoked map[string]time.Time // Revoked token IDs, until the tokens expire
+	token   string               // Token presented to peers
+	expires time.Time            // When token expires, zero if it was configured
+}
+
+// newAuthority sets up access control for a node, loading the revocation list saved under root
+func newAuthority(opts AuthOpts, root string) (*authority, error) {
+	a := &authority{
+		key:     opts.SigningKey,
+		issuers: maps.Clone(opts.Issuers),
+		path:    filepath.Join(root, revokedFileName),
+		revoked: make(map[string]time.Time),
+		token:   opts.Token,
+	}
+	if a.key == nil {
+		_, a.key, _ = ed25519.GenerateKey(rand.Reader)
+	}
+
+	b, err := os.ReadFile(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return a, err
+	}
+	if err := json.Unmarshal(b, &a.revoked); err != nil {
+		return a, fmt.Errorf("%s: %w", a.path, err)
+	}
+	return a, nil
+}
+
+// save writes the revocation list to disk, dropping the entries of expired tokens. Caller holds a.mu.
+func (a *authority) save() error {
+	now := time.Now()
+	for id, until := range a.revoked {
+		if !until.IsZero() && until.Before(now) {
+			delete(a.revoked, id)
+		}
+	}
+	b, err := json.Marshal(a.revoked)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(a.path, b)
+}
+
+// AuthEnabled reports whether the node checks tokens
+func (s *FileServer) AuthEnabled() bool {
+	return s.auth != nil
+}
+
+// PublicKey returns the key that verifies the tokens this node issues, nil if access control is off
+func (s *FileServer) PublicKey() ed25519.PublicKey {
+	if s.auth == nil {
+		return nil
+	}
+	return s.auth.key.Public().(ed25519.PublicKey)
+}
+
+// IssueToken signs a token for a capability. The ID and issuer are filled in, the rest must be set.
+func (s *FileServer) IssueToken(c Capability) (string, Capability, error) {
+	if s.auth == nil {
+		return "", c, ErrAuthDisabled
+	}
+	if len(c.Subject) == 0 || len(c.Ops) == 0 || c.Expires.IsZero() {
+		return "", c, fmt.Errorf("%w: a token needs a subject, operations and an expiry", ErrInvalidToken)
+	}
+	for _, op := range c.Ops {
+		if !validOp(op) {
+			return "", c, fmt.Errorf("%w: unknown operation %q", ErrInvalidToken, op)
+		}
+	}
+	if len(c.Tenant) > 0 {
+		if _, ok := s.tenants[c.Tenant]; !ok {
+			return "", c, fmt.Errorf("%w: %s", ErrNoSuchTenant, c.Tenant)
+		}
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	c.ID = hex.EncodeToString(id)
+	c.Issuer = s.ID
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", c, err
+	}
+	signed := tokenVersion + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig := ed25519.Sign(s.auth.key, []byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), c, nil
+}
+
+// ParseToken returns the capability a token claims without checking its signature
+func ParseToken(token string) (Capability, error) {
+	c, _, _, err := splitToken(token)
+	return c, err
+}
+
+// splitToken decodes a token into its capability, the signed part and the signature
+func splitToken(token string) (Capability, string, []byte, error) {
+	var c Capability
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenVersion {
+		return c, "", nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return c, "", nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return c, "", nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, "", nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return c, parts[0] + "." + parts[1], sig, nil
+}
+
+// verify returns the capability of a token signed by a trusted issuer that has neither expired nor been revoked
+func (s *FileServer) verify(token string) (Capability, error) {
+	if len(token) == 0 {
+		return Capability{}, fmt.Errorf("%w: missing", ErrInvalidToken)
+	}
+	c, signed, sig, err := splitToken(token)
+	if err != nil {
+		return c, err
+	}
+
+	key, ok := s.auth.issuers[c.Issuer]
+	if c.Issuer == s.ID {
+		key, ok = s.PublicKey(), true
+	}
+	if !ok {
+		return c, fmt.Errorf("%w: unknown issuer %s", ErrInvalidToken, shortID(c.Issuer))
+	}
+	if !ed25519.Verify(key, []byte(signed), sig) {
+		return c, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	if !time.Now().Before(c.Expires) {
+		return c, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+
+	s.auth.mu.Lock()
+	_, revoked := s.auth.revoked[c.ID]
+	s.auth.mu.Unlock()
+	if revoked {
+		return c, fmt.Errorf("%w: revoked", ErrInvalidToken)
+	}
+	return c, nil
+}
+
+// Authorize checks that a token allows op on a key inside the node and returns its capability.
+// Every request is allowed if access control is off.
+func (s *FileServer) Authorize(token string, op Op, key string) (Capability, error) {
+	if s.auth == nil {
+		return Capability{}, nil
+	}
+	c, err := s.verify(token)
+	if err == nil && !c.Allows(op, key) {
+		err = fmt.Errorf("%w: %s %s", ErrAccessDenied, op, key)
+	}
+	if err != nil {
+		s.metrics.denied.Inc(string(op))
+		return c, err
+	}
+	return c, nil
+}
+
+// authorizePeer checks the token a peer sent with a file message. The subject must be the node the message comes
+// from and the token must cover every key, since peers only see the hashes of keys.
+func (s *FileServer) authorizePeer(token string, node string, op Op) error {
+	if s.auth == nil {
+		return nil
+	}
+	c, err := s.verify(token)
+	if err == nil && (c.Subject != node || !c.Allows(op, "")) {
+		err = fmt.Errorf("%w: token of %s does not allow %s by node %s", ErrAccessDenied, c.Subject, op, shortID(node))
+	}
+	if err != nil {
+		s.metrics.denied.Inc(string(op))
+	}
+	return err
+}
+
+// peerToken returns the token this node presents to its peers, empty if access control is off
+func (s *FileServer) peerToken() string {
+	if s.auth == nil {
+		return ""
+	}
+
+	s.auth.mu.Lock()
+	token, expires := s.auth.token, s.auth.expires
+	s.auth.mu.Unlock()
+	if len(token) > 0 && (expires.IsZero() || time.Until(expires) > peerTokenTTL/2) {
+		return token
+	}
+
+	// Renew the token well before peers stop accepting it
+	expires = time.Now().Add(peerTokenTTL)
+	token, _, err := s.IssueToken(Capability{Subject: s.ID, Ops: []Op{OpAdmin}, Expires: expires})
+	if err != nil {
+		s.logger.Error("issuing peer token failed", "err", err)
+		return ""
+	}
+	s.auth.mu.Lock()
+	s.auth.token, s.auth.expires = token, expires
+	s.auth.mu.Unlock()
+	return token
+}
+
+// MessageRevoke tells peers about revoked tokens
+type MessageRevoke struct {
+	ID      string               // Node ID
+	Token   string               // Token of the node, which must allow OpAdmin
+	Revoked map[string]time.Time // Revoked token IDs, until the tokens expire
+}
+
+// Revoke stops a token from being accepted by this node and its peers. The revocation is kept until the
+// token expires, forever if until is zero.
+func (s *FileServer) Revoke(id string, until time.Time) error {
+	if s.auth == nil {
+		return ErrAuthDisabled
+	}
+	if err := s.revoke(map[string]time.Time{id: until}); err != nil {
+		return err
+	}
+	s.logger.Info("revoked token", "token", id)
+
+	msg := Message{Payload: MessageRevoke{ID: s.ID, Token: s.peerToken(), Revoked: map[string]time.Time{id: until}}}
+	return s.broadcast(&msg)
+}
+
+// revoke adds token IDs to the revocation list and saves it
+func (s *FileServer) revoke(revoked map[string]time.Time) error {
+	s.auth.mu.Lock()
+	defer s.auth.mu.Unlock()
+
+	for id, until := range revoked {
+		if prev, ok := s.auth.revoked[id]; ok && !revokedBefore(prev, until) {
+			continue // Already revoked for as long
+		}
+		s.auth.revoked[id] = until
+	}
+	return s.auth.save()
+}
+
+// revokedBefore reports whether a revocation kept until a ends before one kept until b, zero being forever
+func revokedBefore(a time.Time, b time.Time) bool {
+	if a.IsZero() {
+		return false
+	}
+	return b.IsZero() || a.Before(b)
+}
+
+// Revoked returns the revoked token IDs and until when they are kept, zero for ever
+func (s *FileServer) Revoked() map[string]time.Time {
+	if s.auth == nil {
+		return map[string]time.Time{}
+	}
+	s.auth.mu.Lock()
+	defer s.auth.mu.Unlock()
+	return maps.Clone(s.auth.revoked)
+}
+
+// sendRevoked sends the revocation list to a peer that just connected, so it learns what it missed
+func (s *FileServer) sendRevoked(addr string) error {
+	revoked := s.Revoked()
+	if len(revoked) == 0 {
+		return nil
+	}
+	msg := Message{Payload: MessageRevoke{ID: s.ID, Token: s.peerToken(), Revoked: revoked}}
+	return s.sendToPeer(addr, &msg)
+}
+
+// handleMessageRevoke adds the tokens a peer revoked to the revocation list
+func (s *FileServer) handleMessageRevoke(from string, msg MessageRevoke) error {
+	if s.auth == nil {
+		return nil
+	}
+	if err := s.authorizePeer(msg.Token, msg.ID, OpAdmin); err != nil {
+		return fmt.Errorf("revocation from %s: %w", from, err)
+	}
+	return s.revoke(msg.Revoked)
+}
+
+// denyStore answers MessageStoreFile with a refusal because its token does not allow the write
+func (s *FileServer) denyStore(stream io.Writer) error {
+	frame, err := encodeMessage(&Message{Payload: MessageStoreReply{From: s.ID, Refused: refusedDenied, Capacity: s.Capacity()}})
+	if err != nil {
+		return err
+	}
+	_, err = stream.Write(frame)
+	return err
+}
+
+// bearerToken returns the token of an Authorization header with the Bearer scheme
+func bearerToken(header string) string {
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+func init() {
+	gob.Register(MessageRevoke{})
+}
@@ -0,0 +1,219 @@
+// Tests for access control with capability tokens
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/client"
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// withAuth turns on access control for a test server with ID id, signing with key and trusting issuers
+func withAuth(id string, key ed25519.PrivateKey, issuers map[string]ed25519.PublicKey) func(*FileServerOpts) {
+	return func(opts *FileServerOpts) {
+		opts.ID = id
+		opts.Auth = &AuthOpts{SigningKey: key, Issuers: issuers}
+	}
+}
+
+// TestAccessControl checks that peers only serve and store files for nodes whose tokens they trust, that every
+// API front end checks the operations and prefix of the client's token, that tokens can only be handed on with
+// less access, and that a revocation holds on every node and across restarts
+func TestAccessControl(t *testing.T) {
+	t.Parallel()
+
+	id1, id2, id3 := crypto.GenerateID(), crypto.GenerateID(), crypto.GenerateID()
+	pub1, key1, _ := ed25519.GenerateKey(nil)
+	pub2, key2, _ := ed25519.GenerateKey(nil)
+	_, key3, _ := ed25519.GenerateKey(nil)
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", withAuth(id1, key1, map[string]ed25519.PublicKey{id2: pub2}))
+	s2 := newTestServer(t, network, ":4000", withAuth(id2, key2, map[string]ed25519.PublicKey{id1: pub1}), ":3000")
+	s3 := newTestServer(t, network, ":5000", withAuth(id3, key3, nil), ":3000") // Trusted by nobody
+	defer stopServers(s1, s2, s3)
+
+	startServers(t, s1, s2, s3)
+
+	// Trusted nodes replicate to each other, the untrusted one can neither store nor read on them
+	assert.Nil(t, s2.Store("docs/a.txt", bytes.NewReader([]byte("hello"))))
+	hash := crypto.HashKey("docs/a.txt")
+	assert.True(t, s1.store.Has(s2.ID, hash))
+	assert.False(t, s3.store.Has(s2.ID, hash))
+
+	peer, ok := s3.memberPeer(s1.ID)
+	assert.True(t, ok)
+	msg := Message{Payload: MessageStoreFile{ID: s3.ID, Token: s3.peerToken(), Key: "denied", Size: 10}}
+	_, _, err := s3.openStoreStream(context.Background(), peer, &msg)
+	assert.ErrorIs(t, err, ErrAccessDenied)
+	assert.False(t, s1.store.Has(s3.ID, "denied"))
+	_, err = s3.Get("docs/a.txt")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A node cannot pass off another node's token as its own
+	msg = Message{Payload: MessageGetFile{ID: s3.ID, Token: s2.peerToken(), Key: hash}}
+	n, err := s3.fetchFile(context.Background(), peer, "docs/a.txt", &msg)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), n)
+
+	// The gateway wants a token that allows the operation on the key
+	gw := httptest.NewServer(NewGateway(s2))
+	defer gw.Close()
+	do := func(url string, method string, path string, token string, body string) *http.Response {
+		req, _ := http.NewRequest(method, url+path, strings.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return send(t, req)
+	}
+	read := func(resp *http.Response) string {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	resp := do(gw.URL, http.MethodGet, "/objects/docs/a.txt", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusOK, do(gw.URL, http.MethodGet, "/health", "", "").StatusCode)
+
+	admin, _, err := s2.IssueToken(Capability{Subject: "ops", Ops: []Op{OpAdmin}, Expires: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	resp = do(gw.URL, http.MethodPost, "/tokens", admin, `{"subject": "reader", "ops": ["read"], "prefix": "docs/", "ttl": "1h"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var issued tokenResponse
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&issued))
+	reader := issued.Token
+	assert.Equal(t, "reader", issued.Capability.Subject)
+	assert.Equal(t, s2.ID, issued.Capability.Issuer)
+
+	assert.Equal(t, "hello", read(do(gw.URL, http.MethodGet, "/objects/docs/a.txt", reader, "")))
+	assert.Equal(t, http.StatusForbidden, do(gw.URL, http.MethodGet, "/objects/other.txt", reader, "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do(gw.URL, http.MethodPut, "/objects/docs/b.txt", reader, "b").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do(gw.URL, http.MethodGet, "/objects?prefix=", reader, "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do(gw.URL, http.MethodGet, "/status", reader, "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, do(gw.URL, http.MethodPost, "/tokens", reader, `{"subject": "x", "ops": ["read"], "ttl": "1h"}`).StatusCode)
+
+	// Tokens can only be handed on with less access
+	docsAdmin, _, _ := s2.IssueToken(Capability{Subject: "docs", Ops: []Op{OpAdmin}, Prefix: "docs/", Expires: time.Now().Add(time.Hour)})
+	assert.Equal(t, http.StatusForbidden, do(gw.URL, http.MethodPost, "/tokens", docsAdmin, `{"subject": "x", "ops": ["read"], "ttl": "1h"}`).StatusCode)
+	assert.Equal(t, http.StatusCreated, do(gw.URL, http.MethodPost, "/tokens", docsAdmin, `{"subject": "x", "ops": ["read"], "prefix": "docs/x/", "ttl": "1m"}`).StatusCode)
+
+	// Nodes that trust the issuer accept its tokens, others do not
+	_, err = s1.Authorize(reader, OpRead, "docs/a.txt")
+	assert.Nil(t, err)
+	_, err = s3.Authorize(reader, OpRead, "docs/a.txt")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// The admin socket needs no token
+	trusted := NewGateway(s2)
+	trusted.trusted = true
+	gwAdmin := httptest.NewServer(trusted)
+	defer gwAdmin.Close()
+	assert.Equal(t, http.StatusOK, do(gwAdmin.URL, http.MethodGet, "/status", "", "").StatusCode)
+
+	// gRPC calls carry the token in their metadata
+	c := newTestClient(t, s2, client.WithToken(reader))
+	info, err := c.Stat(context.Background(), "docs/a.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), info.Size)
+	assert.ErrorIs(t, c.Delete(context.Background(), "docs/a.txt"), client.ErrPermissionDenied)
+	_, err = newTestClient(t, s2).Stat(context.Background(), "docs/a.txt")
+	assert.ErrorIs(t, err, client.ErrUnauthenticated)
+
+	// WebDAV takes the token as the password of basic auth
+	dav := httptest.NewServer(NewWebDAV(s2))
+	defer dav.Close()
+	req, _ := http.NewRequest(http.MethodGet, dav.URL+"/docs/a.txt", nil)
+	req.SetBasicAuth("reader", reader)
+	assert.Equal(t, "hello", read(send(t, req)))
+	resp = do(dav.URL, "MOVE", "/docs/a.txt", reader, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = do(dav.URL, http.MethodGet, "/docs/a.txt", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Basic")
+
+	// S3 requests send the token as their session token
+	ts := httptest.NewServer(NewS3Server(s2, S3Opts{AccessKeys: map[string]string{testAccessKey: testSecretKey}}))
+	defer ts.Close()
+	photos, _, _ := s2.IssueToken(Capability{Subject: "photos", Ops: []Op{OpRead, OpWrite}, Prefix: "photos/", Expires: time.Now().Add(time.Hour)})
+	resp = s3Do(t, http.MethodPut, ts.URL+"/photos", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "InvalidToken", s3ErrorCode(t, resp))
+	assert.Equal(t, http.StatusOK, s3Do(t, http.MethodPut, ts.URL+"/photos", nil, "X-Amz-Security-Token", photos).StatusCode)
+	assert.Equal(t, http.StatusOK, s3Do(t, http.MethodPut, ts.URL+"/photos/a.png", []byte("png"), "X-Amz-Security-Token", photos).StatusCode)
+	resp = s3Do(t, http.MethodPut, ts.URL+"/videos", nil, "X-Amz-Security-Token", photos)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "AccessDenied", s3ErrorCode(t, resp))
+
+	// A revocation holds on the node and its peers, and across restarts
+	assert.Equal(t, http.StatusNoContent, do(gw.URL, http.MethodDelete, "/tokens/"+issued.Capability.ID, admin, "").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, do(gw.URL, http.MethodGet, "/objects/docs/a.txt", reader, "").StatusCode)
+	waitFor(t, 5*time.Second, func() bool {
+		_, err := s1.Authorize(reader, OpRead, "docs/a.txt")
+		return err != nil
+	})
+	_, err = s1.Authorize(reader, OpRead, "docs/a.txt")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	a, err := newAuthority(AuthOpts{}, s2.store.Root)
+	assert.Nil(t, err)
+	assert.Contains(t, a.revoked, issued.Capability.ID)
+
+	// Revocations from untrusted nodes are ignored
+	c1, _ := ParseToken(admin)
+	assert.Nil(t, s3.Revoke(c1.ID, time.Time{}))
+	time.Sleep(100 * time.Millisecond)
+	_, err = s2.Authorize(admin, OpAdmin, "")
+	assert.Nil(t, err)
+}
+
+// TestCapability checks which operations and keys a capability allows
+func TestCapability(t *testing.T) {
+	t.Parallel()
+
+	c := Capability{Ops: []Op{OpRead, OpWrite}, Prefix: "docs/"}
+	assert.True(t, c.Allows(OpRead, "docs/a.txt"))
+	assert.True(t, c.Allows(OpWrite, "docs/"))
+	assert.False(t, c.Allows(OpDelete, "docs/a.txt"))
+	assert.False(t, c.Allows(OpRead, "photos/a.png"))
+	assert.False(t, c.Allows(OpRead, tenantKey("acme", "docs/a.txt")))
+
+	acme := Capability{Ops: []Op{OpAdmin}, Tenant: "acme"}
+	assert.True(t, acme.Allows(OpDelete, tenantKey("acme", "docs/a.txt")))
+	assert.False(t, acme.Allows(OpRead, "docs/a.txt"))
+	assert.False(t, acme.Allows(OpRead, tenantKey("globex", "docs/a.txt")))
+
+	_, err := ParseToken("gv1.not-a-token")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+// TestRevokeUntil checks that a revocation is only ever extended: a later expiry or a permanent revocation replaces
+// a timed one, and an earlier expiry replaces neither
+func TestRevokeUntil(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServer(t, p2p.NewMemNetwork(), ":3000", withAuth("node", nil, nil))
+	soon, later := time.Now().Add(time.Hour), time.Now().Add(2*time.Hour)
+
+	assert.Nil(t, s.Revoke("token", soon))
+	assert.Nil(t, s.Revoke("token", later))
+	assert.True(t, s.Revoked()["token"].Equal(later))
+	assert.Nil(t, s.Revoke("token", soon))
+	assert.True(t, s.Revoked()["token"].Equal(later))
+
+	assert.Nil(t, s.Revoke("token", time.Time{}))
+	assert.True(t, s.Revoked()["token"].IsZero())
+	assert.Nil(t, s.Revoke("token", later))
+	assert.True(t, s.Revoked()["token"].IsZero())
+}
@@ -0,0 +1,54 @@
+// Bandwidth budgets of a file server node
+// Peer transfers for client requests and replication traffic draw on separate token buckets, so replicating a
+// large file cannot starve reads, and local disk I/O has limits of its own. The connection limits of the
+// transport (p2p.RateLimits) apply on top of these budgets.
+package server
+
+import (
+	"context"
+	"io"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/ratelimit"
+)
+
+// BandwidthOpts bound the bandwidth of a node in bytes per second, 0 for no limit
+type BandwidthOpts struct {
+	Foreground float64 // Peer transfers for client requests: files and shards fetched for Get and served to peers
+	Background float64 // Replication: replicas and shards sent to peers and received from them
+	DiskRead   float64 // Reads from local storage
+	DiskWrite  float64 // Writes to local storage
+}
+
+// trafficClass tells which budget a peer transfer draws on
+type trafficClass int
+
+// Traffic classes
+const (
+	foreground trafficClass = iota
+	background
+)
+
+// newBandwidthLimiters creates the limiters of the foreground and background budgets
+func newBandwidthLimiters(b BandwidthOpts) [2]*ratelimit.Limiter {
+	return [2]*ratelimit.Limiter{
+		foreground: ratelimit.NewLimiter(b.Foreground, 0),
+		background: ratelimit.NewLimiter(b.Background, 0),
+	}
+}
+
+// setBandwidth changes every budget; transfers in progress continue at the new rates
+func (s *FileServer) setBandwidth(b BandwidthOpts) {
+	s.bandwidth[foreground].SetLimit(b.Foreground, 0)
+	s.bandwidth[background].SetLimit(b.Background, 0)
+	s.store.SetRates(b.DiskRead, b.DiskWrite)
+}
+
+// limitReader bounds reads from a peer stream by the budget of class
+func (s *FileServer) limitReader(ctx context.Context, class trafficClass, r io.Reader) io.Reader {
+	return ratelimit.NewReader(ctx, r, s.bandwidth[class])
+}
+
+// limitWriter bounds writes to a peer stream by the budget of class
+func (s *FileServer) limitWriter(ctx context.Context, class trafficClass, w io.Writer) io.Writer {
+	return ratelimit.NewWriter(ctx, w, s.bandwidth[class])
+}
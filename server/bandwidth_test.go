@@ -0,0 +1,59 @@
+// Tests for the bandwidth budgets of a node
+package server
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBandwidth checks that replication is bounded by the background budget
+// while a Get during the replication goes ahead on the foreground budget
+func TestBandwidth(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	st := s2.Settings()
+	st.Bandwidth.Background = 200 * 1024
+	s2.Reload(st)
+	assert.Equal(t, float64(200*1024), s2.Settings().Bandwidth.Background)
+
+	assert.Nil(t, s2.Store("small.txt", strings.NewReader("small")))
+	assert.Nil(t, s2.store.Delete(s2.ID, "small.txt"))
+
+	// The first 200 KiB are the burst, the rest take a second
+	done := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		assert.Nil(t, s2.Store("big.bin", bytes.NewReader(make([]byte, 400*1024))))
+		done <- time.Since(start)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	r, err := s2.Get("small.txt")
+	assert.Nil(t, err)
+	b, _ := io.ReadAll(r)
+	assert.Equal(t, "small", string(b))
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	assert.Greater(t, <-done, 800*time.Millisecond)
+
+	// Lifting the budget applies to the next write
+	st.Bandwidth = BandwidthOpts{}
+	s2.Reload(st)
+	start = time.Now()
+	assert.Nil(t, s2.Store("big2.bin", bytes.NewReader(make([]byte, 400*1024))))
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
@@ -0,0 +1,360 @@
+// Cache of files fetched from peers for GoVaultFS
+// A Get that finds a file only on the network, or rebuilds it from shards, keeps the copy in a cache tier of its
+// own, apart from the files and replicas the node is responsible for. The cache is bounded in bytes and evicts the
+// least recently or least frequently used copies first; pinned keys stay until they are unpinned. Its index is
+// saved next to the node's files, so cached copies and pins survive a restart.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+)
+
+// cacheID is the store directory of cached copies. Node IDs are hex, so it never collides with an owner.
+const cacheID = "cache"
+
+// cacheFileName is the name of the cache index in the storage root
+const cacheFileName = "cache.json"
+
+// CacheEviction selects which cached copies make room first
+type CacheEviction string
+
+// Eviction policies
+const (
+	EvictLRU CacheEviction = "lru" // Least recently used, the default
+	EvictLFU CacheEviction = "lfu" // Least frequently used, the least recently used of them first
+)
+
+// CacheOpts configure the cache of files fetched from peers
+type CacheOpts struct {
+	Size     int64         // Most bytes of cached copies, 0 for no limit. Pinned copies count but are never evicted.
+	Eviction CacheEviction // Eviction policy, LRU if empty
+}
+
+// CacheStats describe the cache of a node
+type CacheStats struct {
+	Bytes   int64 `json:"bytes"`   // Bytes of cached copies
+	Limit   int64 `json:"limit"`   // Most bytes cached, 0 if unlimited
+	Entries int   `json:"entries"` // Cached copies
+	Pinned  int   `json:"pinned"`  // Pinned keys
+}
+
+// cacheEntry is a cached copy of a file
+type cacheEntry struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	Hits int64  `json:"hits"` // Reads since the copy was cached
+	Used uint64 `json:"used"` // Logical time of the last read
+}
+
+// fetchCache keeps track of the cached copies and pins and decides what to evict.
+// The files themselves live in the store under cacheID; the server deletes the ones evicted.
+type fetchCache struct {
+	mu      sync.Mutex
+	path    string
+	opts    CacheOpts
+	entries map[string]*cacheEntry
+	pins    map[string]bool
+	bytes   int64
+	clock   uint64 // Last logical time handed out
+}
+
+// cacheIndex is the saved form of the cache
+type cacheIndex struct {
+	Entries []*cacheEntry `json:"entries"`
+	Pins    []string      `json:"pins"`
+}
+
+// openCache loads the cache index stored at path, keeping the entries whose file has returns true
+func openCache(path string, opts CacheOpts, has func(key string) bool) (*fetchCache, error) {
+	c := &fetchCache{
+		path:    path,
+		opts:    opts,
+		entries: make(map[string]*cacheEntry),
+		pins:    make(map[string]bool),
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	var x cacheIndex
+	if err := json.Unmarshal(b, &x); err != nil {
+		return c, err
+	}
+	for _, e := range x.Entries {
+		if has(e.Key) {
+			c.entries[e.Key] = e
+			c.bytes += e.Size
+			c.clock = max(c.clock, e.Used)
+		}
+	}
+	for _, key := range x.Pins {
+		c.pins[key] = true
+	}
+	return c, nil
+}
+
+// has reports whether a copy of key is cached
+func (c *fetchCache) has(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[key]
+	return ok
+}
+
+// touch records a read of the cached copy of key
+func (c *fetchCache) touch(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.clock++
+		e.Hits++
+		e.Used = c.clock
+	}
+}
+
+// add records a cached copy of size bytes and returns the keys evicted to make room for it.
+// A copy larger than the cache is evicted right away unless it is pinned.
+func (c *fetchCache) add(key string, size int64) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.bytes -= e.Size
+	}
+	c.clock++
+	c.entries[key] = &cacheEntry{Key: key, Size: size, Hits: 1, Used: c.clock}
+	c.bytes += size
+
+	evicted := c.evict()
+	return evicted, c.save()
+}
+
+// remove forgets the cached copy and the pin of key
+func (c *fetchCache) remove(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.bytes -= e.Size
+		delete(c.entries, key)
+	}
+	delete(c.pins, key)
+	return c.save()
+}
+
+// drop forgets the cached copy of key but not its pin
+func (c *fetchCache) drop(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.bytes -= e.Size
+	delete(c.entries, key)
+	return c.save()
+}
+
+// pin keeps the copy of key from being evicted
+func (c *fetchCache) pin(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pins[key] = true
+	return c.save()
+}
+
+// unpin lets the copy of key be evicted again and returns the keys evicted as a result.
+// It reports false if key was not pinned.
+func (c *fetchCache) unpin(key string) ([]string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.pins[key] {
+		return nil, false, nil
+	}
+	delete(c.pins, key)
+	evicted := c.evict()
+	return evicted, true, c.save()
+}
+
+// pinned returns the pinned keys in order
+func (c *fetchCache) pinned() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.pins))
+	for key := range c.pins {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// setOpts changes the size and eviction policy and returns the keys evicted to fit the new size
+func (c *fetchCache) setOpts(opts CacheOpts) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.opts == opts {
+		return nil, nil
+	}
+	c.opts = opts
+	evicted := c.evict()
+	if len(evicted) == 0 {
+		return nil, nil
+	}
+	return evicted, c.save()
+}
+
+// stats returns the size and contents of the cache
+func (c *fetchCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Bytes: c.bytes, Limit: c.opts.Size, Entries: len(c.entries), Pinned: len(c.pins)}
+}
+
+// evict drops unpinned copies in eviction order until the cache fits its size. Caller holds c.mu.
+func (c *fetchCache) evict() []string {
+	evicted := []string{}
+	for c.opts.Size > 0 && c.bytes > c.opts.Size {
+		var victim *cacheEntry
+		for _, e := range c.entries {
+			if !c.pins[e.Key] && (victim == nil || c.before(e, victim)) {
+				victim = e
+			}
+		}
+		if victim == nil {
+			break // Everything left is pinned
+		}
+		delete(c.entries, victim.Key)
+		c.bytes -= victim.Size
+		evicted = append(evicted, victim.Key)
+	}
+	return evicted
+}
+
+// before reports whether a is evicted before b. Caller holds c.mu.
+func (c *fetchCache) before(a, b *cacheEntry) bool {
+	if c.opts.Eviction == EvictLFU && a.Hits != b.Hits {
+		return a.Hits < b.Hits
+	}
+	return a.Used < b.Used
+}
+
+// save writes the cache index to disk. Caller holds c.mu.
+func (c *fetchCache) save() error {
+	x := cacheIndex{Entries: make([]*cacheEntry, 0, len(c.entries)), Pins: make([]string, 0, len(c.pins))}
+	for _, e := range c.entries {
+		x.Entries = append(x.Entries, e)
+	}
+	for key := range c.pins {
+		x.Pins = append(x.Pins, key)
+	}
+	b, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, b)
+}
+
+// readCached returns the cached copy of key and counts the read, if there is one
+func (s *FileServer) readCached(ctx context.Context, key string) (io.Reader, bool) {
+	if !s.cache.has(key) {
+		return nil, false
+	}
+	_, r, err := s.readLocal(ctx, cacheID, key)
+	if err != nil {
+		return nil, false
+	}
+	s.cache.touch(key)
+	return r, true
+}
+
+// cacheCopy records a copy of key written to the cache and deletes the copies evicted for it
+func (s *FileServer) cacheCopy(key string, size int64) {
+	evicted, err := s.cache.add(key, size)
+	if err != nil {
+		s.logger.Error("saving cache index failed", "err", err)
+	}
+	s.dropCached(evicted)
+}
+
+// dropCached deletes evicted copies from the cache directory
+func (s *FileServer) dropCached(keys []string) {
+	for _, key := range keys {
+		s.logger.Debug("evicted cached copy", "key", key)
+		s.deleteLocal(cacheID, key)
+	}
+	s.metrics.evictions.Add(float64(len(keys)))
+}
+
+// Pin keeps a copy of key on this node until Unpin is called: a cached copy is never evicted,
+// and a key with no local copy is fetched into the cache now.
+func (s *FileServer) Pin(key string) error {
+	return s.PinContext(context.Background(), key)
+}
+
+// PinContext is Pin as part of the operation in ctx
+func (s *FileServer) PinContext(ctx context.Context, key string) error {
+	if err := s.cache.pin(key); err != nil {
+		return err
+	}
+	if s.store.Has(s.owner(key), key) || s.cache.has(key) {
+		return nil
+	}
+
+	r, err := s.GetContext(ctx, key)
+	if err != nil {
+		s.cache.unpin(key)
+		return err
+	}
+	if rc, ok := r.(io.Closer); ok {
+		rc.Close()
+	}
+	return nil
+}
+
+// Unpin lets the cached copy of key be evicted again
+func (s *FileServer) Unpin(key string) error {
+	evicted, ok, err := s.cache.unpin(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s is not pinned", ErrNotFound, key)
+	}
+	s.dropCached(evicted)
+	return nil
+}
+
+// Pins returns the pinned keys in order
+func (s *FileServer) Pins() []string {
+	return s.cache.pinned()
+}
+
+// CacheStats returns the size and contents of the cache of files fetched from peers
+func (s *FileServer) CacheStats() CacheStats {
+	return s.cache.stats()
+}
+
+// setCache applies new cache options, evicting what no longer fits
+func (s *FileServer) setCache(opts CacheOpts) {
+	evicted, err := s.cache.setOpts(opts)
+	if err != nil {
+		s.logger.Error("saving cache index failed", "err", err)
+	}
+	s.dropCached(evicted)
+}
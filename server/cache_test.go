@@ -0,0 +1,94 @@
+// Tests for the cache of files fetched from peers
+package server
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCache checks that fetched copies land in a bounded cache apart from the node's own files,
+// that LRU and LFU evict the right copies, and that pinned keys stay
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	// Replicate four files of 1000 bytes to s1, then drop the local copies so Get has to fetch them
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, s2.Store(key, bytes.NewReader(bytes.Repeat([]byte(key), 1000))))
+		assert.Nil(t, s2.store.Delete(s2.ID, key))
+	}
+	used := s2.Capacity().Used
+
+	st := s2.Settings()
+	st.Cache = CacheOpts{Size: 2500}
+	s2.Reload(st)
+
+	get := func(key string) {
+		r, err := s2.Get(key)
+		assert.Nil(t, err)
+		b, _ := io.ReadAll(r)
+		r.(io.Closer).Close()
+		assert.Equal(t, bytes.Repeat([]byte(key), 1000), b)
+	}
+	cached := func() []string {
+		keys := []string{}
+		for _, key := range []string{"a", "b", "c", "d"} {
+			if s2.store.Has(cacheID, key) {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+
+	// LRU: a was read after b, so b makes room for c
+	get("a")
+	get("b")
+	get("a")
+	assert.False(t, s2.store.Has(s2.ID, "a"))
+	get("c")
+	assert.Equal(t, []string{"a", "c"}, cached())
+	assert.Equal(t, CacheStats{Bytes: 2000, Limit: 2500, Entries: 2}, s2.CacheStats())
+	assert.Equal(t, used, s2.Capacity().Used) // Cached copies are not the node's own storage
+
+	// LFU: a was read twice, c once, so c makes room for d
+	st.Cache.Eviction = EvictLFU
+	s2.Reload(st)
+	get("a")
+	get("d")
+	assert.Equal(t, []string{"a", "d"}, cached())
+
+	// A pinned key is fetched right away and outlives other copies
+	assert.Nil(t, s2.Pin("b"))
+	assert.Equal(t, []string{"b"}, s2.Pins())
+	get("c")
+	get("c")
+	get("c")
+	assert.Contains(t, cached(), "b")
+
+	// Shrinking the cache keeps only pinned copies, unpinning lets them go
+	st.Cache.Size = 500
+	s2.Reload(st)
+	assert.Equal(t, []string{"b"}, cached())
+	assert.Nil(t, s2.Unpin("b"))
+	assert.Empty(t, cached())
+	assert.ErrorIs(t, s2.Unpin("b"), ErrNotFound)
+
+	// Deleting a key drops its cached copy and its pin
+	st.Cache.Size = 0
+	s2.Reload(st)
+	get("a")
+	assert.Nil(t, s2.Pin("a"))
+	assert.Nil(t, s2.Delete("a"))
+	assert.Empty(t, cached())
+	assert.Empty(t, s2.Pins())
+}
@@ -0,0 +1,112 @@
+// Conditional writes for GoVaultFS
+// A Store can require a condition on the latest version of its key: that there is none, for create-only writes,
+// or that it has a given ETag or version ID, for compare-and-swap. The node a key is stored through owns it, and
+// writes to a key take turns there, so the condition is checked against the latest version and the new version
+// takes its place without another write in between. A create-only write also requires that no other node has stored
+// the key: its replicas are sent as create-only, a peer that holds the key for another node refuses them before any
+// data is sent, and the write is then undone.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrPreconditionFailed is returned by a conditional Store whose condition does not hold
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// errUnsupportedCondition is returned for an If-None-Match header other than *
+var errUnsupportedCondition = errors.New("only * is supported in If-None-Match")
+
+// Precondition is what a conditional Store requires of the latest version of its key.
+// The zero value requires nothing.
+type Precondition struct {
+	IfNoneMatch bool   // The key has no version yet
+	IfMatch     string // The latest version has this ETag or version ID, or * for any version
+}
+
+// check returns ErrPreconditionFailed if the condition does not hold for the latest version of key,
+// which exists if ok is set
+func (p Precondition) check(key string, latest ObjectInfo, ok bool) error {
+	if p.IfNoneMatch && ok {
+		return fmt.Errorf("%w: %s exists", ErrPreconditionFailed, key)
+	}
+	if len(p.IfMatch) > 0 {
+		if !ok {
+			return fmt.Errorf("%w: %s does not exist", ErrPreconditionFailed, key)
+		}
+		if p.IfMatch != "*" && p.IfMatch != latest.ETag && p.IfMatch != latest.Version {
+			return fmt.Errorf("%w: latest version of %s is %s", ErrPreconditionFailed, key, latest.Version)
+		}
+	}
+	return nil
+}
+
+// set reports whether the condition requires anything
+func (p Precondition) set() bool {
+	return p.IfNoneMatch || len(p.IfMatch) > 0
+}
+
+// heldElsewhere reports whether this node holds the file under a hashed key for an owner other than owner:
+// as a replica of another node's file, or as its own object
+func (s *FileServer) heldElsewhere(owner string, hash string) bool {
+	for _, o := range s.replicas.owners(hash) {
+		if o != owner && s.store.Has(o, hash) {
+			return true
+		}
+	}
+	if key, ok := s.index.hashed(hash); ok && s.owner(key) != owner {
+		return true
+	}
+	return false
+}
+
+// checkCreate returns ErrPreconditionFailed for a create-only replica of a file this node holds for another owner
+func (s *FileServer) checkCreate(owner string, msg MessageStoreFile) error {
+	if msg.Create && s.heldElsewhere(owner, msg.Key) {
+		return fmt.Errorf("%w: (%s) exists on %s", ErrPreconditionFailed, msg.Key, shortID(s.ID))
+	}
+	return nil
+}
+
+// undoCreate removes a create-only write that a peer refused because another node had stored the key
+func (s *FileServer) undoCreate(ctx context.Context, key string) {
+	if err := s.index.remove(key); err != nil {
+		s.logger.Error("indexing object failed", "key", key, "err", err)
+	}
+	s.publish(Event{Type: EventDeleted, Key: key})
+	if err := s.dropFile(ctx, key); err != nil {
+		s.logger.Warn("undoing create failed", "key", key, "err", err)
+	}
+}
+
+// StoreIf stores a file like Store if the latest version of key meets the condition,
+// and returns ErrPreconditionFailed otherwise
+func (s *FileServer) StoreIf(key string, r io.Reader, cond Precondition) error {
+	return s.StoreIfContext(context.Background(), key, r, cond)
+}
+
+// StoreIfContext is StoreIf as part of the operation in ctx
+func (s *FileServer) StoreIfContext(ctx context.Context, key string, r io.Reader, cond Precondition) error {
+	return s.storeWithPolicy(ctx, key, r, s.policyFor(key), cond)
+}
+
+// headerPrecondition reads the condition of a PUT from its If-Match and If-None-Match headers.
+// Entity tags may be quoted, as servers send them in ETag headers.
+func headerPrecondition(h http.Header) (Precondition, error) {
+	var p Precondition
+	if v := strings.TrimSpace(h.Get("If-None-Match")); len(v) > 0 {
+		if v != "*" {
+			return p, errUnsupportedCondition
+		}
+		p.IfNoneMatch = true
+	}
+	if v := strings.TrimSpace(h.Get("If-Match")); len(v) > 0 {
+		p.IfMatch = strings.Trim(strings.TrimPrefix(v, "W/"), `"`)
+	}
+	return p, nil
+}
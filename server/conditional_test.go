@@ -0,0 +1,133 @@
+// Tests for conditional writes
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConditionalStore checks create-only and compare-and-swap writes, that of several writers swapping the same
+// version exactly one wins, and that the gateway maps the headers and failures
+func TestConditionalStore(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	// Create only if absent
+	create := Precondition{IfNoneMatch: true}
+	assert.Nil(t, s2.StoreIf("lock", bytes.NewReader([]byte("mine")), create))
+	assert.ErrorIs(t, s2.StoreIf("lock", bytes.NewReader([]byte("yours")), create), ErrPreconditionFailed)
+	assert.ErrorIs(t, s2.StoreIf("missing", bytes.NewReader(nil), Precondition{IfMatch: "*"}), ErrPreconditionFailed)
+
+	// Replace only if unchanged, by ETag or version ID
+	info, _ := s2.Stat("lock")
+	assert.Nil(t, s2.StoreIf("lock", bytes.NewReader([]byte("released")), Precondition{IfMatch: info.ETag}))
+	assert.ErrorIs(t, s2.StoreIf("lock", bytes.NewReader([]byte("stale")), Precondition{IfMatch: info.ETag}), ErrPreconditionFailed)
+	info, _ = s2.Stat("lock")
+	assert.Nil(t, s2.StoreIf("lock", bytes.NewReader([]byte("again")), Precondition{IfMatch: info.Version}))
+
+	// Of the writers that all read the same version, one swaps it and the others fail
+	info, _ = s2.Stat("lock")
+	errs := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func(i int) {
+			errs <- s2.StoreIf("lock", bytes.NewReader([]byte(fmt.Sprintf("writer %d", i))), Precondition{IfMatch: info.Version})
+		}(i)
+	}
+	won := 0
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err == nil {
+			won++
+		} else {
+			assert.ErrorIs(t, err, ErrPreconditionFailed)
+		}
+	}
+	assert.Equal(t, 1, won)
+	latest, _ := s2.Stat("lock")
+	assert.Empty(t, latest.Siblings)
+	versions, _ := s2.ListVersions("lock")
+	assert.Len(t, versions, 4)
+
+	// The gateway reads the conditions from headers
+	ts := httptest.NewServer(NewGateway(s2))
+	defer ts.Close()
+	put := func(key string, header string, value string) int {
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/objects/"+key, bytes.NewReader([]byte("body")))
+		req.Header.Set(header, value)
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.Nil(t, err) {
+			return 0
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusCreated, put("new", "If-None-Match", "*"))
+	assert.Equal(t, http.StatusPreconditionFailed, put("new", "If-None-Match", "*"))
+	assert.Equal(t, http.StatusBadRequest, put("new", "If-None-Match", `"abc"`))
+	info, _ = s2.Stat("new")
+	assert.Equal(t, http.StatusCreated, put("new", "If-Match", `"`+info.ETag+`"`))
+	assert.Equal(t, http.StatusPreconditionFailed, put("new", "If-Match", `"`+info.Version+`"`))
+}
+
+// TestConditionalCreateRace checks that a key created through one node cannot be created through another: by the
+// second node itself if it holds a replica of the key, by its peers otherwise, and that of two nodes creating the
+// same key at once at most one succeeds
+func TestConditionalCreateRace(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	create := Precondition{IfNoneMatch: true}
+	assert.Nil(t, s1.StoreIf("lock", bytes.NewReader([]byte("s1")), create))
+	assert.ErrorIs(t, s2.StoreIf("lock", bytes.NewReader([]byte("s2")), create), ErrPreconditionFailed)
+	_, err := s2.Stat("lock")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Without a replica the peer refuses the create, and the write is undone
+	assert.Nil(t, s1.Store("job", bytes.NewReader([]byte("s1"))))
+	hash := crypto.HashKey("job")
+	assert.Nil(t, s2.store.Delete(s1.ID, hash))
+	s2.dropReplica(s1.ID, hash)
+	assert.ErrorIs(t, s2.StoreIf("job", bytes.NewReader([]byte("s2")), create), ErrPreconditionFailed)
+	_, err = s2.Stat("job")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.False(t, s2.store.Has(s2.ID, "job"))
+	assert.False(t, s1.store.Has(s2.ID, hash))
+
+	// Racing creates
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("race-%d", i)
+		errs := make(chan error)
+		for _, s := range []*FileServer{s1, s2} {
+			go func(s *FileServer) { errs <- s.StoreIf(key, bytes.NewReader([]byte(shortID(s.ID))), create) }(s)
+		}
+		won := 0
+		for j := 0; j < 2; j++ {
+			if err := <-errs; err == nil {
+				won++
+			} else {
+				assert.ErrorIs(t, err, ErrPreconditionFailed)
+			}
+		}
+		assert.LessOrEqual(t, won, 1, key)
+	}
+}
@@ -0,0 +1,433 @@
+// Conflict detection for GoVaultFS
+// Every version of an object carries a version vector of the writes it descends from and a hybrid logical clock
+// timestamp. A Store notes the versions of its key it has seen when it begins; writes to a key then take turns, and
+// if another write finished in the meantime the two are concurrent siblings. A ConflictResolver decides which one
+// becomes the latest: the later by timestamp, both, or whatever a custom resolver returns. Siblings kept side by side
+// make Get fail with a ConflictError listing them until a Store that has seen all of them replaces them.
+// Replicas carry the vector and timestamp of their version to peers. A peer drops a replica older than the one it
+// holds, replaces the one it holds with a newer one, and keeps concurrent replicas side by side as siblings.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replicasFileName names the file in the storage root that keeps the versions of the replicas held for peers
+const replicasFileName = "replicas.json"
+
+// VersionVector counts the writes of each node a version descends from, by node ID
+type VersionVector map[string]uint64
+
+// Covers reports whether the vector includes the n-th write of a node
+func (v VersionVector) Covers(node string, n uint64) bool {
+	return v[node] >= n
+}
+
+// Descends reports whether the vector includes every write of another vector
+func (v VersionVector) Descends(o VersionVector) bool {
+	for node, n := range o {
+		if !v.Covers(node, n) {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge returns a vector that includes the writes of both vectors
+func (v VersionVector) Merge(o VersionVector) VersionVector {
+	out := maps.Clone(v)
+	if out == nil {
+		out = make(VersionVector)
+	}
+	for node, n := range o {
+		out[node] = max(out[node], n)
+	}
+	return out
+}
+
+// Timestamp is a hybrid logical clock reading: wall time in nanoseconds and a counter that orders
+// events within the same wall time
+type Timestamp struct {
+	Wall    int64  `json:"wall"`
+	Logical uint32 `json:"logical"`
+}
+
+// Before reports whether t happened before o
+func (t Timestamp) Before(o Timestamp) bool {
+	return t.Wall < o.Wall || (t.Wall == o.Wall && t.Logical < o.Logical)
+}
+
+// hlc is a hybrid logical clock. It follows wall time but never runs backwards,
+// and moves past the timestamps it sees from peers.
+type hlc struct {
+	mu   sync.Mutex
+	last Timestamp
+}
+
+// now returns a timestamp after every one the clock returned or observed before
+func (c *hlc) now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if wall := time.Now().UnixNano(); wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// observe moves the clock past a timestamp seen from a peer
+func (c *hlc) observe(t Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.last.Before(t) {
+		c.last = t
+	}
+}
+
+// Resolution is what a ConflictResolver keeps of two concurrent versions
+type Resolution int
+
+// Resolutions
+const (
+	KeepExisting Resolution = iota + 1 // The version stored first stays the latest, the other joins the history
+	KeepIncoming                       // The version stored last becomes the latest, the other joins the history
+	KeepBoth                           // Both are kept as siblings until a later Store replaces them
+)
+
+// String returns the name of a resolution
+func (r Resolution) String() string {
+	switch r {
+	case KeepExisting:
+		return "keep-existing"
+	case KeepIncoming:
+		return "keep-incoming"
+	case KeepBoth:
+		return "keep-both"
+	}
+	return "unknown"
+}
+
+// ConflictResolver decides between the latest version of a key and a concurrent version being stored
+type ConflictResolver func(key string, existing ObjectInfo, incoming ObjectInfo) Resolution
+
+// LastWriterWins keeps the version with the later timestamp, the default resolver
+func LastWriterWins(key string, existing ObjectInfo, incoming ObjectInfo) Resolution {
+	if incoming.Clock.Before(existing.Clock) {
+		return KeepExisting
+	}
+	return KeepIncoming
+}
+
+// KeepSiblings keeps both versions, so readers see the conflict and resolve it
+func KeepSiblings(key string, existing ObjectInfo, incoming ObjectInfo) Resolution {
+	return KeepBoth
+}
+
+// ErrConflict is returned by Get for a key whose latest version has concurrent siblings
+var ErrConflict = errors.New("conflicting versions")
+
+// ConflictError lists the concurrent versions of a key. Each can be read with GetVersion;
+// a Store or RestoreVersion replaces them all.
+type ConflictError struct {
+	Key      string
+	Versions []ObjectInfo // The latest version and its siblings
+}
+
+// Error describes the conflict
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: %d %s", e.Key, len(e.Versions), ErrConflict)
+}
+
+// Unwrap returns ErrConflict
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// concurrent reports whether a version was written without the writer having seen it
+func concurrent(info ObjectInfo, seen VersionVector) bool {
+	return len(info.Writer) > 0 && !seen.Covers(info.Writer, info.Vector[info.Writer])
+}
+
+// resolve decides how a new version of a key relates to the versions in the index, given the vector of the versions
+// its writer had seen. It returns the IDs of the versions to keep as its siblings if both are kept.
+func (s *FileServer) resolve(key string, seen VersionVector, incoming ObjectInfo) (Resolution, []string) {
+	latest, ok := s.index.get(key)
+	if !ok || !concurrent(latest, seen) {
+		return KeepIncoming, nil
+	}
+
+	resolver := s.Resolver
+	if resolver == nil {
+		resolver = LastWriterWins
+	}
+	resolution := resolver(key, latest, incoming)
+	s.logger.Info("concurrent write", "key", key, "version", incoming.Version, "latest", latest.Version, "resolution", resolution)
+	s.metrics.conflicts.Inc(resolution.String())
+	switch resolution {
+	case KeepExisting:
+		return KeepExisting, nil
+	case KeepBoth:
+	default:
+		return KeepIncoming, nil
+	}
+
+	siblings := []string{latest.Version}
+	for _, id := range latest.Siblings {
+		if info, ok := s.index.version(key, id); ok && concurrent(info, seen) {
+			siblings = append(siblings, id)
+		}
+	}
+	return resolution, siblings
+}
+
+// conflict returns a ConflictError if the latest version of a key has siblings
+func (s *FileServer) conflict(key string) error {
+	latest, ok := s.index.get(key)
+	if !ok || len(latest.Siblings) == 0 {
+		return nil
+	}
+	versions := []ObjectInfo{latest}
+	for _, id := range latest.Siblings {
+		if info, ok := s.index.version(key, id); ok {
+			versions = append(versions, info)
+		}
+	}
+	if len(versions) == 1 {
+		return nil // Retention dropped the siblings
+	}
+	return &ConflictError{Key: key, Versions: versions}
+}
+
+// replicaVersion is the version of a replica a peer stored here, along with the concurrent replicas kept beside it
+type replicaVersion struct {
+	Key      string           `json:"key,omitempty"` // Where a sibling is stored, empty for the replica itself
+	Vector   VersionVector    `json:"vector"`
+	Clock    Timestamp        `json:"clock"`
+	Siblings []replicaVersion `json:"siblings,omitempty"`
+}
+
+// replicaIndex keeps the versions of the replicas held for peers by owner directory and key, and persists them to disk
+type replicaIndex struct {
+	mu       sync.Mutex
+	path     string
+	versions map[string]replicaVersion
+}
+
+// openReplicaIndex loads the replica versions stored at path, starting empty if there are none
+func openReplicaIndex(path string) (*replicaIndex, error) {
+	x := &replicaIndex{path: path, versions: make(map[string]replicaVersion)}
+	return x, readJSON(path, &x.versions)
+}
+
+// get returns the version of a replica
+func (x *replicaIndex) get(owner string, key string) (replicaVersion, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	v, ok := x.versions[owner+"/"+key]
+	return v, ok
+}
+
+// put records the version of a replica and saves the index
+func (x *replicaIndex) put(owner string, key string, v replicaVersion) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.versions[owner+"/"+key] = v
+	return x.save()
+}
+
+// remove forgets the version of a replica and saves the index. It returns the version, whose siblings are left to delete.
+func (x *replicaIndex) remove(owner string, key string) (replicaVersion, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	v, ok := x.versions[owner+"/"+key]
+	if ok {
+		delete(x.versions, owner+"/"+key)
+		x.save()
+	}
+	return v, ok
+}
+
+// owners returns the owner directories holding a replica under key
+func (x *replicaIndex) owners(key string) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	owners := []string{}
+	for k := range x.versions {
+		if owner, ok := strings.CutSuffix(k, "/"+key); ok {
+			owners = append(owners, owner)
+		}
+	}
+	return owners
+}
+
+// move records the version of a replica under another key and saves the index
+func (x *replicaIndex) move(owner string, from string, to string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	v, ok := x.versions[owner+"/"+from]
+	if !ok {
+		return nil
+	}
+	delete(x.versions, owner+"/"+from)
+	x.versions[owner+"/"+to] = v
+	return x.save()
+}
+
+// save writes the index to disk. Caller holds x.mu.
+func (x *replicaIndex) save() error {
+	b, err := json.Marshal(x.versions)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(x.path, b)
+}
+
+// siblingKey names a replica kept beside a concurrent one in local storage
+func siblingKey(key string, id string) string {
+	return key + ".sibling-" + id
+}
+
+// storeReplica writes a replica a peer sends on r and returns the bytes received. A replica carrying a vector is
+// compared with the one held here: it is dropped if the one held here descends from it and is not older, it replaces
+// the one held here if it descends from that, and otherwise it is concurrent and both are kept, the later by
+// timestamp under the key. Replicas without a vector, shards and older versions, replace whatever is there.
+func (s *FileServer) storeReplica(ctx context.Context, owner string, msg MessageStoreFile, r io.Reader) (int64, error) {
+	if len(msg.Vector) == 0 {
+		return s.writeReplica(ctx, owner, msg.Key, r, msg.Size)
+	}
+
+	unlock := s.replicaLocks.lock(owner + "/" + msg.Key)
+	defer unlock()
+
+	incoming := replicaVersion{Vector: msg.Vector, Clock: msg.Clock}
+	held, ok := s.replicas.get(owner, msg.Key)
+	if !ok || !s.store.Has(owner, msg.Key) {
+		n, err := s.writeReplica(ctx, owner, msg.Key, r, msg.Size)
+		if err != nil {
+			return n, err
+		}
+		incoming.Siblings = held.Siblings
+		return n, s.replicas.put(owner, msg.Key, incoming)
+	}
+
+	switch {
+	case held.Vector.Descends(incoming.Vector) && !held.Clock.Before(incoming.Clock):
+		// A retry or a write that arrived late
+		n, err := io.Copy(io.Discard, r)
+		if err == nil && n != msg.Size {
+			err = fmt.Errorf("short transfer: %d of %d bytes", n, msg.Size)
+		}
+		s.logger.Debug("dropped older replica", "key", msg.Key, "owner", owner)
+		return n, err
+
+	case incoming.Vector.Descends(held.Vector):
+		n, err := s.writeReplica(ctx, owner, msg.Key, r, msg.Size)
+		if err != nil {
+			return n, err
+		}
+		for _, sibling := range held.Siblings {
+			if incoming.Vector.Descends(sibling.Vector) {
+				s.deleteLocal(owner, sibling.Key)
+			} else {
+				incoming.Siblings = append(incoming.Siblings, sibling)
+			}
+		}
+		return n, s.replicas.put(owner, msg.Key, incoming)
+	}
+
+	// Concurrent replicas are kept side by side, the later one under the key
+	incoming.Key = siblingKey(msg.Key, newVersionID(time.Now()))
+	n, err := s.writeReplica(ctx, owner, incoming.Key, r, msg.Size)
+	if err != nil {
+		return n, err
+	}
+	s.logger.Info("concurrent replica", "key", msg.Key, "owner", owner)
+	s.metrics.conflicts.Inc(KeepBoth.String())
+
+	latest, sibling := held, incoming
+	if held.Clock.Before(incoming.Clock) {
+		latest, sibling = incoming, held
+		sibling.Key = siblingKey(msg.Key, newVersionID(time.Now()))
+		if err := s.store.Rename(owner, msg.Key, sibling.Key); err != nil {
+			s.deleteLocal(owner, incoming.Key)
+			return n, err
+		}
+		if err := s.store.Rename(owner, incoming.Key, msg.Key); err != nil {
+			return n, err
+		}
+		latest.Key = ""
+	}
+	latest.Siblings = append(held.Siblings, replicaVersion{Key: sibling.Key, Vector: sibling.Vector, Clock: sibling.Clock})
+	return n, s.replicas.put(owner, msg.Key, latest)
+}
+
+// writeReplica writes a replica of size bytes a peer sends, and drops it if the transfer falls short
+func (s *FileServer) writeReplica(ctx context.Context, owner string, key string, r io.Reader, size int64) (int64, error) {
+	n, err := s.writeLocal(ctx, owner, key, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("short transfer: %d of %d bytes", n, size)
+	}
+	if err != nil {
+		s.store.Delete(owner, key) // Don't keep a truncated replica
+	}
+	return n, err
+}
+
+// dropReplica forgets the version of a replica that was deleted and deletes the siblings kept beside it
+func (s *FileServer) dropReplica(owner string, key string) {
+	v, ok := s.replicas.remove(owner, key)
+	if !ok {
+		return
+	}
+	for _, sibling := range v.Siblings {
+		s.deleteLocal(owner, sibling.Key)
+	}
+}
+
+// keyLocks makes writes to the same key take turns
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is the lock of one key and the number of writers holding or waiting for it
+type keyLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock waits for the turn of a write to key and returns the function that ends it
+func (k *keyLocks) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.users++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		if l.users--; l.users == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Tests for conflict detection of concurrent writes
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// storeConcurrently runs two Stores of key that have both begun before either finishes, the first one
+// finishing first, and returns their errors
+func storeConcurrently(t *testing.T, s *FileServer, key string, first string, second string) (error, error) {
+	pr, pw := io.Pipe()
+	errs1, errs2 := make(chan error, 1), make(chan error, 1)
+	go func() { errs1 <- s.Store(key, pr) }()
+	pw.Write([]byte(first[:1])) // Returns once the first Store reads its file, in its turn
+	go func() { errs2 <- s.Store(key, bytes.NewReader([]byte(second))) }()
+	waitFor(t, 5*time.Second, func() bool { return keyWriters(s, key) == 2 }) // The second has seen the versions before the first and waits for its turn
+	pw.Write([]byte(first[1:]))
+	pw.Close()
+	return <-errs1, <-errs2
+}
+
+// keyWriters returns the number of writes of key holding or waiting for its turn
+func keyWriters(s *FileServer, key string) int {
+	s.keyLocks.mu.Lock()
+	defer s.keyLocks.mu.Unlock()
+	if l, ok := s.keyLocks.locks[key]; ok {
+		return l.users
+	}
+	return 0
+}
+
+// sendReplica sends a replica of a file of s to a peer as Store does, and returns the bytes the peer confirms
+func sendReplica(t *testing.T, s *FileServer, peer p2p.Peer, key string, data string, vector VersionVector, clock Timestamp) int64 {
+	msg := Message{Payload: MessageStoreFile{ID: s.ID, Key: key, Size: int64(len(data)), Clock: clock, Vector: vector}}
+	st, _, err := s.openStoreStream(context.Background(), peer, &msg)
+	if !assert.Nil(t, err) {
+		return 0
+	}
+	defer st.Close()
+	st.Write([]byte(data))
+	var n int64
+	assert.Nil(t, binary.Read(st, binary.LittleEndian, &n))
+	return n
+}
+
+// TestConflicts checks that concurrent writes of a key are detected and resolved by the configured resolver,
+// that siblings kept side by side fail Get with a ConflictError until a later Store replaces them, and that
+// writes in sequence are never taken for conflicts
+func TestConflicts(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	read := func(r io.Reader, err error) string {
+		assert.Nil(t, err)
+		if err != nil {
+			return ""
+		}
+		b, _ := io.ReadAll(r)
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+		return string(b)
+	}
+
+	// Writes in sequence each descend from the one before
+	assert.Nil(t, s2.Store("seq", bytes.NewReader([]byte("one"))))
+	assert.Nil(t, s2.Store("seq", bytes.NewReader([]byte("two"))))
+	seq, _ := s2.ListVersions("seq")
+	assert.Equal(t, VersionVector{s2.ID: 2}, seq[0].Vector)
+	assert.Empty(t, seq[0].Siblings)
+	assert.True(t, seq[1].Clock.Before(seq[0].Clock))
+
+	// By default the write with the later timestamp wins and the other one joins the history
+	err1, err2 := storeConcurrently(t, s2, "lww", "first", "second")
+	assert.Nil(t, err1)
+	assert.Nil(t, err2)
+	assert.Equal(t, "second", read(s2.Get("lww")))
+	versions, _ := s2.ListVersions("lww")
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "first", read(s2.GetVersion("lww", versions[1].Version)))
+
+	// A resolver that keeps the existing version stores the incoming one straight into the history, on peers too
+	st := s2.Settings()
+	s2.Resolver = func(key string, existing ObjectInfo, incoming ObjectInfo) Resolution { return KeepExisting }
+	err1, err2 = storeConcurrently(t, s2, "first-wins", "first", "second")
+	assert.Nil(t, err1)
+	assert.Nil(t, err2)
+	assert.Equal(t, "first", read(s2.Get("first-wins")))
+	versions, _ = s2.ListVersions("first-wins")
+	assert.Len(t, versions, 2)
+	assert.True(t, versions[0].Version < versions[1].Version) // The history holds a version newer than the latest
+	assert.True(t, s1.store.Has(s2.ID, crypto.HashKey(versionKey("first-wins", versions[1].Version))))
+	assert.Nil(t, s2.store.Delete(s2.ID, versionKey("first-wins", versions[1].Version)))
+	assert.Equal(t, "second", read(s2.GetVersion("first-wins", versions[1].Version))) // Fetched from s1
+
+	// Siblings kept side by side are reported until a write that has seen both replaces them
+	s2.Resolver = KeepSiblings
+	st.Versions = VersionOpts{Keep: 1}
+	s2.Reload(st)
+	err1, err2 = storeConcurrently(t, s2, "both", "first", "second")
+	assert.Nil(t, err1)
+	assert.Nil(t, err2)
+	_, err := s2.Get("both")
+	assert.ErrorIs(t, err, ErrConflict)
+	var conflict *ConflictError
+	if assert.ErrorAs(t, err, &conflict) {
+		assert.Len(t, conflict.Versions, 2)
+		assert.Equal(t, "second", read(s2.GetVersion("both", conflict.Versions[0].Version)))
+		assert.Equal(t, "first", read(s2.GetVersion("both", conflict.Versions[1].Version)))
+	}
+
+	assert.Nil(t, s2.Store("both", bytes.NewReader([]byte("merged"))))
+	assert.Equal(t, "merged", read(s2.Get("both")))
+	versions, _ = s2.ListVersions("both")
+	assert.Len(t, versions, 1) // Retention drops the siblings once they are resolved
+	assert.Equal(t, VersionVector{s2.ID: 3}, versions[0].Vector)
+}
+
+// TestReplicaConflicts checks that a peer compares the vector of a replica with the one it holds: it drops older
+// replicas, replaces its replica with newer ones, keeps concurrent ones side by side with the later one under the key,
+// and drops the siblings once a replica that descends from them arrives or the file is deleted
+func TestReplicaConflicts(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	peer, ok := s2.memberPeer(s1.ID)
+	assert.True(t, ok)
+	key := crypto.HashKey("doc")
+	read := func(key string) string {
+		_, r, err := s1.store.Read(s2.ID, key)
+		if !assert.Nil(t, err) {
+			return ""
+		}
+		defer r.(io.Closer).Close()
+		b, _ := io.ReadAll(r)
+		return string(b)
+	}
+	a, b := "node-a", "node-b"
+
+	// A newer replica replaces the one held, an older one does not
+	assert.Equal(t, int64(3), sendReplica(t, s2, peer, key, "one", VersionVector{a: 1}, Timestamp{Wall: 1}))
+	assert.Equal(t, int64(3), sendReplica(t, s2, peer, key, "two", VersionVector{a: 2}, Timestamp{Wall: 2}))
+	assert.Equal(t, int64(3), sendReplica(t, s2, peer, key, "one", VersionVector{a: 1}, Timestamp{Wall: 1}))
+	assert.Equal(t, "two", read(key))
+
+	// Concurrent replicas are both kept, the later one under the key, whichever arrives first
+	assert.Equal(t, int64(5), sendReplica(t, s2, peer, key, "three", VersionVector{a: 2, b: 1}, Timestamp{Wall: 4}))
+	assert.Equal(t, int64(4), sendReplica(t, s2, peer, key, "four", VersionVector{a: 3}, Timestamp{Wall: 3}))
+	assert.Equal(t, "three", read(key))
+	held, ok := s1.replicas.get(s2.ID, key)
+	if assert.True(t, ok) && assert.Len(t, held.Siblings, 1) {
+		assert.Equal(t, VersionVector{a: 3}, held.Siblings[0].Vector)
+		assert.Equal(t, "four", read(held.Siblings[0].Key))
+	}
+
+	// A replica that has seen both replaces them
+	assert.Equal(t, int64(6), sendReplica(t, s2, peer, key, "merged", VersionVector{a: 3, b: 1}, Timestamp{Wall: 5}))
+	assert.Equal(t, "merged", read(key))
+	held, _ = s1.replicas.get(s2.ID, key)
+	assert.Empty(t, held.Siblings)
+	assert.Equal(t, int64(6), s1.store.Usage(s2.ID))
+
+	// Deleting the file drops its siblings too
+	assert.Equal(t, int64(4), sendReplica(t, s2, peer, key, "five", VersionVector{b: 2}, Timestamp{Wall: 6}))
+	held, _ = s1.replicas.get(s2.ID, key)
+	assert.Len(t, held.Siblings, 1)
+	s2.broadcast(&Message{Payload: MessageDeleteFile{ID: s2.ID, Key: key}})
+	waitFor(t, 5*time.Second, func() bool { return s1.store.Usage(s2.ID) == 0 })
+	_, ok = s1.replicas.get(s2.ID, key)
+	assert.False(t, ok)
+
+	// Replicas written by Store carry the vector of their version
+	assert.Nil(t, s2.Store("seq", bytes.NewReader([]byte("one"))))
+	held, ok = s1.replicas.get(s2.ID, crypto.HashKey("seq"))
+	assert.True(t, ok)
+	assert.Equal(t, VersionVector{s2.ID: 1}, held.Vector)
+}
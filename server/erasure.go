@@ -0,0 +1,285 @@
+// Reed-Solomon erasure coding for GoVaultFS
+// This file implements a systematic Reed-Solomon code over GF(2^8). A file is split into k data shards
+// and m parity shards are computed from them; any k of the k+m shards are enough to rebuild the file.
+// The encoding matrix is a Vandermonde matrix normalised so that its top k rows are the identity,
+// which keeps the data shards as plain slices of the input.
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the erasure coder
+var (
+	ErrTooFewShards   = errors.New("erasure: too few shards to reconstruct")
+	ErrShardSize      = errors.New("erasure: shards have different sizes")
+	errSingularMatrix = errors.New("erasure: matrix is singular")
+)
+
+// GF(2^8) arithmetic with the polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11d) and generator 2
+var (
+	gfExp [512]byte // Doubled so gfMul can skip the modulo
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+// gfMul multiplies two field elements
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfInv returns the multiplicative inverse of a non-zero field element
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// gfPow raises a field element to a power
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+// gfMatrix is a matrix over GF(2^8)
+type gfMatrix [][]byte
+
+// newMatrix allocates a zero matrix
+func newMatrix(rows, cols int) gfMatrix {
+	m := make(gfMatrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+// vandermonde returns the rows x cols matrix with m[r][c] = r^c, any cols rows of which are invertible
+func vandermonde(rows, cols int) gfMatrix {
+	m := newMatrix(rows, cols)
+	for r := range m {
+		for c := range m[r] {
+			m[r][c] = gfPow(byte(r), c)
+		}
+	}
+	return m
+}
+
+// mul returns the matrix product m * o
+func (m gfMatrix) mul(o gfMatrix) gfMatrix {
+	out := newMatrix(len(m), len(o[0]))
+	for r := range m {
+		for c := range o[0] {
+			var v byte
+			for i := range o {
+				v ^= gfMul(m[r][i], o[i][c])
+			}
+			out[r][c] = v
+		}
+	}
+	return out
+}
+
+// invert returns the inverse of a square matrix using Gauss-Jordan elimination
+func (m gfMatrix) invert() (gfMatrix, error) {
+	n := len(m)
+	work := newMatrix(n, 2*n)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		// Find a pivot and move it into place
+		p := c
+		for p < n && work[p][c] == 0 {
+			p++
+		}
+		if p == n {
+			return nil, errSingularMatrix
+		}
+		work[c], work[p] = work[p], work[c]
+
+		// Scale the pivot row to 1, then clear the column everywhere else
+		inv := gfInv(work[c][c])
+		for i := range work[c] {
+			work[c][i] = gfMul(work[c][i], inv)
+		}
+		for r := 0; r < n; r++ {
+			if r == c || work[r][c] == 0 {
+				continue
+			}
+			f := work[r][c]
+			for i := range work[r] {
+				work[r][i] ^= gfMul(f, work[c][i])
+			}
+		}
+	}
+
+	out := newMatrix(n, n)
+	for r := range out {
+		copy(out[r], work[r][n:])
+	}
+	return out, nil
+}
+
+// ReedSolomon encodes data into DataShards + ParityShards shards and rebuilds it from any DataShards of them
+type ReedSolomon struct {
+	DataShards   int
+	ParityShards int
+	matrix       gfMatrix // (DataShards+ParityShards) x DataShards encoding matrix, identity on top
+}
+
+// NewReedSolomon creates a coder with k data shards and m parity shards
+func NewReedSolomon(k, m int) (*ReedSolomon, error) {
+	if k <= 0 || m < 0 || k+m > 256 {
+		return nil, fmt.Errorf("erasure: invalid shard counts %d+%d", k, m)
+	}
+
+	v := vandermonde(k+m, k)
+	top, err := v[:k].invert()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReedSolomon{
+		DataShards:   k,
+		ParityShards: m,
+		matrix:       v.mul(top),
+	}, nil
+}
+
+// Split cuts data into DataShards equally sized shards, zero padding the last one,
+// and appends ParityShards parity shards
+func (rs *ReedSolomon) Split(data []byte) [][]byte {
+	size := (len(data) + rs.DataShards - 1) / rs.DataShards
+	if size == 0 {
+		size = 1
+	}
+
+	shards := make([][]byte, rs.DataShards+rs.ParityShards)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		if i < rs.DataShards && i*size < len(data) {
+			copy(shards[i], data[i*size:])
+		}
+	}
+
+	rs.encode(shards)
+	return shards
+}
+
+// Reconstruct rebuilds missing shards in place. Missing shards are nil; at least DataShards must be present.
+func (rs *ReedSolomon) Reconstruct(shards [][]byte) error {
+	if len(shards) != rs.DataShards+rs.ParityShards {
+		return fmt.Errorf("erasure: got %d shards, want %d", len(shards), rs.DataShards+rs.ParityShards)
+	}
+
+	// Pick the first DataShards shards that survived
+	present := []int{}
+	size := -1
+	for i, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		if size >= 0 && len(shard) != size {
+			return ErrShardSize
+		}
+		size = len(shard)
+		if len(present) < rs.DataShards {
+			present = append(present, i)
+		}
+	}
+	if len(present) < rs.DataShards {
+		return ErrTooFewShards
+	}
+
+	// The surviving rows of the encoding matrix map data to the surviving shards, so their inverse maps back
+	sub := make(gfMatrix, rs.DataShards)
+	for i, r := range present {
+		sub[i] = rs.matrix[r]
+	}
+	decode, err := sub.invert()
+	if err != nil {
+		return err
+	}
+
+	for d := 0; d < rs.DataShards; d++ {
+		if shards[d] != nil {
+			continue
+		}
+		shards[d] = make([]byte, size)
+		for i, r := range present {
+			mulAdd(shards[d], shards[r], decode[d][i])
+		}
+	}
+
+	// Recompute missing parity from the complete data
+	for p := rs.DataShards; p < len(shards); p++ {
+		if shards[p] == nil {
+			shards[p] = make([]byte, size)
+			for d := 0; d < rs.DataShards; d++ {
+				mulAdd(shards[p], shards[d], rs.matrix[p][d])
+			}
+		}
+	}
+
+	return nil
+}
+
+// Join concatenates the data shards and trims the padding to size bytes
+func (rs *ReedSolomon) Join(shards [][]byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < rs.DataShards && len(out) < size; i++ {
+		if shards[i] == nil {
+			return nil, ErrTooFewShards
+		}
+		out = append(out, shards[i]...)
+	}
+	if len(out) < size {
+		return nil, fmt.Errorf("erasure: shards hold %d bytes, want %d", len(out), size)
+	}
+	return out[:size], nil
+}
+
+// encode computes the parity shards from the data shards
+func (rs *ReedSolomon) encode(shards [][]byte) {
+	for p := rs.DataShards; p < len(shards); p++ {
+		for i := range shards[p] {
+			shards[p][i] = 0
+		}
+		for d := 0; d < rs.DataShards; d++ {
+			mulAdd(shards[p], shards[d], rs.matrix[p][d])
+		}
+	}
+}
+
+// mulAdd adds c * src to dst element-wise
+func mulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	for i, v := range src {
+		dst[i] ^= gfMul(c, v)
+	}
+}
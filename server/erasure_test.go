@@ -0,0 +1,157 @@
+// Tests for erasure coding in GoVaultFS
+// These tests check that the Reed-Solomon coder rebuilds data from any large enough subset of shards,
+// and that erasure-coded files survive the loss of as many nodes as they have parity shards.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+)
+
+// TestReedSolomonReconstruct drops every combination of up to ParityShards shards and rebuilds the data
+func TestReedSolomonReconstruct(t *testing.T) {
+	rs, err := NewReedSolomon(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 1001)
+	rand.Read(data)
+	shards := rs.Split(data)
+	if len(shards) != 6 {
+		t.Fatalf("got %d shards, want 6", len(shards))
+	}
+
+	for a := 0; a < len(shards); a++ {
+		for b := a; b < len(shards); b++ {
+			damaged := make([][]byte, len(shards))
+			copy(damaged, shards)
+			damaged[a], damaged[b] = nil, nil
+
+			if err := rs.Reconstruct(damaged); err != nil {
+				t.Fatalf("lost shards %d and %d: %s", a, b, err)
+			}
+			for i := range shards {
+				if !bytes.Equal(damaged[i], shards[i]) {
+					t.Fatalf("lost shards %d and %d: shard %d rebuilt wrong", a, b, i)
+				}
+			}
+			got, err := rs.Join(damaged, len(data))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("lost shards %d and %d: data rebuilt wrong", a, b)
+			}
+		}
+	}
+
+	// One shard too many is gone
+	damaged := [][]byte{nil, nil, nil, shards[3], shards[4], shards[5]}
+	if err := rs.Reconstruct(damaged); !errors.Is(err, ErrTooFewShards) {
+		t.Errorf("expected ErrTooFewShards, got %v", err)
+	}
+}
+
+// TestErasureStoreGet stores files as 3+2 shards on a five node cluster, stops two nodes
+// and checks that every file can still be rebuilt
+func TestErasureStoreGet(t *testing.T) {
+	t.Parallel()
+
+	c := newSimCluster(t, 3, 5, 0)
+	writer := c.servers[0]
+	writer.NamespacePolicies = map[string]StoragePolicy{"ec/": {DataShards: 3, ParityShards: 2}}
+
+	files := make(map[string][]byte)
+	for _, key := range []string{"ec/a", "ec/b", "ec/c"} {
+		data := bytes.Repeat([]byte(key), 3000)
+		if err := writer.Store(key, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if writer.store.Has(writer.ID, key) {
+			t.Errorf("writer kept a full copy of %s", key)
+		}
+		files[key] = data
+	}
+
+	c.stop(1)
+	c.stop(2)
+	c.waitConverged()
+
+	for key, want := range files {
+		r, err := writer.Get(key)
+		if err != nil {
+			t.Fatalf("file %s lost: %s", key, err)
+		}
+		got, _ := io.ReadAll(r)
+		if !bytes.Equal(got, want) {
+			t.Errorf("corrupt content for %s", key)
+		}
+	}
+}
+
+// TestFetchShardSize checks that a shard a peer announces with another size than expected is refused
+func TestFetchShardSize(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	peer, _ := s2.memberPeer(s1.ID)
+	shard := func(key string, size int) *Message {
+		if _, err := s1.store.Write(s2.ID, key, bytes.NewReader(make([]byte, size))); err != nil {
+			t.Fatal(err)
+		}
+		return &Message{Payload: MessageGetFile{ID: s2.ID, Key: key}}
+	}
+	ctx := context.Background()
+
+	msg := shard("a.shard1", 100)
+	if b, err := s2.fetchShard(ctx, peer, msg, 100); err != nil || len(b) != 100 {
+		t.Errorf("expected shard of 100 bytes, got %d bytes and %v", len(b), err)
+	}
+	if b, err := s2.fetchShard(ctx, peer, msg, 0); err != nil || len(b) != 100 {
+		t.Errorf("expected shard of 100 bytes without a known size, got %d bytes and %v", len(b), err)
+	}
+	if _, err := s2.fetchShard(ctx, peer, msg, 64); !errors.Is(err, errBadShard) {
+		t.Errorf("expected errBadShard for a shard of the wrong size, got %v", err)
+	}
+	if _, err := s2.fetchShard(ctx, peer, shard("b.shard1", 4), 0); !errors.Is(err, errBadShard) {
+		t.Errorf("expected errBadShard for a shard without a header, got %v", err)
+	}
+	if b, err := s2.fetchShard(ctx, peer, &Message{Payload: MessageGetFile{ID: s2.ID, Key: "missing"}}, 100); b != nil || err != nil {
+		t.Errorf("expected no shard from a peer without it, got %d bytes and %v", len(b), err)
+	}
+}
+
+// TestErasureShardHeader checks that shards whose header claims more data than they hold are refused before rebuilding
+func TestErasureShardHeader(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServer(t, p2p.NewMemNetwork(), ":3000", nil)
+	policy := StoragePolicy{DataShards: 2, ParityShards: 1}
+
+	for _, size := range []uint64{1 << 63, 1 << 40, 17} {
+		key := fmt.Sprintf("ec/%d", size)
+		for i := 0; i < 3; i++ {
+			shard := binary.LittleEndian.AppendUint64(nil, size)
+			shard = append(shard, make([]byte, 8)...)
+			if _, err := s.store.Write(s.ID, shardKey(crypto.HashKey(key), i), bytes.NewReader(shard)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := s.getErasure(context.Background(), key, policy); !errors.Is(err, errBadShard) {
+			t.Errorf("expected errBadShard for a header of %d bytes, got %v", size, err)
+		}
+	}
+}
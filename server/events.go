@@ -0,0 +1,92 @@
+// Change events for GoVaultFS
+// A file server publishes an event whenever an object is stored or deleted through it and whenever a peer
+// connects or disconnects. API front ends subscribe to stream them to clients. Delivery never blocks the
+// server: a subscriber that falls behind by more than its buffer misses events.
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies what an event reports
+type EventType int
+
+// Event types
+const (
+	EventStored           EventType = iota + 1 // An object was stored through the node
+	EventDeleted                               // An object was deleted through the node
+	EventPeerConnected                         // A peer connected
+	EventPeerDisconnected                      // A peer disconnected
+)
+
+// String returns the name of an event type
+func (t EventType) String() string {
+	switch t {
+	case EventStored:
+		return "stored"
+	case EventDeleted:
+		return "deleted"
+	case EventPeerConnected:
+		return "peer-connected"
+	case EventPeerDisconnected:
+		return "peer-disconnected"
+	}
+	return "unknown"
+}
+
+// Event is a change seen by a file server
+type Event struct {
+	Type EventType
+	Key  string // Object events only
+	Size int64  // Stored events only
+	ETag string // Stored events only
+	Peer string // Peer events only, the peer's address
+	Time time.Time
+}
+
+// eventBus fans events out to subscribers
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Subscribe returns a channel of the server's events, buffering up to buffer of them, and a function
+// that ends the subscription and closes the channel
+func (s *FileServer) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	s.events.mu.Lock()
+	if s.events.subs == nil {
+		s.events.subs = make(map[chan Event]struct{})
+	}
+	s.events.subs[ch] = struct{}{}
+	s.events.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.events.mu.Lock()
+			delete(s.events.subs, ch)
+			s.events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish sends an event to every subscriber with room for it
+func (s *FileServer) publish(e Event) {
+	if len(tenantOf(e.Key)) > 0 {
+		return // Subscribers watch the node's own objects
+	}
+	e.Time = time.Now().UTC()
+
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+	for ch := range s.events.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Examples for the server package
+// These show how a program embeds a GoVaultFS node using only the exported API.
+package server_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/AnshSinghSonkhia/GoVaultFS/server"
+	"github.com/AnshSinghSonkhia/GoVaultFS/store"
+)
+
+// ExampleNewFileServer runs a node on TCP, stores a file and reads it back
+func ExampleNewFileServer() {
+	tr := p2p.NewTCPTransport(p2p.TCPTransportOpts{
+		ListenAddr:    ":3000",
+		HandshakeFunc: p2p.NOPHandshakeFunc,
+		Decoder:       p2p.DefaultDecoder{},
+	})
+
+	s := server.NewFileServer(server.FileServerOpts{
+		EncKey:            crypto.NewEncryptionKey(),
+		StorageRoot:       "port3000_network",
+		PathTransformFunc: store.CASPathTransformFunc,
+		Transport:         tr,
+		BootstrapNodes:    []string{":4000"},
+		ReplicationFactor: 2,
+	})
+	tr.OnPeer = s.OnPeer
+	tr.OnPeerDisconnect = s.OnPeerDisconnect
+
+	go s.Start()
+	defer s.Stop()
+
+	if err := s.Store("notes/todo.txt", strings.NewReader("buy milk")); err != nil {
+		log.Fatal(err)
+	}
+
+	r, err := s.Get("notes/todo.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	fmt.Println(string(b))
+
+	info, _ := s.Stat("notes/todo.txt")
+	fmt.Println(info.Size, info.ETag)
+
+	if err := s.Delete("notes/todo.txt"); err != nil {
+		log.Fatal(err)
+	}
+}
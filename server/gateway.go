@@ -0,0 +1,569 @@
+// HTTP gateway for GoVaultFS
+// This file exposes a FileServer over a small REST API so that services written in any language can use the vault.
+// Request and response bodies are streamed straight into FileServer.Store and out of FileServer.Get.
+//
+//	PUT    /objects/{key}                   store the request body under key
+//	GET    /objects/{key}?version=          download an object, the latest version unless one is given
+//	HEAD   /objects/{key}?version=          object size and modification time
+//	DELETE /objects/{key}                   delete an object and all its versions
+//	GET    /objects?prefix=&after=&limit=   list objects in key order, one page at a time
+//	GET    /versions/{key}                  versions kept of an object, the latest first
+//	POST   /versions/{key}?version=         restore a version as the latest
+//	GET    /dirs/{path}                     a directory and its entries
+//	PUT    /dirs/{path}                     create a directory or replace its metadata with the JSON body
+//	DELETE /dirs/{path}                     delete a directory with everything in it
+//	POST   /move/{path}?to=                 rename a file or directory
+//	PUT    /pins/{key}                      keep a copy of an object on this node
+//	DELETE /pins/{key}                      let the cached copy of an object be evicted
+//	GET    /pins                            pinned keys
+//	GET    /peers                           cluster members as seen by this node
+//	GET    /health                          liveness of this node
+//	GET    /status                          identity, uptime, peers and object count of this node
+//	GET    /metrics                         metrics in the Prometheus text format
+//	POST   /tokens                          issue a token for the capability in the JSON body, needs OpAdmin on its prefix
+//	DELETE /tokens/{id}?until=              revoke a token, until it expires if until is given
+//	GET    /tokens/revoked                  revoked token IDs
+//
+// With access control on, requests carry a token in an "Authorization: Bearer" header that allows the operation
+// on the key, path or prefix they name; the peers, status and revocation endpoints need OpAdmin. Health and metrics
+// stay open, and the admin socket is trusted as it is.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Page sizes for object listings
+const (
+	defaultListLimit = 1000
+	maxListLimit     = 1000
+)
+
+// Gateway serves the REST API of a file server
+type Gateway struct {
+	server  *FileServer
+	mux     *http.ServeMux
+	trusted bool // Serves the admin socket, which needs no token
+}
+
+// NewGateway creates the HTTP handler for a file server
+func NewGateway(s *FileServer) *Gateway {
+	g := &Gateway{
+		server: s,
+		mux:    http.NewServeMux(),
+	}
+
+	g.mux.HandleFunc("PUT /objects/{key...}", g.allow(OpWrite, pathKey, g.handlePut))
+	g.mux.HandleFunc("GET /objects/{key...}", g.allow(OpRead, objectKey, g.handleGet))
+	g.mux.HandleFunc("HEAD /objects/{key...}", g.allow(OpRead, pathKey, g.handleHead))
+	g.mux.HandleFunc("DELETE /objects/{key...}", g.allow(OpDelete, pathKey, g.handleDelete))
+	g.mux.HandleFunc("GET /objects", g.allow(OpRead, prefixKey, g.handleList))
+	g.mux.HandleFunc("GET /versions/{key...}", g.allow(OpRead, pathKey, g.handleVersions))
+	g.mux.HandleFunc("POST /versions/{key...}", g.allow(OpWrite, pathKey, g.handleRestore))
+	g.mux.HandleFunc("GET /dirs/{path...}", g.allow(OpRead, dirPath, g.handleReadDir))
+	g.mux.HandleFunc("PUT /dirs/{path...}", g.allow(OpWrite, dirPath, g.handleMkdir))
+	g.mux.HandleFunc("DELETE /dirs/{path...}", g.allow(OpDelete, dirPath, g.handleRemoveAll))
+	g.mux.HandleFunc("POST /move/{path...}", g.allow(OpDelete, dirPath, g.allow(OpWrite, movePath, g.handleRename)))
+	g.mux.HandleFunc("PUT /pins/{key...}", g.allow(OpRead, pathKey, g.handlePin))
+	g.mux.HandleFunc("DELETE /pins/{key...}", g.allow(OpRead, pathKey, g.handleUnpin))
+	g.mux.HandleFunc("GET /pins", g.allow(OpRead, anyKey, g.handlePins))
+	g.mux.HandleFunc("GET /peers", g.allow(OpAdmin, anyKey, g.handlePeers))
+	g.mux.HandleFunc("GET /health", g.handleHealth)
+	g.mux.HandleFunc("GET /status", g.allow(OpAdmin, anyKey, g.handleStatus))
+	g.mux.Handle("GET /metrics", s.registry.Handler())
+	g.mux.HandleFunc("POST /tokens", g.handleIssueToken)
+	g.mux.HandleFunc("DELETE /tokens/{id}", g.allow(OpAdmin, anyKey, g.handleRevokeToken))
+	g.mux.HandleFunc("GET /tokens/revoked", g.allow(OpAdmin, anyKey, g.handleRevoked))
+
+	return g
+}
+
+// ServeHTTP dispatches a request to its handler (http.Handler interface).
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := validRequest(r); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	g.mux.ServeHTTP(w, r)
+}
+
+// Keys named by a request, for access control
+var (
+	pathKey   = func(r *http.Request) string { return r.PathValue("key") }
+	prefixKey = func(r *http.Request) string { return r.URL.Query().Get("prefix") }
+	dirPath   = func(r *http.Request) string { return CleanPath(r.PathValue("path")) }
+	movePath  = func(r *http.Request) string { return CleanPath(r.URL.Query().Get("to")) }
+	anyKey    = func(r *http.Request) string { return "" }
+)
+
+// objectKey is the key of a download, or the prefix of a listing if the key is empty
+func objectKey(r *http.Request) string {
+	if key := pathKey(r); len(key) > 0 {
+		return key
+	}
+	return prefixKey(r)
+}
+
+// allow wraps a handler so that it only runs for requests whose token allows op on the key they name
+func (g *Gateway) allow(op Op, key func(*http.Request) string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := g.authorize(r, op, key(r)); err != nil {
+			if errors.Is(err, ErrInvalidToken) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="govault"`)
+			}
+			writeError(w, statusFor(err), err)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// authorize checks the token of a request for op on key and returns its capability,
+// nil if the gateway is trusted or access control is off
+func (g *Gateway) authorize(r *http.Request, op Op, key string) (*Capability, error) {
+	if g.trusted || !g.server.AuthEnabled() {
+		return nil, nil
+	}
+	c, err := g.server.Authorize(bearerToken(r.Header.Get("Authorization")), op, key)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// handlePut stores the request body
+func (g *Gateway) handlePut(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if len(key) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("missing object key"))
+		return
+	}
+
+	cond, err := headerPrecondition(r.Header)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := g.server.StoreIfContext(r.Context(), key, r.Body, cond); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	info, err := g.server.Stat(key)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, info)
+}
+
+// handleGet streams an object to the client
+func (g *Gateway) handleGet(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if len(key) == 0 {
+		g.handleList(w, r)
+		return
+	}
+
+	var rd io.Reader
+	var err error
+	version := r.URL.Query().Get("version")
+	if len(version) > 0 {
+		rd, err = g.server.GetVersionContext(r.Context(), key, version)
+	} else {
+		rd, err = g.server.GetContext(r.Context(), key)
+	}
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		// List the siblings, so the client can read them by version and store the one it settles on
+		writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "versions": conflict.Versions})
+		return
+	}
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	if rc, ok := rd.(io.Closer); ok {
+		defer rc.Close()
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if info, err := g.stat(key, version); err == nil {
+		setObjectHeaders(w, info)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, rd); err != nil {
+		g.server.loggerFrom(r.Context()).Warn("sending object failed", "key", key, "err", err)
+	}
+}
+
+// handleHead returns the headers of an object without its body
+func (g *Gateway) handleHead(w http.ResponseWriter, r *http.Request) {
+	info, err := g.stat(r.PathValue("key"), r.URL.Query().Get("version"))
+	if err != nil {
+		w.WriteHeader(statusFor(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	setObjectHeaders(w, info)
+	w.WriteHeader(http.StatusOK)
+}
+
+// stat returns the info of a version of an object, the latest if version is empty
+func (g *Gateway) stat(key string, version string) (ObjectInfo, error) {
+	if len(version) > 0 {
+		return g.server.StatVersion(key, version)
+	}
+	return g.server.Stat(key)
+}
+
+// handleDelete deletes an object
+func (g *Gateway) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if err := g.server.DeleteContext(r.Context(), r.PathValue("key")); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleVersions lists the versions kept of an object
+func (g *Gateway) handleVersions(w http.ResponseWriter, r *http.Request) {
+	versions, err := g.server.ListVersions(r.PathValue("key"))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]ObjectInfo{"versions": versions})
+}
+
+// handleRestore makes a version of an object the latest again
+func (g *Gateway) handleRestore(w http.ResponseWriter, r *http.Request) {
+	key, version := r.PathValue("key"), r.URL.Query().Get("version")
+	if len(version) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("missing version"))
+		return
+	}
+
+	if err := g.server.RestoreVersionContext(r.Context(), key, version); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	info, err := g.server.Stat(key)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, info)
+}
+
+// handleReadDir lists a directory
+func (g *Gateway) handleReadDir(w http.ResponseWriter, r *http.Request) {
+	dir, err := g.server.ns.Stat(r.PathValue("path"))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	entries, err := g.server.ns.ReadDir(dir.Path)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"dir": dir, "entries": entries})
+}
+
+// handleMkdir creates a directory, or replaces the metadata of an existing one, from an optional JSON object
+func (g *Gateway) handleMkdir(w http.ResponseWriter, r *http.Request) {
+	p := CleanPath(r.PathValue("path"))
+	var meta map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	status := http.StatusCreated
+	err := g.server.ns.Mkdir(p, meta)
+	if errors.Is(err, ErrExist) {
+		status, err = http.StatusOK, g.server.ns.SetMeta(p, meta)
+	}
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	dir, err := g.server.ns.Stat(p)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, status, dir)
+}
+
+// handleRemoveAll deletes a directory with everything in it
+func (g *Gateway) handleRemoveAll(w http.ResponseWriter, r *http.Request) {
+	if err := g.server.ns.RemoveAll(r.PathValue("path")); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRename moves a file or directory to the path in the to parameter
+func (g *Gateway) handleRename(w http.ResponseWriter, r *http.Request) {
+	to := r.URL.Query().Get("to")
+	if len(to) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("missing destination"))
+		return
+	}
+
+	if err := g.server.ns.Rename(r.PathValue("path"), to); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	e, err := g.server.ns.Stat(to)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, e)
+}
+
+// handlePin keeps a copy of an object on this node, fetching it if needed
+func (g *Gateway) handlePin(w http.ResponseWriter, r *http.Request) {
+	if err := g.server.PinContext(r.Context(), r.PathValue("key")); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleUnpin lets the cached copy of an object be evicted
+func (g *Gateway) handleUnpin(w http.ResponseWriter, r *http.Request) {
+	if err := g.server.Unpin(r.PathValue("key")); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePins lists the pinned keys
+func (g *Gateway) handlePins(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]string{"pins": g.server.Pins()})
+}
+
+// listResponse is one page of an object listing.
+// Next is the value of after for the following page and is only set if there are more objects.
+type listResponse struct {
+	Objects []ObjectInfo `json:"objects"`
+	Next    string       `json:"next,omitempty"`
+}
+
+// handleList lists objects by prefix, one page at a time
+func (g *Gateway) handleList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit := defaultListLimit
+	if v := q.Get("limit"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+		limit = min(n, maxListLimit)
+	}
+
+	// Ask for one more object than fits on the page to learn whether there is another page
+	objects := g.server.List(q.Get("prefix"), q.Get("after"), limit+1)
+	resp := listResponse{Objects: objects}
+	if len(objects) > limit {
+		resp.Objects = objects[:limit]
+		resp.Next = objects[limit-1].Key
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// memberResponse is a cluster member in API responses
+type memberResponse struct {
+	ID    string `json:"id"`
+	Addr  string `json:"addr"`
+	State string `json:"state"`
+}
+
+// handlePeers lists the cluster members
+func (g *Gateway) handlePeers(w http.ResponseWriter, r *http.Request) {
+	members := []memberResponse{}
+	for _, m := range g.server.Members() {
+		members = append(members, memberResponse{ID: m.ID, Addr: m.Addr, State: m.State.String()})
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+// handleHealth reports that the node is up and how many peers it is connected to
+func (g *Gateway) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status": "ok",
+		"id":     g.server.ID,
+		"addr":   g.server.Transport.Addr(),
+		"peers":  len(g.server.peerList()),
+	})
+}
+
+// handleStatus reports the state of the node
+func (g *Gateway) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, g.server.Status())
+}
+
+// tokenRequest is the capability asked for when issuing a token
+type tokenRequest struct {
+	Subject string `json:"subject"`
+	Ops     []Op   `json:"ops"`
+	Tenant  string `json:"tenant"`
+	Prefix  string `json:"prefix"`
+	TTL     string `json:"ttl"` // Go duration the token lasts for
+}
+
+// tokenResponse is an issued token and the capability it carries
+type tokenResponse struct {
+	Token      string     `json:"token"`
+	Capability Capability `json:"capability"`
+}
+
+// handleIssueToken signs a token. It needs OpAdmin on the prefix of the token, and a client can only hand out what
+// its own token allows, for no longer than it lasts.
+func (g *Gateway) handleIssueToken(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ttl %q", req.TTL))
+		return
+	}
+
+	c := Capability{Subject: req.Subject, Ops: req.Ops, Tenant: req.Tenant, Prefix: req.Prefix, Expires: time.Now().Add(ttl).UTC()}
+	caller, err := g.authorize(r, OpAdmin, tenantKey(req.Tenant, req.Prefix))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	if caller != nil && c.Expires.After(caller.Expires) {
+		c.Expires = caller.Expires
+	}
+	if caller != nil && !caller.covers(c) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%w: the token would allow more than yours", ErrAccessDenied))
+		return
+	}
+
+	token, c, err := g.server.IssueToken(c)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, tokenResponse{Token: token, Capability: c})
+}
+
+// handleRevokeToken revokes a token on this node and its peers
+func (g *Gateway) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	var until time.Time
+	if v := r.URL.Query().Get("until"); len(v) > 0 {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid until %q", v))
+			return
+		}
+		until = t
+	}
+
+	if err := g.server.Revoke(r.PathValue("id"), until); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRevoked lists the revoked tokens
+func (g *Gateway) handleRevoked(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]map[string]time.Time{"revoked": g.server.Revoked()})
+}
+
+// setObjectHeaders describes an object in response headers
+func setObjectHeaders(w http.ResponseWriter, info ObjectInfo) {
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("ETag", `"`+info.ETag+`"`)
+	w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("X-Version-Id", info.Version)
+}
+
+// statusFor maps a file server error to an HTTP status code
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInsufficientReplicas), errors.Is(err, ErrTooFewShards):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrNodeFull), errors.Is(err, ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ErrExist), errors.Is(err, ErrParentNotFound), errors.Is(err, ErrIsDir), errors.Is(err, ErrNotDir):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrAuthDisabled), errors.Is(err, ErrNoSuchTenant):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error as a JSON response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// listenHTTP serves an HTTP API of the node in the background, if it is enabled
+func (s *FileServer) listenHTTP(srv *http.Server, name string) error {
+	if srv == nil {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	srv.Handler = s.logRequests(name, srv.Handler)
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error(name+" failed", "err", err)
+		}
+	}()
+
+	s.logger.Info(name+" listening", "addr", ln.Addr().String())
+
+	return nil
+}
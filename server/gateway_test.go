@@ -0,0 +1,101 @@
+// Tests for the HTTP gateway in GoVaultFS
+// These tests drive a two node cluster through the REST API with a plain HTTP client.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGatewayObjects stores, reads, lists and deletes objects over HTTP
+func TestGatewayObjects(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	ts := httptest.NewServer(NewGateway(s2))
+	defer ts.Close()
+
+	// Store a few objects
+	for i := 0; i < 5; i++ {
+		resp := do(t, http.MethodPut, ts.URL+fmt.Sprintf("/objects/photos/%d.png", i), []byte(fmt.Sprintf("image %d", i)))
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	resp := do(t, http.MethodPut, ts.URL+"/objects/notes.txt", []byte("hello"))
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Read one back after dropping the local copy, so it comes from the peer
+	assert.Nil(t, s2.store.Delete(s2.ID, "photos/3.png"))
+	resp = do(t, http.MethodGet, ts.URL+"/objects/photos/3.png", nil)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image 3", string(body))
+	assert.Equal(t, "7", resp.Header.Get("Content-Length"))
+
+	resp = do(t, http.MethodHead, ts.URL+"/objects/notes.txt", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get("Content-Length"))
+
+	// List by prefix, two at a time
+	keys := []string{}
+	after := ""
+	for pages := 0; ; pages++ {
+		resp = do(t, http.MethodGet, ts.URL+"/objects?prefix=photos/&limit=2&after="+after, nil)
+		var page listResponse
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&page))
+		for _, o := range page.Objects {
+			keys = append(keys, o.Key)
+		}
+		if len(page.Next) == 0 {
+			assert.Equal(t, 2, pages)
+			break
+		}
+		after = page.Next
+	}
+	assert.Equal(t, []string{"photos/0.png", "photos/1.png", "photos/2.png", "photos/3.png", "photos/4.png"}, keys)
+
+	// Delete removes the object and its replica
+	resp = do(t, http.MethodDelete, ts.URL+"/objects/notes.txt", nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, http.StatusNotFound, do(t, http.MethodHead, ts.URL+"/objects/notes.txt", nil).StatusCode)
+	assert.Equal(t, http.StatusNotFound, do(t, http.MethodGet, ts.URL+"/objects/notes.txt", nil).StatusCode)
+	assert.Equal(t, http.StatusNotFound, do(t, http.MethodDelete, ts.URL+"/objects/notes.txt", nil).StatusCode)
+	waitFor(t, 5*time.Second, func() bool { return !s1.store.Has(s2.ID, crypto.HashKey("notes.txt")) })
+	assert.Equal(t, http.StatusBadRequest, do(t, http.MethodGet, ts.URL+"/objects?limit=x", nil).StatusCode)
+
+	// Cluster endpoints
+	var members []memberResponse
+	assert.Nil(t, json.NewDecoder(do(t, http.MethodGet, ts.URL+"/peers", nil).Body).Decode(&members))
+	assert.Len(t, members, 2)
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/health", nil).StatusCode)
+}
+
+// do sends a request and fails the test if it cannot be sent. The body is closed at the end of the test.
+func do(t *testing.T, method string, url string, body []byte) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
@@ -0,0 +1,420 @@
+// SWIM-style gossip protocol for GoVaultFS
+// This file drives failure detection and membership dissemination between file servers.
+// Every probe interval a node pings one member; if no ack arrives it asks a few other members
+// to probe on its behalf, and only then suspects the member. Membership updates are piggybacked
+// on every ping and ack, and a full member list is exchanged whenever a new connection is made,
+// so every node converges on the same cluster view from a single seed.
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+)
+
+// maxPiggyback caps how many membership updates ride along on a single ping or ack
+const maxPiggyback = 8
+
+// reconnectProbes is how many probe intervals pass between attempts to reach dead members.
+// A node that was cut off by a partition rejoins this way once the network heals.
+const reconnectProbes = 5
+
+// GossipOpts configures the membership protocol. Zero values fall back to defaults.
+type GossipOpts struct {
+	ProbeInterval    time.Duration // How often a member is probed (default 1s)
+	ProbeTimeout     time.Duration // How long to wait for an ack (default 500ms)
+	SuspicionTimeout time.Duration // How long a member stays suspect before it is declared dead (default 5s)
+	IndirectProbes   int           // Members asked to probe on our behalf after a missed ack (default 3)
+}
+
+// withDefaults fills in zero values with sensible defaults.
+// A probe timeout that does not leave time for indirect probes within the interval is cut to half the interval.
+func (o GossipOpts) withDefaults() GossipOpts {
+	if o.ProbeInterval == 0 {
+		o.ProbeInterval = time.Second
+	}
+	if o.ProbeTimeout == 0 {
+		o.ProbeTimeout = 500 * time.Millisecond
+	}
+	if o.ProbeTimeout >= o.ProbeInterval {
+		o.ProbeTimeout = o.ProbeInterval / 2
+	}
+	if o.SuspicionTimeout == 0 {
+		o.SuspicionTimeout = 5 * time.Second
+	}
+	if o.IndirectProbes == 0 {
+		o.IndirectProbes = 3
+	}
+	return o
+}
+
+// MessagePing probes a member for liveness
+type MessagePing struct {
+	From     Member   // Sender
+	SeqNo    uint64   // Sequence number echoed back in the ack
+	Updates  []Member // Piggybacked membership updates
+	Capacity Capacity // Storage capacity of the sender
+}
+
+// MessageAck answers a ping, directly or on behalf of an indirect probe
+type MessageAck struct {
+	From     Member   // Sender
+	SeqNo    uint64   // Sequence number of the ping being answered
+	Updates  []Member // Piggybacked membership updates
+	Capacity Capacity // Storage capacity of the sender
+}
+
+// MessagePingReq asks a member to probe Target on the sender's behalf
+type MessagePingReq struct {
+	From    Member   // Sender
+	SeqNo   uint64   // Sequence number to use in the forwarded ack
+	Target  Member   // Member to probe
+	Updates []Member // Piggybacked membership updates
+}
+
+// MessageSync pushes the complete member list to a newly connected peer
+type MessageSync struct {
+	From     Member   // Sender
+	Members  []Member // Every member known to the sender
+	Capacity Capacity // Storage capacity of the sender
+}
+
+// indirectProbe remembers who asked us to probe a member so the ack can be forwarded
+type indirectProbe struct {
+	from  string // Peer address of the requester
+	seqNo uint64 // Requester's sequence number
+}
+
+// Members returns the cluster member list as seen by this node, including itself
+func (s *FileServer) Members() []Member {
+	return s.members.Members()
+}
+
+// gossipLoop periodically probes members and expires suspects until the server stops
+func (s *FileServer) gossipLoop() {
+	ticker := time.NewTicker(s.Gossip.ProbeInterval)
+	defer ticker.Stop()
+
+	for tick := 1; ; tick++ {
+		select {
+		case <-ticker.C:
+			s.connectMembers(tick%reconnectProbes == 0)
+			s.probe()
+			for _, m := range s.members.ExpireSuspects(s.Gossip.SuspicionTimeout) {
+				s.logger.Warn("member declared dead", "peer_id", shortID(m.ID), "peer", m.Addr)
+				s.dropMemberPeer(m.ID)
+			}
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+// probe pings one random active member, falling back to indirect probes before suspecting it.
+// Members we lost the connection to are probed too, so they are suspected if nobody can reach them.
+func (s *FileServer) probe() {
+	candidates := s.members.Active()
+	if len(candidates) == 0 {
+		return
+	}
+	target := candidates[rand.Intn(len(candidates))]
+
+	seqNo, ackch := s.newAckWaiter()
+	defer s.removeAckWaiter(seqNo)
+
+	ping := &Message{Payload: MessagePing{
+		From:     s.members.Self(),
+		SeqNo:    seqNo,
+		Updates:  s.members.Updates(maxPiggyback),
+		Capacity: s.Capacity(),
+	}}
+	if err := s.sendToMember(target.ID, ping); err == nil {
+		select {
+		case <-ackch:
+			return
+		case <-time.After(s.Gossip.ProbeTimeout):
+		case <-s.quitch:
+			return
+		}
+	}
+
+	// Direct probe failed, ask other members to try
+	helpers := []Member{}
+	for _, m := range candidates {
+		if m.ID == target.ID || m.State != MemberAlive {
+			continue
+		}
+		if _, ok := s.memberPeer(m.ID); ok {
+			helpers = append(helpers, m)
+		}
+	}
+	rand.Shuffle(len(helpers), func(i, j int) { helpers[i], helpers[j] = helpers[j], helpers[i] })
+	if len(helpers) > s.Gossip.IndirectProbes {
+		helpers = helpers[:s.Gossip.IndirectProbes]
+	}
+	for _, h := range helpers {
+		req := &Message{Payload: MessagePingReq{
+			From:    s.members.Self(),
+			SeqNo:   seqNo,
+			Target:  target,
+			Updates: s.members.Updates(maxPiggyback),
+		}}
+		s.sendToMember(h.ID, req)
+	}
+
+	select {
+	case <-ackch:
+		return
+	case <-time.After(s.Gossip.ProbeInterval - s.Gossip.ProbeTimeout):
+	case <-s.quitch:
+		return
+	}
+
+	if s.members.Suspect(target.ID) {
+		s.logger.Info("member is suspect", "peer_id", shortID(target.ID), "peer", target.Addr)
+	}
+}
+
+// connectMembers dials alive members we have no connection with, and dead members too if dead is set.
+// Only the node with the smaller ID dials, so two nodes that learn about each other at the same time
+// do not open duplicate connections.
+func (s *FileServer) connectMembers(dead bool) {
+	self := s.members.Self()
+	for _, m := range s.members.Members() {
+		if m.ID == self.ID || self.ID > m.ID {
+			continue
+		}
+		if m.State != MemberAlive && !(dead && m.State == MemberDead) {
+			continue
+		}
+		if _, ok := s.memberPeer(m.ID); ok {
+			continue
+		}
+
+		s.peerLock.Lock()
+		dialing := s.dialing[m.ID]
+		s.dialing[m.ID] = true
+		s.peerLock.Unlock()
+		if dialing {
+			continue
+		}
+
+		go func(m Member) {
+			defer func() {
+				s.peerLock.Lock()
+				delete(s.dialing, m.ID)
+				s.peerLock.Unlock()
+			}()
+			s.logger.Debug("dialing member", "peer_id", shortID(m.ID), "peer", m.Addr)
+			if err := s.Transport.Dial(m.Addr); err != nil {
+				s.logger.Warn("dialing member failed", "peer_id", shortID(m.ID), "peer", m.Addr, "err", err)
+			}
+		}(m)
+	}
+}
+
+// handleMessagePing acknowledges a probe
+func (s *FileServer) handleMessagePing(from string, msg MessagePing) error {
+	s.learnMember(from, msg.From)
+	s.applyUpdates(msg.Updates)
+	s.noteCapacity(msg.From.ID, msg.Capacity)
+
+	ack := &Message{Payload: MessageAck{
+		From:     s.members.Self(),
+		SeqNo:    msg.SeqNo,
+		Updates:  s.members.Updates(maxPiggyback),
+		Capacity: s.Capacity(),
+	}}
+	return s.sendToPeer(from, ack)
+}
+
+// handleMessageAck completes a direct probe or forwards an ack for an indirect probe
+func (s *FileServer) handleMessageAck(from string, msg MessageAck) error {
+	s.learnMember(from, msg.From)
+	s.applyUpdates(msg.Updates)
+	s.noteCapacity(msg.From.ID, msg.Capacity)
+
+	s.ackLock.Lock()
+	ackch, ok := s.acks[msg.SeqNo]
+	req, forward := s.indirect[msg.SeqNo]
+	delete(s.indirect, msg.SeqNo)
+	s.ackLock.Unlock()
+
+	if ok {
+		select {
+		case ackch <- struct{}{}:
+		default:
+		}
+	}
+
+	if forward {
+		fwd := &Message{Payload: MessageAck{
+			From:     s.members.Self(),
+			SeqNo:    req.seqNo,
+			Updates:  s.members.Updates(maxPiggyback),
+			Capacity: s.Capacity(),
+		}}
+		return s.sendToPeer(req.from, fwd)
+	}
+
+	return nil
+}
+
+// handleMessagePingReq probes the target on behalf of another member
+func (s *FileServer) handleMessagePingReq(from string, msg MessagePingReq) error {
+	s.learnMember(from, msg.From)
+	s.applyUpdates(msg.Updates)
+
+	seqNo, _ := s.newAckWaiter()
+	s.ackLock.Lock()
+	s.indirect[seqNo] = indirectProbe{from: from, seqNo: msg.SeqNo}
+	s.ackLock.Unlock()
+
+	// Forget the request if the target never answers
+	time.AfterFunc(s.Gossip.ProbeInterval, func() {
+		s.removeAckWaiter(seqNo)
+		s.ackLock.Lock()
+		delete(s.indirect, seqNo)
+		s.ackLock.Unlock()
+	})
+
+	ping := &Message{Payload: MessagePing{
+		From:     s.members.Self(),
+		SeqNo:    seqNo,
+		Updates:  s.members.Updates(maxPiggyback),
+		Capacity: s.Capacity(),
+	}}
+	return s.sendToMember(msg.Target.ID, ping)
+}
+
+// handleMessageSync merges a full member list pushed by a newly connected peer.
+// If the peer believed we had failed, our refutation is pushed back right away:
+// after a partition heals neither side probes the other, so it would not arrive otherwise.
+func (s *FileServer) handleMessageSync(from string, msg MessageSync) error {
+	incarnation := s.members.Self().Incarnation
+	s.learnMember(from, msg.From)
+	s.applyUpdates(msg.Members)
+	s.noteCapacity(msg.From.ID, msg.Capacity)
+
+	if self := s.members.Self(); self.Incarnation != incarnation && self.State == MemberAlive {
+		return s.sendSync(from)
+	}
+	return nil
+}
+
+// sendSync pushes our complete member list to the peer connected from addr
+func (s *FileServer) sendSync(addr string) error {
+	msg := &Message{Payload: MessageSync{
+		From:     s.members.Self(),
+		Members:  s.members.Members(),
+		Capacity: s.Capacity(),
+	}}
+	return s.sendToPeer(addr, msg)
+}
+
+// learnMember records which peer connection belongs to the sending member.
+// Listen addresses without a host (e.g. ":3000") are completed with the host the connection came from.
+func (s *FileServer) learnMember(from string, m Member) {
+	m.Addr = advertiseAddr(m.Addr, from)
+	s.members.Apply(m)
+
+	s.peerLock.Lock()
+	s.memberPeers[m.ID] = from
+	s.peerLock.Unlock()
+
+	if m.State == MemberLeft {
+		s.dropMemberPeer(m.ID)
+	}
+}
+
+// applyUpdates merges piggybacked updates and tears down connections to members that failed or left
+func (s *FileServer) applyUpdates(updates []Member) {
+	for _, u := range updates {
+		if !s.members.Apply(u) {
+			continue
+		}
+		if u.State == MemberDead || u.State == MemberLeft {
+			s.dropMemberPeer(u.ID)
+		}
+	}
+}
+
+// memberPeer returns the connected peer of a member
+func (s *FileServer) memberPeer(id string) (p2p.Peer, bool) {
+	s.peerLock.Lock()
+	defer s.peerLock.Unlock()
+
+	addr, ok := s.memberPeers[id]
+	if !ok {
+		return nil, false
+	}
+	peer, ok := s.peers[addr]
+	return peer, ok
+}
+
+// dropMemberPeer closes and forgets the connection to a failed member
+func (s *FileServer) dropMemberPeer(id string) {
+	s.peerLock.Lock()
+	defer s.peerLock.Unlock()
+
+	addr, ok := s.memberPeers[id]
+	if !ok {
+		return
+	}
+	delete(s.memberPeers, id)
+	if peer, ok := s.peers[addr]; ok {
+		peer.Close()
+		delete(s.peers, addr)
+	}
+}
+
+// sendToMember sends a message over the connection of a member
+func (s *FileServer) sendToMember(id string, msg *Message) error {
+	peer, ok := s.memberPeer(id)
+	if !ok {
+		return fmt.Errorf("member %s is not connected", id)
+	}
+	return s.sendToPeer(peer.RemoteAddr().String(), msg)
+}
+
+// newAckWaiter allocates a sequence number and a channel that receives its ack
+func (s *FileServer) newAckWaiter() (uint64, chan struct{}) {
+	s.ackLock.Lock()
+	defer s.ackLock.Unlock()
+
+	s.seqNo++
+	ackch := make(chan struct{}, 1)
+	s.acks[s.seqNo] = ackch
+	return s.seqNo, ackch
+}
+
+// removeAckWaiter forgets an ack channel once the probe is over
+func (s *FileServer) removeAckWaiter(seqNo uint64) {
+	s.ackLock.Lock()
+	defer s.ackLock.Unlock()
+	delete(s.acks, seqNo)
+}
+
+// shortID abbreviates a node ID for log output
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// advertiseAddr completes a listen address that has no host with the host of the connection it came from
+func advertiseAddr(listenAddr string, remoteAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil || len(host) > 0 {
+		return listenAddr
+	}
+	remoteHost, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return listenAddr
+	}
+	return net.JoinHostPort(remoteHost, port)
+}
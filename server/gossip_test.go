@@ -0,0 +1,78 @@
+// Integration test for gossip membership in GoVaultFS
+// This test starts a small cluster where every node only knows the seed and checks that all nodes converge.
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+)
+
+// TestGossipConvergence starts three nodes that only bootstrap to the first one
+// and waits until every node sees every other node as alive.
+// It then stops one node and checks that the others learn that it left.
+func TestGossipConvergence(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	seed := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	s3 := newTestServer(t, network, ":5000", nil, ":3000")
+
+	servers := []*FileServer{seed, s2, s3}
+	startServers(t, servers...)
+	waitFor(t, 5*time.Second, func() bool {
+		for _, s := range servers {
+			if countMembers(s, MemberAlive) != 3 {
+				return false
+			}
+		}
+		return true
+	})
+
+	s3.Stop()
+
+	waitFor(t, 5*time.Second, func() bool {
+		return countMembers(seed, MemberLeft) == 1 && countMembers(s2, MemberLeft) == 1
+	})
+	seed.Stop()
+	s2.Stop()
+}
+
+// TestGossipOptsDefaults checks that a probe timeout always leaves part of the interval for indirect probes
+func TestGossipOptsDefaults(t *testing.T) {
+	o := GossipOpts{ProbeInterval: 200 * time.Millisecond}.withDefaults()
+	if o.ProbeTimeout != 100*time.Millisecond {
+		t.Errorf("have probe timeout %s want 100ms", o.ProbeTimeout)
+	}
+
+	o = GossipOpts{ProbeInterval: time.Second, ProbeTimeout: 300 * time.Millisecond}.withDefaults()
+	if o.ProbeTimeout != 300*time.Millisecond {
+		t.Errorf("have probe timeout %s want 300ms", o.ProbeTimeout)
+	}
+}
+
+// countMembers returns how many members a server sees in the given state
+func countMembers(s *FileServer, state MemberState) int {
+	n := 0
+	for _, m := range s.Members() {
+		if m.State == state {
+			n++
+		}
+	}
+	return n
+}
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("condition not met before timeout")
+}
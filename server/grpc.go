@@ -0,0 +1,330 @@
+// gRPC service for GoVaultFS
+// This file implements the GoVault service of the rpc package on top of a FileServer, for service-to-service use.
+// Uploads and downloads are streamed in chunks straight into FileServer.Store and out of FileServer.Get.
+// Go programs can use the client package instead of the generated stubs.
+// With access control on, calls carry a capability token in "authorization: Bearer" metadata. A token issued for
+// a tenant acts for the tenant, the way a tenant's S3 access key does, so its calls see only the tenant's objects.
+// Without access control there are no tenant credentials, and calls reach the node's own objects.
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Sizes of the gRPC service
+const (
+	grpcChunkSize   = 64 * 1024 // Content bytes per streamed message
+	grpcEventBuffer = 256       // Events a watcher may fall behind by before missing some
+)
+
+// grpcService serves the GoVault gRPC service of a file server
+type grpcService struct {
+	rpc.UnimplementedGoVaultServer
+	server *FileServer
+}
+
+// NewGRPCServer creates a gRPC server with the GoVault service of a file server registered
+func NewGRPCServer(s *FileServer, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.logUnary),
+		grpc.ChainStreamInterceptor(s.logStream),
+	}, opts...)
+	srv := grpc.NewServer(opts...)
+	rpc.RegisterGoVaultServer(srv, &grpcService{server: s})
+	return srv
+}
+
+// Put stores an object streamed by the client
+func (g *grpcService) Put(stream grpc.ClientStreamingServer[rpc.PutRequest, rpc.ObjectInfo]) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if len(first.GetKey()) == 0 {
+		return status.Error(codes.InvalidArgument, "missing object key")
+	}
+	if err := validKey(first.GetKey()); err != nil {
+		return grpcError(err)
+	}
+	key, err := g.authorize(stream.Context(), OpWrite, first.GetKey())
+	if err != nil {
+		return grpcError(err)
+	}
+
+	r := &putReader{stream: stream, buf: first.GetChunk()}
+	if err := g.server.StoreContext(stream.Context(), key, r); err != nil {
+		return grpcError(err)
+	}
+
+	info, err := g.server.Stat(key)
+	if err != nil {
+		return grpcError(err)
+	}
+	return stream.SendAndClose(objectInfoPB(info))
+}
+
+// putReader reads the content of an upload from its messages
+type putReader struct {
+	stream grpc.ClientStreamingServer[rpc.PutRequest, rpc.ObjectInfo]
+	buf    []byte
+}
+
+// Read returns the content of the current message, receiving the next one once it is used up
+func (r *putReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		msg, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = msg.GetChunk()
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Get streams an object to the client, its info first
+func (g *grpcService) Get(req *rpc.GetRequest, stream grpc.ServerStreamingServer[rpc.GetResponse]) error {
+	if err := validKey(req.GetKey()); err != nil {
+		return grpcError(err)
+	}
+	key, err := g.authorize(stream.Context(), OpRead, req.GetKey())
+	if err != nil {
+		return grpcError(err)
+	}
+	rd, err := g.server.GetContext(stream.Context(), key)
+	if err != nil {
+		return grpcError(err)
+	}
+	if rc, ok := rd.(io.Closer); ok {
+		defer rc.Close()
+	}
+
+	// Objects fetched from the network are not indexed here, so only their key is known
+	info := &rpc.ObjectInfo{Key: req.GetKey()}
+	if i, err := g.server.Stat(key); err == nil {
+		info = objectInfoPB(i)
+	}
+	if err := stream.Send(&rpc.GetResponse{Info: info}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, grpcChunkSize)
+	for {
+		n, err := rd.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&rpc.GetResponse{Chunk: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return grpcError(err)
+		}
+	}
+}
+
+// Stat describes an object
+func (g *grpcService) Stat(ctx context.Context, req *rpc.StatRequest) (*rpc.ObjectInfo, error) {
+	if err := validKey(req.GetKey()); err != nil {
+		return nil, grpcError(err)
+	}
+	key, err := g.authorize(ctx, OpRead, req.GetKey())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	info, err := g.server.Stat(key)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return objectInfoPB(info), nil
+}
+
+// List returns one page of objects by prefix
+func (g *grpcService) List(ctx context.Context, req *rpc.ListRequest) (*rpc.ListResponse, error) {
+	limit := defaultListLimit
+	if req.GetLimit() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative limit")
+	}
+	if err := validKey(req.GetPrefix() + req.GetAfter()); err != nil {
+		return nil, grpcError(err)
+	}
+	prefix, err := g.authorize(ctx, OpRead, req.GetPrefix())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if req.GetLimit() > 0 {
+		limit = min(int(req.GetLimit()), maxListLimit)
+	}
+	after := req.GetAfter()
+	if len(after) > 0 {
+		after = tenantKey(tenantOf(prefix), after)
+	}
+
+	// Ask for one more object than fits on the page to learn whether there is another page
+	objects := g.server.List(prefix, after, limit+1)
+	resp := &rpc.ListResponse{}
+	if len(objects) > limit {
+		objects = objects[:limit]
+		resp.Next = untenant(objects[limit-1].Key)
+	}
+	for _, info := range objects {
+		resp.Objects = append(resp.Objects, objectInfoPB(info))
+	}
+	return resp, nil
+}
+
+// Delete deletes an object and its replicas
+func (g *grpcService) Delete(ctx context.Context, req *rpc.DeleteRequest) (*rpc.DeleteResponse, error) {
+	if err := validKey(req.GetKey()); err != nil {
+		return nil, grpcError(err)
+	}
+	key, err := g.authorize(ctx, OpDelete, req.GetKey())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := g.server.DeleteContext(ctx, key); err != nil {
+		return nil, grpcError(err)
+	}
+	return &rpc.DeleteResponse{}, nil
+}
+
+// WatchEvents streams the server's events until the client cancels or the server stops
+func (g *grpcService) WatchEvents(req *rpc.WatchRequest, stream grpc.ServerStreamingServer[rpc.Event]) error {
+	prefix, err := g.authorize(stream.Context(), OpRead, req.GetPrefix())
+	if err != nil {
+		return grpcError(err)
+	}
+	events, cancel := g.server.Subscribe(grpcEventBuffer)
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-g.server.quitch:
+			return status.Error(codes.Unavailable, "server stopped")
+		case e := <-events:
+			isObject := e.Type == EventStored || e.Type == EventDeleted
+			if isObject && (!strings.HasPrefix(e.Key, prefix) || tenantOf(e.Key) != tenantOf(prefix)) {
+				continue
+			}
+			e.Key = untenant(e.Key)
+			if err := stream.Send(eventPB(e)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// authorize checks the token in the metadata of a call for op on key and returns the key inside the node,
+// which is the tenant's key for a token issued for a tenant
+func (g *grpcService) authorize(ctx context.Context, op Op, key string) (string, error) {
+	if !g.server.AuthEnabled() {
+		return key, nil
+	}
+	token := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			token = bearerToken(v[0])
+		}
+	}
+
+	c, _ := g.server.verify(token) // The tenant only counts once Authorize accepts the token
+	key = tenantKey(c.Tenant, key)
+	if _, err := g.server.Authorize(token, op, key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// objectInfoPB converts object info to its protobuf form
+func objectInfoPB(info ObjectInfo) *rpc.ObjectInfo {
+	return &rpc.ObjectInfo{
+		Key:     untenant(info.Key),
+		Size:    info.Size,
+		Etag:    info.ETag,
+		ModTime: timestamppb.New(info.ModTime),
+	}
+}
+
+// eventPB converts an event to its protobuf form
+func eventPB(e Event) *rpc.Event {
+	types := map[EventType]rpc.Event_Type{
+		EventStored:           rpc.Event_TYPE_STORED,
+		EventDeleted:          rpc.Event_TYPE_DELETED,
+		EventPeerConnected:    rpc.Event_TYPE_PEER_CONNECTED,
+		EventPeerDisconnected: rpc.Event_TYPE_PEER_DISCONNECTED,
+	}
+	return &rpc.Event{
+		Type: types[e.Type],
+		Key:  e.Key,
+		Size: e.Size,
+		Etag: e.ETag,
+		Peer: e.Peer,
+		Time: timestamppb.New(e.Time),
+	}
+}
+
+// grpcError maps a file server error to a gRPC status
+func grpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	msg := untenant(err.Error())
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, msg)
+	case errors.Is(err, ErrInsufficientReplicas), errors.Is(err, ErrTooFewShards):
+		return status.Error(codes.Unavailable, msg)
+	case errors.Is(err, ErrNodeFull), errors.Is(err, ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, msg)
+	case errors.Is(err, ErrConflict):
+		return status.Error(codes.Aborted, msg)
+	case errors.Is(err, ErrPreconditionFailed):
+		return status.Error(codes.FailedPrecondition, msg)
+	case errors.Is(err, errReservedKey):
+		return status.Error(codes.InvalidArgument, msg)
+	case errors.Is(err, ErrInvalidToken):
+		return status.Error(codes.Unauthenticated, msg)
+	case errors.Is(err, ErrAccessDenied):
+		return status.Error(codes.PermissionDenied, msg)
+	}
+	return status.Error(codes.Internal, msg)
+}
+
+// listenGRPC serves the gRPC API on GRPCAddr, if set
+func (s *FileServer) listenGRPC() error {
+	if s.grpc == nil {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", s.GRPCAddr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.grpc.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			s.logger.Error("gRPC API failed", "err", err)
+		}
+	}()
+
+	s.logger.Info("gRPC API listening", "addr", ln.Addr().String())
+
+	return nil
+}
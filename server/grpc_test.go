@@ -0,0 +1,175 @@
+// Tests for the gRPC API in GoVaultFS
+// These tests serve a node over an in-memory gRPC connection and use it through the client package.
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/client"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// TestGRPCService stores, streams, lists, watches and deletes objects over gRPC
+func TestGRPCService(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s := newTestServer(t, network, ":3000", nil)
+	defer stopServers(s)
+	go s.Start()
+
+	c := newTestClient(t, s)
+	ctx := context.Background()
+
+	// Watch object events under reports/
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := c.Watch(watchCtx, "reports/")
+	assert.Nil(t, err)
+	waitFor(t, 5*time.Second, func() bool {
+		s.events.mu.Lock()
+		defer s.events.mu.Unlock()
+		return len(s.events.subs) == 1
+	})
+
+	// Upload an object spanning several messages, and a couple of small ones
+	big := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	info, err := c.Put(ctx, "reports/big.bin", bytes.NewReader(big))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(big)), info.Size)
+	assert.Len(t, info.ETag, 32)
+
+	for _, key := range []string{"notes.txt", "reports/q1.txt", "reports/q2.txt"} {
+		_, err := c.Put(ctx, key, strings.NewReader(key))
+		assert.Nil(t, err)
+	}
+	_, err = c.Put(ctx, "empty.txt", strings.NewReader(""))
+	assert.Nil(t, err)
+
+	// Download
+	r, err := c.Get(ctx, "reports/big.bin")
+	assert.Nil(t, err)
+	got, err := io.ReadAll(r)
+	r.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, big, got)
+	assert.Equal(t, info.ETag, r.Info.ETag)
+
+	_, err = c.Get(ctx, "missing.txt")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+	_, err = c.Stat(ctx, "missing.txt")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+
+	// List two at a time
+	keys := []string{}
+	after := ""
+	for {
+		objects, next, err := c.List(ctx, "reports/", after, 2)
+		assert.Nil(t, err)
+		for _, o := range objects {
+			keys = append(keys, o.Key)
+		}
+		if len(next) == 0 {
+			break
+		}
+		after = next
+	}
+	assert.Equal(t, []string{"reports/big.bin", "reports/q1.txt", "reports/q2.txt"}, keys)
+
+	// Delete
+	assert.Nil(t, c.Delete(ctx, "reports/q1.txt"))
+	assert.ErrorIs(t, c.Delete(ctx, "reports/q1.txt"), client.ErrNotFound)
+
+	// The watcher saw the object events under its prefix, in order
+	want := []client.Event{
+		{Type: client.EventStored, Key: "reports/big.bin"},
+		{Type: client.EventStored, Key: "reports/q1.txt"},
+		{Type: client.EventStored, Key: "reports/q2.txt"},
+		{Type: client.EventDeleted, Key: "reports/q1.txt"},
+	}
+	for _, e := range want {
+		got, err := w.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, e.Type, got.Type)
+		assert.Equal(t, e.Key, got.Key)
+	}
+}
+
+// TestGRPCTenant checks that calls with a token issued for a tenant only reach the tenant's objects
+func TestGRPCTenant(t *testing.T) {
+	t.Parallel()
+
+	s := newTestServer(t, p2p.NewMemNetwork(), ":3000", func(opts *FileServerOpts) {
+		opts.Auth = &AuthOpts{}
+		opts.Tenants = []Tenant{{Name: "acme"}}
+	})
+	defer stopServers(s)
+	go s.Start()
+
+	acme, err := s.Tenant("acme")
+	assert.Nil(t, err)
+	assert.Nil(t, acme.Store("reports/old.txt", strings.NewReader("acme")))
+	assert.Nil(t, s.Store("reports/node.txt", strings.NewReader("node")))
+
+	token, _, err := s.IssueToken(Capability{Subject: "acme-app", Ops: []Op{OpRead, OpWrite}, Tenant: "acme", Expires: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	c := newTestClient(t, s, client.WithToken(token))
+	ctx := context.Background()
+
+	info, err := c.Put(ctx, "reports/new.txt", strings.NewReader("new"))
+	assert.Nil(t, err)
+	assert.Equal(t, "reports/new.txt", info.Key)
+	_, err = acme.Stat("reports/new.txt")
+	assert.Nil(t, err)
+	_, err = s.Stat("reports/new.txt")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	objects, next, err := c.List(ctx, "reports/", "", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "reports/new.txt", objects[0].Key)
+	objects, _, err = c.List(ctx, "reports/", next, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "reports/old.txt", objects[0].Key)
+	assert.Len(t, objects, 1)
+
+	// The node's own objects are out of reach
+	_, err = c.Stat(ctx, "reports/node.txt")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+	r, err := c.Get(ctx, "reports/old.txt")
+	assert.Nil(t, err)
+	got, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "acme", string(got))
+}
+
+// newTestClient serves a file server's gRPC API on an in-memory listener and connects a client to it with extra options
+func newTestClient(t *testing.T, s *FileServer, opts ...grpc.DialOption) *client.Client {
+	t.Helper()
+
+	ln := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(s)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	opts = append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, opts...)
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return client.New(conn)
+}
@@ -0,0 +1,484 @@
+// Object index for GoVaultFS
+// Content-addressable storage only knows hashed keys, so this file keeps a small index of the objects a node
+// has stored under their original keys, along with the older versions of each key. It backs Stat and List.
+// Every change appends the new state of the keys it touched to a log next to the node's files, and the log is
+// compacted once most of its records are stale, so a write costs the same however many objects there are.
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+)
+
+// Names of the index files in the storage root
+const (
+	indexFileName          = "index.log"
+	legacyIndexFileName    = "index.json"    // Whole index of nodes from before the log
+	legacyVersionsFileName = "versions.json" // Older versions of nodes from before the log
+)
+
+// compactRecords is how many records the index log holds at least before it is compacted
+const compactRecords = 1024
+
+// ObjectInfo describes an object stored through this node
+type ObjectInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ETag    string    `json:"etag"` // Hex MD5 of the content
+	ModTime time.Time `json:"mod_time"`
+	Version string    `json:"version"` // ID of the version, sorting in the order versions were stored
+
+	Writer   string        `json:"writer,omitempty"`   // ID of the node that stored the version
+	Vector   VersionVector `json:"vector,omitempty"`   // Writes the version descends from, its own included
+	Clock    Timestamp     `json:"clock"`              // Hybrid logical clock reading of the write
+	Siblings []string      `json:"siblings,omitempty"` // Concurrent versions kept alongside the latest
+}
+
+// objectIndex maps original keys to object info and persists it to disk
+type objectIndex struct {
+	mu       sync.RWMutex
+	path     string
+	objects  map[string]ObjectInfo   // Latest version by key
+	versions map[string][]ObjectInfo // Older versions by key, oldest first
+	keys     []string                // Indexed keys in order, so listings can seek
+	hashes   map[string]string       // Keys by their hash, for peers that only know the hash
+	records  int                     // Records in the log, stale ones included
+	torn     bool                    // A write to the log failed, so the next change rewrites it
+}
+
+// indexRecord is a line of the index log: the state of a key after a change.
+// A record without a latest version removes the key.
+type indexRecord struct {
+	Key      string       `json:"key"`
+	Latest   *ObjectInfo  `json:"latest,omitempty"`
+	Versions []ObjectInfo `json:"versions,omitempty"`
+}
+
+// openIndex loads the index log stored at path, starting empty if there is none.
+// An index saved as JSON by an older node is moved into the log.
+func openIndex(path string) (*objectIndex, error) {
+	x := &objectIndex{
+		path:     path,
+		objects:  make(map[string]ObjectInfo),
+		versions: make(map[string][]ObjectInfo),
+		hashes:   make(map[string]string),
+	}
+
+	legacy := filepath.Join(filepath.Dir(path), legacyIndexFileName)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(legacy); err == nil {
+			return x, x.migrate(legacy)
+		}
+		return x, nil
+	}
+
+	torn, err := x.replay()
+	if err != nil {
+		x.torn = true // Rewrite the log with what could be read on the next change
+		return x, err
+	}
+	if torn {
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		return x, x.compact() // Drop the record a crash cut short, so appends start on a new line
+	}
+	return x, nil
+}
+
+// replay applies every record of the log in order and reports whether the last one was cut short
+func (x *objectIndex) replay() (bool, error) {
+	f, err := os.Open(x.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return false, err
+		}
+		if len(line) == 0 {
+			return false, nil
+		}
+		torn := errors.Is(err, io.EOF) // A crash cut the last record short
+
+		var rec indexRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if torn {
+				return true, nil
+			}
+			return false, fmt.Errorf("index record %d: %w", x.records+1, err)
+		}
+		x.apply(rec)
+		x.records++
+		if torn {
+			return true, nil
+		}
+	}
+}
+
+// migrate loads an index saved as JSON, writes it to the log and removes the JSON files
+func (x *objectIndex) migrate(legacy string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if err := readJSON(legacy, &x.objects); err != nil {
+		return err
+	}
+	versions := filepath.Join(filepath.Dir(legacy), legacyVersionsFileName)
+	if err := readJSON(versions, &x.versions); err != nil {
+		return err
+	}
+	for key, info := range x.objects {
+		if len(info.Version) == 0 {
+			info.Version = legacyVersionID(info.ModTime) // Stored before objects had versions
+			x.objects[key] = info
+		}
+		x.keys = append(x.keys, key)
+		x.hashes[crypto.HashKey(key)] = key
+	}
+	sort.Strings(x.keys)
+
+	if err := x.compact(); err != nil {
+		return err
+	}
+	os.Remove(versions)
+	return os.Remove(legacy)
+}
+
+// readJSON decodes the JSON file at path into v, leaving v alone if there is no file
+func readJSON(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// apply sets a key to the state of a record. Caller holds x.mu or owns x.
+func (x *objectIndex) apply(rec indexRecord) {
+	i, found := slices.BinarySearch(x.keys, rec.Key)
+	if rec.Latest == nil {
+		delete(x.objects, rec.Key)
+		delete(x.versions, rec.Key)
+		if found {
+			x.keys = slices.Delete(x.keys, i, i+1)
+			delete(x.hashes, crypto.HashKey(rec.Key))
+		}
+		return
+	}
+
+	x.objects[rec.Key] = *rec.Latest
+	if len(rec.Versions) > 0 {
+		x.versions[rec.Key] = rec.Versions
+	} else {
+		delete(x.versions, rec.Key)
+	}
+	if !found {
+		x.keys = slices.Insert(x.keys, i, rec.Key)
+		x.hashes[crypto.HashKey(rec.Key)] = rec.Key
+	}
+}
+
+// record returns the current state of a key. Caller holds x.mu.
+func (x *objectIndex) record(key string) indexRecord {
+	rec := indexRecord{Key: key, Versions: x.versions[key]}
+	if info, ok := x.objects[key]; ok {
+		rec.Latest = &info
+	}
+	return rec
+}
+
+// get returns the info of an object
+func (x *objectIndex) get(key string) (ObjectInfo, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	info, ok := x.objects[key]
+	return info, ok
+}
+
+// context returns the vector of the versions a new write to key supersedes: the latest and its siblings
+func (x *objectIndex) context(key string) VersionVector {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	info, ok := x.objects[key]
+	if !ok {
+		return VersionVector{}
+	}
+	v := VersionVector{}.Merge(info.Vector)
+	for _, older := range x.versions[key] {
+		if slices.Contains(info.Siblings, older.Version) {
+			v = v.Merge(older.Vector)
+		}
+	}
+	return v
+}
+
+// hashed returns the key of an object whose hashed key is hash
+func (x *objectIndex) hashed(hash string) (string, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	key, ok := x.hashes[hash]
+	return key, ok
+}
+
+// nextWrite returns the number of the next write of a node to key, after every one any version of key has seen
+func (x *objectIndex) nextWrite(key string, node string) uint64 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	n := x.objects[key].Vector[node]
+	for _, info := range x.versions[key] {
+		n = max(n, info.Vector[node])
+	}
+	return n + 1
+}
+
+// put records the latest version of an object and saves the index.
+// With keep set, the version it replaces becomes an older version of the object.
+func (x *objectIndex) put(info ObjectInfo, keep bool) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	rec := indexRecord{Key: info.Key, Latest: &info, Versions: x.versions[info.Key]}
+	if prev, ok := x.objects[info.Key]; ok && keep {
+		rec.Versions = append(rec.Versions, prev)
+	}
+	return x.commit(rec)
+}
+
+// insert records an older version of an object, which a concurrent write replaced before it was stored,
+// and saves the index
+func (x *objectIndex) insert(info ObjectInfo) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	rec := x.record(info.Key)
+	older := append(rec.Versions, info)
+	sort.Slice(older, func(i, j int) bool { return older[i].Version < older[j].Version })
+	rec.Versions = older
+	return x.commit(rec)
+}
+
+// remove forgets an object and its older versions and saves the index
+func (x *objectIndex) remove(key string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.commit(indexRecord{Key: key})
+}
+
+// rename moves an object and its older versions to another key and saves the index
+func (x *objectIndex) rename(from string, to string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	rec := x.record(from)
+	if rec.Latest == nil {
+		return nil
+	}
+	info := *rec.Latest
+	info.Key = to
+	moved := indexRecord{Key: to, Latest: &info}
+	for _, older := range rec.Versions {
+		older.Key = to
+		moved.Versions = append(moved.Versions, older)
+	}
+	return x.commit(indexRecord{Key: from}, moved)
+}
+
+// history returns every version of an object, the latest first. It is empty if the object is not indexed.
+func (x *objectIndex) history(key string) []ObjectInfo {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	info, ok := x.objects[key]
+	if !ok {
+		return []ObjectInfo{}
+	}
+	out := []ObjectInfo{info}
+	for i := len(x.versions[key]) - 1; i >= 0; i-- {
+		out = append(out, x.versions[key][i])
+	}
+	return out
+}
+
+// version returns one version of an object
+func (x *objectIndex) version(key string, id string) (ObjectInfo, bool) {
+	for _, info := range x.history(key) {
+		if info.Version == id {
+			return info, true
+		}
+	}
+	return ObjectInfo{}, false
+}
+
+// prune forgets the older versions of an object beyond the keep newest versions, counting the latest,
+// and those stored before cutoff, and returns them. Siblings of the latest are kept.
+// A keep of 0 or a zero cutoff does not limit.
+func (x *objectIndex) prune(key string, keep int, cutoff time.Time) ([]ObjectInfo, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	siblings := x.objects[key].Siblings
+	older, pruned := []ObjectInfo{}, []ObjectInfo{}
+	for i, info := range x.versions[key] {
+		newer := len(x.versions[key]) - i // Versions newer than this one, the latest included
+		if ((keep > 0 && newer >= keep) || info.ModTime.Before(cutoff)) && !slices.Contains(siblings, info.Version) {
+			pruned = append(pruned, info)
+			continue
+		}
+		older = append(older, info)
+	}
+	if len(pruned) == 0 {
+		return pruned, nil
+	}
+	rec := x.record(key)
+	rec.Versions = older
+	return pruned, x.commit(rec)
+}
+
+// versioned returns the keys that have older versions
+func (x *objectIndex) versioned() []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	keys := make([]string, 0, len(x.versions))
+	for key := range x.versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// len returns the number of indexed objects
+func (x *objectIndex) len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.objects)
+}
+
+// list returns up to limit objects whose key starts with prefix and sorts after the key after, in key order
+func (x *objectIndex) list(prefix string, after string, limit int) []ObjectInfo {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	i, _ := slices.BinarySearch(x.keys, prefix)
+	if after >= prefix {
+		j, found := slices.BinarySearch(x.keys, after)
+		if found {
+			j++
+		}
+		i = j
+	}
+
+	out := []ObjectInfo{}
+	for i < len(x.keys) && (limit <= 0 || len(out) < limit) {
+		key := x.keys[i]
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+		if strings.HasPrefix(key, tenantPrefix) && !strings.HasPrefix(prefix, tenantPrefix) {
+			// Tenants' objects are only listed through their tenant
+			i, _ = slices.BinarySearch(x.keys, tenantPrefix+"\xff")
+			continue
+		}
+		out = append(out, x.objects[key])
+		i++
+	}
+	return out
+}
+
+// commit applies records to the index and appends them to the log. Caller holds x.mu.
+// Once most records in the log are stale, or an append may have been cut short, the log is compacted instead.
+func (x *objectIndex) commit(recs ...indexRecord) error {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, rec := range recs {
+		x.apply(rec)
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	x.records += len(recs)
+
+	if x.torn || (x.records > compactRecords && x.records > 2*len(x.keys)) {
+		return x.compact()
+	}
+	if err := appendFile(x.path, buf.Bytes()); err != nil {
+		x.torn = true
+		return err
+	}
+	return nil
+}
+
+// compact rewrites the log with one record per key. Caller holds x.mu.
+func (x *objectIndex) compact() error {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, key := range x.keys {
+		if err := enc.Encode(x.record(key)); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(x.path, buf.Bytes()); err != nil {
+		x.torn = true
+		return err
+	}
+	x.records, x.torn = len(x.keys), false
+	return nil
+}
+
+// legacyVersionID returns the version ID of an object stored before objects had versions
+func legacyVersionID(modTime time.Time) string {
+	return fmt.Sprintf("%016x%08x", modTime.UnixNano(), 0)
+}
+
+// writeFileAtomic writes a file to a temporary name and renames it into place,
+// so a crash never leaves a half written file
+func writeFileAtomic(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// appendFile appends b to the file at path, creating it if needed
+func appendFile(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
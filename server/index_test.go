@@ -0,0 +1,135 @@
+// Tests for the object index
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIndexLog checks that the index and its keys by hash survive a reopen, a record cut short by a crash and compaction,
+// and that an index saved as JSON by an older node is moved into the log
+func TestIndexLog(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, indexFileName)
+	x, err := openIndex(path)
+	assert.NoError(t, err)
+
+	info := func(key string, version int) ObjectInfo {
+		return ObjectInfo{Key: key, Size: int64(version), Version: fmt.Sprintf("%08d", version)}
+	}
+	assert.NoError(t, x.put(info("a", 1), true))
+	assert.NoError(t, x.put(info("a", 2), true))
+	assert.NoError(t, x.put(info("b", 1), true))
+	assert.NoError(t, x.rename("b", "c"))
+	assert.NoError(t, x.put(info("d", 1), true))
+	assert.NoError(t, x.remove("d"))
+
+	// Changes are appended, one record per key they touched
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, strings.Count(string(b), "\n"))
+
+	x, err = openIndex(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"00000002", "00000001"}, versionIDs(x.history("a")))
+	assert.Equal(t, []string{"00000001"}, versionIDs(x.history("c")))
+	assert.Equal(t, []string{"a", "c"}, keysOf(x.list("", "", 0)))
+
+	// Keys are found by their hash once renamed, and not once removed
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": false} {
+		got, ok := x.hashed(crypto.HashKey(key))
+		assert.Equal(t, want, ok, key)
+		if ok {
+			assert.Equal(t, key, got)
+		}
+	}
+
+	// A crash in the middle of an append loses only that record
+	assert.NoError(t, os.WriteFile(path, append(b, `{"key":"e","lat`...), 0o644))
+	x, err = openIndex(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, keysOf(x.list("", "", 0)))
+	assert.NoError(t, x.put(info("e", 1), true))
+	x, err = openIndex(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c", "e"}, keysOf(x.list("", "", 0)))
+
+	// Rewriting the same keys compacts the log instead of growing it
+	for i := 0; i < 3*compactRecords; i++ {
+		assert.NoError(t, x.put(info("e", i), false))
+	}
+	b, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, strings.Count(string(b), "\n"), compactRecords+3)
+	x, err = openIndex(path)
+	assert.NoError(t, err)
+	e, _ := x.get("e")
+	assert.Equal(t, int64(3*compactRecords-1), e.Size)
+
+	// A node from before the log
+	legacy := t.TempDir()
+	modTime := time.Unix(1700000000, 0)
+	objects, _ := json.Marshal(map[string]ObjectInfo{"old": {Key: "old", ModTime: modTime}})
+	versions, _ := json.Marshal(map[string][]ObjectInfo{"old": {info("old", 1)}})
+	assert.NoError(t, os.WriteFile(filepath.Join(legacy, legacyIndexFileName), objects, 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(legacy, legacyVersionsFileName), versions, 0o644))
+
+	x, err = openIndex(filepath.Join(legacy, indexFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{legacyVersionID(modTime), "00000001"}, versionIDs(x.history("old")))
+	_, ok := x.hashed(crypto.HashKey("old"))
+	assert.True(t, ok)
+	assert.NoFileExists(t, filepath.Join(legacy, legacyIndexFileName))
+	assert.NoFileExists(t, filepath.Join(legacy, legacyVersionsFileName))
+
+	x, err = openIndex(filepath.Join(legacy, indexFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(x.history("old")))
+}
+
+// TestIndexList checks that listings seek to the key after, stop at the limit and leave out tenants' objects
+func TestIndexList(t *testing.T) {
+	t.Parallel()
+
+	x, err := openIndex(filepath.Join(t.TempDir(), indexFileName))
+	assert.NoError(t, err)
+	for _, key := range []string{"b/2", "a", "b/1", tenantKey("acme", "b/1"), "c", "b/3"} {
+		assert.NoError(t, x.put(ObjectInfo{Key: key}, false))
+	}
+
+	assert.Equal(t, []string{"a", "b/1", "b/2", "b/3", "c"}, keysOf(x.list("", "", 0)))
+	assert.Equal(t, []string{"b/1", "b/2"}, keysOf(x.list("b/", "", 2)))
+	assert.Equal(t, []string{"b/2", "b/3"}, keysOf(x.list("b/", "b/1", 0)))
+	assert.Equal(t, []string{"b/1", "b/2", "b/3"}, keysOf(x.list("b/", "a", 0)))
+	assert.Equal(t, []string{"c"}, keysOf(x.list("", "b/3", 5)))
+	assert.Empty(t, keysOf(x.list("b/", "b/3", 0)))
+	assert.Equal(t, []string{tenantKey("acme", "b/1")}, keysOf(x.list(tenantKey("acme", ""), "", 0)))
+}
+
+// versionIDs returns the version IDs of a history
+func versionIDs(history []ObjectInfo) []string {
+	ids := []string{}
+	for _, info := range history {
+		ids = append(ids, info.Version)
+	}
+	return ids
+}
+
+// keysOf returns the keys of a listing
+func keysOf(objects []ObjectInfo) []string {
+	keys := []string{}
+	for _, info := range objects {
+		keys = append(keys, info.Key)
+	}
+	return keys
+}
@@ -0,0 +1,137 @@
+// Request logging for the APIs of a node
+// Every HTTP and gRPC request gets an ID, taken from the client's X-Request-Id header when it sends one,
+// which is returned in the response and attached to every log record written while serving the request.
+// When tracing is enabled every request is also a server span, joining the client's trace if it sent a traceparent.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// requestIDHeader carries the ID of a request, in HTTP headers and gRPC metadata
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen bounds request IDs taken from clients
+const maxRequestIDLen = 128
+
+// loggerKey is the context key of the request logger
+type loggerKey struct{}
+
+// requestID returns the ID the client sent, or a new random one
+func requestID(sent string) string {
+	if len(sent) > 0 && len(sent) <= maxRequestIDLen {
+		return sent
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// loggerFrom returns the logger of the request served with ctx, or the node logger outside of requests
+func (s *FileServer) loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return s.logger
+}
+
+// logRequests assigns every request to h an ID, traces it and logs it at debug level once it is served
+func (s *FileServer) logRequests(api string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r.Header.Get(requestIDHeader))
+		w.Header().Set(requestIDHeader, id)
+
+		ctx, span := s.startRequestSpan(r.Context(), r.Header.Get(traceparentHeader), api+" "+r.Method,
+			"request_id", id, "path", r.URL.Path)
+		logger := s.logger.With("api", api, "request_id", id)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(context.WithValue(ctx, loggerKey{}, logger)))
+
+		span.SetAttr("status", sw.status)
+		if sw.status >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(sw.status)))
+		}
+		span.End()
+		logger.Debug("request served", "method", r.Method, "path", r.URL.Path, "status", sw.status,
+			"duration", time.Since(start))
+	})
+}
+
+// statusWriter records the status code of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code and sends the header
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController reaches its optional interfaces
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// grpcRequest assigns a gRPC call an ID and returns it in the response header.
+// It returns the context for the call, carrying its logger and span, and the span.
+func (s *FileServer) grpcRequest(ctx context.Context, method string) (context.Context, *slog.Logger, *trace.Span) {
+	var sent, traceparent string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(requestIDHeader); len(v) > 0 {
+			sent = v[0]
+		}
+		if v := md.Get(traceparentHeader); len(v) > 0 {
+			traceparent = v[0]
+		}
+	}
+	id := requestID(sent)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+
+	ctx, span := s.startRequestSpan(ctx, traceparent, method, "request_id", id)
+	logger := s.logger.With("api", "gRPC API", "request_id", id, "method", method)
+	return context.WithValue(ctx, loggerKey{}, logger), logger, span
+}
+
+// logUnary logs and traces unary gRPC calls (grpc.UnaryServerInterceptor)
+func (s *FileServer) logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, logger, span := s.grpcRequest(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	endSpan(span, err)
+	logger.Debug("request served", "err", err, "duration", time.Since(start))
+	return resp, err
+}
+
+// logStream logs and traces streaming gRPC calls (grpc.StreamServerInterceptor)
+func (s *FileServer) logStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, logger, span := s.grpcRequest(ss.Context(), info.FullMethod)
+	err := handler(srv, &loggedStream{ServerStream: ss, ctx: ctx})
+	endSpan(span, err)
+	logger.Debug("request served", "err", err, "duration", time.Since(start))
+	return err
+}
+
+// loggedStream is a server stream whose context carries the request logger and span
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the call with the request logger and span
+func (s *loggedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,119 @@
+// Tests for structured logging in GoVaultFS
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a buffer that log handlers can write to while a test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records returns the JSON log records written so far
+func (b *syncBuffer) records() []map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	recs := []map[string]any{}
+	sc := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for sc.Scan() {
+		var rec map[string]any
+		if json.Unmarshal(sc.Bytes(), &rec) == nil {
+			recs = append(recs, rec)
+		}
+	}
+	return recs
+}
+
+// find returns the first record with the given message whose attributes include attrs
+func (b *syncBuffer) find(msg string, attrs map[string]any) map[string]any {
+outer:
+	for _, rec := range b.records() {
+		if rec["msg"] != msg {
+			continue
+		}
+		for k, v := range attrs {
+			if rec[k] != v {
+				continue outer
+			}
+		}
+		return rec
+	}
+	return nil
+}
+
+// logTo makes a test server and its transport log JSON at debug level to logs
+func logTo(logs io.Writer) func(*FileServerOpts) {
+	return func(opts *FileServerOpts) {
+		opts.Logger = slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+}
+
+// TestLogging checks that records carry the node, peer, key and request ID as attributes,
+// and that stopping a node is not logged as a dropped connection by its peer
+func TestLogging(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	logs1, logs2 := new(syncBuffer), new(syncBuffer)
+	s1 := newTestServer(t, network, ":3000", logTo(logs1))
+	s2 := newTestServer(t, network, ":4000", logTo(logs2), ":3000")
+	defer stopServers(s1)
+
+	startServers(t, s1, s2)
+
+	ts := httptest.NewServer(s2.logRequests("HTTP gateway", NewGateway(s2)))
+	defer ts.Close()
+
+	// The request ID the client sends is kept, otherwise one is generated
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/objects/a.txt", strings.NewReader("hello"))
+	req.Header.Set(requestIDHeader, "req-1")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "req-1", resp.Header.Get(requestIDHeader))
+
+	assert.Nil(t, s2.store.Delete(s2.ID, "a.txt"))
+	resp = do(t, http.MethodGet, ts.URL+"/objects/a.txt", nil)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "hello", string(body))
+	assert.Len(t, resp.Header.Get(requestIDHeader), 16)
+
+	rec := logs2.find("request served", map[string]any{"request_id": "req-1"})
+	if assert.NotNil(t, rec) {
+		assert.Equal(t, shortID(s2.ID), rec["node"])
+		assert.Equal(t, "HTTP gateway", rec["api"])
+		assert.Equal(t, "PUT", rec["method"])
+		assert.Equal(t, float64(http.StatusCreated), rec["status"])
+	}
+	assert.NotNil(t, logs2.find("fetched file", map[string]any{"node": shortID(s2.ID), "key": "a.txt"}))
+	waitFor(t, time.Second, func() bool {
+		return logs1.find("served file to peer", map[string]any{"node": shortID(s1.ID)}) != nil
+	})
+
+	// s1 sees s2 leave, not a dropped connection
+	s2.Stop()
+	waitFor(t, 5*time.Second, func() bool { return logs1.find("peer connection closed", nil) != nil })
+	assert.Nil(t, logs1.find("dropping peer connection", nil))
+}
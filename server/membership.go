@@ -0,0 +1,281 @@
+// Cluster membership state for GoVaultFS
+// This file keeps the list of known nodes and merges membership updates using SWIM rules.
+// Each update carries an incarnation number so that newer information always wins,
+// and a node can refute rumours of its own failure by bumping its incarnation.
+package server
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// retransmitMult scales how many times an update is piggybacked before it is dropped.
+// Each update is sent retransmitMult * log2(n+1) times, which is enough for it to reach every node with high probability.
+const retransmitMult = 4
+
+// MemberState describes what the local node believes about a cluster member
+type MemberState int
+
+const (
+	MemberAlive   MemberState = iota // Responding to probes
+	MemberSuspect                    // Missed a probe, may be refuted by the member itself
+	MemberDead                       // Confirmed failed after the suspicion timeout
+	MemberLeft                       // Left the cluster gracefully
+)
+
+// String returns a human readable member state
+func (s MemberState) String() string {
+	switch s {
+	case MemberAlive:
+		return "alive"
+	case MemberSuspect:
+		return "suspect"
+	case MemberDead:
+		return "dead"
+	case MemberLeft:
+		return "left"
+	}
+	return "unknown"
+}
+
+// Member is a node in the cluster as seen by gossip.
+// Members are also the unit of dissemination: every gossip message carries a few of them as updates.
+type Member struct {
+	ID          string      // Node ID
+	Addr        string      // Address the node listens on
+	State       MemberState // Alive, suspect, dead or left
+	Incarnation uint64      // Version of the member's state, only the member itself increases it
+}
+
+// memberEntry is the local bookkeeping for a member
+type memberEntry struct {
+	Member
+	suspectedAt time.Time // When the member became suspect
+}
+
+// memberUpdate is a membership change waiting to be piggybacked on gossip messages
+type memberUpdate struct {
+	member    Member
+	transmits int // How many times the update has been sent
+}
+
+// Membership tracks the cluster member list of a single node
+type Membership struct {
+	mu      sync.Mutex
+	self    Member
+	members map[string]*memberEntry // Known members by ID, excluding self
+	updates []*memberUpdate         // Pending updates to disseminate
+}
+
+// NewMembership creates a member list that initially contains only the local node
+func NewMembership(self Member) *Membership {
+	self.State = MemberAlive
+	m := &Membership{
+		self:    self,
+		members: make(map[string]*memberEntry),
+	}
+	m.enqueue(self)
+	return m
+}
+
+// Self returns the local node's own member record
+func (m *Membership) Self() Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.self
+}
+
+// Get returns the member with the given ID
+func (m *Membership) Get(id string) (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.members[id]
+	if !ok {
+		return Member{}, false
+	}
+	return e.Member, true
+}
+
+// Members returns every known member, including self, sorted by ID
+func (m *Membership) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := []Member{m.self}
+	for _, e := range m.members {
+		members = append(members, e.Member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// Active returns the remote members that are alive or suspect, sorted by ID
+func (m *Membership) Active() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := []Member{}
+	for _, e := range m.members {
+		if e.State == MemberAlive || e.State == MemberSuspect {
+			members = append(members, e.Member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// Apply merges an update received from the network.
+// It returns true if the update changed local state, in which case it is queued for further dissemination.
+// Rumours about the local node are refuted by bumping its incarnation.
+func (m *Membership) Apply(u Member) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u.ID == m.self.ID {
+		if u.State == MemberAlive || u.Incarnation < m.self.Incarnation {
+			return false
+		}
+		// Somebody thinks we are suspect or dead: refute with a higher incarnation
+		m.self.Incarnation = u.Incarnation + 1
+		m.enqueue(m.self)
+		return true
+	}
+
+	e, ok := m.members[u.ID]
+	if !ok {
+		m.members[u.ID] = &memberEntry{Member: u}
+		if u.State == MemberSuspect {
+			m.members[u.ID].suspectedAt = time.Now()
+		}
+		m.enqueue(u)
+		return true
+	}
+
+	if !overrides(u, e.Member) {
+		return false
+	}
+
+	if u.State == MemberSuspect && e.State != MemberSuspect {
+		e.suspectedAt = time.Now()
+	}
+	if len(u.Addr) == 0 {
+		u.Addr = e.Addr
+	}
+	e.Member = u
+	m.enqueue(u)
+
+	return true
+}
+
+// Suspect marks a member as suspect after a failed probe
+func (m *Membership) Suspect(id string) bool {
+	m.mu.Lock()
+	e, ok := m.members[id]
+	if !ok || e.State != MemberAlive {
+		m.mu.Unlock()
+		return false
+	}
+	u := e.Member
+	m.mu.Unlock()
+
+	u.State = MemberSuspect
+	return m.Apply(u)
+}
+
+// ExpireSuspects declares dead every member that stayed suspect for longer than timeout
+// and returns the members that were declared dead
+func (m *Membership) ExpireSuspects(timeout time.Duration) []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dead := []Member{}
+	for _, e := range m.members {
+		if e.State != MemberSuspect || time.Since(e.suspectedAt) < timeout {
+			continue
+		}
+		e.State = MemberDead
+		m.enqueue(e.Member)
+		dead = append(dead, e.Member)
+	}
+	return dead
+}
+
+// Leave marks the local node as having left the cluster and returns the update to announce
+func (m *Membership) Leave() Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.self.Incarnation++
+	m.self.State = MemberLeft
+	m.enqueue(m.self)
+	return m.self
+}
+
+// Updates returns up to max pending updates to piggyback on an outgoing message.
+// Least transmitted updates go first, and updates that were sent often enough are dropped.
+func (m *Membership) Updates(max int) []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	limit := retransmitMult * int(math.Ceil(math.Log2(float64(len(m.members)+2))))
+
+	sort.SliceStable(m.updates, func(i, j int) bool {
+		return m.updates[i].transmits < m.updates[j].transmits
+	})
+
+	out := []Member{}
+	for _, u := range m.updates {
+		if len(out) == max {
+			break
+		}
+		out = append(out, u.member)
+		u.transmits++
+	}
+
+	kept := m.updates[:0]
+	for _, u := range m.updates {
+		if u.transmits < limit {
+			kept = append(kept, u)
+		}
+	}
+	m.updates = kept
+
+	return out
+}
+
+// enqueue schedules an update for dissemination, replacing any older update for the same member
+func (m *Membership) enqueue(u Member) {
+	for _, pending := range m.updates {
+		if pending.member.ID == u.ID {
+			pending.member = u
+			pending.transmits = 0
+			return
+		}
+	}
+	m.updates = append(m.updates, &memberUpdate{member: u})
+}
+
+// overrides reports whether update u carries newer information than the current state cur.
+// These are the SWIM precedence rules:
+//   - alive overrides alive or suspect only with a strictly higher incarnation
+//   - suspect overrides alive with the same or higher incarnation, and suspect with a higher one
+//   - dead and left override anything with the same or higher incarnation
+func overrides(u Member, cur Member) bool {
+	switch u.State {
+	case MemberAlive:
+		return u.Incarnation > cur.Incarnation
+	case MemberSuspect:
+		if cur.State == MemberAlive {
+			return u.Incarnation >= cur.Incarnation
+		}
+		return cur.State == MemberSuspect && u.Incarnation > cur.Incarnation
+	case MemberDead, MemberLeft:
+		if cur.State == MemberDead || cur.State == MemberLeft {
+			return u.Incarnation > cur.Incarnation
+		}
+		return u.Incarnation >= cur.Incarnation
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+// Unit tests for cluster membership in GoVaultFS
+// These tests verify the SWIM precedence rules used to merge membership updates.
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMembershipApply checks that newer information wins and stale updates are ignored
+func TestMembershipApply(t *testing.T) {
+	m := NewMembership(Member{ID: "a", Addr: ":3000"})
+
+	if !m.Apply(Member{ID: "b", Addr: ":4000", State: MemberAlive}) {
+		t.Fatal("expected new member to be applied")
+	}
+
+	// A suspicion with the same incarnation overrides alive
+	if !m.Apply(Member{ID: "b", State: MemberSuspect}) {
+		t.Fatal("expected suspect to override alive")
+	}
+
+	// An alive update with the same incarnation must not clear the suspicion
+	if m.Apply(Member{ID: "b", State: MemberAlive}) {
+		t.Fatal("expected stale alive to be ignored")
+	}
+
+	// The member refutes with a higher incarnation
+	if !m.Apply(Member{ID: "b", State: MemberAlive, Incarnation: 1}) {
+		t.Fatal("expected refutation to be applied")
+	}
+
+	b, _ := m.Get("b")
+	if b.State != MemberAlive || b.Addr != ":4000" {
+		t.Errorf("have %+v want alive member at :4000", b)
+	}
+}
+
+// TestMembershipRefute checks that a node bumps its incarnation when it hears it is suspected
+func TestMembershipRefute(t *testing.T) {
+	m := NewMembership(Member{ID: "a"})
+
+	if !m.Apply(Member{ID: "a", State: MemberSuspect, Incarnation: 3}) {
+		t.Fatal("expected suspicion of self to be refuted")
+	}
+
+	self := m.Self()
+	if self.State != MemberAlive || self.Incarnation != 4 {
+		t.Errorf("have %+v want alive with incarnation 4", self)
+	}
+}
+
+// TestMembershipExpireSuspects checks that suspects are declared dead after the timeout
+func TestMembershipExpireSuspects(t *testing.T) {
+	m := NewMembership(Member{ID: "a"})
+	m.Apply(Member{ID: "b", State: MemberAlive})
+	m.Suspect("b")
+
+	if dead := m.ExpireSuspects(time.Hour); len(dead) != 0 {
+		t.Fatalf("expected no dead members, have %v", dead)
+	}
+
+	dead := m.ExpireSuspects(0)
+	if len(dead) != 1 || dead[0].ID != "b" {
+		t.Fatalf("expected b to be declared dead, have %v", dead)
+	}
+
+	if active := m.Active(); len(active) != 0 {
+		t.Errorf("expected no active members, have %v", active)
+	}
+}
@@ -0,0 +1,149 @@
+// Metrics for GoVaultFS nodes
+// Every node keeps a metrics registry that the HTTP gateway, the admin socket and an optional dedicated listener
+// serve at /metrics in the Prometheus text format. Counters are updated on the data path; gauges that other
+// components already track, like peer byte counts and queue depth, are read when the registry is scraped.
+package server
+
+import (
+	"io"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/metrics"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+)
+
+// nodeMetrics are the metrics a node updates as it works
+type nodeMetrics struct {
+	storedBytes *metrics.Counter   // Bytes written to disk, by source: client or peer
+	servedBytes *metrics.Counter   // Bytes read back, by destination: client or peer
+	gets        *metrics.Counter   // Get calls, by result: hit, cache, fetch, rebuild or miss
+	transfers   *metrics.Histogram // Duration of peer transfers, by op
+	refused     *metrics.Counter   // Writes refused for lack of room, by reason: full or quota
+	evictions   *metrics.Counter   // Cached copies evicted
+	conflicts   *metrics.Counter   // Concurrent writes, by resolution
+	denied      *metrics.Counter   // Requests refused by access control, by op
+}
+
+// statsTransport is a transport that reports its load, as the TCP and in-memory transports do
+type statsTransport interface {
+	Stats() p2p.TransportStats
+}
+
+// statsPeer is a peer that reports the bytes exchanged over its connection
+type statsPeer interface {
+	Stats() p2p.PeerStats
+}
+
+// newMetrics creates the registry of a node with all its metrics
+func newMetrics(s *FileServer) (*metrics.Registry, nodeMetrics) {
+	m := nodeMetrics{
+		storedBytes: metrics.NewCounter("govault_stored_bytes_total", "Bytes written to local disk.", "source"),
+		servedBytes: metrics.NewCounter("govault_served_bytes_total", "Bytes of files served.", "dest"),
+		gets:        metrics.NewCounter("govault_get_total", "Get calls by where the file came from.", "result"),
+		transfers:   metrics.NewHistogram("govault_transfer_duration_seconds", "Duration of file transfers with peers.", nil, "op"),
+		refused:     metrics.NewCounter("govault_refused_writes_total", "Writes refused because the node is full or the owner's quota is used up.", "reason"),
+		evictions:   metrics.NewCounter("govault_cache_evictions_total", "Cached copies of fetched files evicted to make room."),
+		conflicts:   metrics.NewCounter("govault_conflicts_total", "Concurrent writes of the same key by how they were resolved.", "resolution"),
+		denied:      metrics.NewCounter("govault_access_denied_total", "Requests of clients and peers refused by access control.", "op"),
+	}
+
+	reg := metrics.NewRegistry()
+	reg.Register(m.storedBytes, m.servedBytes, m.gets, m.transfers, m.refused, m.evictions, m.conflicts, m.denied)
+
+	reg.Register(
+		metrics.NewGaugeFunc("govault_peers", "Connected peers.", func() float64 {
+			return float64(len(s.peerList()))
+		}),
+		metrics.NewFunc("govault_members", "Cluster members by state.", metrics.GaugeType, []string{"state"},
+			func(emit func(float64, ...string)) {
+				counts := map[MemberState]int{MemberAlive: 0, MemberSuspect: 0}
+				for _, m := range s.Members() {
+					counts[m.State]++
+				}
+				for state, n := range counts {
+					emit(float64(n), state.String())
+				}
+			}),
+		metrics.NewFunc("govault_peer_bytes_total", "Bytes exchanged with each connected peer.", metrics.CounterType, []string{"peer", "direction"},
+			func(emit func(float64, ...string)) {
+				for _, p := range s.peerList() {
+					if sp, ok := p.(statsPeer); ok {
+						st := sp.Stats()
+						emit(float64(st.BytesIn), p.RemoteAddr().String(), "in")
+						emit(float64(st.BytesOut), p.RemoteAddr().String(), "out")
+					}
+				}
+			}),
+		metrics.NewFunc("govault_rpc_queue_depth", "Messages waiting in the transport's RPC channel.", metrics.GaugeType, nil,
+			func(emit func(float64, ...string)) {
+				if t, ok := s.Transport.(statsTransport); ok {
+					emit(float64(t.Stats().QueueDepth))
+				}
+			}),
+		metrics.NewFunc("govault_decode_errors_total", "Peer streams dropped because their message could not be decoded.", metrics.CounterType, nil,
+			func(emit func(float64, ...string)) {
+				if t, ok := s.Transport.(statsTransport); ok {
+					emit(float64(t.Stats().DecodeErrors))
+				}
+			}),
+		metrics.NewFunc("govault_disk_usage_bytes", "Size of the files under the storage root.", metrics.GaugeType, nil,
+			func(emit func(float64, ...string)) {
+				if n, err := s.store.DiskUsage(); err == nil {
+					emit(float64(n))
+				}
+			}),
+		metrics.NewFunc("govault_capacity_bytes", "Storage limit of the node, if it has one.", metrics.GaugeType, nil,
+			func(emit func(float64, ...string)) {
+				if c := s.Capacity(); c.Limit > 0 {
+					emit(float64(c.Limit))
+				}
+			}),
+		metrics.NewGaugeFunc("govault_stored_bytes", "Bytes of files stored for this node and its peers.", func() float64 {
+			return float64(s.store.TotalUsage())
+		}),
+		metrics.NewGaugeFunc("govault_cache_bytes", "Bytes of cached copies of fetched files.", func() float64 {
+			return float64(s.cache.stats().Bytes)
+		}),
+		metrics.NewGaugeFunc("govault_objects", "Objects stored through this node.", func() float64 {
+			return float64(s.index.len())
+		}),
+	)
+
+	return reg, m
+}
+
+// Metrics returns the metrics registry of the node, so that embedding programs can add their own
+func (s *FileServer) Metrics() *metrics.Registry {
+	return s.registry
+}
+
+// observeTransfer records how long a transfer that started at start took
+func (s *FileServer) observeTransfer(op string, start time.Time) {
+	s.metrics.transfers.Observe(time.Since(start).Seconds(), op)
+}
+
+// servedReader counts the bytes read from a file returned by Get
+type servedReader struct {
+	io.Reader
+	count func(n int)
+}
+
+// Read reads from the file and counts the bytes read
+func (r *servedReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.count(n)
+	return n, err
+}
+
+// Close closes the file if it needs closing
+func (r *servedReader) Close() error {
+	if c, ok := r.Reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// served wraps a file returned by Get so the bytes read from it count as served to a client
+func (s *FileServer) served(r io.Reader) io.Reader {
+	return &servedReader{Reader: r, count: func(n int) { s.metrics.servedBytes.Add(float64(n), "client") }}
+}
@@ -0,0 +1,90 @@
+// Tests for node metrics in GoVaultFS
+// These drive a two node cluster and read its metrics through the gateway's /metrics endpoint.
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/metrics"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// scrape reads the metrics of a node as Prometheus would
+func scrape(t *testing.T, s *FileServer) map[string]float64 {
+	ts := httptest.NewServer(NewGateway(s))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	samples, err := metrics.ParseText(resp.Body)
+	assert.Nil(t, err)
+	return samples
+}
+
+// TestMetrics checks the counters and gauges a node exports after storing and reading files
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	assert.Nil(t, s2.Store("a.txt", strings.NewReader("hello")))
+	assert.Nil(t, s2.Store("b.txt", strings.NewReader("world!")))
+
+	// One read from disk, one fetched back from the peer, one miss
+	read := func(key string) {
+		r, err := s2.Get(key)
+		assert.Nil(t, err)
+		io.ReadAll(r)
+		r.(io.Closer).Close()
+	}
+	read("a.txt")
+	assert.Nil(t, s2.store.Delete(s2.ID, "b.txt"))
+	read("b.txt")
+	_, err := s2.Get("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A stream that does not decode is counted on the receiver
+	assert.Nil(t, s2.peerList()[0].Send([]byte{0x7f}))
+	waitFor(t, 5*time.Second, func() bool { return scrape(t, s1)["govault_decode_errors_total"] == 1 })
+
+	m := scrape(t, s2)
+	assert.Equal(t, float64(11), m[`govault_stored_bytes_total{source="client"}`])
+	assert.Equal(t, float64(6), m[`govault_stored_bytes_total{source="peer"}`]) // b.txt fetched back
+	assert.Equal(t, float64(11), m[`govault_served_bytes_total{dest="client"}`])
+	assert.Equal(t, float64(1), m[`govault_get_total{result="hit"}`])
+	assert.Equal(t, float64(1), m[`govault_get_total{result="fetch"}`])
+	assert.Equal(t, float64(1), m[`govault_get_total{result="miss"}`])
+	assert.Equal(t, float64(2), m[`govault_transfer_duration_seconds_count{op="replicate"}`])
+	assert.Equal(t, float64(1), m[`govault_transfer_duration_seconds_count{op="fetch"}`])
+	assert.Equal(t, float64(1), m["govault_peers"])
+	assert.Equal(t, float64(2), m[`govault_members{state="alive"}`])
+	assert.Equal(t, float64(2), m["govault_objects"])
+	assert.Greater(t, m["govault_disk_usage_bytes"], float64(11))
+	assert.Contains(t, m, "govault_rpc_queue_depth")
+
+	// Per-peer byte counts cover the replicas sent and the fetch
+	var out float64
+	for k, v := range m {
+		if strings.HasPrefix(k, "govault_peer_bytes_total{") && strings.HasSuffix(k, `direction="out"}`) {
+			out += v
+		}
+	}
+	assert.Greater(t, out, float64(11))
+
+	m = scrape(t, s1)
+	assert.Equal(t, float64(11+2*16), m[`govault_stored_bytes_total{source="peer"}`]) // Encrypted replicas with their IVs
+	assert.Equal(t, float64(6+16), m[`govault_served_bytes_total{dest="peer"}`])
+}
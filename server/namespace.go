@@ -0,0 +1,410 @@
+// Directory namespace for GoVaultFS
+// Keys are flat strings, so this file layers a directory tree over them: a key such as "photos/2024/a.png" is the
+// file a.png in the directory photos/2024, and every prefix ending in a slash is a directory. Directories created
+// explicitly are stored as directory objects under their path with a trailing slash, like the folder markers of S3,
+// so they are replicated and versioned like files and carry the directory's metadata. Renames move the stored
+// files in place on the node and its peers instead of copying them.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dirsFileName is the name of the directory list kept in the storage root before directories were objects
+const dirsFileName = "dirs.json"
+
+// Errors returned by namespace operations
+var (
+	ErrExist          = errors.New("file already exists")
+	ErrParentNotFound = errors.New("parent directory not found")
+	ErrIsDir          = errors.New("is a directory")
+	ErrNotDir         = errors.New("not a directory")
+)
+
+// DirEntry describes a file or directory in the namespace
+type DirEntry struct {
+	Path    string            `json:"path"`           // Path from the root without a leading slash, "" for the root
+	IsDir   bool              `json:"is_dir"`         // Whether the entry is a directory
+	Size    int64             `json:"size"`           // File size, 0 for directories
+	ETag    string            `json:"etag,omitempty"` // Hex MD5 of the file content
+	ModTime time.Time         `json:"mod_time"`       // Last modification, zero for directories implied by their files
+	Meta    map[string]string `json:"meta,omitempty"` // Metadata of a directory, only filled in by Stat
+}
+
+// Name returns the last element of the entry's path
+func (e DirEntry) Name() string {
+	return path.Base("/" + e.Path)
+}
+
+// dirObject is the content of a directory object
+type dirObject struct {
+	Created time.Time         `json:"created"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// Namespace presents the objects stored through a file server as a directory tree.
+// A file shadows a directory of the same name.
+type Namespace struct {
+	server *FileServer
+	legacy string // Where directories were listed before they were objects
+}
+
+// newNamespace creates the namespace of a file server
+func newNamespace(s *FileServer) *Namespace {
+	return &Namespace{
+		server: s,
+		legacy: filepath.Join(s.store.Root, dirsFileName),
+	}
+}
+
+// migrate stores the directories listed in dirs.json as directory objects, then removes the list
+func (ns *Namespace) migrate() {
+	dirs := make(map[string]time.Time)
+	if err := readJSON(ns.legacy, &dirs); err != nil {
+		ns.server.logger.Warn("directory list unreadable", "err", err)
+		return
+	}
+	if len(dirs) == 0 {
+		return
+	}
+
+	for p, created := range dirs {
+		if _, ok := ns.server.index.get(dirKey(p)); ok {
+			continue
+		}
+		if err := ns.putDir(p, dirObject{Created: created}, Precondition{IfNoneMatch: true}); err != nil {
+			ns.server.logger.Warn("storing directory object failed", "path", p, "err", err)
+		}
+	}
+	if err := os.Remove(ns.legacy); err != nil {
+		ns.server.logger.Warn("removing directory list failed", "err", err)
+	}
+}
+
+// CleanPath turns a slash separated path into a namespace path: cleaned, without leading or trailing slashes
+func CleanPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// Stat describes the file or directory at a path
+func (ns *Namespace) Stat(p string) (DirEntry, error) {
+	p = CleanPath(p)
+	if len(p) == 0 {
+		return DirEntry{IsDir: true}, nil
+	}
+
+	if info, err := ns.server.Stat(p); err == nil {
+		return DirEntry{Path: p, Size: info.Size, ETag: info.ETag, ModTime: info.ModTime}, nil
+	}
+
+	if info, err := ns.server.Stat(dirKey(p)); err == nil {
+		dir, err := ns.readDir(p)
+		if err != nil {
+			ns.server.logger.Warn("reading directory object failed", "path", p, "err", err)
+		}
+		return DirEntry{Path: p, IsDir: true, ModTime: info.ModTime, Meta: dir.Meta}, nil
+	}
+	if len(ns.server.List(p+"/", "", 1)) > 0 {
+		return DirEntry{Path: p, IsDir: true}, nil
+	}
+
+	return DirEntry{}, fmt.Errorf("%w: %s", ErrNotFound, p)
+}
+
+// ReadDir lists the entries of a directory in name order
+func (ns *Namespace) ReadDir(p string) ([]DirEntry, error) {
+	p = CleanPath(p)
+	dir, err := ns.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.IsDir {
+		return nil, fmt.Errorf("%w: %s", ErrNotDir, p)
+	}
+
+	prefix := dirPrefix(p)
+	entries := make(map[string]DirEntry)
+
+	// Files directly in the directory, the directory objects of its subdirectories and the directories
+	// implied by deeper files. Keys come in order, so a file comes before the directory it shadows.
+	for _, info := range ns.server.List(prefix, "", 0) {
+		name, rest, nested := strings.Cut(strings.TrimPrefix(info.Key, prefix), "/")
+		if len(name) == 0 {
+			continue
+		}
+		if !nested {
+			entries[name] = DirEntry{Path: prefix + name, Size: info.Size, ETag: info.ETag, ModTime: info.ModTime}
+		} else if e, ok := entries[name]; !ok || (e.IsDir && len(rest) == 0) {
+			e = DirEntry{Path: prefix + name, IsDir: true}
+			if len(rest) == 0 {
+				e.ModTime = info.ModTime
+			}
+			entries[name] = e
+		}
+	}
+
+	out := make([]DirEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// Mkdir creates a directory with optional metadata. Its parent must exist.
+func (ns *Namespace) Mkdir(p string, meta map[string]string) error {
+	p = CleanPath(p)
+	if _, err := ns.Stat(p); err == nil {
+		return fmt.Errorf("%w: %s", ErrExist, p)
+	}
+	if parent, err := ns.Stat(path.Dir("/" + p)); err != nil || !parent.IsDir {
+		return fmt.Errorf("%w: %s", ErrParentNotFound, p)
+	}
+
+	err := ns.putDir(p, dirObject{Created: time.Now().UTC(), Meta: meta}, Precondition{IfNoneMatch: true})
+	if errors.Is(err, ErrPreconditionFailed) {
+		return fmt.Errorf("%w: %s", ErrExist, p)
+	}
+	return err
+}
+
+// SetMeta replaces the metadata of a directory. A directory only implied by its files gets a directory object.
+func (ns *Namespace) SetMeta(p string, meta map[string]string) error {
+	p = CleanPath(p)
+	e, err := ns.Stat(p)
+	if err != nil {
+		return err
+	}
+	if !e.IsDir || len(p) == 0 {
+		return fmt.Errorf("%w: %s", ErrNotDir, p)
+	}
+
+	dir, err := ns.readDir(p)
+	if errors.Is(err, ErrNotFound) {
+		dir.Created = time.Now().UTC()
+	} else if err != nil {
+		return err
+	}
+	dir.Meta = meta
+	return ns.putDir(p, dir, Precondition{})
+}
+
+// RemoveAll deletes a file, or a directory with everything in it
+func (ns *Namespace) RemoveAll(p string) error {
+	p = CleanPath(p)
+	e, err := ns.Stat(p)
+	if err != nil {
+		return err
+	}
+	if len(p) == 0 {
+		return fmt.Errorf("%w: cannot remove the root", ErrIsDir)
+	}
+	if !e.IsDir {
+		return ns.server.Delete(p)
+	}
+
+	// The directory's own object and those of its subdirectories are among its keys
+	for _, info := range ns.server.List(p+"/", "", 0) {
+		if err := ns.server.Delete(info.Key); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy copies a file, or a directory with everything in it, to a path that does not exist yet.
+// With recursive unset only the directory itself and its metadata are copied.
+func (ns *Namespace) Copy(src string, dst string, recursive bool) error {
+	src, dst = CleanPath(src), CleanPath(dst)
+	e, err := ns.checkTarget(src, dst)
+	if err != nil {
+		return err
+	}
+
+	if !e.IsDir {
+		return ns.copyFile(src, dst)
+	}
+	if !recursive {
+		return ns.putDir(dst, dirObject{Created: time.Now().UTC(), Meta: e.Meta}, Precondition{IfNoneMatch: true})
+	}
+
+	for _, info := range ns.server.List(src+"/", "", 0) {
+		if err := ns.copyFile(info.Key, dst+strings.TrimPrefix(info.Key, src)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename moves a file, or a directory with everything in it, to a path that does not exist yet.
+// Files keep their versions and are moved where they are stored rather than copied. A file that cannot be moved
+// stays whole at src; a directory whose rename stops part way returns a RenameError naming what moved.
+func (ns *Namespace) Rename(src string, dst string) error {
+	src, dst = CleanPath(src), CleanPath(dst)
+	e, err := ns.checkTarget(src, dst)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if !e.IsDir {
+		return ns.server.renameObject(ctx, src, dst)
+	}
+	moved := []string{}
+	for _, info := range ns.server.List(src+"/", "", 0) {
+		if err := ns.server.renameObject(ctx, info.Key, dst+strings.TrimPrefix(info.Key, src)); err != nil {
+			return &RenameError{Moved: moved, Err: err}
+		}
+		moved = append(moved, info.Key)
+	}
+	return nil
+}
+
+// RenameError lists the keys a directory rename moved before it failed on the next one
+type RenameError struct {
+	Moved []string // Keys under the source path that now live under the destination
+	Err   error
+}
+
+// Error describes how far the rename got
+func (e *RenameError) Error() string {
+	return fmt.Sprintf("rename stopped after moving %d objects: %v", len(e.Moved), e.Err)
+}
+
+// Unwrap returns the error that stopped the rename
+func (e *RenameError) Unwrap() error {
+	return e.Err
+}
+
+// checkTarget returns the entry at src if it can be copied or renamed to dst: dst does not exist,
+// is not inside src and its parent is a directory
+func (ns *Namespace) checkTarget(src string, dst string) (DirEntry, error) {
+	e, err := ns.Stat(src)
+	if err != nil {
+		return e, err
+	}
+	if len(src) == 0 || len(dst) == 0 || dst == src || (e.IsDir && strings.HasPrefix(dst, src+"/")) {
+		return e, fmt.Errorf("%w: cannot move %s into itself", ErrExist, src)
+	}
+	if _, err := ns.Stat(dst); err == nil {
+		return e, fmt.Errorf("%w: %s", ErrExist, dst)
+	}
+	if parent, err := ns.Stat(path.Dir("/" + dst)); err != nil || !parent.IsDir {
+		return e, fmt.Errorf("%w: %s", ErrParentNotFound, dst)
+	}
+	return e, nil
+}
+
+// copyFile stores the content of one key under another
+func (ns *Namespace) copyFile(src string, dst string) error {
+	r, err := ns.server.Get(src)
+	if err != nil {
+		return err
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	return ns.server.Store(dst, r)
+}
+
+// readDir reads the directory object of a directory
+func (ns *Namespace) readDir(p string) (dirObject, error) {
+	var dir dirObject
+	r, err := ns.server.Get(dirKey(p))
+	if err != nil {
+		return dir, err
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	return dir, json.NewDecoder(r).Decode(&dir)
+}
+
+// putDir stores the directory object of a directory if the condition holds
+func (ns *Namespace) putDir(p string, dir dirObject, cond Precondition) error {
+	b, err := json.Marshal(dir)
+	if err != nil {
+		return err
+	}
+	return ns.server.StoreIf(dirKey(p), bytes.NewReader(b), cond)
+}
+
+// renameObject moves an object stored through this node to a key that is not in use, with its older versions.
+// The node and its peers move the files they hold under the old key. The latest version moves last, and if a move
+// fails the files already moved go back, so the object stays whole under from.
+func (s *FileServer) renameObject(ctx context.Context, from string, to string) error {
+	first, second := min(from, to), max(from, to)
+	defer s.keyLocks.lock(first)()
+	defer s.keyLocks.lock(second)()
+
+	latest, ok := s.index.get(from)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, from)
+	}
+	if _, ok := s.index.get(to); ok {
+		return fmt.Errorf("%w: %s", ErrExist, to)
+	}
+
+	s.deleteLocal(cacheID, from)
+	if err := s.cache.remove(from); err != nil {
+		s.logger.Error("saving cache index failed", "err", err)
+	}
+	moves := []fileMove{}
+	for _, info := range s.index.history(from)[1:] {
+		moves = append(moves, fileMove{versionKey(from, info.Version), versionKey(to, info.Version)})
+	}
+	moves = append(moves, fileMove{from, to})
+	for i, m := range moves {
+		if err := s.moveFile(ctx, m.from, m.to); err != nil {
+			s.unmove(ctx, moves[:i])
+			return err
+		}
+	}
+	if err := s.index.rename(from, to); err != nil {
+		s.unmove(ctx, moves)
+		return err
+	}
+
+	s.publish(Event{Type: EventDeleted, Key: from})
+	s.publish(Event{Type: EventStored, Key: to, Size: latest.Size, ETag: latest.ETag})
+	return nil
+}
+
+// fileMove is a file moved from one key to another
+type fileMove struct {
+	from string
+	to   string
+}
+
+// unmove moves files back to the keys they were moved from, the last one first
+func (s *FileServer) unmove(ctx context.Context, moves []fileMove) {
+	for i := len(moves) - 1; i >= 0; i-- {
+		if err := s.moveFile(ctx, moves[i].to, moves[i].from); err != nil {
+			s.logger.Error("moving file back failed", "key", moves[i].from, "err", err)
+		}
+	}
+}
+
+// dirKey is the key of the directory object of a directory
+func dirKey(p string) string {
+	return p + "/"
+}
+
+// dirPrefix is the key prefix of the entries in a directory
+func dirPrefix(p string) string {
+	if len(p) == 0 {
+		return ""
+	}
+	return p + "/"
+}
@@ -0,0 +1,142 @@
+// Tests for the directory namespace
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNamespace checks that directories are replicated objects with metadata, that renames move files and their
+// versions where they are stored, that directories listed in dirs.json are migrated, and the gateway endpoints
+func TestNamespace(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	// A directory created before directories were objects
+	assert.Nil(t, os.MkdirAll(s2.store.Root, 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(s2.store.Root, dirsFileName), []byte(`{"old":"2024-01-02T03:04:05Z"}`), 0644))
+
+	startServers(t, s1, s2)
+	ns := s2.Namespace()
+
+	read := func(r io.Reader, err error) string {
+		assert.Nil(t, err)
+		if err != nil {
+			return ""
+		}
+		b, _ := io.ReadAll(r)
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+		return string(b)
+	}
+
+	old, err := ns.Stat("old")
+	assert.Nil(t, err)
+	assert.True(t, old.IsDir)
+	_, err = os.Stat(filepath.Join(s2.store.Root, dirsFileName))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Directories are objects replicated to peers, with their metadata
+	assert.Nil(t, ns.Mkdir("docs", map[string]string{"owner": "alice"}))
+	assert.ErrorIs(t, ns.Mkdir("docs", nil), ErrExist)
+	assert.ErrorIs(t, ns.Mkdir("none/sub", nil), ErrParentNotFound)
+	assert.Nil(t, ns.Mkdir("docs/drafts", nil))
+	assert.True(t, s1.store.Has(s2.ID, crypto.HashKey(dirKey("docs"))))
+	assert.Nil(t, s2.store.Delete(s2.ID, dirKey("docs")))
+	docs, err := ns.Stat("docs")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"owner": "alice"}, docs.Meta) // Read from s1
+	assert.Nil(t, ns.SetMeta("docs", map[string]string{"owner": "bob"}))
+	docs, _ = ns.Stat("docs")
+	assert.Equal(t, "bob", docs.Meta["owner"])
+
+	assert.Nil(t, s2.Store("docs/a.txt", strings.NewReader("one")))
+	assert.Nil(t, s2.Store("docs/a.txt", strings.NewReader("two")))
+	assert.Nil(t, s2.Store("docs/drafts/b.txt", strings.NewReader("draft")))
+	assert.ErrorIs(t, ns.SetMeta("docs/a.txt", nil), ErrNotDir)
+	entries, err := ns.ReadDir("docs")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"docs/a.txt", "docs/drafts"}, []string{entries[0].Path, entries[1].Path})
+	assert.False(t, entries[1].ModTime.IsZero())
+
+	// A renamed directory keeps its files, their versions and its metadata, moved on the peer too
+	versions, _ := s2.ListVersions("docs/a.txt")
+	assert.ErrorIs(t, ns.Rename("docs", "docs/inside"), ErrExist)
+	assert.Nil(t, ns.Rename("docs", "archive"))
+	_, err = ns.Stat("docs")
+	assert.ErrorIs(t, err, ErrNotFound)
+	moved, _ := s2.ListVersions("archive/a.txt")
+	assert.Len(t, moved, 2)
+	assert.Equal(t, versions[1].Version, moved[1].Version)
+	assert.Equal(t, "one", read(s2.GetVersion("archive/a.txt", moved[1].Version)))
+	assert.True(t, s1.store.Has(s2.ID, crypto.HashKey("archive/drafts/b.txt")))
+	assert.False(t, s1.store.Has(s2.ID, crypto.HashKey("docs/drafts/b.txt")))
+	assert.Nil(t, s2.store.Delete(s2.ID, "archive/drafts/b.txt"))
+	assert.Equal(t, "draft", read(s2.Get("archive/drafts/b.txt"))) // Fetched from s1
+	archive, _ := ns.Stat("archive")
+	assert.Equal(t, "bob", archive.Meta["owner"])
+
+	// A rename that cannot move the latest version puts the older ones back, and a directory rename
+	// names the objects it moved before it stopped
+	assert.Nil(t, s2.Store("archive/drafts/b.txt", strings.NewReader("final")))
+	blocked := filepath.Join(s2.store.Root, s2.ID, s2.store.PathTransformFunc("attic/drafts/b.txt").FullPath())
+	assert.Nil(t, os.MkdirAll(filepath.Join(blocked, "in-the-way"), 0o755))
+	var renameErr *RenameError
+	assert.ErrorAs(t, ns.Rename("archive", "attic"), &renameErr)
+	assert.Equal(t, []string{"archive/", "archive/a.txt", "archive/drafts/"}, renameErr.Moved)
+	drafts, _ := s2.ListVersions("archive/drafts/b.txt")
+	assert.Len(t, drafts, 2)
+	assert.Equal(t, "draft", read(s2.GetVersion("archive/drafts/b.txt", drafts[1].Version)))
+	assert.Equal(t, "final", read(s2.Get("archive/drafts/b.txt")))
+	assert.True(t, s1.store.Has(s2.ID, crypto.HashKey(versionKey("archive/drafts/b.txt", drafts[1].Version))))
+	assert.Nil(t, os.RemoveAll(blocked))
+	for _, key := range renameErr.Moved {
+		assert.Nil(t, s2.renameObject(context.Background(), "attic"+strings.TrimPrefix(key, "archive"), key))
+	}
+
+	// The gateway creates, lists, renames and removes directories
+	ts := httptest.NewServer(NewGateway(s2))
+	defer ts.Close()
+	do := func(method string, path string, body string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		return resp
+	}
+	assert.Equal(t, http.StatusCreated, do(http.MethodPut, "/dirs/archive/2024", `{"year":"2024"}`).StatusCode)
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, "/dirs/archive/2024", `{"year":"MMXXIV"}`).StatusCode)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPut, "/dirs/archive/a.txt", "").StatusCode)
+	resp := do(http.MethodPost, "/move/archive/a.txt?to=archive/2024/a.txt", "")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var dir struct {
+		Dir     DirEntry   `json:"dir"`
+		Entries []DirEntry `json:"entries"`
+	}
+	resp = do(http.MethodGet, "/dirs/archive/2024", "")
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&dir))
+	assert.Equal(t, "MMXXIV", dir.Dir.Meta["year"])
+	assert.Len(t, dir.Entries, 1)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/dirs/archive", "").StatusCode)
+	assert.Empty(t, s2.List("archive/", "", 0))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/dirs/archive", "").StatusCode)
+	assert.Nil(t, s2.Store("top", bytes.NewReader(nil)))
+	assert.Equal(t, http.StatusConflict, do(http.MethodGet, "/dirs/top", "").StatusCode)
+}
@@ -0,0 +1,73 @@
+// Replica placement for GoVaultFS
+// This file decides which peers hold the replicas of a file. Peers are ranked per key with
+// rendezvous (highest random weight) hashing: every node ranks the same members in the same order,
+// and a node joining or leaving only moves the keys it owned. New files skip members known to be full.
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+)
+
+// rankPeers returns the connected peers ordered by preference for holding key.
+// Peers are identified by member ID once gossip has matched them to a member, and by address before that.
+func (s *FileServer) rankPeers(key string) []p2p.Peer {
+	ranked := s.rankMembers(key)
+	out := make([]p2p.Peer, len(ranked))
+	for i, p := range ranked {
+		out[i] = p.peer
+	}
+	return out
+}
+
+// rankPeersFor ranks the connected peers for holding a file of size bytes under key.
+// Peers known to lack room for it move to the end of the line, in their rendezvous order.
+func (s *FileServer) rankPeersFor(key string, size int64) []p2p.Peer {
+	ranked := s.rankMembers(key)
+	out, full := make([]p2p.Peer, 0, len(ranked)), []p2p.Peer{}
+	for _, p := range ranked {
+		if s.hasRoom(p.id, size) {
+			out = append(out, p.peer)
+		} else {
+			full = append(full, p.peer)
+		}
+	}
+	return append(out, full...)
+}
+
+// rankedPeer is a connected peer with the ID it is ranked by
+type rankedPeer struct {
+	peer  p2p.Peer
+	id    string
+	score uint64
+}
+
+// rankMembers returns the connected peers in rendezvous order for key
+func (s *FileServer) rankMembers(key string) []rankedPeer {
+	s.peerLock.Lock()
+	ids := make(map[string]string, len(s.memberPeers))
+	for id, addr := range s.memberPeers {
+		ids[addr] = id
+	}
+	peers := make([]rankedPeer, 0, len(s.peers))
+	for addr, peer := range s.peers {
+		id, ok := ids[addr]
+		if !ok {
+			id = addr
+		}
+		peers = append(peers, rankedPeer{peer: peer, id: id, score: placementScore(id, key)})
+	}
+	s.peerLock.Unlock()
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].score > peers[j].score })
+	return peers
+}
+
+// placementScore is the rendezvous hash weight of a member for a key
+func placementScore(id string, key string) uint64 {
+	sum := sha256.Sum256([]byte(id + "/" + key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,311 @@
+// Storage quotas and capacity-aware placement for GoVaultFS
+// A node can cap the bytes it stores in total and for each owner, the node a file is stored for. A full node
+// refuses a replica before any data is sent and points the sender at a member it knows has room. Nodes
+// advertise their capacity on gossip messages, and placement moves peers known to be full to the end of the line.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+)
+
+// QuotaOpts limit the bytes a node stores, 0 for no limit
+type QuotaOpts struct {
+	Capacity int64            // Bytes stored in total, for this node and its peers
+	PerOwner int64            // Bytes stored for each owner node, including this one
+	Owners   map[string]int64 // Limits of specific owners by node ID, overriding PerOwner
+}
+
+// Capacity is the storage of a node as advertised to its peers
+type Capacity struct {
+	Limit int64 `json:"limit"` // Bytes the node may store, 0 if unlimited
+	Used  int64 `json:"used"`  // Bytes stored
+}
+
+// Free returns the bytes the node still has room for
+func (c Capacity) Free() int64 {
+	if c.Limit <= 0 {
+		return math.MaxInt64
+	}
+	return max(c.Limit-c.Used, 0)
+}
+
+// MessageStoreReply answers MessageStoreFile on its stream before any data is sent
+type MessageStoreReply struct {
+	From     string   // Member ID of the receiver
+	Refused  string   // Why the file was refused ("full", "quota", "denied" or "precondition"), empty if it was accepted
+	Redirect string   // Member ID of a node with room for the file, if the receiver knows one
+	Capacity Capacity // Capacity of the receiver
+}
+
+// Reasons for refusing a file
+const (
+	refusedFull   = "full"
+	refusedQuota  = "quota"
+	refusedDenied = "denied"       // The sender's token does not allow the write
+	refusedExists = "precondition" // The file of a create-only write is held here for another node
+)
+
+// Errors returned when a file does not fit
+var (
+	ErrNodeFull      = errors.New("node storage full")
+	ErrQuotaExceeded = errors.New("owner quota exceeded")
+)
+
+// Capacity returns the storage limit and usage of this node
+func (s *FileServer) Capacity() Capacity {
+	s.settingsLock.RLock()
+	limit := s.Quota.Capacity
+	s.settingsLock.RUnlock()
+
+	return Capacity{Limit: limit, Used: s.store.TotalUsage() - s.store.Usage(cacheID)} // Cached copies can be evicted
+}
+
+// ownerLimit returns the bytes this node stores for an owner, 0 for no limit
+func (s *FileServer) ownerLimit(owner string) int64 {
+	s.settingsLock.RLock()
+	defer s.settingsLock.RUnlock()
+
+	if limit, ok := s.Quota.Owners[owner]; ok {
+		return limit
+	}
+	return s.Quota.PerOwner
+}
+
+// room returns the bytes an owner may still add, taking writes in progress and staged parts into account, and the error for
+// going beyond them. limited is false if neither the node nor the owner has a limit. The caller holds quotaLock.
+func (s *FileServer) room(owner string) (room int64, limitErr error, limited bool) {
+	c := s.Capacity()
+	var reserved int64
+	for _, n := range s.reserved {
+		reserved += n
+	}
+
+	room, limitErr = c.Free()-reserved, ErrNodeFull
+	if limit := s.ownerLimit(owner); limit > 0 {
+		if left := limit - s.store.Usage(owner) - s.reserved[owner] - s.staged[owner]; left < room {
+			room, limitErr = left, ErrQuotaExceeded
+		}
+		limited = true
+	}
+	if t, ok := s.tenants[ownerTenant(owner)]; ok && t.Quota > 0 {
+		if left := t.Quota - s.tenantUsage(t.Name); left < room {
+			room, limitErr = left, ErrQuotaExceeded
+		}
+		limited = true
+	}
+	return max(room, 0), limitErr, limited || c.Limit > 0
+}
+
+// reserve holds room for a file of size bytes that replaces key of owner, until release is called.
+// It fails with ErrNodeFull or ErrQuotaExceeded if the file does not fit.
+func (s *FileServer) reserve(owner string, key string, size int64) (release func(), err error) {
+	need := size - s.store.Size(owner, key)
+	if need <= 0 {
+		return func() {}, nil
+	}
+
+	s.quotaLock.Lock()
+	defer s.quotaLock.Unlock()
+
+	if room, limitErr, _ := s.room(owner); need > room {
+		s.metrics.refused.Inc(refusal(limitErr))
+		return nil, fmt.Errorf("%w: %d bytes for (%s)", limitErr, size, key)
+	}
+	s.reserved[owner] += need
+
+	return func() {
+		s.quotaLock.Lock()
+		defer s.quotaLock.Unlock()
+		s.reserved[owner] -= need
+		if s.reserved[owner] <= 0 {
+			delete(s.reserved, owner)
+		}
+	}, nil
+}
+
+// quotaReader returns r bounded by the room an owner has left, for writes whose size is not known up front.
+// Reading past the room fails with ErrNodeFull or ErrQuotaExceeded.
+func (s *FileServer) quotaReader(owner string, key string, r io.Reader) (io.Reader, error) {
+	s.quotaLock.Lock()
+	room, limitErr, limited := s.room(owner)
+	s.quotaLock.Unlock()
+
+	if !limited {
+		return r, nil
+	}
+	room += s.store.Size(owner, key) // The file being replaced makes room
+	if room <= 0 {
+		s.metrics.refused.Inc(refusal(limitErr))
+		return nil, fmt.Errorf("%w: (%s)", limitErr, key)
+	}
+	return &quotaReader{r: r, left: room, err: limitErr, server: s, key: key}, nil
+}
+
+// quotaReader fails once more bytes were read than there is room for
+type quotaReader struct {
+	r      io.Reader
+	left   int64 // Bytes still allowed
+	err    error // Error once the room is used up
+	server *FileServer
+	key    string
+}
+
+// Read reads from the underlying reader and fails once the room is used up
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.left -= int64(n)
+	if q.left < 0 {
+		q.server.metrics.refused.Inc(refusal(q.err))
+		return n, fmt.Errorf("%w: (%s)", q.err, q.key)
+	}
+	return n, err
+}
+
+// refusal returns the reason sent to peers, and used as metric label, for a quota error
+func refusal(err error) string {
+	if errors.Is(err, ErrQuotaExceeded) {
+		return refusedQuota
+	}
+	if errors.Is(err, ErrPreconditionFailed) {
+		return refusedExists
+	}
+	return refusedFull
+}
+
+// refusalError returns the error for a reason a peer refused a file
+func refusalError(reason string) error {
+	switch reason {
+	case refusedQuota:
+		return ErrQuotaExceeded
+	case refusedDenied:
+		return ErrAccessDenied
+	case refusedExists:
+		return ErrPreconditionFailed
+	}
+	return ErrNodeFull
+}
+
+// noteCapacity records the capacity a member advertised
+func (s *FileServer) noteCapacity(id string, c Capacity) {
+	s.quotaLock.Lock()
+	defer s.quotaLock.Unlock()
+	s.capacities[id] = c
+}
+
+// Capacities returns the capacity last advertised by each member, by member ID
+func (s *FileServer) Capacities() map[string]Capacity {
+	s.quotaLock.Lock()
+	defer s.quotaLock.Unlock()
+	return maps.Clone(s.capacities)
+}
+
+// hasRoom reports whether a member may have room for size bytes. Members that never advertised a capacity may.
+func (s *FileServer) hasRoom(id string, size int64) bool {
+	s.quotaLock.Lock()
+	defer s.quotaLock.Unlock()
+
+	c, ok := s.capacities[id]
+	return !ok || c.Free() >= size
+}
+
+// redirectFor picks a member other than the owner that advertised room for size bytes, or returns ""
+func (s *FileServer) redirectFor(owner string, size int64) string {
+	s.quotaLock.Lock()
+	defer s.quotaLock.Unlock()
+
+	best, free := "", int64(0)
+	for id, c := range s.capacities {
+		if id == owner || id == s.ID || c.Free() < size {
+			continue
+		}
+		if f := c.Free(); len(best) == 0 || f > free || (f == free && id < best) {
+			best, free = id, f
+		}
+	}
+	return best
+}
+
+// admitStore checks the condition of a file a peer is about to send, reserves room for it and answers on its stream
+// whether it may.
+// The file counts against the owner directory it is stored under, so a tenant's replicas count against its quota.
+// The returned release must be called once the file is written, unless an error is returned.
+func (s *FileServer) admitStore(owner string, msg MessageStoreFile, stream io.Writer) (func(), error) {
+	var release func()
+	err := s.checkCreate(owner, msg)
+	if err == nil {
+		release, err = s.reserve(owner, msg.Key, msg.Size)
+	}
+
+	reply := MessageStoreReply{From: s.ID, Capacity: s.Capacity()}
+	if err != nil {
+		reply.Refused = refusal(err)
+	}
+	if errors.Is(err, ErrNodeFull) || errors.Is(err, ErrQuotaExceeded) {
+		reply.Redirect = s.redirectFor(msg.ID, msg.Size)
+	}
+	frame, ferr := encodeMessage(&Message{Payload: reply})
+	if ferr == nil {
+		_, ferr = stream.Write(frame)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if ferr != nil {
+		release()
+		return nil, ferr
+	}
+	return release, nil
+}
+
+// openStoreStream opens a stream that sends a file to a peer and waits for the peer to accept it.
+// A refusal is returned as ErrNodeFull, ErrQuotaExceeded, ErrAccessDenied or ErrPreconditionFailed, along with the reply that may name a member to try instead.
+func (s *FileServer) openStoreStream(ctx context.Context, peer p2p.Peer, msg *Message) (net.Conn, MessageStoreReply, error) {
+	st, err := s.openStream(ctx, peer, msg)
+	if err != nil {
+		return nil, MessageStoreReply{}, err
+	}
+
+	reply, err := readStoreReply(st)
+	if err != nil {
+		st.Close()
+		return nil, reply, err
+	}
+	s.noteCapacity(reply.From, reply.Capacity)
+	if len(reply.Refused) > 0 {
+		st.Close()
+		return nil, reply, fmt.Errorf("%w on peer %s", refusalError(reply.Refused), shortID(reply.From))
+	}
+	return st, reply, nil
+}
+
+// readStoreReply reads the answer to MessageStoreFile from its stream
+func readStoreReply(st io.Reader) (MessageStoreReply, error) {
+	var rpc p2p.RPC
+	if err := (p2p.DefaultDecoder{}).Decode(st, &rpc); err != nil {
+		return MessageStoreReply{}, err
+	}
+	var msg Message
+	if err := gob.NewDecoder(bytes.NewReader(rpc.Payload)).Decode(&msg); err != nil {
+		return MessageStoreReply{}, err
+	}
+	reply, ok := msg.Payload.(MessageStoreReply)
+	if !ok {
+		return MessageStoreReply{}, fmt.Errorf("unexpected reply %T to a store request", msg.Payload)
+	}
+	return reply, nil
+}
+
+func init() {
+	gob.Register(MessageStoreReply{})
+}
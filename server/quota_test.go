@@ -0,0 +1,79 @@
+// Tests for storage quotas and capacity-aware placement
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestQuota checks that a full node refuses replicas and points at a member with room,
+// that peers learn its capacity and place replicas elsewhere, and that local writes respect quotas
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	s3 := newTestServer(t, network, ":5000", nil, ":3000")
+	defer stopServers(s1, s2, s3)
+
+	startServers(t, s1, s2, s3)
+
+	st := s1.Settings()
+	st.Quota.Capacity = 1024
+	s1.Reload(st)
+	st = s2.Settings()
+	st.ReplicationFactor = 2
+	s2.Reload(st)
+
+	// Capacity spreads with gossip
+	waitFor(t, 5*time.Second, func() bool {
+		return s2.Capacities()[s1.ID].Limit == 1024 && s1.Capacities()[s3.ID] == s3.Capacity()
+	})
+
+	// The full node refuses the file before any data is sent and names the node with room
+	peer, ok := s2.memberPeer(s1.ID)
+	assert.True(t, ok)
+	msg := Message{Payload: MessageStoreFile{ID: s2.ID, Key: "refused", Size: 2000}}
+	_, reply, err := s2.openStoreStream(context.Background(), peer, &msg)
+	assert.ErrorIs(t, err, ErrNodeFull)
+	assert.Equal(t, s3.ID, reply.Redirect)
+	assert.False(t, s1.store.Has(s2.ID, "refused"))
+
+	// Placement skips the full node even where it ranks first for the key
+	key := ""
+	for i := 0; len(key) == 0; i++ {
+		k := fmt.Sprintf("file-%d", i)
+		if placementScore(s1.ID, crypto.HashKey(k)) > placementScore(s3.ID, crypto.HashKey(k)) {
+			key = k
+		}
+	}
+	assert.Equal(t, []p2p.Peer{peer}, s2.rankPeersFor(crypto.HashKey(key), 2000)[1:])
+	assert.Nil(t, s2.Store(key, bytes.NewReader(make([]byte, 2000))))
+	assert.True(t, s3.store.Has(s2.ID, crypto.HashKey(key)))
+	assert.False(t, s1.store.Has(s2.ID, crypto.HashKey(key)))
+
+	// Small files still fit
+	assert.Nil(t, s2.Store(key+".small", bytes.NewReader(make([]byte, 100))))
+
+	// Local writes fail once the owner's quota or the node's capacity is used up, without leaving a partial file
+	st = s3.Settings()
+	st.Quota.PerOwner = 1000
+	s3.Reload(st)
+	assert.ErrorIs(t, s3.Store("big.bin", bytes.NewReader(make([]byte, 2000))), ErrQuotaExceeded)
+	assert.False(t, s3.store.Has(s3.ID, "big.bin"))
+	assert.Nil(t, s3.Store("fits.bin", bytes.NewReader(make([]byte, 500))))
+
+	st.Quota = QuotaOpts{Capacity: s3.store.TotalUsage() + 100, Owners: map[string]int64{}}
+	s3.Reload(st)
+	assert.ErrorIs(t, s3.Store("full.bin", bytes.NewReader(make([]byte, 200))), ErrNodeFull)
+	assert.Equal(t, int64(100), s3.Capacity().Free())
+	assert.Equal(t, 507, statusFor(ErrNodeFull))
+}
@@ -0,0 +1,698 @@
+// S3-compatible API for GoVaultFS
+// This file serves the subset of the Amazon S3 REST API that common tools rely on, on top of a FileServer.
+// Buckets are namespaces: object key k in bucket b is stored under the key "b/k". Requests use path-style
+// addressing (http://host/bucket/key) and must be signed with SigV4 by one of the configured access keys.
+// Requests signed with the access key of a tenant only see the tenant's buckets, whose objects are the tenant's.
+// With access control on, requests also carry a capability token as their session token (X-Amz-Security-Token)
+// that allows the operation on "bucket/key", or on "bucket/prefix" for listings.
+//
+//	GET    /                         ListBuckets
+//	PUT    /{bucket}                 CreateBucket
+//	HEAD   /{bucket}                 HeadBucket
+//	DELETE /{bucket}                 DeleteBucket
+//	GET    /{bucket}?list-type=2     ListObjectsV2
+//	PUT    /{bucket}/{key}           PutObject, or UploadPart with ?partNumber=&uploadId=
+//	GET    /{bucket}/{key}           GetObject, with Range and ?versionId= support
+//	HEAD   /{bucket}/{key}           HeadObject, with ?versionId= support
+//	DELETE /{bucket}/{key}           DeleteObject, or AbortMultipartUpload with ?uploadId=
+//	POST   /{bucket}/{key}?uploads   CreateMultipartUpload
+//	POST   /{bucket}/{key}?uploadId= CompleteMultipartUpload
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the S3 API
+const (
+	defaultS3Region = "us-east-1"
+	maxS3Keys       = 1000
+	bucketsFileName = "buckets.json"
+)
+
+// S3Opts configures the S3-compatible API of a file server
+type S3Opts struct {
+	ListenAddr string            // Address to serve the API on
+	Region     string            // Region clients sign requests for (default us-east-1)
+	AccessKeys map[string]string // Secret key by access key ID; with none, every request is rejected
+}
+
+// S3 errors, each maps to the S3 error code of the same name
+var (
+	errNoSuchBucket            = errors.New("NoSuchBucket")
+	errNoSuchKey               = errors.New("NoSuchKey")
+	errNoSuchVersion           = errors.New("NoSuchVersion")
+	errNoSuchUpload            = errors.New("NoSuchUpload")
+	errBucketAlreadyOwnedByYou = errors.New("BucketAlreadyOwnedByYou")
+	errBucketNotEmpty          = errors.New("BucketNotEmpty")
+	errInvalidBucketName       = errors.New("InvalidBucketName")
+	errInvalidArgument         = errors.New("InvalidArgument")
+	errInvalidPart             = errors.New("InvalidPart")
+	errInvalidRange            = errors.New("InvalidRange")
+	errMalformedXML            = errors.New("MalformedXML")
+	errNotImplemented          = errors.New("NotImplemented")
+)
+
+// s3Status maps S3 error codes to HTTP status codes
+var s3Status = map[error]int{
+	errAccessDenied:            http.StatusForbidden,
+	errInvalidAccessKeyID:      http.StatusForbidden,
+	errSignatureDoesNotMatch:   http.StatusForbidden,
+	errRequestTimeTooSkewed:    http.StatusForbidden,
+	errBadDigest:               http.StatusBadRequest,
+	errNoSuchBucket:            http.StatusNotFound,
+	errNoSuchKey:               http.StatusNotFound,
+	errNoSuchVersion:           http.StatusNotFound,
+	errNoSuchUpload:            http.StatusNotFound,
+	errBucketAlreadyOwnedByYou: http.StatusConflict,
+	errBucketNotEmpty:          http.StatusConflict,
+	errInvalidBucketName:       http.StatusBadRequest,
+	errInvalidArgument:         http.StatusBadRequest,
+	errInvalidPart:             http.StatusBadRequest,
+	errInvalidRange:            http.StatusRequestedRangeNotSatisfiable,
+	errMalformedXML:            http.StatusBadRequest,
+	errNotImplemented:          http.StatusNotImplemented,
+}
+
+// bucketNameRe matches valid bucket names
+var bucketNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// S3Server serves the S3-compatible API of a file server
+type S3Server struct {
+	S3Opts
+	server *FileServer
+
+	mu      sync.Mutex
+	buckets map[string]time.Time // Creation time by bucket name
+	uploads map[string]*s3Upload // Multipart uploads in progress by upload ID
+	now     func() time.Time     // Clock used to check request times
+}
+
+// NewS3Server creates the S3 API handler for a file server.
+// Buckets are kept in the server's storage root so they survive restarts.
+func NewS3Server(s *FileServer, opts S3Opts) *S3Server {
+	if len(opts.Region) == 0 {
+		opts.Region = defaultS3Region
+	}
+
+	srv := &S3Server{
+		S3Opts:  opts,
+		server:  s,
+		buckets: make(map[string]time.Time),
+		uploads: make(map[string]*s3Upload),
+		now:     time.Now,
+	}
+
+	if b, err := os.ReadFile(srv.bucketsPath()); err == nil {
+		if err := json.Unmarshal(b, &srv.buckets); err != nil {
+			s.logger.Warn("S3 bucket list unreadable", "err", err)
+		}
+	}
+
+	return srv
+}
+
+// accessKeys returns the access keys the API accepts
+func (s3 *S3Server) accessKeys() map[string]string {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	return s3.AccessKeys
+}
+
+// setAccessKeys replaces the access keys the API accepts
+func (s3 *S3Server) setAccessKeys(keys map[string]string) {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	s3.AccessKeys = maps.Clone(keys)
+}
+
+// ServeHTTP authenticates a request and dispatches it to the S3 operation it names (http.Handler interface).
+func (s3 *S3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	keys, tenant := s3.credentials(r)
+	if err := verifySigV4(r, s3.Region, keys, s3.now()); err != nil {
+		s3.writeError(w, r, err)
+		return
+	}
+	if err := validRequest(r); err != nil {
+		s3.writeError(w, r, fmt.Errorf("%w: %w", errInvalidArgument, err))
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(bucket) > 0 {
+		bucket = tenantKey(tenant, bucket)
+	}
+	q := r.URL.Query()
+	if err := s3.authorize(r, tenant, bucket, key); err != nil {
+		s3.writeError(w, r, err)
+		return
+	}
+
+	var err error
+	switch {
+	case len(bucket) == 0 && r.Method == http.MethodGet:
+		err = s3.listBuckets(w, tenant)
+	case len(bucket) == 0:
+		err = errNotImplemented
+	case len(key) == 0:
+		err = s3.serveBucket(w, r, bucket)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		err = s3.uploadPart(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		err = s3.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		err = s3.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		err = s3.abortUpload(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		err = s3.deleteObject(w, r, bucket, key)
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		err = s3.createUpload(w, bucket, key)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		err = s3.completeUpload(w, r, bucket, key)
+	default:
+		err = errNotImplemented
+	}
+
+	if err != nil {
+		s3.writeError(w, r, err)
+	}
+}
+
+// s3Ops are the operations each method needs
+var s3Ops = map[string]Op{
+	http.MethodGet:    OpRead,
+	http.MethodHead:   OpRead,
+	http.MethodPut:    OpWrite,
+	http.MethodPost:   OpWrite,
+	http.MethodDelete: OpDelete,
+}
+
+// authorize checks the session token of a request for the operation its method needs on the object it names,
+// on the listed prefix of a bucket, or on every key of the tenant for ListBuckets
+func (s3 *S3Server) authorize(r *http.Request, tenant string, bucket string, key string) error {
+	if !s3.server.AuthEnabled() {
+		return nil
+	}
+
+	target := tenantKey(tenant, "")
+	switch {
+	case len(bucket) > 0 && len(key) == 0 && r.Method == http.MethodGet:
+		target = bucket + "/" + r.URL.Query().Get("prefix")
+	case len(bucket) > 0:
+		target = bucket + "/" + key
+	}
+
+	token := r.Header.Get("X-Amz-Security-Token")
+	if len(token) == 0 {
+		token = r.URL.Query().Get("X-Amz-Security-Token")
+	}
+	_, err := s3.server.Authorize(token, s3Ops[r.Method], target)
+	return err
+}
+
+// credentials returns the access keys a request may be signed with and the tenant it acts for:
+// the node's keys, or the key of the tenant the request names if it is not one of them
+func (s3 *S3Server) credentials(r *http.Request) (map[string]string, string) {
+	keys := s3.accessKeys()
+	auth, err := parseSigV4(r.Header.Get("Authorization"))
+	if err != nil {
+		return keys, ""
+	}
+	if _, ok := keys[auth.accessKey]; ok {
+		return keys, ""
+	}
+	if tenant, secret, ok := s3.server.tenantAccessKey(auth.accessKey); ok {
+		return map[string]string{auth.accessKey: secret}, tenant
+	}
+	return keys, ""
+}
+
+// serveBucket handles the operations on a bucket itself
+func (s3 *S3Server) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	switch r.Method {
+	case http.MethodPut:
+		return s3.createBucket(w, bucket)
+	case http.MethodHead:
+		if !s3.hasBucket(bucket) {
+			return errNoSuchBucket
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodDelete:
+		return s3.deleteBucket(w, bucket)
+	case http.MethodGet:
+		if r.URL.Query().Get("list-type") != "2" {
+			return fmt.Errorf("%w: only ListObjectsV2 (list-type=2) is supported", errNotImplemented)
+		}
+		return s3.listObjects(w, r, bucket)
+	}
+	return errNotImplemented
+}
+
+// listAllMyBucketsResult is the ListBuckets response
+type listAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+// s3Owner is the owner of every bucket: the node itself
+type s3Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+// s3Bucket is a bucket in ListBuckets
+type s3Bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+// listBuckets lists every bucket of a tenant, or of the node for ""
+func (s3 *S3Server) listBuckets(w http.ResponseWriter, tenant string) error {
+	s3.mu.Lock()
+	result := listAllMyBucketsResult{Owner: s3Owner{ID: s3.server.ID, DisplayName: s3.server.Transport.Addr()}}
+	if len(tenant) > 0 {
+		result.Owner = s3Owner{ID: ownerID(s3.server.ID, tenant), DisplayName: tenant}
+	}
+	for name, created := range s3.buckets {
+		if tenantOf(name) != tenant {
+			continue
+		}
+		result.Buckets = append(result.Buckets, s3Bucket{Name: untenant(name), CreationDate: created.Format(time.RFC3339)})
+	}
+	s3.mu.Unlock()
+
+	sort.Slice(result.Buckets, func(i, j int) bool { return result.Buckets[i].Name < result.Buckets[j].Name })
+	return writeXML(w, http.StatusOK, result)
+}
+
+// createBucket creates an empty bucket
+func (s3 *S3Server) createBucket(w http.ResponseWriter, bucket string) error {
+	if !bucketNameRe.MatchString(untenant(bucket)) {
+		return errInvalidBucketName
+	}
+
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+
+	if _, ok := s3.buckets[bucket]; ok {
+		return errBucketAlreadyOwnedByYou
+	}
+	s3.buckets[bucket] = time.Now().UTC()
+	if err := s3.saveBuckets(); err != nil {
+		delete(s3.buckets, bucket)
+		return err
+	}
+
+	w.Header().Set("Location", "/"+untenant(bucket))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// deleteBucket deletes a bucket if it holds no objects
+func (s3 *S3Server) deleteBucket(w http.ResponseWriter, bucket string) error {
+	if !s3.hasBucket(bucket) {
+		return errNoSuchBucket
+	}
+	if len(s3.server.List(bucket+"/", "", 1)) > 0 {
+		return errBucketNotEmpty
+	}
+
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+
+	delete(s3.buckets, bucket)
+	if err := s3.saveBuckets(); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// hasBucket reports whether a bucket exists
+func (s3 *S3Server) hasBucket(bucket string) bool {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	_, ok := s3.buckets[bucket]
+	return ok
+}
+
+// bucketsPath is where the bucket list is kept
+func (s3 *S3Server) bucketsPath() string {
+	return filepath.Join(s3.server.store.Root, bucketsFileName)
+}
+
+// saveBuckets writes the bucket list to disk. Caller holds s3.mu.
+func (s3 *S3Server) saveBuckets() error {
+	b, err := json.Marshal(s3.buckets)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s3.bucketsPath(), b)
+}
+
+// listBucketResult is the ListObjectsV2 response
+type listBucketResult struct {
+	XMLName               xml.Name         `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int              `xml:"KeyCount"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	Contents              []s3Object       `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// s3Object is an object in ListObjectsV2
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// s3CommonPrefix groups keys that share a prefix up to the delimiter
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listObjects lists the objects of a bucket in key order.
+// Keys sharing a prefix up to the delimiter are rolled up into a single common prefix.
+// The continuation token is the last key or common prefix of the previous page.
+func (s3 *S3Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	if !s3.hasBucket(bucket) {
+		return errNoSuchBucket
+	}
+
+	q := r.URL.Query()
+	result := listBucketResult{
+		Name:              untenant(bucket),
+		Prefix:            q.Get("prefix"),
+		Delimiter:         q.Get("delimiter"),
+		StartAfter:        q.Get("start-after"),
+		ContinuationToken: q.Get("continuation-token"),
+		MaxKeys:           maxS3Keys,
+	}
+	if v := q.Get("max-keys"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%w: invalid max-keys", errInvalidArgument)
+		}
+		result.MaxKeys = min(n, maxS3Keys)
+	}
+
+	after := result.StartAfter
+	if len(result.ContinuationToken) > 0 {
+		b, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			return fmt.Errorf("%w: invalid continuation token", errInvalidArgument)
+		}
+		after = string(b)
+	}
+
+	// Read the index a page at a time, skipping past every key rolled up into a common prefix
+	ns := bucket + "/"
+	last := ""
+	cursor := ns + after
+pages:
+	for {
+		page := s3.server.List(ns+result.Prefix, cursor, maxS3Keys+1)
+		for _, info := range page {
+			cursor = max(cursor, info.Key)
+			key := strings.TrimPrefix(info.Key, ns)
+
+			// Roll keys with the delimiter after the prefix up into their common prefix
+			if len(result.Delimiter) > 0 {
+				if i := strings.Index(key[len(result.Prefix):], result.Delimiter); i >= 0 {
+					prefix := key[:len(result.Prefix)+i+len(result.Delimiter)]
+					if prefix == last || (len(after) > 0 && strings.HasPrefix(after, prefix)) {
+						cursor = max(cursor, ns+prefix+"\xff")
+						continue
+					}
+					if result.KeyCount == result.MaxKeys {
+						result.IsTruncated = true
+						break pages
+					}
+					result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: prefix})
+					result.KeyCount++
+					last = prefix
+					cursor = ns + prefix + "\xff" // Skip the rest of the common prefix
+					continue
+				}
+			}
+
+			if result.KeyCount == result.MaxKeys {
+				result.IsTruncated = true
+				break pages
+			}
+			result.Contents = append(result.Contents, s3Object{
+				Key:          key,
+				LastModified: info.ModTime.UTC().Format(time.RFC3339),
+				ETag:         `"` + info.ETag + `"`,
+				Size:         info.Size,
+				StorageClass: "STANDARD",
+			})
+			result.KeyCount++
+			last = key
+		}
+		if len(page) <= maxS3Keys {
+			break
+		}
+	}
+
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+
+	return writeXML(w, http.StatusOK, result)
+}
+
+// putObject stores the request body as an object
+func (s3 *S3Server) putObject(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	if !s3.hasBucket(bucket) {
+		return errNoSuchBucket
+	}
+	if len(r.Header.Get("X-Amz-Copy-Source")) > 0 {
+		return fmt.Errorf("%w: CopyObject", errNotImplemented)
+	}
+
+	cond, err := headerPrecondition(r.Header)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errNotImplemented, err)
+	}
+	if err := s3.server.StoreIfContext(r.Context(), bucket+"/"+key, r.Body, cond); err != nil {
+		return err
+	}
+
+	info, err := s3.server.Stat(bucket + "/" + key)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", `"`+info.ETag+`"`)
+	w.Header().Set("x-amz-version-id", info.Version)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// getObject serves an object or, for HEAD, its headers. A single byte range may be requested,
+// and a versionId selects an older version.
+func (s3 *S3Server) getObject(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	if !s3.hasBucket(bucket) {
+		return errNoSuchBucket
+	}
+
+	info, err := s3.server.Stat(bucket + "/" + key)
+	if err != nil {
+		return errNoSuchKey
+	}
+	if version := r.URL.Query().Get("versionId"); len(version) > 0 {
+		if info, err = s3.server.StatVersion(bucket+"/"+key, version); err != nil {
+			return errNoSuchVersion
+		}
+	}
+
+	start, end := int64(0), info.Size-1
+	partial := false
+	if v := r.Header.Get("Range"); len(v) > 0 {
+		start, end, err = parseRange(v, info.Size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			return err
+		}
+		partial = true
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", `"`+info.ETag+`"`)
+	w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("x-amz-version-id", info.Version)
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	status := http.StatusOK
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, info.Size))
+		status = http.StatusPartialContent
+	}
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return nil
+	}
+
+	rd, err := s3.server.GetVersionContext(r.Context(), bucket+"/"+key, info.Version)
+	if err != nil {
+		return err
+	}
+	if rc, ok := rd.(io.Closer); ok {
+		defer rc.Close()
+	}
+
+	// Skip to the start of the range, seeking when the object is on local disk
+	if seeker, ok := rd.(io.Seeker); ok {
+		_, err = seeker.Seek(start, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, rd, start)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(status)
+	if _, err := io.CopyN(w, rd, end-start+1); err != nil {
+		s3.server.loggerFrom(r.Context()).Warn("sending object failed", "key", bucket+"/"+key, "err", err)
+	}
+	return nil
+}
+
+// parseRange parses a single byte range ("bytes=a-b", "bytes=a-" or "bytes=-n") against an object size
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("%w: only a single byte range is supported", errInvalidRange)
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, errInvalidRange
+	}
+
+	if len(first) == 0 {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, errInvalidRange
+		}
+		return max(size-n, 0), size - 1, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, errInvalidRange
+	}
+	end := size - 1
+	if len(last) > 0 {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errInvalidRange
+		}
+		end = min(end, size-1)
+	}
+	return start, end, nil
+}
+
+// deleteObject deletes an object. Like S3, deleting a missing key succeeds.
+func (s3 *S3Server) deleteObject(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	if !s3.hasBucket(bucket) {
+		return errNoSuchBucket
+	}
+
+	if err := s3.server.DeleteContext(r.Context(), bucket+"/"+key); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// s3Error is the XML body of an error response
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// writeError writes an S3 error response. Errors from the file server are mapped to the closest S3 code.
+func (s3 *S3Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code, status := "InternalError", http.StatusInternalServerError
+	for e, st := range s3Status {
+		if errors.Is(err, e) {
+			code, status = e.Error(), st
+			break
+		}
+	}
+	switch {
+	case code != "InternalError":
+	case errors.Is(err, ErrNotFound):
+		code, status = errNoSuchKey.Error(), http.StatusNotFound
+	case errors.Is(err, ErrInsufficientReplicas), errors.Is(err, ErrTooFewShards):
+		code, status = "ServiceUnavailable", http.StatusServiceUnavailable
+	case errors.Is(err, ErrQuotaExceeded):
+		code, status = "QuotaExceeded", http.StatusForbidden
+	case errors.Is(err, ErrNodeFull):
+		code, status = "InsufficientStorage", http.StatusInsufficientStorage
+	case errors.Is(err, ErrConflict):
+		code, status = "Conflict", http.StatusConflict
+	case errors.Is(err, ErrPreconditionFailed):
+		code, status = "PreconditionFailed", http.StatusPreconditionFailed
+	case errors.Is(err, ErrInvalidToken):
+		code, status = "InvalidToken", http.StatusBadRequest
+	case errors.Is(err, ErrAccessDenied):
+		code, status = errAccessDenied.Error(), http.StatusForbidden
+	}
+
+	if status == http.StatusInternalServerError {
+		s3.server.loggerFrom(r.Context()).Error("S3 request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+	}
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, s3Error{
+		Code:      code,
+		Message:   untenant(err.Error()),
+		Resource:  r.URL.Path,
+		RequestID: fmt.Sprintf("%x", time.Now().UnixNano()),
+	})
+}
+
+// writeXML writes v as an XML response
+func writeXML(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	return xml.NewEncoder(w).Encode(v)
+}
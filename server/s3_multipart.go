@@ -0,0 +1,299 @@
+// Multipart uploads for the GoVaultFS S3 API
+// Parts are staged in the store under multipartID, encrypted like the object will be and counted against the quota
+// of its owner, until the upload is completed. They are then streamed in order through FileServer.Store as a single
+// object. Uploads in progress are kept in memory, so a restart abandons them and the next start deletes their parts.
+package server
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/store"
+)
+
+// multipartID is the store directory of staged parts. Node IDs may not take its name.
+const multipartID = "multipart"
+
+// maxPartNumber is the highest part number of a multipart upload
+const maxPartNumber = 10000
+
+// s3Upload is a multipart upload in progress
+type s3Upload struct {
+	bucket string
+	key    string
+	owner  string         // Owner the staged parts count against
+	parts  map[int]string // ETag by part number
+}
+
+// initiateMultipartUploadResult is the CreateMultipartUpload response
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+// completeMultipartUpload is the CompleteMultipartUpload request body
+type completeMultipartUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+// completeMultipartUploadResult is the CompleteMultipartUpload response
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+// createUpload starts a multipart upload
+func (s3 *S3Server) createUpload(w http.ResponseWriter, bucket string, key string) error {
+	if !s3.hasBucket(bucket) {
+		return errNoSuchBucket
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(buf)
+
+	upload := &s3Upload{
+		bucket: bucket,
+		key:    key,
+		owner:  s3.server.owner(bucket + "/" + key),
+		parts:  make(map[int]string),
+	}
+
+	s3.mu.Lock()
+	s3.uploads[id] = upload
+	s3.mu.Unlock()
+
+	return writeXML(w, http.StatusOK, initiateMultipartUploadResult{Bucket: untenant(bucket), Key: key, UploadID: id})
+}
+
+// upload looks up a multipart upload of an object
+func (s3 *S3Server) upload(r *http.Request, bucket string, key string) (string, *s3Upload, error) {
+	id := r.URL.Query().Get("uploadId")
+
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+
+	upload, ok := s3.uploads[id]
+	if !ok || upload.bucket != bucket || upload.key != key {
+		return id, nil, errNoSuchUpload
+	}
+	return id, upload, nil
+}
+
+// uploadPart stages one part of a multipart upload. Uploading a part number again replaces it.
+func (s3 *S3Server) uploadPart(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	id, upload, err := s3.upload(r, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 || n > maxPartNumber {
+		return fmt.Errorf("%w: part number must be between 1 and %d", errInvalidArgument, maxPartNumber)
+	}
+
+	hash := md5.New()
+	if err := s3.server.stagePart(r.Context(), upload.owner, bucket+"/"+key, partKey(id, n), io.TeeReader(r.Body, hash)); err != nil {
+		return err
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+
+	s3.mu.Lock()
+	upload.parts[n] = etag
+	s3.mu.Unlock()
+
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// completeUpload joins the listed parts into the object and ends the upload
+func (s3 *S3Server) completeUpload(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	id, upload, err := s3.upload(r, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		return errMalformedXML
+	}
+
+	// Parts must be listed in ascending order and match the uploaded ETags
+	s3.mu.Lock()
+	for i, part := range req.Parts {
+		etag, ok := upload.parts[part.PartNumber]
+		if !ok || etag != trimETag(part.ETag) || (i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber) {
+			s3.mu.Unlock()
+			return fmt.Errorf("%w: part %d", errInvalidPart, part.PartNumber)
+		}
+	}
+	s3.mu.Unlock()
+
+	files := []io.Reader{}
+	for _, part := range req.Parts {
+		f, err := s3.server.readPart(r.Context(), bucket+"/"+key, partKey(id, part.PartNumber))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	// The parts stop counting against the owner while the object they make up takes their place
+	var size int64
+	for _, part := range req.Parts {
+		size += s3.server.store.Size(multipartID, partKey(id, part.PartNumber))
+	}
+	s3.server.addStaged(upload.owner, -size)
+	err = s3.server.StoreContext(r.Context(), bucket+"/"+key, io.MultiReader(files...))
+	s3.server.addStaged(upload.owner, size)
+	if err != nil {
+		return err
+	}
+	s3.removeUpload(r.Context(), id, upload)
+
+	info, err := s3.server.Stat(bucket + "/" + key)
+	if err != nil {
+		return err
+	}
+	return writeXML(w, http.StatusOK, completeMultipartUploadResult{Bucket: untenant(bucket), Key: key, ETag: `"` + info.ETag + `"`})
+}
+
+// abortUpload discards a multipart upload and its parts
+func (s3 *S3Server) abortUpload(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	id, upload, err := s3.upload(r, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	s3.removeUpload(r.Context(), id, upload)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// removeUpload forgets an upload and deletes its staged parts
+func (s3 *S3Server) removeUpload(ctx context.Context, id string, upload *s3Upload) {
+	s3.mu.Lock()
+	delete(s3.uploads, id)
+	parts := []int{}
+	for n := range upload.parts {
+		parts = append(parts, n)
+	}
+	s3.mu.Unlock()
+
+	for _, n := range parts {
+		if err := s3.server.unstagePart(upload.owner, partKey(id, n)); err != nil {
+			s3.server.loggerFrom(ctx).Warn("removing part of upload failed", "upload", id, "part", n, "err", err)
+		}
+	}
+}
+
+// partKey returns the store key of a part of an upload
+func partKey(id string, n int) string {
+	return id + "/" + strconv.Itoa(n)
+}
+
+// stagePart stores a part of an upload of key, encrypted with the key's encryption key. It counts against the room
+// owner has left until it is unstaged.
+func (s *FileServer) stagePart(ctx context.Context, owner string, key string, part string, r io.Reader) error {
+	r, err := s.quotaReader(owner, part, r)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := crypto.CopyEncrypt(s.encKey(key), r, pw)
+		pw.CloseWithError(err)
+	}()
+
+	old := s.store.Size(multipartID, part)
+	_, err = s.writeLocal(ctx, multipartID, part, pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		s.deleteLocal(multipartID, part) // Don't keep a partial part
+	}
+
+	s.addStaged(owner, s.store.Size(multipartID, part)-old)
+	return err
+}
+
+// readPart returns the content of a staged part of an upload of key
+func (s *FileServer) readPart(ctx context.Context, key string, part string) (io.ReadCloser, error) {
+	_, r, err := s.readLocal(ctx, multipartID, part)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := crypto.CopyDecrypt(s.encKey(key), r, pw)
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// unstagePart deletes a staged part and gives its room back to owner
+func (s *FileServer) unstagePart(owner string, part string) error {
+	size := s.store.Size(multipartID, part)
+	if err := s.store.Delete(multipartID, part); err != nil {
+		return err
+	}
+
+	s.addStaged(owner, -size)
+	return nil
+}
+
+// addStaged changes the bytes of parts staged for owner by delta
+func (s *FileServer) addStaged(owner string, delta int64) {
+	s.quotaLock.Lock()
+	defer s.quotaLock.Unlock()
+	s.staged[owner] += delta
+	if s.staged[owner] <= 0 {
+		delete(s.staged, owner)
+	}
+}
+
+// sweepUploads deletes the parts a previous run staged, since uploads do not outlive the process, and creates
+// the directory parts are staged in so that only this user can enter it
+func sweepUploads(st *store.Store) error {
+	if err := st.ClearID(multipartID); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(st.Root, os.ModePerm); err != nil {
+		return err
+	}
+	return os.Mkdir(filepath.Join(st.Root, multipartID), 0o700)
+}
+
+// trimETag strips the quotes around an ETag
+func trimETag(etag string) string {
+	if len(etag) >= 2 && etag[0] == '"' && etag[len(etag)-1] == '"' {
+		return etag[1 : len(etag)-1]
+	}
+	return etag
+}
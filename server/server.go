@@ -0,0 +1,1205 @@
+// Package server implements a GoVaultFS node and the APIs it serves.
+// This file defines the distributed file server node, its network protocol, and file operations.
+// Each node can store, retrieve, and replicate files across a peer-to-peer network.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/metrics"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/AnshSinghSonkhia/GoVaultFS/ratelimit"
+	"github.com/AnshSinghSonkhia/GoVaultFS/store"
+	"github.com/AnshSinghSonkhia/GoVaultFS/trace"
+	"google.golang.org/grpc"
+)
+
+// FileServerOpts holds configuration for a file server node
+type FileServerOpts struct {
+	ID                string                   // Unique node identifier
+	EncKey            []byte                   // AES encryption key
+	StorageRoot       string                   // Local storage directory
+	PathTransformFunc store.PathTransformFunc  // Hash-to-path converter
+	Transport         p2p.Transport            // Network transport layer
+	BootstrapNodes    []string                 // List of bootstrap peer addresses
+	ReplicationFactor int                      // Copies of each file including the local one, 0 replicates to every peer
+	Policy            StoragePolicy            // Default storage policy, full replication unless erasure coding is set
+	NamespacePolicies map[string]StoragePolicy // Storage policy per key prefix, the longest matching prefix wins
+	Gossip            GossipOpts               // Membership protocol settings
+	Discovery         *p2p.DiscoveryOpts       // Local network discovery, nil disables it
+	HTTPAddr          string                   // Address of the HTTP gateway, empty disables it
+	S3                *S3Opts                  // S3-compatible API, nil disables it
+	WebDAVAddr        string                   // Address of the WebDAV server, empty disables it
+	GRPCAddr          string                   // Address of the gRPC API, empty disables it
+	AdminSocket       string                   // Path of the unix socket for the admin API, empty disables it
+	MetricsAddr       string                   // Address serving only /metrics, empty disables it
+	Logger            *slog.Logger             // Logger for node events, slog.Default() if nil
+	Tracer            *trace.Tracer            // Tracer for file operations and the peer requests they make, nil disables tracing
+	Bandwidth         BandwidthOpts            // Bandwidth budgets of peer transfers and disk I/O
+	Quota             QuotaOpts                // Storage limits of the node and of each owner
+	Cache             CacheOpts                // Cache of files fetched from peers
+	Versions          VersionOpts              // Retention of older object versions
+	Resolver          ConflictResolver         // Decides between concurrent writes of a key, LastWriterWins if nil
+	Tenants           []Tenant                 // Namespaces isolated from the node's own objects and from each other
+	Auth              *AuthOpts                // Access control with capability tokens, nil lets every client and peer in
+}
+
+// FileServer represents a node in the distributed file system
+type FileServer struct {
+	FileServerOpts
+
+	peerLock    sync.Mutex          // Protects concurrent access to peers, memberPeers and dialing
+	peers       map[string]p2p.Peer // Connected peer nodes
+	memberPeers map[string]string   // Member ID to peer address
+	dialing     map[string]bool     // Members currently being dialed by gossip
+
+	members  *Membership              // Cluster member list maintained by gossip
+	ackLock  sync.Mutex               // Protects seqNo, acks and indirect
+	seqNo    uint64                   // Last probe sequence number
+	acks     map[uint64]chan struct{} // Probes waiting for an ack
+	indirect map[uint64]indirectProbe // Probes run on behalf of other members
+
+	discovery *p2p.Discovery // Local network discovery, if enabled
+
+	settingsLock sync.RWMutex // Protects the options Reload can change
+
+	bandwidth [2]*ratelimit.Limiter // Budgets of peer transfers by traffic class
+
+	quotaLock  sync.Mutex          // Protects reserved, staged and capacities
+	reserved   map[string]int64    // Bytes of incoming files being written, by owner
+	staged     map[string]int64    // Bytes of multipart upload parts staged for an owner, by owner
+	capacities map[string]Capacity // Capacity last advertised by each member
+
+	store        *store.Store      // Local file storage
+	index        *objectIndex      // Original keys of the objects stored through this node
+	tenants      map[string]Tenant // Tenants by name
+	auth         *authority        // Issues and checks tokens, nil if access control is off
+	replicas     *replicaIndex     // Versions of the replicas held for peers
+	keyLocks     keyLocks          // Turns of the writes to each key
+	replicaLocks keyLocks          // Turns of the replicas peers send of each key
+	clock        hlc               // Hybrid logical clock timestamping writes
+	cache        *fetchCache       // Copies of files fetched from peers
+	ns           *Namespace        // Directory tree over the indexed keys
+	events       eventBus          // Subscribers to change events
+	registry     *metrics.Registry // Metrics served at /metrics
+	metrics      nodeMetrics       // Metrics updated on the data path
+	http         *http.Server      // HTTP gateway, if enabled
+	s3           *http.Server      // S3 API, if enabled
+	s3api        *S3Server         // Handler of the S3 API, which the server wraps in request logging
+	webdav       *http.Server      // WebDAV server, if enabled
+	grpc         *grpc.Server      // gRPC API, if enabled
+	admin        *http.Server      // Admin API on the unix socket, if enabled
+	metricsSrv   *http.Server      // Dedicated metrics listener, if enabled
+	started      time.Time         // When Start was called
+	logger       *slog.Logger      // Logger with the node ID attached
+	quitch       chan struct{}     // Channel to signal server shutdown
+	stopOnce     sync.Once         // Makes Stop safe to call more than once
+}
+
+// Errors returned by file server operations
+var (
+	ErrNotFound             = errors.New("file not found")
+	ErrInsufficientReplicas = errors.New("not enough replicas")
+)
+
+// NewFileServer creates a new file server node with the given options
+func NewFileServer(opts FileServerOpts) *FileServer {
+	// Generate a unique ID if not provided
+	if len(opts.ID) == 0 {
+		opts.ID = crypto.GenerateID()
+	}
+	opts.Gossip = opts.Gossip.withDefaults()
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("node", shortID(opts.ID))
+
+	st := store.NewStore(store.StoreOpts{
+		Root:              opts.StorageRoot,
+		PathTransformFunc: opts.PathTransformFunc,
+		Logger:            logger,
+		ReadRate:          opts.Bandwidth.DiskRead,
+		WriteRate:         opts.Bandwidth.DiskWrite,
+	})
+	if err := sweepUploads(st); err != nil {
+		logger.Warn("removing abandoned multipart uploads failed", "err", err)
+	}
+	index, err := openIndex(filepath.Join(st.Root, indexFileName))
+	if err != nil {
+		logger.Warn("object index unreadable, starting empty", "err", err)
+	}
+	replicas, err := openReplicaIndex(filepath.Join(st.Root, replicasFileName))
+	if err != nil {
+		logger.Warn("replica versions unreadable, starting empty", "err", err)
+	}
+	cache, err := openCache(filepath.Join(st.Root, cacheFileName), opts.Cache, func(key string) bool { return st.Has(cacheID, key) })
+	if err != nil {
+		logger.Warn("cache index unreadable, starting empty", "err", err)
+	}
+
+	s := &FileServer{
+		FileServerOpts: opts,
+		store:          st,
+		index:          index,
+		replicas:       replicas,
+		cache:          cache,
+		quitch:         make(chan struct{}),
+		peers:          make(map[string]p2p.Peer),
+		memberPeers:    make(map[string]string),
+		dialing:        make(map[string]bool),
+		members:        NewMembership(Member{ID: opts.ID, Addr: opts.Transport.Addr()}),
+		acks:           make(map[uint64]chan struct{}),
+		indirect:       make(map[uint64]indirectProbe),
+		bandwidth:      newBandwidthLimiters(opts.Bandwidth),
+		reserved:       make(map[string]int64),
+		staged:         make(map[string]int64),
+		capacities:     make(map[string]Capacity),
+		logger:         logger,
+	}
+	s.tenants = make(map[string]Tenant)
+	for _, t := range opts.Tenants {
+		if !tenantNameRe.MatchString(t.Name) {
+			logger.Warn("ignoring tenant with invalid name", "tenant", t.Name)
+			continue
+		}
+		s.tenants[t.Name] = t
+	}
+	if opts.Auth != nil {
+		s.auth, err = newAuthority(*opts.Auth, st.Root)
+		if err != nil {
+			logger.Error("revocation list unreadable, starting empty", "err", err)
+		}
+	}
+	s.ns = newNamespace(s)
+	s.registry, s.metrics = newMetrics(s)
+
+	if len(opts.HTTPAddr) > 0 {
+		s.http = &http.Server{
+			Addr:              opts.HTTPAddr,
+			Handler:           NewGateway(s),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	if opts.S3 != nil {
+		s.s3api = NewS3Server(s, *opts.S3)
+		s.s3 = &http.Server{
+			Addr:              opts.S3.ListenAddr,
+			Handler:           s.s3api,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	if len(opts.WebDAVAddr) > 0 {
+		s.webdav = &http.Server{
+			Addr:              opts.WebDAVAddr,
+			Handler:           NewWebDAV(s),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	if len(opts.GRPCAddr) > 0 {
+		s.grpc = NewGRPCServer(s)
+	}
+	if len(opts.MetricsAddr) > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", s.registry.Handler())
+		s.metricsSrv = &http.Server{
+			Addr:              opts.MetricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	if len(opts.AdminSocket) > 0 {
+		admin := NewGateway(s)
+		admin.trusted = true
+		s.admin = &http.Server{
+			Handler:           admin,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+
+	return s
+}
+
+// encodeMessage gob-encodes a message and frames it for the wire
+func encodeMessage(msg *Message) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(msg); err != nil {
+		return nil, err
+	}
+	return p2p.FrameMessage(buf.Bytes()), nil
+}
+
+// broadcast sends a message to all connected peers
+func (s *FileServer) broadcast(msg *Message) error {
+	frame, err := encodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range s.peerList() {
+		if err := peer.Send(frame); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendToPeer sends a single message to the peer connected from addr
+func (s *FileServer) sendToPeer(addr string, msg *Message) error {
+	s.peerLock.Lock()
+	peer, ok := s.peers[addr]
+	s.peerLock.Unlock()
+	if !ok {
+		return fmt.Errorf("peer %s not in map", addr)
+	}
+
+	frame, err := encodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	return peer.Send(frame)
+}
+
+// peerList returns a snapshot of the connected peers
+func (s *FileServer) peerList() []p2p.Peer {
+	s.peerLock.Lock()
+	defer s.peerLock.Unlock()
+
+	peers := make([]p2p.Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// Message is a generic wrapper for network messages
+type Message struct {
+	Payload any    // Can be MessageStoreFile, MessageGetFile, MessageDeleteFile, MessageArchiveFile, MessageRevoke or one of the gossip messages
+	Trace   string // W3C traceparent of the span that sent the message, empty if it was not traced
+}
+
+// MessageStoreFile requests a peer to store a file
+type MessageStoreFile struct {
+	ID     string        // Node ID
+	Tenant string        // Tenant of the file, empty for the node's own files
+	Token  string        // Token of the node, which must allow OpWrite if access control is on
+	Key    string        // File hash
+	Size   int64         // File size
+	Clock  Timestamp     // Hybrid logical clock reading of the write, zero for shards
+	Vector VersionVector // Version vector of the latest version of an object, nil for shards and older versions
+	Create bool          // Create-only write, refused if the receiver holds the file for another node
+}
+
+// MessageGetFile requests a peer to send a file
+type MessageGetFile struct {
+	ID     string // Node ID
+	Tenant string // Tenant of the file, empty for the node's own files
+	Token  string // Token of the node, which must allow OpRead if access control is on
+	Key    string // File hash
+}
+
+// MessageDeleteFile asks peers to drop their replica or shards of a file
+type MessageDeleteFile struct {
+	ID     string // Node ID
+	Tenant string // Tenant of the file, empty for the node's own files
+	Token  string // Token of the node, which must allow OpDelete if access control is on
+	Key    string // File hash
+	Shards int    // Number of erasure-coded shards, 0 for a replicated file
+}
+
+// Get retrieves a file by key.
+// If the file is not found locally or in the cache, it asks each peer in turn on a dedicated stream, starting with
+// the peers the file was most likely placed on, and keeps the first copy it receives in the cache.
+func (s *FileServer) Get(key string) (io.Reader, error) {
+	return s.GetContext(context.Background(), key)
+}
+
+// GetContext is Get as part of the operation in ctx, whose span becomes the parent of the spans of the Get
+func (s *FileServer) GetContext(ctx context.Context, key string) (_ io.Reader, err error) {
+	ctx, span := s.startSpan(ctx, "FileServer.Get", trace.KindInternal, "key", key)
+	defer func() { endSpan(span, err) }()
+
+	if err := s.conflict(key); err != nil {
+		return nil, err
+	}
+	return s.get(ctx, span, key, s.policyFor(key))
+}
+
+// get retrieves the file stored under key for GetContext and GetVersionContext.
+// policy applies if there is no local manifest telling how the file was stored.
+func (s *FileServer) get(ctx context.Context, span *trace.Span, key string, policy StoragePolicy) (io.Reader, error) {
+	// Check if file exists locally
+	if s.store.Has(s.owner(key), key) {
+		s.logger.Debug("serving file from local disk", "key", key)
+		span.SetAttr("source", "local")
+		_, r, err := s.readLocal(ctx, s.owner(key), key)
+		if err != nil {
+			return nil, err
+		}
+		s.metrics.gets.Inc("hit")
+		return s.served(r), nil
+	}
+
+	// Copies fetched before are served from the cache
+	if r, ok := s.readCached(ctx, key); ok {
+		s.logger.Debug("serving file from cache", "key", key)
+		span.SetAttr("source", "cache")
+		s.metrics.gets.Inc("cache")
+		return s.served(r), nil
+	}
+
+	// Erasure-coded files are rebuilt from their shards
+	if m, ok := s.readManifest(key); ok {
+		policy = StoragePolicy{DataShards: m.DataShards, ParityShards: m.ParityShards}
+	}
+	if policy.Erasure() {
+		span.SetAttr("source", "shards")
+		b, err := s.getErasure(ctx, key, policy)
+		if err != nil {
+			s.metrics.gets.Inc("miss")
+			return nil, err
+		}
+		if _, err := s.writeLocal(ctx, cacheID, key, bytes.NewReader(b)); err == nil {
+			s.cacheCopy(key, int64(len(b)))
+		} else {
+			s.deleteLocal(cacheID, key)
+		}
+		s.metrics.gets.Inc("rebuild")
+		return s.served(bytes.NewReader(b)), nil
+	}
+
+	// File not found locally, request from peers
+	s.logger.Debug("file not stored locally, fetching from network", "key", key)
+	span.SetAttr("source", "network")
+
+	msg := Message{
+		Payload: MessageGetFile{
+			ID:     s.ID,
+			Tenant: tenantOf(key),
+			Token:  s.peerToken(),
+			Key:    crypto.HashKey(key),
+		},
+	}
+
+	for _, peer := range s.rankPeers(crypto.HashKey(key)) {
+		n, err := s.fetchFile(ctx, peer, key, &msg)
+		if err != nil {
+			s.logger.Warn("fetching file failed", "key", key, "peer", peer.RemoteAddr().String(), "err", err)
+			continue
+		}
+		if n < 0 {
+			continue // Peer does not have the file
+		}
+
+		s.logger.Debug("fetched file", "key", key, "peer", peer.RemoteAddr().String(), "bytes", n)
+
+		// Return file reader from the cache. The copy is opened first, so evicting it right away is safe.
+		_, r, err := s.readLocal(ctx, cacheID, key)
+		if err != nil {
+			return nil, err
+		}
+		s.cacheCopy(key, s.store.Size(cacheID, key))
+		s.metrics.gets.Inc("fetch")
+		return s.served(r), nil
+	}
+
+	s.metrics.gets.Inc("miss")
+	return nil, fmt.Errorf("[%s] %w on the network: %s", s.Transport.Addr(), ErrNotFound, key)
+}
+
+// fetchFile requests a file from a single peer and decrypts it into the cache directory.
+// It returns -1 if the peer does not have the file.
+func (s *FileServer) fetchFile(ctx context.Context, peer p2p.Peer, key string, msg *Message) (n int64, err error) {
+	ctx, span := s.startSpan(ctx, "p2p.fetch", trace.KindClient, "key", key, "peer", peer.RemoteAddr().String())
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	st, err := s.openStream(ctx, peer, msg)
+	if err != nil {
+		return 0, err
+	}
+	defer st.Close()
+
+	// Read file size from peer
+	var fileSize int64
+	if err := binary.Read(st, binary.LittleEndian, &fileSize); err != nil {
+		return 0, err
+	}
+	if fileSize < 0 {
+		span.SetAttr("found", false)
+		return -1, nil
+	}
+
+	// Decrypt and write file to local storage
+	_, dspan := s.startSpan(ctx, "crypto.decrypt", trace.KindInternal, "key", key, "bytes", fileSize)
+	n, err = s.store.WriteDecrypt(s.encKey(key), cacheID, key, io.LimitReader(s.limitReader(ctx, foreground, st), fileSize))
+	if err == nil && n != fileSize {
+		err = fmt.Errorf("short transfer: %d of %d bytes", n, fileSize)
+	}
+	endSpan(dspan, err)
+	if err != nil {
+		s.store.Delete(cacheID, key) // Don't keep a truncated copy
+		return 0, err
+	}
+	s.metrics.storedBytes.Add(float64(n-aes.BlockSize), "peer") // n counts the IV, which is not stored
+	s.observeTransfer("fetch", start)
+
+	return n, nil
+}
+
+// Store saves a file under the storage policy of its key.
+func (s *FileServer) Store(key string, r io.Reader) error {
+	return s.StoreContext(context.Background(), key, r)
+}
+
+// StoreContext is Store as part of the operation in ctx, whose span becomes the parent of the spans of the Store
+func (s *FileServer) StoreContext(ctx context.Context, key string, r io.Reader) error {
+	return s.storeWithPolicy(ctx, key, r, s.policyFor(key), Precondition{})
+}
+
+// StoreWithPolicy saves a file locally and replicates it to peers, or erasure codes it if the policy says so.
+// The file is encrypted before storage and transfer, and every peer receives it on its own stream
+// and confirms once the replica is on disk. With a replication factor set, replicas go to the
+// highest ranked peers for the key, peers that fail are replaced by the next ones in line, and
+// Store fails if the file could not reach the replication factor.
+func (s *FileServer) StoreWithPolicy(key string, r io.Reader, policy StoragePolicy) error {
+	return s.storeWithPolicy(context.Background(), key, r, policy, Precondition{})
+}
+
+// storeWithPolicy performs StoreWithPolicy as part of the operation in ctx.
+// Writes to the same key take turns; one that finds a version it had not seen when it began is concurrent with it,
+// and the conflict resolver decides which of the two becomes the latest. A condition is checked in the write's turn.
+func (s *FileServer) storeWithPolicy(ctx context.Context, key string, r io.Reader, policy StoragePolicy, cond Precondition) (err error) {
+	ctx, span := s.startSpan(ctx, "FileServer.Store", trace.KindInternal, "key", key)
+	defer func() { endSpan(span, err) }()
+
+	hash := md5.New()
+	r = io.TeeReader(r, hash)
+
+	seen := s.index.context(key)
+	info := ObjectInfo{Key: key, Version: newVersionID(time.Now()), Writer: s.ID, Clock: s.clock.now()}
+	span.SetAttr("version", info.Version)
+	unlock := s.keyLocks.lock(key)
+	defer unlock()
+
+	// A write whose condition holds has seen the latest version, whatever it saw when it began
+	if cond.set() {
+		latest, ok := s.index.get(key)
+		if err := cond.check(key, latest, ok); err != nil {
+			return err
+		}
+		if cond.IfNoneMatch && s.heldElsewhere(s.owner(key), crypto.HashKey(key)) {
+			return fmt.Errorf("%w: %s exists on another node", ErrPreconditionFailed, key)
+		}
+		seen = s.index.context(key)
+	}
+
+	info.Vector = seen.Merge(VersionVector{s.ID: s.index.nextWrite(key, s.ID)})
+	resolution, siblings := s.resolve(key, seen, info)
+	info.Siblings = siblings
+
+	// A version that lost to a concurrent one goes straight into the history. Otherwise the version it replaces
+	// moves aside if retention keeps it or it stays as a sibling, and the cached copy of it is dropped.
+	skey := key
+	var prev ObjectInfo
+	archived := false
+	if resolution == KeepExisting {
+		skey = versionKey(key, info.Version)
+	} else {
+		if err := s.cache.drop(key); err != nil {
+			s.logger.Error("saving cache index failed", "err", err)
+		}
+		s.deleteLocal(cacheID, key)
+		prev, archived, err = s.archiveLatest(ctx, key, resolution == KeepBoth)
+		if err != nil {
+			return err
+		}
+	}
+
+	if policy.Erasure() {
+		span.SetAttr("policy", fmt.Sprintf("%d+%d", policy.DataShards, policy.ParityShards))
+		size, err := s.storeErasure(ctx, skey, r, policy)
+		if size > 0 || err == nil {
+			info.Size, info.ETag = size, hex.EncodeToString(hash.Sum(nil))
+			s.indexObject(ctx, info, resolution, archived)
+		} else if archived {
+			s.unarchive(ctx, key, prev)
+		}
+		return err
+	}
+
+	// Write file to local storage, within the room this node has left
+	r, err = s.quotaReader(s.owner(key), skey, r)
+	if err == nil {
+		info.Size, err = s.writeLocal(ctx, s.owner(key), skey, r)
+		if err != nil {
+			s.deleteLocal(s.owner(key), skey) // Don't keep a partial file
+			if !archived && skey == key {
+				s.index.remove(key) // The file it overwrote is gone too
+			}
+		}
+	}
+	if err != nil {
+		if archived {
+			s.unarchive(ctx, key, prev)
+		}
+		return err
+	}
+	s.metrics.storedBytes.Add(float64(info.Size), "client")
+	info.ETag = hex.EncodeToString(hash.Sum(nil))
+	s.indexObject(ctx, info, resolution, archived)
+
+	// Tell peers what is coming at the start of each stream. A version that lost to a concurrent one
+	// is stored under its own key, so its peers need no vector to place it.
+	vector := info.Vector
+	if skey != key {
+		vector = nil
+	}
+	msg := Message{
+		Payload: MessageStoreFile{
+			ID:     s.ID,
+			Tenant: tenantOf(key),
+			Token:  s.peerToken(),
+			Key:    crypto.HashKey(skey),
+			Size:   info.Size + 16, // Add padding for encryption
+			Clock:  info.Clock,
+			Vector: vector,
+			Create: cond.IfNoneMatch,
+		},
+	}
+
+	peers := s.rankPeersFor(crypto.HashKey(skey), info.Size+16)
+	want := len(peers)
+	rf := s.replicationFactor(key)
+	if rf > 0 {
+		want = rf - 1
+	}
+
+	// Peers that refuse the file may point at members with room, which then take their turn
+	tried := make(map[p2p.Peer]bool)
+	confirmed := 0
+	for confirmed < want && len(peers) > 0 {
+		batch := peers[:min(want-confirmed, len(peers))]
+		peers = peers[len(batch):]
+		for _, peer := range batch {
+			tried[peer] = true
+		}
+		n, redirects, err := s.replicate(ctx, skey, &msg, batch)
+		if err != nil {
+			s.undoCreate(ctx, key)
+			return err
+		}
+		confirmed += n
+		for _, peer := range redirects {
+			if !tried[peer] {
+				tried[peer] = true
+				peers = append([]p2p.Peer{peer}, slices.DeleteFunc(peers, func(p p2p.Peer) bool { return p == peer })...)
+			}
+		}
+	}
+
+	if rf > 0 && confirmed < want {
+		return fmt.Errorf("%w: %d of %d copies of (%s) confirmed", ErrInsufficientReplicas, confirmed+1, rf, key)
+	}
+
+	return nil
+}
+
+// indexObject records a new version of an object stored through this node as the resolution of any conflict says:
+// as the latest, keeping the version it replaces if that was archived, or as an older version.
+// It then drops the older versions retention no longer keeps.
+func (s *FileServer) indexObject(ctx context.Context, info ObjectInfo, resolution Resolution, archived bool) {
+	info.ModTime = time.Now().UTC()
+	put := s.index.put
+	if resolution == KeepExisting {
+		put = func(info ObjectInfo, _ bool) error { return s.index.insert(info) }
+	}
+	if err := put(info, archived); err != nil {
+		s.logger.Error("indexing object failed", "key", info.Key, "err", err)
+	}
+	s.pruneVersions(ctx, info.Key)
+	s.publish(Event{Type: EventStored, Key: info.Key, Size: info.Size, ETag: info.ETag})
+}
+
+// Stat returns the info of an object stored through this node
+func (s *FileServer) Stat(key string) (ObjectInfo, error) {
+	info, ok := s.index.get(key)
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return info, nil
+}
+
+// List returns up to limit objects stored through this node whose key starts with prefix,
+// in key order and starting after the key after. A limit of 0 returns every match.
+func (s *FileServer) List(prefix string, after string, limit int) []ObjectInfo {
+	return s.index.list(prefix, after, limit)
+}
+
+// Storage returns the local content-addressable store of this node
+func (s *FileServer) Storage() *store.Store {
+	return s.store
+}
+
+// Namespace returns the directory tree over the objects stored through this node
+func (s *FileServer) Namespace() *Namespace {
+	return s.ns
+}
+
+// Delete removes an object and its older versions from local storage and asks every peer to drop its replicas or shards
+func (s *FileServer) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is Delete as part of the operation in ctx, whose span becomes the parent of the spans of the Delete
+func (s *FileServer) DeleteContext(ctx context.Context, key string) (err error) {
+	ctx, span := s.startSpan(ctx, "FileServer.Delete", trace.KindInternal, "key", key)
+	defer func() { endSpan(span, err) }()
+
+	_, erasure := s.readManifest(key)
+	_, indexed := s.index.get(key)
+	if !indexed && !erasure && !s.store.Has(s.owner(key), key) {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	versions := s.index.history(key)
+	if err := s.index.remove(key); err != nil {
+		return err
+	}
+	s.publish(Event{Type: EventDeleted, Key: key})
+
+	errs := []error{s.dropFile(ctx, key)}
+	for _, info := range versions[min(1, len(versions)):] {
+		errs = append(errs, s.dropFile(ctx, versionKey(key, info.Version)))
+	}
+	return errors.Join(errs...)
+}
+
+// dropFile deletes the local copy, shards and cached copy of the file stored under key
+// and asks every peer to drop its replica or shards
+func (s *FileServer) dropFile(ctx context.Context, key string) error {
+	shards := 0
+	if manifest, ok := s.readManifest(key); ok {
+		shards = manifest.DataShards + manifest.ParityShards
+		s.deleteLocal(s.owner(key), manifestKey(key))
+		s.deleteLocal(s.owner(key), shardKey(crypto.HashKey(key), 0))
+	}
+	s.deleteLocal(s.owner(key), key)
+	s.deleteLocal(cacheID, key)
+	if err := s.cache.remove(key); err != nil {
+		return err
+	}
+
+	msg := Message{
+		Payload: MessageDeleteFile{
+			ID:     s.ID,
+			Tenant: tenantOf(key),
+			Token:  s.peerToken(),
+			Key:    crypto.HashKey(key),
+			Shards: shards,
+		},
+	}
+	return s.broadcast(traced(ctx, &msg))
+}
+
+// deleteLocal removes a file from local storage if it is there
+func (s *FileServer) deleteLocal(id string, key string) {
+	if err := s.store.Delete(id, key); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.logger.Error("deleting file failed", "key", key, "err", err)
+	}
+}
+
+// replicate streams the local copy of a file to a batch of peers in parallel
+// and returns how many confirmed a complete replica. Peers that refuse the file for lack of room
+// may name other members to try; the connected ones are returned as redirects. A peer that refuses a create-only
+// file because it holds it for another node fails the replication with ErrPreconditionFailed, before any data is sent.
+func (s *FileServer) replicate(ctx context.Context, key string, msg *Message, peers []p2p.Peer) (confirmed int, redirects []p2p.Peer, err error) {
+	ctx, span := s.startSpan(ctx, "p2p.replicate", trace.KindClient, "key", key, "peers", len(peers))
+	defer func() {
+		span.SetAttr("confirmed", confirmed)
+		span.End()
+	}()
+
+	streams := []net.Conn{}
+	writers := []io.Writer{}
+	for _, peer := range peers {
+		st, reply, err := s.openStoreStream(ctx, peer, msg)
+		if errors.Is(err, ErrPreconditionFailed) {
+			return 0, nil, err
+		}
+		if err != nil {
+			s.logger.Warn("replicating file failed", "key", key, "peer", peer.RemoteAddr().String(), "err", err)
+			if redirect, ok := s.memberPeer(reply.Redirect); ok {
+				redirects = append(redirects, redirect)
+			}
+			continue
+		}
+		defer st.Close()
+		streams = append(streams, st)
+		writers = append(writers, s.limitWriter(ctx, background, st))
+	}
+	if len(streams) == 0 {
+		return 0, redirects, nil
+	}
+	start := time.Now()
+
+	_, r, err := s.store.Read(s.owner(key), key)
+	if err != nil {
+		s.logger.Error("replicating file failed", "key", key, "err", err)
+		return 0, redirects, nil
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+
+	// Send encrypted file to all peers
+	fw := &fanoutWriter{writers: writers, errs: make([]error, len(writers))}
+	_, espan := s.startSpan(ctx, "crypto.encrypt", trace.KindInternal, "key", key)
+	n, err := crypto.CopyEncrypt(s.encKey(key), r, fw)
+	espan.SetAttr("bytes", n)
+	endSpan(espan, err)
+	if err != nil {
+		s.logger.Error("replicating file failed", "key", key, "err", err)
+		return 0, redirects, nil
+	}
+
+	// Wait for every peer to confirm the replica is on disk
+	size := msg.Payload.(MessageStoreFile).Size
+	for i, st := range streams {
+		if fw.errs[i] != nil {
+			s.logger.Warn("replicating file failed", "key", key, "peer", st.RemoteAddr().String(), "err", fw.errs[i])
+			continue
+		}
+		var written int64
+		if err := binary.Read(st, binary.LittleEndian, &written); err != nil || written != size {
+			s.logger.Warn("replica not confirmed", "key", key, "peer", st.RemoteAddr().String(), "err", err)
+			continue
+		}
+		confirmed++
+		s.observeTransfer("replicate", start)
+	}
+
+	s.logger.Debug("replicated file", "key", key, "bytes", n, "replicas", confirmed)
+
+	return confirmed, redirects, nil
+}
+
+// fanoutWriter writes to several replica streams at once.
+// Unlike io.MultiWriter it keeps going when a stream fails, so one broken peer does not abort the others.
+type fanoutWriter struct {
+	writers []io.Writer
+	errs    []error // First write error of each stream
+}
+
+// Write writes p to every stream that has not failed yet. It only fails once every stream has.
+func (w *fanoutWriter) Write(p []byte) (int, error) {
+	ok := false
+	for i, st := range w.writers {
+		if w.errs[i] != nil {
+			continue
+		}
+		if _, err := st.Write(p); err != nil {
+			w.errs[i] = err
+			continue
+		}
+		ok = true
+	}
+	if !ok {
+		return 0, errors.Join(w.errs...)
+	}
+	return len(p), nil
+}
+
+// openStream opens a new stream to a peer and writes the message that describes the transfer
+func (s *FileServer) openStream(ctx context.Context, peer p2p.Peer, msg *Message) (net.Conn, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(traced(ctx, msg)); err != nil {
+		return nil, err
+	}
+
+	st, err := peer.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := st.Write(p2p.FrameStream(buf.Bytes())); err != nil {
+		st.Close()
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// Stop announces that this node leaves the cluster and signals the file server to shut down.
+// Calling it again has no effect.
+func (s *FileServer) Stop() {
+	s.stopOnce.Do(s.stop)
+}
+
+// stop performs the shutdown for Stop
+func (s *FileServer) stop() {
+	self := s.members.Leave()
+	msg := Message{
+		Payload: MessageSync{From: self, Members: []Member{self}},
+	}
+
+	if err := s.broadcast(&msg); err != nil {
+		s.logger.Warn("leave broadcast failed", "err", err)
+	}
+
+	if s.discovery != nil {
+		s.discovery.Close()
+	}
+	for _, srv := range []*http.Server{s.http, s.s3, s.webdav, s.admin, s.metricsSrv} {
+		if srv != nil {
+			srv.Close()
+		}
+	}
+	if s.grpc != nil {
+		s.grpc.Stop()
+	}
+
+	close(s.quitch)
+}
+
+// OnPeer is called when a new peer connects.
+// It pushes our member list to the peer so both sides learn about each other's cluster view.
+func (s *FileServer) OnPeer(p p2p.Peer) error {
+	s.peerLock.Lock()
+	s.peers[p.RemoteAddr().String()] = p // Add peer to map
+	s.peerLock.Unlock()
+
+	s.logger.Info("connected with peer", "peer", p.RemoteAddr().String())
+	s.publish(Event{Type: EventPeerConnected, Peer: p.RemoteAddr().String()})
+
+	if err := s.sendSync(p.RemoteAddr().String()); err != nil {
+		return err
+	}
+	return s.sendRevoked(p.RemoteAddr().String())
+}
+
+// OnPeerDisconnect is called when a peer connection drops.
+// It forgets the peer so replication and gossip stop using it, and gossip dials the member again if it is still alive.
+func (s *FileServer) OnPeerDisconnect(p p2p.Peer) {
+	addr := p.RemoteAddr().String()
+
+	s.peerLock.Lock()
+	defer s.peerLock.Unlock()
+
+	if s.peers[addr] != p {
+		return // Already replaced or dropped
+	}
+	delete(s.peers, addr)
+	for id, a := range s.memberPeers {
+		if a == addr {
+			delete(s.memberPeers, id)
+		}
+	}
+	s.logger.Info("disconnected from peer", "peer", addr)
+	s.publish(Event{Type: EventPeerDisconnected, Peer: addr})
+}
+
+// loop is the main event loop for the file server
+// It processes incoming RPCs and handles shutdown
+func (s *FileServer) loop() {
+	defer func() {
+		s.logger.Info("file server stopped")
+		s.Transport.Close()
+	}()
+
+	for {
+		select {
+		case rpc := <-s.Transport.Consume():
+			if rpc.Stream {
+				// Transfers run concurrently so they never hold up other messages
+				go s.handleRPC(rpc)
+				continue
+			}
+			s.handleRPC(rpc)
+
+		case <-s.quitch:
+			return
+		}
+	}
+}
+
+// handleRPC decodes an incoming RPC and handles it, closing its stream afterwards
+func (s *FileServer) handleRPC(rpc p2p.RPC) {
+	if rpc.Body != nil {
+		defer rpc.Body.Close()
+	}
+
+	var msg Message
+	// Decode incoming message
+	if err := gob.NewDecoder(bytes.NewReader(rpc.Payload)).Decode(&msg); err != nil {
+		s.logger.Warn("decoding message failed", "peer", rpc.From, "err", err)
+		return
+	}
+	// Handle the message
+	if err := s.handleMessage(messageContext(&msg), rpc.From, &msg, rpc.Body); err != nil {
+		s.logger.Warn("handling message failed", "peer", rpc.From, "err", err)
+	}
+}
+
+// handleMessage dispatches incoming messages to the correct handler.
+// stream is the logical stream of a transfer and nil for plain messages. ctx carries the span of the sender, if any.
+func (s *FileServer) handleMessage(ctx context.Context, from string, msg *Message, stream net.Conn) error {
+	switch v := msg.Payload.(type) {
+	case MessageStoreFile:
+		return s.handleMessageStoreFile(ctx, from, v, stream)
+	case MessageGetFile:
+		return s.handleMessageGetFile(ctx, from, v, stream)
+	case MessageDeleteFile:
+		return s.handleMessageDeleteFile(ctx, from, v)
+	case MessageArchiveFile:
+		return s.handleMessageArchiveFile(ctx, from, v, stream)
+	case MessagePing:
+		return s.handleMessagePing(from, v)
+	case MessageAck:
+		return s.handleMessageAck(from, v)
+	case MessagePingReq:
+		return s.handleMessagePingReq(from, v)
+	case MessageSync:
+		return s.handleMessageSync(from, v)
+	case MessageRevoke:
+		return s.handleMessageRevoke(from, v)
+	}
+
+	return nil
+}
+
+// handleMessageGetFile serves a file to a requesting peer on the request's stream.
+// A size of -1 tells the requester that the file is not here.
+func (s *FileServer) handleMessageGetFile(ctx context.Context, from string, msg MessageGetFile, stream net.Conn) (err error) {
+	ctx, span := s.startSpan(ctx, "handle GetFile", trace.KindServer, "key", msg.Key, "peer", from)
+	defer func() { endSpan(span, err) }()
+
+	if stream == nil {
+		return fmt.Errorf("get file request from %s without a stream", from)
+	}
+	if err := s.authorizePeer(msg.Token, msg.ID, OpRead); err != nil {
+		binary.Write(stream, binary.LittleEndian, int64(-1))
+		return err
+	}
+	owner, err := s.peerOwner(msg.ID, msg.Tenant)
+	if err != nil {
+		binary.Write(stream, binary.LittleEndian, int64(-1))
+		return err
+	}
+
+	// Check if file exists locally, among the files of the requested tenant
+	if !s.store.Has(owner, msg.Key) {
+		span.SetAttr("found", false)
+		binary.Write(stream, binary.LittleEndian, int64(-1))
+		return fmt.Errorf("need to serve file (%s) but it does not exist on disk", msg.Key)
+	}
+
+	fileSize, r, err := s.readLocal(ctx, owner, msg.Key)
+	if err != nil {
+		binary.Write(stream, binary.LittleEndian, int64(-1))
+		return err
+	}
+
+	// Close file after sending if possible
+	if rc, ok := r.(io.ReadCloser); ok {
+		defer rc.Close()
+	}
+
+	// Send file size followed by the file
+	if err := binary.Write(stream, binary.LittleEndian, fileSize); err != nil {
+		return err
+	}
+	n, err := io.Copy(s.limitWriter(ctx, foreground, stream), r)
+	s.metrics.servedBytes.Add(float64(n), "peer")
+	if err != nil {
+		return err
+	}
+
+	s.logger.Debug("served file to peer", "key", msg.Key, "peer", from, "bytes", n)
+
+	return nil
+}
+
+// handleMessageStoreFile receives and stores a file sent by a peer on the transfer's stream,
+// then confirms the number of bytes written
+func (s *FileServer) handleMessageStoreFile(ctx context.Context, from string, msg MessageStoreFile, stream net.Conn) (err error) {
+	ctx, span := s.startSpan(ctx, "handle StoreFile", trace.KindServer, "key", msg.Key, "peer", from)
+	defer func() { endSpan(span, err) }()
+
+	if stream == nil {
+		return fmt.Errorf("store file request from %s without a stream", from)
+	}
+	if err := s.authorizePeer(msg.Token, msg.ID, OpWrite); err != nil {
+		s.denyStore(stream)
+		return err
+	}
+	owner, err := s.peerOwner(msg.ID, msg.Tenant)
+	if err != nil {
+		s.denyStore(stream)
+		return err
+	}
+
+	// Accept the file only if there is room for it
+	release, err := s.admitStore(owner, msg, stream)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s.clock.observe(msg.Clock)
+
+	// Write file to local storage, unless the replica held here is newer
+	n, err := s.storeReplica(ctx, owner, msg, io.LimitReader(s.limitReader(ctx, background, stream), msg.Size))
+	if err != nil {
+		return fmt.Errorf("replica from %s: %w", from, err)
+	}
+	s.metrics.storedBytes.Add(float64(n), "peer")
+
+	s.logger.Debug("stored file from peer", "key", msg.Key, "peer", from, "bytes", n)
+
+	// Confirm the replica to the sender
+	return binary.Write(stream, binary.LittleEndian, n)
+}
+
+// handleMessageDeleteFile drops the replica or shards a peer stored here
+func (s *FileServer) handleMessageDeleteFile(ctx context.Context, from string, msg MessageDeleteFile) error {
+	_, span := s.startSpan(ctx, "handle DeleteFile", trace.KindServer, "key", msg.Key, "peer", from)
+	defer span.End()
+
+	if err := s.authorizePeer(msg.Token, msg.ID, OpDelete); err != nil {
+		return err
+	}
+	owner, err := s.peerOwner(msg.ID, msg.Tenant)
+	if err != nil {
+		return err
+	}
+
+	s.deleteLocal(owner, msg.Key)
+	s.dropReplica(owner, msg.Key)
+	for i := 0; i < msg.Shards; i++ {
+		s.deleteLocal(owner, shardKey(msg.Key, i))
+	}
+	return nil
+}
+
+// bootstrapNetwork connects to all bootstrap peers
+func (s *FileServer) bootstrapNetwork() error {
+	for _, addr := range s.Settings().BootstrapNodes {
+		if len(addr) == 0 {
+			continue
+		}
+
+		go func(addr string) {
+			s.logger.Info("connecting to bootstrap node", "peer", addr)
+			if err := s.Transport.Dial(addr); err != nil {
+				s.logger.Warn("dialing bootstrap node failed", "peer", addr, "err", err)
+			}
+		}(addr)
+	}
+
+	return nil
+}
+
+// startDiscovery announces this node on the local network and dials the nodes it finds
+func (s *FileServer) startDiscovery() error {
+	if s.Discovery == nil {
+		return nil
+	}
+
+	opts := *s.Discovery
+	if len(opts.ID) == 0 {
+		opts.ID = s.ID
+	}
+	if len(opts.ListenAddr) == 0 {
+		opts.ListenAddr = s.Transport.Addr()
+	}
+	if opts.Transport == nil {
+		opts.Transport = s.Transport
+	}
+	if opts.Logger == nil {
+		opts.Logger = s.logger
+	}
+
+	s.discovery = p2p.NewDiscovery(opts)
+	return s.discovery.Start()
+}
+
+// Start launches the file server: listens for connections, bootstraps peers, and enters event loop
+func (s *FileServer) Start() error {
+	s.logger.Info("starting file server", "addr", s.Transport.Addr())
+	s.started = time.Now()
+
+	// Start listening for incoming connections
+	if err := s.Transport.ListenAndAccept(); err != nil {
+		return err
+	}
+
+	// Connect to bootstrap peers
+	s.bootstrapNetwork()
+
+	// Directories listed before they were objects become objects
+	s.ns.migrate()
+
+	// Find peers on the local network
+	if err := s.startDiscovery(); err != nil {
+		return err
+	}
+
+	// Serve the HTTP gateway, the S3, WebDAV and gRPC APIs, metrics and the admin socket
+	if err := s.listenHTTP(s.http, "HTTP gateway"); err != nil {
+		return err
+	}
+	if err := s.listenHTTP(s.s3, "S3 API"); err != nil {
+		return err
+	}
+	if err := s.listenHTTP(s.webdav, "WebDAV server"); err != nil {
+		return err
+	}
+	if err := s.listenHTTP(s.metricsSrv, "metrics"); err != nil {
+		return err
+	}
+	if err := s.listenGRPC(); err != nil {
+		return err
+	}
+	if err := s.listenAdmin(); err != nil {
+		return err
+	}
+
+	// Probe members and spread membership changes in the background
+	go s.gossipLoop()
+
+	// Enter main event loop
+	s.loop()
+
+	return nil
+}
+
+// init registers message types for gob encoding/decoding
+func init() {
+	gob.Register(MessageStoreFile{})
+	gob.Register(MessageGetFile{})
+	gob.Register(MessageDeleteFile{})
+	gob.Register(MessagePing{})
+	gob.Register(MessageAck{})
+	gob.Register(MessagePingReq{})
+	gob.Register(MessageSync{})
+}
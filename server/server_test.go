@@ -0,0 +1,139 @@
+// Integration tests for the file server in GoVaultFS
+// These tests run small clusters over in-memory transports and verify replication and network retrieval.
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/AnshSinghSonkhia/GoVaultFS/store"
+)
+
+// TestFileServerStoreGet stores files on one node, deletes the local copies and
+// fetches them back from the network concurrently over a single peer connection.
+func TestFileServerStoreGet(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	const files = 10
+	for i := 0; i < files; i++ {
+		key := fmt.Sprintf("file_%d", i)
+		if err := s2.Store(key, bytes.NewReader(bytes.Repeat([]byte(key), 1000))); err != nil {
+			t.Fatal(err)
+		}
+		if err := s2.store.Delete(s2.ID, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Wait for the replicas to land on s1
+	waitFor(t, 5*time.Second, func() bool {
+		for i := 0; i < files; i++ {
+			if !s1.store.Has(s2.ID, crypto.HashKey(fmt.Sprintf("file_%d", i))) {
+				return false
+			}
+		}
+		return true
+	})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < files; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			r, err := s2.Get(key)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			b, _ := io.ReadAll(r)
+			if rc, ok := r.(io.Closer); ok {
+				rc.Close()
+			}
+			if !bytes.Equal(b, bytes.Repeat([]byte(key), 1000)) {
+				t.Errorf("corrupt content for %s", key)
+			}
+		}(fmt.Sprintf("file_%d", i))
+	}
+	wg.Wait()
+
+	// A key nobody has fails instead of hanging
+	if _, err := s2.Get("missing"); err == nil {
+		t.Error("expected error for missing key")
+	}
+}
+
+// newTestServer creates a file server on an in-memory network with fast gossip timers, after configure, if not nil,
+// changed its options. Its storage lives in a temporary directory removed at the end of the test.
+func newTestServer(t *testing.T, network *p2p.MemNetwork, listenAddr string, configure func(*FileServerOpts), nodes ...string) *FileServer {
+	tr := p2p.NewMemTransport(p2p.MemTransportOpts{
+		ListenAddr: listenAddr,
+		Network:    network,
+	})
+	opts := testServerOpts(t, tr, nodes...)
+	if configure != nil {
+		configure(&opts)
+	}
+	tr.Logger = opts.Logger // The transport logs with the node
+	s := NewFileServer(opts)
+	tr.OnPeer = s.OnPeer
+	tr.OnPeerDisconnect = s.OnPeerDisconnect
+	return s
+}
+
+// startServers starts the servers in order, each once the one before listens so that it can dial it,
+// and waits until every server is connected to all the others
+func startServers(t *testing.T, servers ...*FileServer) {
+	t.Helper()
+
+	for _, s := range servers {
+		go s.Start()
+		tr := s.Transport.(*p2p.MemTransport)
+		waitFor(t, 5*time.Second, func() bool { return tr.Network.Listening(tr.Addr()) })
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		for _, s := range servers {
+			if len(s.peerList()) != len(servers)-1 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// testServerOpts returns file server options for tests on the given transport
+func testServerOpts(t *testing.T, tr p2p.Transport, nodes ...string) FileServerOpts {
+	return FileServerOpts{
+		EncKey:            crypto.NewEncryptionKey(),
+		StorageRoot:       filepath.Join(t.TempDir(), strings.ReplaceAll(tr.Addr(), ":", "port")),
+		PathTransformFunc: store.CASPathTransformFunc,
+		Transport:         tr,
+		BootstrapNodes:    nodes,
+		Gossip: GossipOpts{
+			ProbeInterval:    100 * time.Millisecond,
+			ProbeTimeout:     50 * time.Millisecond,
+			SuspicionTimeout: time.Second,
+		},
+	}
+}
+
+// stopServers stops every server
+func stopServers(servers ...*FileServer) {
+	for _, s := range servers {
+		s.Stop()
+	}
+}
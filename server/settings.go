@@ -0,0 +1,105 @@
+// Runtime settings for GoVaultFS
+// Some options of a running node can change without a restart: how files are protected, which nodes it
+// bootstraps from, who may use the S3 API, its bandwidth budgets, storage limits, cache and how many object
+// versions it keeps. Reload swaps them in; the rest of FileServerOpts is fixed at start.
+package server
+
+import (
+	"maps"
+	"slices"
+)
+
+// Settings are the options of a running node that Reload can change
+type Settings struct {
+	BootstrapNodes    []string                 // Bootstrap peers; new ones are dialed on reload
+	ReplicationFactor int                      // Copies of each file including the local one, 0 replicates to every peer
+	Policy            StoragePolicy            // Default storage policy
+	NamespacePolicies map[string]StoragePolicy // Storage policy per key prefix
+	S3AccessKeys      map[string]string        // Secret key by access key ID for the S3 API
+	Bandwidth         BandwidthOpts            // Bandwidth budgets of peer transfers and disk I/O
+	Quota             QuotaOpts                // Storage limits of the node and of each owner
+	Cache             CacheOpts                // Cache of files fetched from peers
+	Versions          VersionOpts              // Retention of older object versions
+}
+
+// Settings returns the current runtime settings of the node
+func (s *FileServer) Settings() Settings {
+	s.settingsLock.RLock()
+	defer s.settingsLock.RUnlock()
+
+	st := Settings{
+		BootstrapNodes:    slices.Clone(s.BootstrapNodes),
+		ReplicationFactor: s.ReplicationFactor,
+		Policy:            s.Policy,
+		NamespacePolicies: maps.Clone(s.NamespacePolicies),
+		Bandwidth:         s.Bandwidth,
+		Quota:             s.quotaOpts(),
+		Cache:             s.Cache,
+		Versions:          s.Versions,
+	}
+	if api := s.s3api; api != nil {
+		st.S3AccessKeys = api.accessKeys()
+	}
+	return st
+}
+
+// Reload applies new runtime settings.
+// Files already stored keep the protection they were written with; the new policies apply to later writes.
+func (s *FileServer) Reload(st Settings) {
+	s.settingsLock.Lock()
+	added := []string{}
+	for _, addr := range st.BootstrapNodes {
+		if len(addr) > 0 && !slices.Contains(s.BootstrapNodes, addr) {
+			added = append(added, addr)
+		}
+	}
+	s.BootstrapNodes = slices.Clone(st.BootstrapNodes)
+	s.ReplicationFactor = st.ReplicationFactor
+	s.Policy = st.Policy
+	s.NamespacePolicies = maps.Clone(st.NamespacePolicies)
+	s.Bandwidth = st.Bandwidth
+	s.setBandwidth(st.Bandwidth)
+	s.Quota = st.Quota
+	s.Quota.Owners = maps.Clone(st.Quota.Owners)
+	s.Cache = st.Cache
+	s.setCache(st.Cache)
+	pruneVersions := st.Versions != s.Versions
+	s.Versions = st.Versions
+	s.settingsLock.Unlock()
+
+	if pruneVersions {
+		go s.pruneAllVersions()
+	}
+
+	if api := s.s3api; api != nil {
+		api.setAccessKeys(st.S3AccessKeys)
+	}
+
+	for _, addr := range added {
+		go func(addr string) {
+			s.logger.Info("connecting to bootstrap node", "peer", addr)
+			if err := s.Transport.Dial(addr); err != nil {
+				s.logger.Warn("dialing bootstrap node failed", "peer", addr, "err", err)
+			}
+		}(addr)
+	}
+
+	s.logger.Info("settings reloaded")
+}
+
+// quotaOpts returns a copy of the storage limits. The caller holds settingsLock.
+func (s *FileServer) quotaOpts() QuotaOpts {
+	q := s.Quota
+	q.Owners = maps.Clone(q.Owners)
+	return q
+}
+
+// replicationFactor returns the current replication factor of a key, which its tenant may set
+func (s *FileServer) replicationFactor(key string) int {
+	if t, ok := s.tenants[tenantOf(key)]; ok && t.ReplicationFactor > 0 {
+		return t.ReplicationFactor
+	}
+	s.settingsLock.RLock()
+	defer s.settingsLock.RUnlock()
+	return s.ReplicationFactor
+}
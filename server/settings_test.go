@@ -0,0 +1,83 @@
+// Tests for runtime settings in GoVaultFS
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReload raises the replication factor and adds a bootstrap node on a running server
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil)
+	defer stopServers(s1, s2)
+
+	startServers(t, s1)
+	startServers(t, s2)
+
+	// Alone, s2 cannot hold two copies
+	s2.Reload(Settings{ReplicationFactor: 2})
+	assert.ErrorIs(t, s2.Store("a.txt", strings.NewReader("a")), ErrInsufficientReplicas)
+
+	// A new bootstrap node is dialed and the next write reaches it
+	s2.Reload(Settings{ReplicationFactor: 2, BootstrapNodes: []string{":3000"}})
+	waitFor(t, 5*time.Second, func() bool { return len(s2.peerList()) == 1 })
+	assert.Nil(t, s2.Store("b.txt", strings.NewReader("b")))
+
+	st := s2.Settings()
+	assert.Equal(t, 2, st.ReplicationFactor)
+	assert.Equal(t, []string{":3000"}, st.BootstrapNodes)
+
+	// Namespace policies apply to later writes
+	s2.Reload(Settings{NamespacePolicies: map[string]StoragePolicy{"ec/": {DataShards: 1, ParityShards: 1}}})
+	assert.Equal(t, StoragePolicy{DataShards: 1, ParityShards: 1}, s2.policyFor("ec/file"))
+	assert.Equal(t, StoragePolicy{}, s2.policyFor("plain"))
+}
+
+// TestReloadS3Keys swaps the S3 access keys of a running node, whose S3 API is wrapped in request logging
+func TestReloadS3Keys(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	s := newTestServer(t, p2p.NewMemNetwork(), ":3000", func(opts *FileServerOpts) {
+		opts.S3 = &S3Opts{ListenAddr: addr, AccessKeys: map[string]string{testAccessKey: testSecretKey}}
+	})
+	defer s.Stop()
+	go s.Start()
+
+	listBuckets := func(accessKey string, secret string) int {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+		signS3As(req, nil, accessKey, secret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	waitFor(t, 5*time.Second, func() bool { return listBuckets(testAccessKey, testSecretKey) == http.StatusOK })
+
+	st := s.Settings()
+	assert.Equal(t, map[string]string{testAccessKey: testSecretKey}, st.S3AccessKeys)
+	st.S3AccessKeys = map[string]string{"NEWKEY": "new-secret"}
+	s.Reload(st)
+
+	assert.Equal(t, http.StatusForbidden, listBuckets(testAccessKey, testSecretKey))
+	assert.Equal(t, http.StatusOK, listBuckets("NEWKEY", "new-secret"))
+	assert.Equal(t, st.S3AccessKeys, s.Settings().S3AccessKeys)
+}
@@ -0,0 +1,370 @@
+// Erasure-coded storage policy for GoVaultFS
+// This file stores files as Reed-Solomon shards instead of full replicas. The writer encrypts the file,
+// splits the ciphertext into data and parity shards, keeps the first shard itself and sends every other
+// shard to a different peer. Get collects any DataShards of them and rebuilds the file, so the cluster
+// survives ParityShards lost nodes at (DataShards+ParityShards)/DataShards times the storage.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/AnshSinghSonkhia/GoVaultFS/trace"
+)
+
+// errBadShard is returned for a shard a peer announces with the wrong size, or whose header claims more data than the shards hold
+var errBadShard = errors.New("bad shard")
+
+// StoragePolicy selects how a file is protected against node loss
+type StoragePolicy struct {
+	DataShards   int // Shards a file is split into, 0 uses full replication
+	ParityShards int // Extra shards; any DataShards of the DataShards+ParityShards shards rebuild the file
+}
+
+// Erasure reports whether the policy erasure codes files instead of replicating them
+func (p StoragePolicy) Erasure() bool {
+	return p.DataShards > 0
+}
+
+// shardManifest is what the writer keeps locally in place of an erasure-coded file
+type shardManifest struct {
+	DataShards   int
+	ParityShards int
+	ShardSize    int64 // Bytes of every shard with its header, 0 in manifests written before it was kept
+}
+
+// policyFor returns the storage policy of a key: the policy of its tenant if it sets one, the policy of
+// the longest matching namespace prefix, or the server's default policy
+func (s *FileServer) policyFor(key string) StoragePolicy {
+	if t, ok := s.tenants[tenantOf(key)]; ok && t.Policy != nil {
+		return *t.Policy
+	}
+
+	s.settingsLock.RLock()
+	defer s.settingsLock.RUnlock()
+
+	policy, match := s.Policy, -1
+	for prefix, p := range s.NamespacePolicies {
+		if strings.HasPrefix(key, prefix) && len(prefix) > match {
+			policy, match = p, len(prefix)
+		}
+	}
+	return policy
+}
+
+// shardKey names the i-th shard of a file in peer storage, given the file's hashed key
+func shardKey(hashedKey string, i int) string {
+	return fmt.Sprintf("%s.shard%d", hashedKey, i)
+}
+
+// manifestKey names the local manifest of an erasure-coded file
+func manifestKey(key string) string {
+	return key + ".manifest"
+}
+
+// storeErasure encrypts a file, splits it into shards and places them on distinct nodes.
+// Shards that cannot be placed on their peer move to the next peer in line.
+// The whole file is held in memory while it is encoded. It returns the size of the file.
+func (s *FileServer) storeErasure(ctx context.Context, key string, r io.Reader, policy StoragePolicy) (int64, error) {
+	rs, err := NewReedSolomon(policy.DataShards, policy.ParityShards)
+	if err != nil {
+		return 0, err
+	}
+
+	cipher := new(bytes.Buffer)
+	_, espan := s.startSpan(ctx, "crypto.encrypt", trace.KindInternal, "key", key)
+	n, err := crypto.CopyEncrypt(s.encKey(key), r, cipher)
+	espan.SetAttr("bytes", n)
+	endSpan(espan, err)
+	if err != nil {
+		return 0, err
+	}
+	size := int64(cipher.Len() - 16) // Without the IV
+
+	// Every shard starts with the ciphertext size, so a file can be rebuilt from its shards alone
+	shards := rs.Split(cipher.Bytes())
+	for i, shard := range shards {
+		header := binary.LittleEndian.AppendUint64(nil, uint64(cipher.Len()))
+		shards[i] = append(header, shard...)
+	}
+
+	// The writer keeps the first shard, the others go to the highest ranked peers for the key with room for them
+	release, err := s.reserve(s.owner(key), shardKey(crypto.HashKey(key), 0), int64(len(shards[0])))
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.writeLocal(ctx, s.owner(key), shardKey(crypto.HashKey(key), 0), bytes.NewReader(shards[0]))
+	release()
+	if err != nil {
+		return 0, err
+	}
+	s.metrics.storedBytes.Add(float64(len(shards[0])), "client")
+
+	peers := s.rankPeersFor(crypto.HashKey(key), int64(len(shards[0])))
+	placed := 1
+	for i := 1; i < len(shards); i++ {
+		for len(peers) > 0 {
+			peer := peers[0]
+			peers = peers[1:]
+			if err := s.sendShard(ctx, peer, key, i, shards[i]); err != nil {
+				s.logger.Warn("placing shard failed", "key", key, "shard", i, "peer", peer.RemoteAddr().String(), "err", err)
+				continue
+			}
+			placed++
+			break
+		}
+	}
+
+	m := shardManifest{DataShards: policy.DataShards, ParityShards: policy.ParityShards, ShardSize: int64(len(shards[0]))}
+	if err := s.writeManifest(key, m); err != nil {
+		return 0, err
+	}
+
+	if placed < len(shards) {
+		return size, fmt.Errorf("%w: %d of %d shards of (%s) placed", ErrInsufficientReplicas, placed, len(shards), key)
+	}
+
+	s.logger.Debug("stored file as shards", "key", key, "data_shards", policy.DataShards,
+		"parity_shards", policy.ParityShards, "shard_bytes", len(shards[0]))
+
+	return size, nil
+}
+
+// sendShard sends one shard to a peer and waits until it is on disk
+func (s *FileServer) sendShard(ctx context.Context, peer p2p.Peer, key string, i int, shard []byte) (err error) {
+	ctx, span := s.startSpan(ctx, "p2p.send_shard", trace.KindClient, "key", key, "shard", i, "peer", peer.RemoteAddr().String())
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	msg := Message{
+		Payload: MessageStoreFile{
+			ID:     s.ID,
+			Tenant: tenantOf(key),
+			Token:  s.peerToken(),
+			Key:    shardKey(crypto.HashKey(key), i),
+			Size:   int64(len(shard)),
+		},
+	}
+
+	st, _, err := s.openStoreStream(ctx, peer, &msg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if _, err := s.limitWriter(ctx, background, st).Write(shard); err != nil {
+		return err
+	}
+
+	var written int64
+	if err := binary.Read(st, binary.LittleEndian, &written); err != nil {
+		return err
+	}
+	if written != int64(len(shard)) {
+		return fmt.Errorf("short shard: %d of %d bytes", written, len(shard))
+	}
+	s.observeTransfer("shard_send", start)
+	return nil
+}
+
+// getErasure collects shards of a file from local storage and peers and rebuilds it
+func (s *FileServer) getErasure(ctx context.Context, key string, policy StoragePolicy) ([]byte, error) {
+	rs, err := NewReedSolomon(policy.DataShards, policy.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	// All shards are as long as the one kept here, which the manifest records
+	m, _ := s.readManifest(key)
+	want := m.ShardSize
+	if want == 0 {
+		want = s.store.Size(s.owner(key), shardKey(crypto.HashKey(key), 0))
+	}
+
+	peers := s.rankPeers(crypto.HashKey(key))
+	shards := make([][]byte, policy.DataShards+policy.ParityShards)
+
+	wg := sync.WaitGroup{}
+	for i := range shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			shards[i] = s.findShard(ctx, key, i, want, peers)
+		}(i)
+	}
+	wg.Wait()
+
+	// Drop shards whose header disagrees with the majority, then strip the headers
+	size := shardSize(shards)
+	for i, shard := range shards {
+		if shard == nil || binary.LittleEndian.Uint64(shard) != size {
+			shards[i] = nil
+			continue
+		}
+		shards[i] = shard[8:]
+	}
+
+	if err := rs.Reconstruct(shards); err != nil {
+		return nil, fmt.Errorf("[%s] rebuilding (%s): %w", s.Transport.Addr(), key, err)
+	}
+
+	// The header must fit in the data shards, or it was not written by us
+	if size > uint64(policy.DataShards*len(shards[0])) {
+		return nil, fmt.Errorf("%w: header claims %d bytes, shards hold %d", errBadShard, size, policy.DataShards*len(shards[0]))
+	}
+	cipher, err := rs.Join(shards, int(size))
+	if err != nil {
+		return nil, err
+	}
+
+	plain := new(bytes.Buffer)
+	_, dspan := s.startSpan(ctx, "crypto.decrypt", trace.KindInternal, "key", key, "bytes", len(cipher))
+	_, err = crypto.CopyDecrypt(s.encKey(key), bytes.NewReader(cipher), plain)
+	endSpan(dspan, err)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Debug("rebuilt file from shards", "key", key)
+
+	return plain.Bytes(), nil
+}
+
+// findShard returns the i-th shard of a file from local storage or the first peer that has it, or nil.
+// Shards from peers must have size bytes, if it is known.
+func (s *FileServer) findShard(ctx context.Context, key string, i int, size int64, peers []p2p.Peer) []byte {
+	if s.store.Has(s.owner(key), shardKey(crypto.HashKey(key), i)) {
+		_, r, err := s.readLocal(ctx, s.owner(key), shardKey(crypto.HashKey(key), i))
+		if err == nil {
+			if rc, ok := r.(io.Closer); ok {
+				defer rc.Close()
+			}
+			if b, err := io.ReadAll(r); err == nil && len(b) >= 8 {
+				return b
+			}
+		}
+	}
+
+	msg := Message{
+		Payload: MessageGetFile{
+			ID:     s.ID,
+			Tenant: tenantOf(key),
+			Token:  s.peerToken(),
+			Key:    shardKey(crypto.HashKey(key), i),
+		},
+	}
+
+	// Shard i was placed on the i-th peer in line, so start there
+	for j := range peers {
+		peer := peers[(i-1+j+len(peers))%len(peers)]
+		b, err := s.fetchShard(ctx, peer, &msg, size)
+		if err != nil {
+			s.logger.Warn("fetching shard failed", "key", key, "shard", i, "peer", peer.RemoteAddr().String(), "err", err)
+			continue
+		}
+		if len(b) >= 8 {
+			return b
+		}
+	}
+	return nil
+}
+
+// fetchShard requests a shard of want bytes from a peer. It returns nil if the peer does not have it.
+// A peer announcing another size is refused before anything is allocated; if want is 0 the shard is read
+// as it arrives, so only the bytes the peer actually sends take memory.
+func (s *FileServer) fetchShard(ctx context.Context, peer p2p.Peer, msg *Message, want int64) (_ []byte, err error) {
+	ctx, span := s.startSpan(ctx, "p2p.fetch_shard", trace.KindClient, "key", msg.Payload.(MessageGetFile).Key, "peer", peer.RemoteAddr().String())
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	st, err := s.openStream(ctx, peer, msg)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	var size int64
+	if err := binary.Read(st, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if size == -1 {
+		return nil, nil
+	}
+	if size < 8 || (want > 0 && size != want) {
+		return nil, fmt.Errorf("%w: shard of %d bytes, expected %d", errBadShard, size, want)
+	}
+
+	r := s.limitReader(ctx, foreground, st)
+	if want == 0 {
+		buf := new(bytes.Buffer)
+		if _, err := io.CopyN(buf, r, size); err != nil {
+			return nil, err
+		}
+		s.observeTransfer("shard_fetch", start)
+		return buf.Bytes(), nil
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	s.observeTransfer("shard_fetch", start)
+	return b, nil
+}
+
+// shardSize returns the ciphertext size most shards agree on
+func shardSize(shards [][]byte) uint64 {
+	votes := make(map[uint64]int)
+	var best uint64
+	for _, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		size := binary.LittleEndian.Uint64(shard)
+		votes[size]++
+		if votes[size] > votes[best] {
+			best = size
+		}
+	}
+	return best
+}
+
+// writeManifest records locally that a file is erasure coded and how
+func (s *FileServer) writeManifest(key string, m shardManifest) error {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(m); err != nil {
+		return err
+	}
+	_, err := s.store.Write(s.owner(key), manifestKey(key), buf)
+	return err
+}
+
+// readManifest returns the manifest of an erasure-coded file stored by this node
+func (s *FileServer) readManifest(key string) (shardManifest, bool) {
+	var m shardManifest
+	if !s.store.Has(s.owner(key), manifestKey(key)) {
+		return m, false
+	}
+	_, r, err := s.store.Read(s.owner(key), manifestKey(key))
+	if err != nil {
+		return m, false
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+
+	if err := gob.NewDecoder(r).Decode(&m); err != nil {
+		return m, false
+	}
+	return m, true
+}
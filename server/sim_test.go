@@ -0,0 +1,206 @@
+// Fault-injection tests for GoVaultFS
+// These tests run clusters of file servers over a simulated network that injects latency, packet loss,
+// connection resets, slow links and partitions, and check the cluster invariants:
+// files keep their replication factor, survive node loss, and membership converges after a heal.
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+)
+
+// simCluster is a set of file servers on a simulated network
+type simCluster struct {
+	t       *testing.T
+	sim     *p2p.SimNetwork
+	servers []*FileServer
+	down    map[int]bool // Servers that were stopped
+}
+
+// newSimCluster starts n file servers with the given replication factor on a simulated network seeded with seed.
+// Every server bootstraps to the first one, and the cluster is stopped at the end of the test.
+// Probes wait longer than a retransmission, so a single lost packet does not get a live node declared dead.
+func newSimCluster(t *testing.T, seed int64, n int, replicationFactor int) *simCluster {
+	t.Logf("simulated network seed %d", seed)
+
+	network := p2p.NewMemNetwork()
+	c := &simCluster{t: t, sim: p2p.NewSimNetwork(seed), down: make(map[int]bool)}
+
+	for i := 0; i < n; i++ {
+		nodes := []string{}
+		if i > 0 {
+			nodes = append(nodes, c.addr(0))
+		}
+
+		mem := p2p.NewMemTransport(p2p.MemTransportOpts{ListenAddr: c.addr(i), Network: network})
+		opts := testServerOpts(t, c.sim.Wrap(mem), nodes...)
+		opts.ReplicationFactor = replicationFactor
+		opts.Gossip.ProbeTimeout = p2p.DefaultRetransmitTimeout + 100*time.Millisecond
+		opts.Gossip.ProbeInterval = 2 * opts.Gossip.ProbeTimeout
+		opts.Gossip.SuspicionTimeout = 2 * time.Second
+
+		s := NewFileServer(opts)
+		mem.OnPeer = s.OnPeer
+		mem.OnPeerDisconnect = s.OnPeerDisconnect
+		c.servers = append(c.servers, s)
+	}
+	t.Cleanup(func() { stopServers(c.servers...) })
+
+	// The others can only bootstrap once the first server listens
+	go c.servers[0].Start()
+	probe := p2p.NewMemTransport(p2p.MemTransportOpts{ListenAddr: c.addr(n), Network: network})
+	waitFor(t, 5*time.Second, func() bool { return probe.Dial(c.addr(0)) == nil })
+	probe.Close()
+
+	for _, s := range c.servers[1:] {
+		go s.Start()
+	}
+	c.waitConverged()
+
+	return c
+}
+
+// addr returns the listen address of the i-th server
+func (c *simCluster) addr(i int) string {
+	return fmt.Sprintf(":%d", 3000+i)
+}
+
+// stop stops the i-th server
+func (c *simCluster) stop(i int) {
+	c.servers[i].Stop()
+	c.down[i] = true
+}
+
+// waitConverged waits until every running server sees exactly the running servers as alive
+// and holds a connection to each of them
+func (c *simCluster) waitConverged() {
+	c.t.Helper()
+	waitFor(c.t, 20*time.Second, func() bool {
+		for i, s := range c.servers {
+			for j, peer := range c.servers {
+				if i == j || c.down[i] || c.down[j] {
+					continue
+				}
+				if m, ok := s.members.Get(peer.ID); !ok || m.State != MemberAlive {
+					return false
+				}
+				if _, ok := s.memberPeer(peer.ID); !ok {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
+
+// copies counts the copies of a file stored by writer across the running servers, including the writer's own
+func (c *simCluster) copies(writer *FileServer, key string) int {
+	n := 0
+	if writer.store.Has(writer.ID, key) {
+		n++
+	}
+	for i, s := range c.servers {
+		if s != writer && !c.down[i] && s.store.Has(writer.ID, crypto.HashKey(key)) {
+			n++
+		}
+	}
+	return n
+}
+
+// checkReadable deletes the writer's local copies and fetches every file back from the network
+func (c *simCluster) checkReadable(writer *FileServer, files map[string][]byte) {
+	c.t.Helper()
+	for key, want := range files {
+		if err := writer.store.Delete(writer.ID, key); err != nil {
+			c.t.Fatal(err)
+		}
+		r, err := writer.Get(key)
+		if err != nil {
+			c.t.Errorf("file %s lost: %s", key, err)
+			continue
+		}
+		got, _ := io.ReadAll(r)
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+		if !bytes.Equal(got, want) {
+			c.t.Errorf("corrupt content for %s", key)
+		}
+	}
+}
+
+// storeFiles stores count files on writer and checks that each reached the replication factor
+func (c *simCluster) storeFiles(writer *FileServer, prefix string, count int) map[string][]byte {
+	c.t.Helper()
+	files := make(map[string][]byte)
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("%s_%d", prefix, i)
+		data := bytes.Repeat([]byte(key), 500)
+		if err := writer.Store(key, bytes.NewReader(data)); err != nil {
+			c.t.Fatal(err)
+		}
+		if n := c.copies(writer, key); n < writer.ReplicationFactor {
+			c.t.Fatalf("file %s has %d copies, want %d", key, n, writer.ReplicationFactor)
+		}
+		files[key] = data
+	}
+	return files
+}
+
+// TestSimLossyNetwork stores files over a network with latency, packet loss, a slow node and
+// a node whose links flap, then stops a replica holder and checks that no file was lost.
+func TestSimLossyNetwork(t *testing.T) {
+	t.Parallel()
+
+	c := newSimCluster(t, 1, 5, 3)
+	c.sim.SetLatency(time.Millisecond, 2*time.Millisecond)
+	c.sim.SetDropRate(0.02)
+	c.sim.SetSlow(c.addr(4), 256<<10)
+
+	writer := c.servers[0]
+	files := c.storeFiles(writer, "before", 10)
+
+	// Links of one node flap in the middle of the run
+	c.sim.Reset(c.addr(2))
+	for key, data := range c.storeFiles(writer, "after", 10) {
+		files[key] = data
+	}
+	c.waitConverged()
+
+	// Every file has two remote replicas, so losing any one node loses no data
+	c.stop(1)
+	c.waitConverged()
+	c.checkReadable(writer, files)
+}
+
+// TestSimPartitionHeal partitions a cluster, keeps writing on the majority side,
+// and checks that membership converges again after the partition heals.
+func TestSimPartitionHeal(t *testing.T) {
+	t.Parallel()
+
+	c := newSimCluster(t, 2, 5, 3)
+	c.sim.Partition([]string{c.addr(0), c.addr(1)}, []string{c.addr(2), c.addr(3), c.addr(4)})
+
+	// Each side declares the other one dead
+	waitFor(t, 20*time.Second, func() bool {
+		return countMembers(c.servers[0], MemberDead) == 3 && countMembers(c.servers[4], MemberDead) == 2
+	})
+
+	// The majority side can still place every replica, the minority side cannot
+	files := c.storeFiles(c.servers[2], "partitioned", 5)
+	if err := c.servers[0].Store("minority", bytes.NewReader([]byte("data"))); err == nil {
+		t.Error("expected store on the minority side to miss the replication factor")
+	}
+
+	c.sim.Heal()
+	c.waitConverged()
+
+	c.checkReadable(c.servers[2], files)
+	c.storeFiles(c.servers[0], "healed", 5)
+}
@@ -0,0 +1,259 @@
+// Tenants for GoVaultFS
+// A tenant is a namespace of keys isolated from the node's own and from other tenants, with its own encryption key,
+// quota, replication policy and S3 credentials. Inside the node a tenant's keys carry a prefix no client key can
+// have, so the index, versions and cache keep them apart, and its files live under their own owner directory named
+// after the node and the tenant. Messages about a tenant's files name the tenant, so peers store them under that
+// owner too, apply the tenant's quota to them and only serve them to requests for the same tenant.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// tenantPrefix starts the keys of tenants inside the node. Front ends refuse keys containing it.
+const tenantPrefix = "\x00"
+
+// ErrNoSuchTenant is returned for a tenant the node is not configured with
+var ErrNoSuchTenant = errors.New("no such tenant")
+
+// errReservedKey is returned by front ends for keys that could reach into a tenant
+var errReservedKey = errors.New("key contains a NUL byte")
+
+// errBadDestination is returned for a WebDAV Destination header that is not a valid escaped path
+var errBadDestination = errors.New("invalid Destination header")
+
+// tenantNameRe matches valid tenant names, which become part of directory names
+var tenantNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Tenant configures a namespace isolated from the node's own objects and from other tenants.
+// Every node of a cluster should know the same tenants, so peers apply the same quota.
+type Tenant struct {
+	Name              string            // Lower case letters, digits and dashes
+	EncKey            []byte            // Encrypts the tenant's replicas and shards, the node's key if nil
+	Quota             int64             // Bytes stored for the tenant on each node, 0 for no limit
+	ReplicationFactor int               // Copies of each file, the node's factor if 0
+	Policy            *StoragePolicy    // Storage policy of every key, the node's policies if nil
+	AccessKeys        map[string]string // Secret key by access key ID, for the S3 API
+}
+
+// Vault is the view of a tenant's objects
+type Vault struct {
+	server *FileServer
+	tenant string
+}
+
+// Tenant returns the objects of a tenant, or ErrNoSuchTenant
+func (s *FileServer) Tenant(name string) (*Vault, error) {
+	if _, ok := s.tenants[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchTenant, name)
+	}
+	return &Vault{server: s, tenant: name}, nil
+}
+
+// Store saves a file of the tenant
+func (v *Vault) Store(key string, r io.Reader) error {
+	return v.StoreContext(context.Background(), key, r)
+}
+
+// StoreContext is Store as part of the operation in ctx
+func (v *Vault) StoreContext(ctx context.Context, key string, r io.Reader) error {
+	return v.scrub(v.server.StoreContext(ctx, tenantKey(v.tenant, key), r))
+}
+
+// StoreIf saves a file of the tenant if the latest version of key meets the condition
+func (v *Vault) StoreIf(key string, r io.Reader, cond Precondition) error {
+	return v.scrub(v.server.StoreIf(tenantKey(v.tenant, key), r, cond))
+}
+
+// Get retrieves a file of the tenant
+func (v *Vault) Get(key string) (io.Reader, error) {
+	return v.GetContext(context.Background(), key)
+}
+
+// GetContext is Get as part of the operation in ctx
+func (v *Vault) GetContext(ctx context.Context, key string) (io.Reader, error) {
+	r, err := v.server.GetContext(ctx, tenantKey(v.tenant, key))
+	return r, v.scrub(err)
+}
+
+// Delete removes a file of the tenant with all its versions
+func (v *Vault) Delete(key string) error {
+	return v.scrub(v.server.Delete(tenantKey(v.tenant, key)))
+}
+
+// Stat returns the info of an object of the tenant
+func (v *Vault) Stat(key string) (ObjectInfo, error) {
+	info, err := v.server.Stat(tenantKey(v.tenant, key))
+	info.Key = key
+	return info, v.scrub(err)
+}
+
+// List returns the objects of the tenant like FileServer.List
+func (v *Vault) List(prefix string, after string, limit int) []ObjectInfo {
+	ns := tenantKey(v.tenant, "")
+	if len(after) > 0 {
+		after = ns + after
+	}
+	objects := v.server.List(ns+prefix, after, limit)
+	for i := range objects {
+		objects[i].Key = untenant(objects[i].Key)
+	}
+	return objects
+}
+
+// scrub removes the tenant prefix from the keys in an error
+func (v *Vault) scrub(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &tenantError{err: err}
+}
+
+// tenantError is an error about a tenant's key, described with the key the tenant knows
+type tenantError struct {
+	err error
+}
+
+// Error describes the error without the tenant prefix
+func (e *tenantError) Error() string {
+	return untenant(e.err.Error())
+}
+
+// Unwrap returns the error
+func (e *tenantError) Unwrap() error {
+	return e.err
+}
+
+// tenantKey returns the key a tenant's key has inside the node
+func tenantKey(tenant string, key string) string {
+	if len(tenant) == 0 {
+		return key
+	}
+	return tenantPrefix + tenant + "/" + key
+}
+
+// untenantRe matches the tenant prefixes in a text
+var untenantRe = regexp.MustCompile("\x00[a-z0-9-]*/")
+
+// untenant returns a key inside the node, or a text naming such keys, as the tenants know them
+func untenant(s string) string {
+	return untenantRe.ReplaceAllString(s, "")
+}
+
+// tenantOf returns the tenant of a key inside the node, "" for the node's own keys
+func tenantOf(key string) string {
+	rest, ok := strings.CutPrefix(key, tenantPrefix)
+	if !ok {
+		return ""
+	}
+	tenant, _, _ := strings.Cut(rest, "/")
+	return tenant
+}
+
+// ownerID returns the owner directory of the files a node stores for a tenant, the node's ID for its own files
+func ownerID(node string, tenant string) string {
+	if len(tenant) == 0 {
+		return node
+	}
+	return node + "." + tenant
+}
+
+// peerOwner returns the owner directory of the files a peer's message is about. The node ID and tenant come from
+// the peer and name a directory, so the ID must be a plain name and the tenant one this node knows.
+func (s *FileServer) peerOwner(node string, tenant string) (string, error) {
+	if len(node) == 0 || node == cacheID || node == multipartID || strings.ContainsAny(node, "./\\") {
+		return "", fmt.Errorf("invalid node ID %q", node)
+	}
+	if len(tenant) > 0 {
+		if _, ok := s.tenants[tenant]; !ok || !tenantNameRe.MatchString(tenant) {
+			return "", fmt.Errorf("%w: %q", ErrNoSuchTenant, tenant)
+		}
+	}
+	return ownerID(node, tenant), nil
+}
+
+// ownerTenant returns the tenant of an owner directory
+func ownerTenant(owner string) string {
+	i := strings.LastIndex(owner, ".")
+	if i < 0 {
+		return ""
+	}
+	return owner[i+1:]
+}
+
+// owner returns the owner directory of a key stored through this node
+func (s *FileServer) owner(key string) string {
+	return ownerID(s.ID, tenantOf(key))
+}
+
+// encKey returns the key that encrypts the replicas and shards of a key
+func (s *FileServer) encKey(key string) []byte {
+	if t, ok := s.tenants[tenantOf(key)]; ok && len(t.EncKey) > 0 {
+		return t.EncKey
+	}
+	return s.EncKey
+}
+
+// tenantAccessKey returns the tenant an S3 access key belongs to and its secret
+func (s *FileServer) tenantAccessKey(id string) (tenant string, secret string, ok bool) {
+	for name, t := range s.tenants {
+		if secret, ok := t.AccessKeys[id]; ok {
+			return name, secret, true
+		}
+	}
+	return "", "", false
+}
+
+// tenantUsage returns the bytes stored for a tenant on this node and held for writes in progress and multipart uploads.
+// The caller holds quotaLock.
+func (s *FileServer) tenantUsage(tenant string) int64 {
+	var used int64
+	for owner, n := range s.store.Usages() {
+		if ownerTenant(owner) == tenant {
+			used += n
+		}
+	}
+	for _, held := range []map[string]int64{s.reserved, s.staged} {
+		for owner, n := range held {
+			if ownerTenant(owner) == tenant {
+				used += n
+			}
+		}
+	}
+	return used
+}
+
+// validKey returns errReservedKey for a key from a client that could reach into a tenant
+func validKey(key string) error {
+	if strings.Contains(key, tenantPrefix) {
+		return errReservedKey
+	}
+	return nil
+}
+
+// validRequest returns errReservedKey for an HTTP request naming a key that could reach into a tenant,
+// in its path, its query or a WebDAV Destination header, and errBadDestination if that header cannot be unescaped
+func validRequest(r *http.Request) error {
+	if err := validKey(r.URL.Path); err != nil {
+		return err
+	}
+	for _, values := range r.URL.Query() {
+		for _, v := range values {
+			if err := validKey(v); err != nil {
+				return err
+			}
+		}
+	}
+	dest, err := url.PathUnescape(r.Header.Get("Destination"))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBadDestination, err)
+	}
+	return validKey(dest)
+}
@@ -0,0 +1,174 @@
+// Tests for tenants
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTenants checks that tenants keep their objects apart from the node's and from each other's, on peers too,
+// that peers refuse files of tenants they do not know, that their replicas are encrypted with their own key and count against their own quota, and that S3 requests
+// signed with a tenant's access key only reach the tenant's buckets
+func TestTenants(t *testing.T) {
+	t.Parallel()
+
+	acmeKey := crypto.NewEncryptionKey()
+	tenants := []Tenant{
+		{Name: "acme", EncKey: acmeKey, Quota: 4096, AccessKeys: map[string]string{"ACMEKEY": "acme-secret"}},
+		{Name: "globex"},
+	}
+	network := p2p.NewMemNetwork()
+	withTenants := func(opts *FileServerOpts) { opts.Tenants = tenants }
+	s1 := newTestServer(t, network, ":3000", withTenants)
+	s2 := newTestServer(t, network, ":4000", withTenants, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	read := func(r io.Reader, err error) string {
+		assert.Nil(t, err)
+		if err != nil {
+			return ""
+		}
+		b, _ := io.ReadAll(r)
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+		return string(b)
+	}
+
+	_, err := s2.Tenant("initech")
+	assert.ErrorIs(t, err, ErrNoSuchTenant)
+	acme, err := s2.Tenant("acme")
+	assert.Nil(t, err)
+	globex, _ := s2.Tenant("globex")
+
+	// The same key holds a different object in every namespace
+	assert.Nil(t, s2.Store("report", bytes.NewReader([]byte("node"))))
+	assert.Nil(t, acme.Store("report", bytes.NewReader([]byte("acme"))))
+	assert.Nil(t, globex.Store("report", bytes.NewReader([]byte("globex"))))
+	assert.Equal(t, "node", read(s2.Get("report")))
+	assert.Equal(t, "acme", read(acme.Get("report")))
+	assert.Equal(t, "globex", read(globex.Get("report")))
+	assert.Len(t, s2.List("", "", 0), 1)
+	objects := acme.List("", "", 0)
+	if assert.Len(t, objects, 1) {
+		assert.Equal(t, "report", objects[0].Key)
+	}
+	_, err = acme.Get("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NotContains(t, err.Error(), tenantPrefix)
+
+	// Peers keep a tenant's replicas apart, encrypted with the tenant's key
+	hash := crypto.HashKey(tenantKey("acme", "report"))
+	assert.True(t, s1.store.Has(ownerID(s2.ID, "acme"), hash))
+	assert.False(t, s1.store.Has(s2.ID, hash))
+	_, r, err := s1.store.Read(ownerID(s2.ID, "acme"), hash)
+	if assert.Nil(t, err) {
+		plain := new(bytes.Buffer)
+		_, err = crypto.CopyDecrypt(acmeKey, r, plain)
+		r.(io.Closer).Close()
+		assert.Nil(t, err)
+		assert.Equal(t, "acme", plain.String())
+	}
+	assert.Nil(t, s2.store.Delete(ownerID(s2.ID, "acme"), tenantKey("acme", "report")))
+	assert.Equal(t, "acme", read(acme.Get("report"))) // Fetched from s1
+
+	// Peers only store files for the tenants they know, under plain directory names
+	peer, _ := s2.memberPeer(s1.ID)
+	for _, tenant := range []string{"initech", "../acme", "ACME"} {
+		msg := Message{Payload: MessageStoreFile{ID: s2.ID, Tenant: tenant, Key: hash, Size: 4}}
+		_, _, err := s2.openStoreStream(context.Background(), peer, &msg)
+		assert.ErrorIs(t, err, ErrAccessDenied, tenant)
+	}
+	msg := Message{Payload: MessageStoreFile{ID: "../" + s2.ID, Key: hash, Size: 4}}
+	_, _, err = s2.openStoreStream(context.Background(), peer, &msg)
+	assert.ErrorIs(t, err, ErrAccessDenied)
+
+	// A tenant's quota counts only its own files
+	err = acme.Store("big", bytes.NewReader(make([]byte, 5000)))
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.NotContains(t, err.Error(), tenantPrefix)
+	assert.Nil(t, globex.Store("big", bytes.NewReader(make([]byte, 5000))))
+	assert.Nil(t, s2.Store("big", bytes.NewReader(make([]byte, 5000))))
+
+	// Deleting a tenant's object leaves the others alone
+	assert.Nil(t, acme.Delete("report"))
+	waitFor(t, 5*time.Second, func() bool { return !s1.store.Has(ownerID(s2.ID, "acme"), hash) })
+	assert.Equal(t, "globex", read(globex.Get("report")))
+	assert.Equal(t, "node", read(s2.Get("report")))
+
+	// Clients of the node's own APIs cannot name a tenant's keys
+	gw := httptest.NewServer(NewGateway(s2))
+	defer gw.Close()
+	resp, err := http.Get(gw.URL + "/objects/%00globex/report")
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// S3 requests signed with a tenant's access key work in the tenant's buckets
+	ts := httptest.NewServer(NewS3Server(s2, S3Opts{AccessKeys: map[string]string{testAccessKey: testSecretKey}}))
+	defer ts.Close()
+	acmeDo := func(method string, path string, body []byte) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		signS3As(req, body, "ACMEKEY", "acme-secret")
+		return send(t, req)
+	}
+	assert.Equal(t, http.StatusOK, acmeDo(http.MethodPut, "/photos", nil).StatusCode)
+	assert.Equal(t, http.StatusOK, acmeDo(http.MethodPut, "/photos/a.png", []byte("png")).StatusCode)
+	assert.Equal(t, "png", read(acme.Get("photos/a.png")))
+
+	var buckets listAllMyBucketsResult
+	assert.Nil(t, xml.NewDecoder(acmeDo(http.MethodGet, "/", nil).Body).Decode(&buckets))
+	if assert.Len(t, buckets.Buckets, 1) {
+		assert.Equal(t, "photos", buckets.Buckets[0].Name)
+	}
+	assert.Equal(t, http.StatusNotFound, s3Do(t, http.MethodHead, ts.URL+"/photos", nil).StatusCode)
+	assert.Equal(t, http.StatusOK, s3Do(t, http.MethodPut, ts.URL+"/photos", nil).StatusCode) // The node's own bucket
+	assert.Equal(t, http.StatusNotFound, s3Do(t, http.MethodGet, ts.URL+"/photos/a.png", nil).StatusCode)
+}
+
+// TestTenantQuotaOnPeers checks that a peer counts the replicas it receives for a tenant against the tenant's quota
+func TestTenantQuotaOnPeers(t *testing.T) {
+	t.Parallel()
+
+	tenants := []Tenant{{Name: "acme", Quota: 4096}}
+	network := p2p.NewMemNetwork()
+	withTenants := func(opts *FileServerOpts) { opts.Tenants = tenants }
+	s1 := newTestServer(t, network, ":3000", withTenants)
+	s2 := newTestServer(t, network, ":4000", withTenants, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	acme, _ := s1.Tenant("acme")
+	assert.Nil(t, acme.Store("first", bytes.NewReader(make([]byte, 3000))))
+
+	// s1 has room for another 2000 bytes of its own, but not of the tenant's
+	peer, ok := s2.memberPeer(s1.ID)
+	assert.True(t, ok)
+	key := crypto.HashKey(tenantKey("acme", "second"))
+	msg := Message{Payload: MessageStoreFile{ID: s2.ID, Tenant: "acme", Key: key, Size: 2000}}
+	_, reply, err := s2.openStoreStream(context.Background(), peer, &msg)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Equal(t, refusedQuota, reply.Refused)
+	assert.False(t, s1.store.Has(ownerID(s2.ID, "acme"), key))
+
+	// Without a tenant the replica fits
+	msg = Message{Payload: MessageStoreFile{ID: s2.ID, Key: key, Size: 2000}}
+	st, _, err := s2.openStoreStream(context.Background(), peer, &msg)
+	if assert.Nil(t, err) {
+		st.Close()
+	}
+}
@@ -0,0 +1,81 @@
+// Tracing of file server operations
+// Store, Get and Delete are traced with their store I/O, crypto and peer requests as child spans. Messages that
+// start work on a peer carry the traceparent of the span that sent them, so the peer's handler joins the same trace.
+package server
+
+import (
+	"context"
+	"io"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/trace"
+)
+
+// startSpan starts a span of this node with attrs given as key-value pairs, like slog.
+// It does nothing if tracing is disabled.
+func (s *FileServer) startSpan(ctx context.Context, name string, kind trace.Kind, attrs ...any) (context.Context, *trace.Span) {
+	ctx, span := s.Tracer.Start(ctx, name, kind)
+	if span == nil {
+		return ctx, nil
+	}
+	span.SetAttr("node", shortID(s.ID))
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if k, ok := attrs[i].(string); ok {
+			span.SetAttr(k, attrs[i+1])
+		}
+	}
+	return ctx, span
+}
+
+// endSpan records err, if any, on span and ends it
+func endSpan(span *trace.Span, err error) {
+	span.RecordError(err)
+	span.End()
+}
+
+// traced returns a copy of msg carrying the span context of ctx, so the peer handling it joins the trace
+func traced(ctx context.Context, msg *Message) *Message {
+	m := *msg
+	m.Trace = trace.SpanContextFromContext(ctx).Traceparent()
+	return &m
+}
+
+// messageContext returns the context to handle msg in, a child of the span that sent it if it was traced
+func messageContext(msg *Message) context.Context {
+	ctx := context.Background()
+	if len(msg.Trace) == 0 {
+		return ctx
+	}
+	if sc, err := trace.ParseTraceparent(msg.Trace); err == nil {
+		ctx = trace.ContextWithRemote(ctx, sc)
+	}
+	return ctx
+}
+
+// readLocal opens a stored file within a "store.read" span
+func (s *FileServer) readLocal(ctx context.Context, id, key string) (int64, io.Reader, error) {
+	_, span := s.startSpan(ctx, "store.read", trace.KindInternal, "key", key)
+	n, r, err := s.store.Read(id, key)
+	span.SetAttr("bytes", n)
+	endSpan(span, err)
+	return n, r, err
+}
+
+// writeLocal writes a file to local storage within a "store.write" span
+func (s *FileServer) writeLocal(ctx context.Context, id, key string, r io.Reader) (int64, error) {
+	_, span := s.startSpan(ctx, "store.write", trace.KindInternal, "key", key)
+	n, err := s.store.Write(id, key, r)
+	span.SetAttr("bytes", n)
+	endSpan(span, err)
+	return n, err
+}
+
+// traceparentHeader carries the span context of a client, in HTTP headers and gRPC metadata
+const traceparentHeader = "traceparent"
+
+// startRequestSpan starts the server span of an API request, a child of the client's span if it sent a traceparent
+func (s *FileServer) startRequestSpan(ctx context.Context, traceparent string, name string, attrs ...any) (context.Context, *trace.Span) {
+	if sc, err := trace.ParseTraceparent(traceparent); err == nil {
+		ctx = trace.ContextWithRemote(ctx, sc)
+	}
+	return s.startSpan(ctx, name, trace.KindServer, attrs...)
+}
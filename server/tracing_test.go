@@ -0,0 +1,110 @@
+// Tests for tracing of file operations across nodes
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/AnshSinghSonkhia/GoVaultFS/trace"
+	"github.com/stretchr/testify/assert"
+)
+
+// findSpan returns the first span with the given name and node, or nil
+func findSpan(spans []trace.SpanData, name string, node string) *trace.SpanData {
+	for i := range spans {
+		if spans[i].Name == name && spans[i].Attributes["node"] == node {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+// TestTracing checks that the spans of a request on one node and of the peer requests it makes
+// form a single trace, joined to the trace of the client
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	exp := trace.NewInMemoryExporter()
+	traced := func(opts *FileServerOpts) { opts.Tracer = trace.NewTracer("govault", exp) }
+	s1 := newTestServer(t, network, ":3000", traced)
+	s2 := newTestServer(t, network, ":4000", traced, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	ts := httptest.NewServer(s2.logRequests("HTTP gateway", NewGateway(s2)))
+	defer ts.Close()
+	n1, n2 := shortID(s1.ID), shortID(s2.ID)
+
+	// The store joins the client's trace and the replica is written in a child span on s1
+	client := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/objects/a.txt", strings.NewReader("hello"))
+	req.Header.Set(traceparentHeader, client)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	waitFor(t, time.Second, func() bool { return findSpan(exp.Spans(), "HTTP gateway PUT", n2) != nil })
+	spans := exp.Spans()
+	api := findSpan(spans, "HTTP gateway PUT", n2)
+	store := findSpan(spans, "FileServer.Store", n2)
+	replicate := findSpan(spans, "p2p.replicate", n2)
+	handle := findSpan(spans, "handle StoreFile", n1)
+	write := findSpan(spans, "store.write", n1)
+	if assert.NotNil(t, api) && assert.NotNil(t, store) && assert.NotNil(t, replicate) && assert.NotNil(t, handle) && assert.NotNil(t, write) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", api.TraceID.String())
+		assert.Equal(t, "00f067aa0ba902b7", api.ParentSpanID.String())
+		assert.Equal(t, trace.KindServer, api.Kind)
+		assert.Equal(t, http.StatusCreated, api.Attributes["status"])
+		assert.Equal(t, api.SpanID, store.ParentSpanID)
+		assert.Equal(t, store.SpanID, replicate.ParentSpanID)
+		assert.Equal(t, replicate.SpanID, handle.ParentSpanID)
+		assert.Equal(t, handle.SpanID, write.ParentSpanID)
+		for _, d := range []*trace.SpanData{store, replicate, handle, write} {
+			assert.Equal(t, api.TraceID, d.TraceID, d.Name)
+		}
+		assert.NotNil(t, findSpan(spans, "crypto.encrypt", n2))
+	}
+
+	// A get of a file only s1 has makes s1's handler a child of s2's fetch
+	exp.Reset()
+	assert.Nil(t, s2.store.Delete(s2.ID, "a.txt"))
+	r, err := s2.Get("a.txt")
+	assert.Nil(t, err)
+	b, _ := io.ReadAll(r)
+	assert.Equal(t, "hello", string(b))
+
+	waitFor(t, time.Second, func() bool { return findSpan(exp.Spans(), "handle GetFile", n1) != nil })
+	spans = exp.Spans()
+	get := findSpan(spans, "FileServer.Get", n2)
+	fetch := findSpan(spans, "p2p.fetch", n2)
+	decrypt := findSpan(spans, "crypto.decrypt", n2)
+	remote := findSpan(spans, "handle GetFile", n1)
+	if assert.NotNil(t, get) && assert.NotNil(t, fetch) && assert.NotNil(t, decrypt) && assert.NotNil(t, remote) {
+		assert.Equal(t, trace.SpanID{}, get.ParentSpanID)
+		assert.Equal(t, "network", get.Attributes["source"])
+		assert.Equal(t, get.SpanID, fetch.ParentSpanID)
+		assert.Equal(t, trace.KindClient, fetch.Kind)
+		assert.Equal(t, fetch.SpanID, decrypt.ParentSpanID)
+		assert.Equal(t, fetch.SpanID, remote.ParentSpanID)
+		assert.Equal(t, get.TraceID, remote.TraceID)
+		assert.Equal(t, trace.KindServer, remote.Kind)
+		assert.Equal(t, "unset", remote.Status.Code)
+		assert.NotNil(t, findSpan(spans, "store.read", n1))
+	}
+
+	// Failures are recorded on the span
+	exp.Reset()
+	_, err = s2.Get("missing.txt")
+	assert.NotNil(t, err)
+	if get := findSpan(exp.Spans(), "FileServer.Get", n2); assert.NotNil(t, get) {
+		assert.Equal(t, "error", get.Status.Code)
+		assert.Equal(t, err.Error(), get.Status.Message)
+	}
+}
@@ -0,0 +1,309 @@
+// Object versioning for GoVaultFS
+// Every Store creates a new immutable version of its key, identified by an ID that sorts in the order versions were
+// stored. The latest version lives under the key itself, so reads, replication and deletes of the latest work as
+// before. When a new version arrives, the one it replaces is moved aside under a version key on this node and on
+// its peers, from where GetVersion reads it and RestoreVersion copies it back. Retention bounds how many older
+// versions are kept and for how long.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/AnshSinghSonkhia/GoVaultFS/trace"
+)
+
+const (
+	DefaultKeepVersions = 10 // Versions kept per key including the latest when VersionOpts.Keep is 0
+	KeepAllVersions     = -1 // VersionOpts.Keep that keeps every version
+
+	// archiveTimeout bounds the wait for a peer to confirm it moved a file, unless the operation ends sooner
+	archiveTimeout = 10 * time.Second
+)
+
+// VersionOpts are the retention rules of older object versions
+type VersionOpts struct {
+	Keep   int           // Versions kept per key including the latest, DefaultKeepVersions if 0, KeepAllVersions for every version and 1 for no history
+	MaxAge time.Duration // Older versions stored longer ago than this are dropped, 0 keeps them regardless of age
+}
+
+// withDefaults fills in the number of versions kept if it is not set
+func (o VersionOpts) withDefaults() VersionOpts {
+	if o.Keep == 0 {
+		o.Keep = DefaultKeepVersions
+	}
+	return o
+}
+
+// MessageArchiveFile asks a peer to move its replica or shards of a file to another key, before a new version
+// of the file takes its place or when the file is renamed. The peer confirms on the request's stream with the
+// number of files it moved.
+type MessageArchiveFile struct {
+	ID     string // Node ID
+	Tenant string // Tenant of the file, empty for the node's own files
+	Token  string // Token of the node, which must allow OpWrite if access control is on
+	Key    string // File hash
+	To     string // Hash of the key the file moves to
+	Shards int    // Number of erasure-coded shards, 0 for a replicated file
+}
+
+// newVersionID returns a unique version ID that sorts by the time it was created
+func newVersionID(t time.Time) string {
+	b := binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+	b = append(b, make([]byte, 4)...)
+	rand.Read(b[8:])
+	return hex.EncodeToString(b)
+}
+
+// versionKey names an older version of a file in local storage
+func versionKey(key string, id string) string {
+	return key + ".version-" + id
+}
+
+// versioning returns the current retention rules
+func (s *FileServer) versioning() VersionOpts {
+	s.settingsLock.RLock()
+	defer s.settingsLock.RUnlock()
+	return s.Versions.withDefaults()
+}
+
+// GetVersion retrieves a version of a file, the latest or an older one
+func (s *FileServer) GetVersion(key string, id string) (io.Reader, error) {
+	return s.GetVersionContext(context.Background(), key, id)
+}
+
+// GetVersionContext is GetVersion as part of the operation in ctx
+func (s *FileServer) GetVersionContext(ctx context.Context, key string, id string) (_ io.Reader, err error) {
+	ctx, span := s.startSpan(ctx, "FileServer.GetVersion", trace.KindInternal, "key", key, "version", id)
+	defer func() { endSpan(span, err) }()
+
+	if latest, ok := s.index.get(key); ok && latest.Version == id {
+		return s.get(ctx, span, key, s.policyFor(key))
+	}
+	if _, err := s.StatVersion(key, id); err != nil {
+		return nil, err
+	}
+	return s.get(ctx, span, versionKey(key, id), s.policyFor(key))
+}
+
+// StatVersion returns the info of a version of an object stored through this node
+func (s *FileServer) StatVersion(key string, id string) (ObjectInfo, error) {
+	info, ok := s.index.version(key, id)
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("%w: version %s of %s", ErrNotFound, id, key)
+	}
+	return info, nil
+}
+
+// ListVersions returns every version kept of an object stored through this node, the latest first
+func (s *FileServer) ListVersions(key string) ([]ObjectInfo, error) {
+	versions := s.index.history(key)
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return versions, nil
+}
+
+// RestoreVersion makes an older version of a file the latest again by storing its content as a new version.
+// The versions in between are kept.
+func (s *FileServer) RestoreVersion(key string, id string) error {
+	return s.RestoreVersionContext(context.Background(), key, id)
+}
+
+// RestoreVersionContext is RestoreVersion as part of the operation in ctx
+func (s *FileServer) RestoreVersionContext(ctx context.Context, key string, id string) error {
+	r, err := s.GetVersionContext(ctx, key, id)
+	if err != nil {
+		return err
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	return s.StoreContext(ctx, key, r)
+}
+
+// archiveLatest moves the latest version of a file aside before a new version is stored, if retention keeps
+// older versions or keep is set. It returns the version moved, which the caller moves back with unarchive
+// if the store fails.
+func (s *FileServer) archiveLatest(ctx context.Context, key string, keep bool) (ObjectInfo, bool, error) {
+	prev, ok := s.index.get(key)
+	if !ok || (s.versioning().Keep == 1 && !keep) {
+		return prev, false, nil
+	}
+	if err := s.moveFile(ctx, key, versionKey(key, prev.Version)); err != nil {
+		return prev, false, err
+	}
+	return prev, true, nil
+}
+
+// unarchive moves an archived version back in place of the latest after a failed store
+func (s *FileServer) unarchive(ctx context.Context, key string, prev ObjectInfo) {
+	if err := s.moveFile(ctx, versionKey(key, prev.Version), key); err != nil {
+		s.logger.Error("restoring previous version failed", "key", key, "version", prev.Version, "err", err)
+	}
+}
+
+// moveFile moves the local copy or shards of a file to another key, and asks every peer to do the same
+// with its replica or shards. Peers that cannot be reached or do not confirm in time keep theirs under the old key.
+// If the local files cannot be moved, none are and no peer is asked.
+func (s *FileServer) moveFile(ctx context.Context, from string, to string) (err error) {
+	ctx, span := s.startSpan(ctx, "FileServer.move", trace.KindInternal, "key", from, "to", to)
+	defer func() { endSpan(span, err) }()
+
+	shards := 0
+	if m, ok := s.readManifest(from); ok {
+		shards = m.DataShards + m.ParityShards
+		if err := s.renameLocal(manifestKey(from), manifestKey(to)); err != nil {
+			return err
+		}
+		if err := s.renameLocal(shardKey(crypto.HashKey(from), 0), shardKey(crypto.HashKey(to), 0)); err != nil {
+			s.renameLocal(manifestKey(to), manifestKey(from))
+			return err
+		}
+	} else if err := s.renameLocal(from, to); err != nil {
+		return err
+	}
+
+	msg := Message{
+		Payload: MessageArchiveFile{
+			ID:     s.ID,
+			Tenant: tenantOf(from),
+			Token:  s.peerToken(),
+			Key:    crypto.HashKey(from),
+			To:     crypto.HashKey(to),
+			Shards: shards,
+		},
+	}
+
+	// Every peer must have moved its copy before the new version can take its key
+	wg := sync.WaitGroup{}
+	for _, peer := range s.peerList() {
+		wg.Add(1)
+		go func(peer p2p.Peer) {
+			defer wg.Done()
+			if err := s.archiveOnPeer(ctx, peer, &msg); err != nil {
+				s.logger.Warn("moving file on peer failed", "key", from, "peer", peer.RemoteAddr().String(), "err", err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// renameLocal moves a file of this node to another key of the same tenant if it is there
+func (s *FileServer) renameLocal(from string, to string) error {
+	if err := s.store.Rename(s.owner(from), from, to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// archiveOnPeer sends MessageArchiveFile to a peer and waits for it to confirm, for at most archiveTimeout
+// or until ctx ends
+func (s *FileServer) archiveOnPeer(ctx context.Context, peer p2p.Peer, msg *Message) error {
+	st, err := s.openStream(ctx, peer, msg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	deadline := time.Now().Add(archiveTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	st.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { st.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	var moved int64
+	if err := binary.Read(st, binary.LittleEndian, &moved); err != nil {
+		return fmt.Errorf("waiting for the peer to confirm: %w", err)
+	}
+	return nil
+}
+
+// handleMessageArchiveFile moves the replica or shards a peer stored here to another key,
+// then confirms the number of files moved
+func (s *FileServer) handleMessageArchiveFile(ctx context.Context, from string, msg MessageArchiveFile, stream net.Conn) error {
+	_, span := s.startSpan(ctx, "handle ArchiveFile", trace.KindServer, "key", msg.Key, "peer", from)
+	defer span.End()
+
+	if stream == nil {
+		return fmt.Errorf("archive file request from %s without a stream", from)
+	}
+	if err := s.authorizePeer(msg.Token, msg.ID, OpWrite); err != nil {
+		binary.Write(stream, binary.LittleEndian, int64(0))
+		return err
+	}
+	owner, err := s.peerOwner(msg.ID, msg.Tenant)
+	if err != nil {
+		binary.Write(stream, binary.LittleEndian, int64(0))
+		return err
+	}
+
+	moves := map[string]string{msg.Key: msg.To}
+	for i := 0; i < msg.Shards; i++ {
+		moves[shardKey(msg.Key, i)] = shardKey(msg.To, i)
+	}
+
+	var moved int64
+	for key, to := range moves {
+		if !s.store.Has(owner, key) {
+			continue
+		}
+		if err := s.store.Rename(owner, key, to); err != nil {
+			s.logger.Error("moving file failed", "key", key, "err", err)
+			continue
+		}
+		if err := s.replicas.move(owner, key, to); err != nil {
+			s.logger.Error("saving replica versions failed", "err", err)
+		}
+		moved++
+	}
+
+	return binary.Write(stream, binary.LittleEndian, moved)
+}
+
+// pruneVersions drops the older versions of a key that retention no longer keeps
+func (s *FileServer) pruneVersions(ctx context.Context, key string) {
+	opts := s.versioning()
+	cutoff := time.Time{}
+	if opts.MaxAge > 0 {
+		cutoff = time.Now().Add(-opts.MaxAge)
+	}
+
+	pruned, err := s.index.prune(key, opts.Keep, cutoff)
+	if err != nil {
+		s.logger.Error("indexing object failed", "key", key, "err", err)
+	}
+	for _, info := range pruned {
+		s.logger.Debug("dropping old version", "key", key, "version", info.Version)
+		if err := s.dropFile(ctx, versionKey(key, info.Version)); err != nil {
+			s.logger.Warn("dropping old version failed", "key", key, "version", info.Version, "err", err)
+		}
+	}
+}
+
+// pruneAllVersions applies the retention rules to every key with older versions
+func (s *FileServer) pruneAllVersions() {
+	for _, key := range s.index.versioned() {
+		s.pruneVersions(context.Background(), key)
+	}
+}
+
+func init() {
+	gob.Register(MessageArchiveFile{})
+}
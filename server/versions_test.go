@@ -0,0 +1,141 @@
+// Tests for object versioning
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVersions checks that every Store keeps the version it replaces on the node and its peers, that older
+// versions can be read and restored, that a failed Store leaves the latest in place, and that retention and
+// Delete drop old versions everywhere
+func TestVersions(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s1 := newTestServer(t, network, ":3000", nil)
+	s2 := newTestServer(t, network, ":4000", nil, ":3000")
+	defer stopServers(s1, s2)
+
+	startServers(t, s1, s2)
+
+	read := func(r io.Reader, err error) string {
+		assert.Nil(t, err)
+		if err != nil {
+			return ""
+		}
+		b, _ := io.ReadAll(r)
+		if rc, ok := r.(io.Closer); ok {
+			rc.Close()
+		}
+		return string(b)
+	}
+
+	for _, content := range []string{"one", "two", "three"} {
+		assert.Nil(t, s2.Store("doc", bytes.NewReader([]byte(content))))
+	}
+	versions, err := s2.ListVersions("doc")
+	assert.Nil(t, err)
+	assert.Len(t, versions, 3)
+	assert.True(t, versions[0].Version > versions[1].Version && versions[1].Version > versions[2].Version)
+	latest, _ := s2.Stat("doc")
+	assert.Equal(t, versions[0], latest)
+
+	// The latest stays under the key, older versions move aside on both nodes
+	assert.Equal(t, "three", read(s2.Get("doc")))
+	assert.Equal(t, "one", read(s2.GetVersion("doc", versions[2].Version)))
+	assert.True(t, s1.store.Has(s2.ID, crypto.HashKey(versionKey("doc", versions[1].Version))))
+	assert.Nil(t, s2.store.Delete(s2.ID, versionKey("doc", versions[1].Version)))
+	assert.Equal(t, "two", read(s2.GetVersion("doc", versions[1].Version))) // Fetched from s1
+	_, err = s2.GetVersion("doc", "nope")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Restoring stores the old content as a new version
+	assert.Nil(t, s2.RestoreVersion("doc", versions[2].Version))
+	assert.Equal(t, "one", read(s2.Get("doc")))
+	versions, _ = s2.ListVersions("doc")
+	assert.Len(t, versions, 4)
+
+	// A Store that fails puts the previous version back
+	st := s2.Settings()
+	st.Quota.PerOwner = s2.store.Usage(s2.ID) + 2
+	s2.Reload(st)
+	assert.ErrorIs(t, s2.Store("doc", bytes.NewReader([]byte("too long"))), ErrQuotaExceeded)
+	assert.Equal(t, "one", read(s2.Get("doc")))
+	assert.Equal(t, "three", read(s2.GetVersion("doc", versions[1].Version)))
+	after, _ := s2.ListVersions("doc")
+	assert.Equal(t, versions, after)
+
+	// Erasure-coded versions are moved shard by shard
+	st.Quota = QuotaOpts{}
+	s2.Reload(st)
+	policy := StoragePolicy{DataShards: 1, ParityShards: 1}
+	assert.Nil(t, s2.StoreWithPolicy("ec", bytes.NewReader([]byte("first")), policy))
+	assert.Nil(t, s2.StoreWithPolicy("ec", bytes.NewReader([]byte("second")), policy))
+	ec, _ := s2.ListVersions("ec")
+	assert.Nil(t, s2.store.Delete(s2.ID, shardKey(crypto.HashKey(versionKey("ec", ec[1].Version)), 0)))
+	assert.Equal(t, "first", read(s2.GetVersion("ec", ec[1].Version)))
+	assert.Equal(t, "second", read(s2.Get("ec")))
+
+	// Retention drops the oldest versions on reload and on later writes
+	st.Versions = VersionOpts{Keep: 2}
+	s2.Reload(st)
+	waitFor(t, 5*time.Second, func() bool {
+		v, _ := s2.ListVersions("doc")
+		return len(v) == 2
+	})
+	for _, v := range versions[2:] {
+		assert.False(t, s2.store.Has(s2.ID, versionKey("doc", v.Version)))
+		waitFor(t, 5*time.Second, func() bool { return !s1.store.Has(s2.ID, crypto.HashKey(versionKey("doc", v.Version))) })
+	}
+	assert.Nil(t, s2.Store("doc", bytes.NewReader([]byte("five"))))
+	after, _ = s2.ListVersions("doc")
+	assert.Equal(t, versions[0].Version, after[1].Version)
+
+	// Delete drops every version
+	assert.Nil(t, s2.Delete("doc"))
+	_, err = s2.ListVersions("doc")
+	assert.ErrorIs(t, err, ErrNotFound)
+	waitFor(t, 5*time.Second, func() bool { return !s1.store.Has(s2.ID, crypto.HashKey(versionKey("doc", versions[0].Version))) })
+}
+
+// TestVersionsSilentPeer checks that a peer that never confirms moving a version aside only holds up the move
+// until the operation ends, and that retention is bounded unless every version is kept
+func TestVersionsSilentPeer(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s := newTestServer(t, network, ":3000", nil)
+	defer stopServers(s)
+	startServers(t, s)
+	assert.Equal(t, DefaultKeepVersions, s.versioning().Keep)
+
+	assert.Nil(t, s.Store("doc", bytes.NewReader([]byte("one"))))
+	info, _ := s.Stat("doc")
+
+	// A peer that takes requests but never answers them
+	silent := p2p.NewMemTransport(p2p.MemTransportOpts{ListenAddr: ":4000", Network: network})
+	assert.Nil(t, silent.ListenAndAccept())
+	defer silent.Close()
+	assert.Nil(t, s.Transport.Dial(":4000"))
+	waitFor(t, 5*time.Second, func() bool { return len(s.peerList()) == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Nil(t, s.moveFile(ctx, "doc", versionKey("doc", info.Version)))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.True(t, s.store.Has(s.ID, versionKey("doc", info.Version)))
+
+	st := s.Settings()
+	st.Versions = VersionOpts{Keep: KeepAllVersions}
+	s.Reload(st)
+	assert.Equal(t, KeepAllVersions, s.versioning().Keep)
+}
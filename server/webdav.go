@@ -0,0 +1,680 @@
+// WebDAV server for GoVaultFS
+// This file serves the directory namespace over WebDAV (RFC 4918) so the vault can be mounted as a network drive
+// by desktop file managers. Paths map directly to keys: /photos/a.png is the key "photos/a.png", so files stored
+// through the other APIs show up in the tree. PROPFIND always returns the full set of live properties, and
+// PROPPATCH is not supported since the vault keeps no dead properties.
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// davMethods are the methods the WebDAV server supports
+const davMethods = "OPTIONS, PROPFIND, GET, HEAD, PUT, DELETE, MKCOL, COPY, MOVE, LOCK, UNLOCK"
+
+// lockTokenRe finds the lock tokens submitted in an If header
+var lockTokenRe = regexp.MustCompile(`<(opaquelocktoken:[^>]+)>`)
+
+// WebDAV serves the namespace of a file server over WebDAV
+type WebDAV struct {
+	server *FileServer
+	ns     *Namespace
+	locks  *lockManager
+}
+
+// NewWebDAV creates the WebDAV handler for a file server
+func NewWebDAV(s *FileServer) *WebDAV {
+	return &WebDAV{
+		server: s,
+		ns:     s.Namespace(),
+		locks:  newLockManager(),
+	}
+}
+
+// ServeHTTP dispatches a request to the handler of its method (http.Handler interface).
+// Handlers return a status and error to report, or 0 if they wrote the response themselves.
+func (d *WebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := validRequest(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := d.authorize(r); err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			// Desktop clients only know basic auth, so they send the token as the password
+			w.Header().Set("WWW-Authenticate", `Basic realm="govault"`)
+		}
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	var status int
+	var err error
+
+	switch r.Method {
+	case http.MethodOptions:
+		status, err = d.handleOptions(w, r)
+	case "PROPFIND":
+		status, err = d.handlePropfind(w, r)
+	case http.MethodGet, http.MethodHead:
+		status, err = d.handleGet(w, r)
+	case http.MethodPut:
+		status, err = d.handlePut(w, r)
+	case http.MethodDelete:
+		status, err = d.handleDelete(w, r)
+	case "MKCOL":
+		status, err = d.handleMkcol(w, r)
+	case "COPY", "MOVE":
+		status, err = d.handleCopyMove(w, r)
+	case "LOCK":
+		status, err = d.handleLock(w, r)
+	case "UNLOCK":
+		status, err = d.handleUnlock(w, r)
+	default:
+		w.Header().Set("Allow", davMethods)
+		status, err = http.StatusMethodNotAllowed, fmt.Errorf("method %s not supported", r.Method)
+	}
+
+	if status == 0 {
+		return
+	}
+	if status >= http.StatusInternalServerError {
+		d.server.loggerFrom(r.Context()).Error("WebDAV request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+	}
+	if err == nil {
+		err = errors.New(http.StatusText(status))
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// davOps are the operations each method needs on the path of a request
+var davOps = map[string]Op{
+	"PROPFIND":        OpRead,
+	http.MethodGet:    OpRead,
+	http.MethodHead:   OpRead,
+	http.MethodPut:    OpWrite,
+	"MKCOL":           OpWrite,
+	"LOCK":            OpWrite,
+	"UNLOCK":          OpWrite,
+	http.MethodDelete: OpDelete,
+	"COPY":            OpRead,
+	"MOVE":            OpDelete,
+}
+
+// authorize checks the token of a request, sent as a bearer token or as the password of basic auth,
+// for the operation its method needs on its path and, for COPY and MOVE, OpWrite on its destination
+func (d *WebDAV) authorize(r *http.Request) error {
+	op, ok := davOps[r.Method]
+	if !ok || !d.server.AuthEnabled() {
+		return nil
+	}
+
+	token := bearerToken(r.Header.Get("Authorization"))
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	if _, err := d.server.Authorize(token, op, CleanPath(r.URL.Path)); err != nil {
+		return err
+	}
+	if r.Method == "COPY" || r.Method == "MOVE" {
+		if u, err := url.Parse(r.Header.Get("Destination")); err == nil {
+			_, err = d.server.Authorize(token, OpWrite, CleanPath(u.Path))
+			return err
+		}
+	}
+	return nil
+}
+
+// handleOptions advertises WebDAV class 1 and 2 (locking) support
+func (d *WebDAV) handleOptions(w http.ResponseWriter, r *http.Request) (int, error) {
+	w.Header().Set("DAV", "1, 2")
+	w.Header().Set("Allow", davMethods)
+	w.Header().Set("MS-Author-Via", "DAV")
+	w.WriteHeader(http.StatusOK)
+	return 0, nil
+}
+
+// handlePropfind describes a resource and, depending on the Depth header, its children or whole subtree
+func (d *WebDAV) handlePropfind(w http.ResponseWriter, r *http.Request) (int, error) {
+	depth := -1
+	switch r.Header.Get("Depth") {
+	case "0":
+		depth = 0
+	case "1":
+		depth = 1
+	case "", "infinity":
+	default:
+		return http.StatusBadRequest, errors.New("invalid depth")
+	}
+
+	// Every live property is returned, so the body only has to be well formed
+	if b, err := io.ReadAll(r.Body); err != nil {
+		return http.StatusBadRequest, err
+	} else if len(b) > 0 {
+		var propfind struct {
+			XMLName xml.Name `xml:"DAV: propfind"`
+		}
+		if err := xml.Unmarshal(b, &propfind); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	e, err := d.ns.Stat(r.URL.Path)
+	if err != nil {
+		return davStatus(err), err
+	}
+
+	ms := davMultistatus{XMLNS: "DAV:"}
+	if err := d.walk(e, depth, func(e DirEntry) { ms.Responses = append(ms.Responses, d.propResponse(e)) }); err != nil {
+		return davStatus(err), err
+	}
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(ms); err != nil {
+		d.server.loggerFrom(r.Context()).Warn("sending properties failed", "key", e.Path, "err", err)
+	}
+	return 0, nil
+}
+
+// walk visits an entry and its descendants down to depth levels, or all of them for a negative depth
+func (d *WebDAV) walk(e DirEntry, depth int, visit func(DirEntry)) error {
+	visit(e)
+	if !e.IsDir || depth == 0 {
+		return nil
+	}
+
+	entries, err := d.ns.ReadDir(e.Path)
+	if err != nil {
+		return err
+	}
+	for _, child := range entries {
+		if err := d.walk(child, depth-1, visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleGet streams a file. Directories have no content.
+func (d *WebDAV) handleGet(w http.ResponseWriter, r *http.Request) (int, error) {
+	e, err := d.ns.Stat(r.URL.Path)
+	if err != nil {
+		return davStatus(err), err
+	}
+	if e.IsDir {
+		return http.StatusMethodNotAllowed, fmt.Errorf("%w: %s", ErrIsDir, r.URL.Path)
+	}
+
+	w.Header().Set("Content-Type", contentType(e.Path))
+	setObjectHeaders(w, ObjectInfo{Size: e.Size, ETag: e.ETag, ModTime: e.ModTime})
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return 0, nil
+	}
+
+	rd, err := d.server.GetContext(r.Context(), e.Path)
+	if err != nil {
+		return davStatus(err), err
+	}
+	if rc, ok := rd.(io.Closer); ok {
+		defer rc.Close()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, rd); err != nil {
+		d.server.loggerFrom(r.Context()).Warn("sending object failed", "key", e.Path, "err", err)
+	}
+	return 0, nil
+}
+
+// handlePut stores the request body as a file in an existing directory
+func (d *WebDAV) handlePut(w http.ResponseWriter, r *http.Request) (int, error) {
+	p := CleanPath(r.URL.Path)
+	if err := d.locks.confirm(p, false, ifTokens(r)); err != nil {
+		return http.StatusLocked, err
+	}
+
+	e, err := d.ns.Stat(p)
+	exists := err == nil
+	if exists && e.IsDir {
+		return http.StatusMethodNotAllowed, fmt.Errorf("%w: %s", ErrIsDir, p)
+	}
+	if !d.parentExists(p) {
+		return http.StatusConflict, fmt.Errorf("%w: %s", ErrParentNotFound, p)
+	}
+
+	cond, err := headerPrecondition(r.Header)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := d.server.StoreIfContext(r.Context(), p, r.Body, cond); err != nil {
+		return davStatus(err), err
+	}
+
+	if info, err := d.server.Stat(p); err == nil {
+		w.Header().Set("ETag", `"`+info.ETag+`"`)
+	}
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	return 0, nil
+}
+
+// handleDelete deletes a file or a directory with everything in it
+func (d *WebDAV) handleDelete(w http.ResponseWriter, r *http.Request) (int, error) {
+	p := CleanPath(r.URL.Path)
+	if len(p) == 0 {
+		return http.StatusForbidden, errors.New("cannot delete the root")
+	}
+	if err := d.locks.confirm(p, true, ifTokens(r)); err != nil {
+		return http.StatusLocked, err
+	}
+
+	if err := d.ns.RemoveAll(p); err != nil {
+		return davStatus(err), err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return 0, nil
+}
+
+// handleMkcol creates a directory
+func (d *WebDAV) handleMkcol(w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.ContentLength > 0 {
+		return http.StatusUnsupportedMediaType, errors.New("MKCOL does not take a body")
+	}
+
+	p := CleanPath(r.URL.Path)
+	if err := d.locks.confirm(p, false, ifTokens(r)); err != nil {
+		return http.StatusLocked, err
+	}
+
+	if err := d.ns.Mkdir(p, nil); err != nil {
+		return davStatus(err), err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return 0, nil
+}
+
+// handleCopyMove copies or moves a resource to the URL in the Destination header
+func (d *WebDAV) handleCopyMove(w http.ResponseWriter, r *http.Request) (int, error) {
+	src := CleanPath(r.URL.Path)
+
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || len(u.Path) == 0 {
+		return http.StatusBadRequest, errors.New("missing or invalid destination")
+	}
+	if len(u.Host) > 0 && u.Host != r.Host {
+		return http.StatusBadGateway, errors.New("destination is on another server")
+	}
+	dst := CleanPath(u.Path)
+	if dst == src || isUnder(dst, src) || isUnder(src, dst) {
+		return http.StatusForbidden, errors.New("source and destination overlap")
+	}
+
+	recursive := true
+	switch r.Header.Get("Depth") {
+	case "", "infinity":
+	case "0":
+		if r.Method == "MOVE" {
+			return http.StatusBadRequest, errors.New("MOVE requires depth infinity")
+		}
+		recursive = false
+	default:
+		return http.StatusBadRequest, errors.New("invalid depth")
+	}
+
+	if _, err := d.ns.Stat(src); err != nil {
+		return davStatus(err), err
+	}
+
+	tokens := ifTokens(r)
+	if r.Method == "MOVE" {
+		if err := d.locks.confirm(src, true, tokens); err != nil {
+			return http.StatusLocked, err
+		}
+	}
+	if err := d.locks.confirm(dst, true, tokens); err != nil {
+		return http.StatusLocked, err
+	}
+
+	status := http.StatusCreated
+	if _, err := d.ns.Stat(dst); err == nil {
+		if r.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed, fmt.Errorf("%w: %s", ErrExist, dst)
+		}
+		if err := d.ns.RemoveAll(dst); err != nil {
+			return davStatus(err), err
+		}
+		status = http.StatusNoContent
+	}
+
+	if r.Method == "MOVE" {
+		err = d.ns.Rename(src, dst)
+	} else {
+		err = d.ns.Copy(src, dst, recursive)
+	}
+	if errors.Is(err, ErrParentNotFound) {
+		return http.StatusConflict, err
+	}
+	if err != nil {
+		return davStatus(err), err
+	}
+
+	w.WriteHeader(status)
+	return 0, nil
+}
+
+// davLockInfo is the body of a LOCK request
+type davLockInfo struct {
+	XMLName xml.Name `xml:"DAV: lockinfo"`
+	Scope   struct {
+		Exclusive *struct{} `xml:"DAV: exclusive"`
+		Shared    *struct{} `xml:"DAV: shared"`
+	} `xml:"DAV: lockscope"`
+	Type struct {
+		Write *struct{} `xml:"DAV: write"`
+	} `xml:"DAV: locktype"`
+	Owner struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"DAV: owner"`
+}
+
+// handleLock grants a new write lock, or refreshes one named in the If header if the body is empty.
+// Locking a path that does not exist creates an empty file there.
+func (d *WebDAV) handleLock(w http.ResponseWriter, r *http.Request) (int, error) {
+	p := CleanPath(r.URL.Path)
+	timeout := lockTimeout(r.Header.Get("Timeout"))
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if len(b) == 0 {
+		l, err := d.locks.refresh(p, ifTokens(r), timeout)
+		if err != nil {
+			return http.StatusPreconditionFailed, err
+		}
+		return d.writeLock(w, l, http.StatusOK)
+	}
+
+	var info davLockInfo
+	if err := xml.Unmarshal(b, &info); err != nil || info.Type.Write == nil {
+		return http.StatusBadRequest, errors.New("malformed lockinfo, only write locks are supported")
+	}
+
+	infinite := true
+	switch r.Header.Get("Depth") {
+	case "", "infinity":
+	case "0":
+		infinite = false
+	default:
+		return http.StatusBadRequest, errors.New("invalid depth")
+	}
+
+	_, err = d.ns.Stat(p)
+	exists := err == nil
+	if !exists && !d.parentExists(p) {
+		return http.StatusConflict, fmt.Errorf("%w: %s", ErrParentNotFound, p)
+	}
+
+	l, err := d.locks.create(p, infinite, info.Scope.Shared == nil, info.Owner.Inner, timeout)
+	if err != nil {
+		return http.StatusLocked, err
+	}
+
+	if exists {
+		return d.writeLock(w, l, http.StatusOK)
+	}
+	if err := d.server.StoreContext(r.Context(), p, strings.NewReader("")); err != nil {
+		d.locks.unlock(p, l.token)
+		return davStatus(err), err
+	}
+	return d.writeLock(w, l, http.StatusCreated)
+}
+
+// handleUnlock releases the lock named in the Lock-Token header
+func (d *WebDAV) handleUnlock(w http.ResponseWriter, r *http.Request) (int, error) {
+	token := strings.Trim(r.Header.Get("Lock-Token"), "<>")
+	if err := d.locks.unlock(CleanPath(r.URL.Path), token); err != nil {
+		return http.StatusConflict, err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return 0, nil
+}
+
+// writeLock writes the lock discovery response for a granted or refreshed lock
+func (d *WebDAV) writeLock(w http.ResponseWriter, l davLock, status int) (int, error) {
+	prop := struct {
+		XMLName       xml.Name         `xml:"D:prop"`
+		XMLNS         string           `xml:"xmlns:D,attr"`
+		LockDiscovery davLockDiscovery `xml:"D:lockdiscovery"`
+	}{XMLNS: "DAV:", LockDiscovery: davLockDiscovery{Locks: []davActiveLock{activeLock(l)}}}
+
+	w.Header().Set("Lock-Token", "<"+l.token+">")
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(prop)
+	return 0, nil
+}
+
+// parentExists reports whether the directory a path would be created in exists
+func (d *WebDAV) parentExists(p string) bool {
+	parent, err := d.ns.Stat(path.Dir("/" + p))
+	return err == nil && parent.IsDir
+}
+
+// davMultistatus is the body of a 207 Multi-Status response
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	XMLNS     string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+// davResponse describes one resource in a multistatus response
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+// davPropstat holds properties that share a status
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+// davProp holds the live properties of a resource
+type davProp struct {
+	DisplayName   string            `xml:"D:displayname"`
+	ResourceType  davResourceType   `xml:"D:resourcetype"`
+	ContentLength string            `xml:"D:getcontentlength,omitempty"`
+	ContentType   string            `xml:"D:getcontenttype,omitempty"`
+	ETag          string            `xml:"D:getetag,omitempty"`
+	LastModified  string            `xml:"D:getlastmodified,omitempty"`
+	SupportedLock davSupportedLock  `xml:"D:supportedlock"`
+	LockDiscovery *davLockDiscovery `xml:"D:lockdiscovery,omitempty"`
+}
+
+// davResourceType marks collections
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+// davSupportedLock lists the lock kinds a resource supports
+type davSupportedLock struct {
+	Entries []davLockEntry `xml:"D:lockentry"`
+}
+
+// davLockEntry is a lock scope and type
+type davLockEntry struct {
+	Scope davLockScope `xml:"D:lockscope"`
+	Type  davLockType  `xml:"D:locktype"`
+}
+
+// davLockScope is exclusive or shared
+type davLockScope struct {
+	Exclusive *struct{} `xml:"D:exclusive,omitempty"`
+	Shared    *struct{} `xml:"D:shared,omitempty"`
+}
+
+// davLockType is always write
+type davLockType struct {
+	Write struct{} `xml:"D:write"`
+}
+
+// davLockDiscovery lists the active locks on a resource
+type davLockDiscovery struct {
+	Locks []davActiveLock `xml:"D:activelock"`
+}
+
+// davActiveLock describes a granted lock
+type davActiveLock struct {
+	davLockEntry
+	Depth     string    `xml:"D:depth"`
+	Owner     *davOwner `xml:"D:owner,omitempty"`
+	Timeout   string    `xml:"D:timeout"`
+	LockToken string    `xml:"D:locktoken>D:href"`
+	LockRoot  string    `xml:"D:lockroot>D:href"`
+}
+
+// davOwner is the owner XML a client supplied when locking
+type davOwner struct {
+	Inner string `xml:",innerxml"`
+}
+
+// supportedLocks are the lock kinds every resource supports
+var supportedLocks = davSupportedLock{Entries: []davLockEntry{
+	{Scope: davLockScope{Exclusive: &struct{}{}}},
+	{Scope: davLockScope{Shared: &struct{}{}}},
+}}
+
+// propResponse describes an entry with its live properties
+func (d *WebDAV) propResponse(e DirEntry) davResponse {
+	prop := davProp{
+		DisplayName:   e.Name(),
+		SupportedLock: supportedLocks,
+	}
+	if len(e.Path) == 0 {
+		prop.DisplayName = ""
+	}
+	if e.IsDir {
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		prop.ContentLength = strconv.FormatInt(e.Size, 10)
+		prop.ContentType = contentType(e.Path)
+		prop.ETag = `"` + e.ETag + `"`
+	}
+	if !e.ModTime.IsZero() {
+		prop.LastModified = e.ModTime.UTC().Format(http.TimeFormat)
+	}
+	if locks := d.locks.active(e.Path); len(locks) > 0 {
+		prop.LockDiscovery = &davLockDiscovery{}
+		for _, l := range locks {
+			prop.LockDiscovery.Locks = append(prop.LockDiscovery.Locks, activeLock(l))
+		}
+	}
+
+	return davResponse{
+		Href:     davHref(e.Path, e.IsDir),
+		Propstat: davPropstat{Prop: prop, Status: "HTTP/1.1 200 OK"},
+	}
+}
+
+// activeLock describes a lock in lock discovery
+func activeLock(l davLock) davActiveLock {
+	a := davActiveLock{
+		Depth:     "infinity",
+		Timeout:   fmt.Sprintf("Second-%d", int(l.timeout.Seconds())),
+		LockToken: l.token,
+		LockRoot:  davHref(l.root, false),
+	}
+	if !l.infinite {
+		a.Depth = "0"
+	}
+	if l.exclusive {
+		a.Scope.Exclusive = &struct{}{}
+	} else {
+		a.Scope.Shared = &struct{}{}
+	}
+	if len(l.owner) > 0 {
+		a.Owner = &davOwner{Inner: l.owner}
+	}
+	return a
+}
+
+// davHref is the escaped URL path of a namespace path, with a trailing slash for directories
+func davHref(p string, isDir bool) string {
+	if len(p) == 0 {
+		return "/"
+	}
+
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	href := "/" + strings.Join(segments, "/")
+	if isDir {
+		href += "/"
+	}
+	return href
+}
+
+// contentType guesses the media type of a file from its extension
+func contentType(p string) string {
+	if t := mime.TypeByExtension(path.Ext(p)); len(t) > 0 {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// ifTokens returns the lock tokens submitted in the If header
+func ifTokens(r *http.Request) []string {
+	tokens := []string{}
+	for _, m := range lockTokenRe.FindAllStringSubmatch(r.Header.Get("If"), -1) {
+		tokens = append(tokens, m[1])
+	}
+	return tokens
+}
+
+// lockTimeout parses a Timeout header such as "Second-3600" or "Infinite", capped at maxLockTimeout
+func lockTimeout(header string) time.Duration {
+	first, _, _ := strings.Cut(header, ",")
+	first = strings.TrimSpace(first)
+	if first == "Infinite" {
+		return maxLockTimeout
+	}
+	if s, ok := strings.CutPrefix(first, "Second-"); ok {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			return min(time.Duration(n)*time.Second, maxLockTimeout)
+		}
+	}
+	return defaultLockTimeout
+}
+
+// davStatus maps a namespace or file server error to an HTTP status code
+func davStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrParentNotFound):
+		return http.StatusConflict
+	case errors.Is(err, ErrExist), errors.Is(err, ErrIsDir), errors.Is(err, ErrNotDir):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, errLocked), errors.Is(err, errLockConflict):
+		return http.StatusLocked
+	}
+	return statusFor(err)
+}
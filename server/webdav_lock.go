@@ -0,0 +1,174 @@
+// WebDAV locks for GoVaultFS
+// Clients such as Windows Explorer and macOS Finder take write locks before editing a file. Locks are advisory
+// between WebDAV clients only: they live in memory on the node that granted them and expire after their timeout.
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lock timeouts
+const (
+	defaultLockTimeout = 10 * time.Minute
+	maxLockTimeout     = time.Hour
+)
+
+// Lock errors
+var (
+	errLocked       = errors.New("resource is locked")
+	errNoSuchLock   = errors.New("no matching lock")
+	errLockConflict = errors.New("conflicting lock")
+)
+
+// davLock is a granted write lock
+type davLock struct {
+	token     string        // opaquelocktoken URI
+	root      string        // Locked path
+	infinite  bool          // Whether the lock covers everything under root
+	exclusive bool          // Exclusive or shared
+	owner     string        // Owner XML supplied by the client, returned as is
+	timeout   time.Duration // Lifetime granted on each refresh
+	expires   time.Time
+}
+
+// lockManager grants and checks WebDAV locks
+type lockManager struct {
+	mu    sync.Mutex
+	locks map[string]*davLock // Locks by token
+	now   func() time.Time
+}
+
+// newLockManager creates an empty lock manager
+func newLockManager() *lockManager {
+	return &lockManager{
+		locks: make(map[string]*davLock),
+		now:   time.Now,
+	}
+}
+
+// create grants a lock on root unless it overlaps a lock it cannot share with
+func (m *lockManager) create(root string, infinite bool, exclusive bool, owner string, timeout time.Duration) (davLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	for _, l := range m.locks {
+		overlaps := l.root == root || (l.infinite && isUnder(root, l.root)) || (infinite && isUnder(l.root, root))
+		if overlaps && (exclusive || l.exclusive) {
+			return davLock{}, errLockConflict
+		}
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return davLock{}, err
+	}
+	h := hex.EncodeToString(buf)
+
+	l := &davLock{
+		token:     "opaquelocktoken:" + h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32],
+		root:      root,
+		infinite:  infinite,
+		exclusive: exclusive,
+		owner:     owner,
+		timeout:   timeout,
+		expires:   m.now().Add(timeout),
+	}
+	m.locks[l.token] = l
+	return *l, nil
+}
+
+// refresh extends a lock covering path that one of the tokens names
+func (m *lockManager) refresh(path string, tokens []string, timeout time.Duration) (davLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	for _, token := range tokens {
+		if l, ok := m.locks[token]; ok && l.covers(path, false) {
+			l.timeout = timeout
+			l.expires = m.now().Add(timeout)
+			return *l, nil
+		}
+	}
+	return davLock{}, errNoSuchLock
+}
+
+// unlock releases a lock covering path
+func (m *lockManager) unlock(path string, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	l, ok := m.locks[token]
+	if !ok || !l.covers(path, false) {
+		return errNoSuchLock
+	}
+	delete(m.locks, token)
+	return nil
+}
+
+// confirm checks that the client holds a token for every lock covering path.
+// With recursive set, locks on anything under path count too.
+func (m *lockManager) confirm(path string, recursive bool, tokens []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	for _, l := range m.locks {
+		if !l.covers(path, recursive) {
+			continue
+		}
+		held := false
+		for _, token := range tokens {
+			held = held || token == l.token
+		}
+		if !held {
+			return errLocked
+		}
+	}
+	return nil
+}
+
+// active returns the locks covering path
+func (m *lockManager) active(path string) []davLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	out := []davLock{}
+	for _, l := range m.locks {
+		if l.covers(path, false) {
+			out = append(out, *l)
+		}
+	}
+	return out
+}
+
+// expire drops locks past their timeout. Caller holds m.mu.
+func (m *lockManager) expire() {
+	now := m.now()
+	for token, l := range m.locks {
+		if now.After(l.expires) {
+			delete(m.locks, token)
+		}
+	}
+}
+
+// covers reports whether a lock applies to path, or with recursive set, to anything under it
+func (l *davLock) covers(path string, recursive bool) bool {
+	return l.root == path || (l.infinite && isUnder(path, l.root)) || (recursive && isUnder(l.root, path))
+}
+
+// isUnder reports whether path is strictly inside the directory dir
+func isUnder(path string, dir string) bool {
+	if len(dir) == 0 {
+		return len(path) > 0
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
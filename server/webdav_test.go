@@ -0,0 +1,119 @@
+// Tests for the WebDAV server in GoVaultFS
+// These tests drive the directory namespace through WebDAV requests the way a desktop client would.
+package server
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/p2p"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWebDAV creates, lists, copies, moves, locks and deletes files and directories
+func TestWebDAV(t *testing.T) {
+	t.Parallel()
+
+	network := p2p.NewMemNetwork()
+	s := newTestServer(t, network, ":3000", nil)
+	defer stopServers(s)
+	go s.Start()
+
+	ts := httptest.NewServer(NewWebDAV(s))
+	defer ts.Close()
+
+	// Files stored through the API show up in the tree
+	assert.Nil(t, s.Store("photos/2024/a.png", strings.NewReader("image a")))
+
+	assert.Equal(t, http.StatusConflict, davDo(t, http.MethodPut, ts.URL+"/docs/notes.txt", "hello").StatusCode)
+	assert.Equal(t, http.StatusCreated, davDo(t, "MKCOL", ts.URL+"/docs", "").StatusCode)
+	assert.Equal(t, http.StatusMethodNotAllowed, davDo(t, "MKCOL", ts.URL+"/docs", "").StatusCode)
+	assert.Equal(t, http.StatusCreated, davDo(t, "MKCOL", ts.URL+"/docs/empty", "").StatusCode)
+	assert.Equal(t, http.StatusCreated, davDo(t, http.MethodPut, ts.URL+"/docs/notes.txt", "hello").StatusCode)
+	assert.Equal(t, http.StatusNoContent, davDo(t, http.MethodPut, ts.URL+"/docs/notes.txt", "hello again").StatusCode)
+
+	body, _ := io.ReadAll(davDo(t, http.MethodGet, ts.URL+"/docs/notes.txt", "").Body)
+	assert.Equal(t, "hello again", string(body))
+	assert.Equal(t, []string{"/", "/docs/", "/photos/"}, propfind(t, ts.URL+"/", "1"))
+	assert.Equal(t, []string{"/docs/", "/docs/empty/", "/docs/notes.txt"}, propfind(t, ts.URL+"/docs", "1"))
+	assert.Equal(t, []string{"/photos/", "/photos/2024/", "/photos/2024/a.png"}, propfind(t, ts.URL+"/photos", "infinity"))
+	assert.Equal(t, http.StatusNotFound, davDo(t, "PROPFIND", ts.URL+"/missing", "").StatusCode)
+
+	// Copy and move, with and without overwriting
+	assert.Equal(t, http.StatusCreated, davDo(t, "COPY", ts.URL+"/photos", "", "Destination", ts.URL+"/docs/photos").StatusCode)
+	assert.Equal(t, http.StatusPreconditionFailed, davDo(t, "COPY", ts.URL+"/photos", "", "Destination", ts.URL+"/docs/photos", "Overwrite", "F").StatusCode)
+	assert.Equal(t, http.StatusForbidden, davDo(t, "MOVE", ts.URL+"/docs", "", "Destination", ts.URL+"/docs/inside").StatusCode)
+	bad := davDo(t, "MOVE", ts.URL+"/docs", "", "Destination", ts.URL+"/docs%zz")
+	body, _ = io.ReadAll(bad.Body)
+	assert.Equal(t, http.StatusBadRequest, bad.StatusCode)
+	assert.Contains(t, string(body), errBadDestination.Error())
+	assert.Equal(t, http.StatusCreated, davDo(t, "MOVE", ts.URL+"/docs/notes.txt", "", "Destination", ts.URL+"/docs/empty/notes.txt").StatusCode)
+	assert.Equal(t, []string{"/docs/", "/docs/empty/", "/docs/empty/notes.txt", "/docs/photos/", "/docs/photos/2024/", "/docs/photos/2024/a.png"},
+		propfind(t, ts.URL+"/docs", "infinity"))
+	body, _ = io.ReadAll(davDo(t, http.MethodGet, ts.URL+"/docs/photos/2024/a.png", "").Body)
+	assert.Equal(t, "image a", string(body))
+
+	// A locked file can only be changed with its lock token
+	lockInfo := `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope>` +
+		`<D:locktype><D:write/></D:locktype><D:owner><D:href>alice</D:href></D:owner></D:lockinfo>`
+	resp := davDo(t, "LOCK", ts.URL+"/docs/new.txt", lockInfo, "Timeout", "Second-60")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	token := resp.Header.Get("Lock-Token")
+	assert.True(t, strings.HasPrefix(token, "<opaquelocktoken:"))
+
+	assert.Equal(t, http.StatusLocked, davDo(t, "LOCK", ts.URL+"/docs", lockInfo).StatusCode)
+	assert.Equal(t, http.StatusLocked, davDo(t, http.MethodPut, ts.URL+"/docs/new.txt", "draft").StatusCode)
+	assert.Equal(t, http.StatusLocked, davDo(t, http.MethodDelete, ts.URL+"/docs", "").StatusCode)
+	assert.Equal(t, http.StatusNoContent, davDo(t, http.MethodPut, ts.URL+"/docs/new.txt", "draft", "If", "("+token+")").StatusCode)
+	assert.Equal(t, http.StatusOK, davDo(t, "LOCK", ts.URL+"/docs/new.txt", "", "If", "("+token+")").StatusCode)
+	assert.Equal(t, http.StatusNoContent, davDo(t, "UNLOCK", ts.URL+"/docs/new.txt", "", "Lock-Token", token).StatusCode)
+	assert.Equal(t, http.StatusConflict, davDo(t, "UNLOCK", ts.URL+"/docs/new.txt", "", "Lock-Token", token).StatusCode)
+
+	// Deleting a directory deletes everything in it
+	assert.Equal(t, http.StatusNoContent, davDo(t, http.MethodDelete, ts.URL+"/docs", "").StatusCode)
+	assert.Equal(t, []string{"/", "/photos/"}, propfind(t, ts.URL+"/", "1"))
+	_, err := s.Stat("docs/photos/2024/a.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// davDo sends a WebDAV request. Extra headers are given as name, value pairs.
+func davDo(t *testing.T, method string, url string, body string, headers ...string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	return send(t, req)
+}
+
+// propfind returns the hrefs of a PROPFIND response
+func propfind(t *testing.T, url string, depth string) []string {
+	t.Helper()
+	resp := davDo(t, "PROPFIND", url, `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`, "Depth", depth)
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND %s: status %d", url, resp.StatusCode)
+	}
+
+	var ms struct {
+		Responses []struct {
+			Href string `xml:"DAV: href"`
+		} `xml:"DAV: response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		t.Fatal(err)
+	}
+
+	hrefs := []string{}
+	for _, r := range ms.Responses {
+		hrefs = append(hrefs, r.Href)
+	}
+	return hrefs
+}
@@ -0,0 +1,394 @@
+// Package store implements the content-addressable storage of GoVaultFS.
+// This file provides the logic for storing, retrieving, and managing files using their content hash.
+// Files are organized in a hierarchical directory structure based on their hash for efficient deduplication and lookup.
+package store
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+	"github.com/AnshSinghSonkhia/GoVaultFS/ratelimit"
+)
+
+// Default root folder for all file storage
+const defaultRootFolderName = "ggnetwork"
+
+// CASPathTransformFunc transforms a file key into a hierarchical path using SHA-1 hash
+// This enables content-addressable storage and deduplication
+func CASPathTransformFunc(key string) PathKey {
+	hash := sha1.Sum([]byte(key))
+	hashStr := hex.EncodeToString(hash[:])
+
+	blocksize := 5 // Split hash into blocks for directory structure
+	sliceLen := len(hashStr) / blocksize
+	paths := make([]string, sliceLen)
+
+	for i := 0; i < sliceLen; i++ {
+		from, to := i*blocksize, (i*blocksize)+blocksize
+		paths[i] = hashStr[from:to]
+	}
+
+	return PathKey{
+		PathName: strings.Join(paths, "/"), // e.g. "d44bb/d0bbd/a685d/..."
+		Filename: hashStr,                  // Full hash as filename
+	}
+}
+
+// PathTransformFunc is a function type for converting keys to path structures
+// Allows pluggable path transformation logic
+type PathTransformFunc func(string) PathKey
+
+// PathKey holds the directory path and filename for a file
+type PathKey struct {
+	PathName string // Hierarchical directory path
+	Filename string // Full hash filename
+}
+
+// FirstPathName returns the first directory in the path
+func (p PathKey) FirstPathName() string {
+	paths := strings.Split(p.PathName, "/")
+	if len(paths) == 0 {
+		return ""
+	}
+	return paths[0]
+}
+
+// FullPath returns the full path including directories and filename
+func (p PathKey) FullPath() string {
+	return fmt.Sprintf("%s/%s", p.PathName, p.Filename)
+}
+
+// StoreOpts holds configuration for a Store instance
+type StoreOpts struct {
+	Root              string            // Root directory for all files
+	PathTransformFunc PathTransformFunc // Function to transform keys to paths
+	Logger            *slog.Logger      // Logger for disk operations, slog.Default() if nil
+	ReadRate          float64           // Disk read bandwidth in bytes per second, 0 for no limit
+	WriteRate         float64           // Disk write bandwidth in bytes per second, 0 for no limit
+}
+
+// DefaultPathTransformFunc is a fallback path transformer (no hashing)
+var DefaultPathTransformFunc = func(key string) PathKey {
+	return PathKey{
+		PathName: key,
+		Filename: key,
+	}
+}
+
+// Store manages file storage and retrieval on disk
+type Store struct {
+	StoreOpts
+	readLimiter  *ratelimit.Limiter // Bounds the bytes read from files
+	writeLimiter *ratelimit.Limiter // Bounds the bytes written to files
+
+	usageLock sync.Mutex       // Protects usage
+	usage     map[string]int64 // Bytes stored under each node ID
+}
+
+// NewStore creates a new Store with the given options
+func NewStore(opts StoreOpts) *Store {
+	if opts.PathTransformFunc == nil {
+		opts.PathTransformFunc = DefaultPathTransformFunc
+	}
+	if len(opts.Root) == 0 {
+		opts.Root = defaultRootFolderName
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	s := &Store{
+		StoreOpts:    opts,
+		readLimiter:  ratelimit.NewLimiter(opts.ReadRate, 0),
+		writeLimiter: ratelimit.NewLimiter(opts.WriteRate, 0),
+	}
+	s.usage = s.scanUsage()
+	return s
+}
+
+// scanUsage sums the size of the files under every node ID directory of the root
+func (s *Store) scanUsage() map[string]int64 {
+	usage := make(map[string]int64)
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		return usage
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		filepath.WalkDir(filepath.Join(s.Root, e.Name()), func(path string, d os.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return nil
+			}
+			if fi, err := d.Info(); err == nil {
+				usage[e.Name()] += fi.Size()
+			}
+			return nil
+		})
+	}
+	return usage
+}
+
+// addUsage records that the files of a node ID grew by delta bytes
+func (s *Store) addUsage(id string, delta int64) {
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+
+	s.usage[id] += delta
+	if s.usage[id] <= 0 {
+		delete(s.usage, id)
+	}
+}
+
+// Usage returns the bytes stored under a node ID
+func (s *Store) Usage(id string) int64 {
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+	return s.usage[id]
+}
+
+// Usages returns the bytes stored under each node ID
+func (s *Store) Usages() map[string]int64 {
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+	return maps.Clone(s.usage)
+}
+
+// TotalUsage returns the bytes stored under every node ID
+func (s *Store) TotalUsage() int64 {
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+
+	var total int64
+	for _, n := range s.usage {
+		total += n
+	}
+	return total
+}
+
+// Size returns the size of the file for the given node ID and key, 0 if there is none
+func (s *Store) Size(id string, key string) int64 {
+	pathKey := s.PathTransformFunc(key)
+	fi, err := os.Stat(fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FullPath()))
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// SetRates changes the disk bandwidth limits in bytes per second, 0 for no limit.
+// Reads and writes in progress continue at the new rates.
+func (s *Store) SetRates(read float64, write float64) {
+	s.readLimiter.SetLimit(read, 0)
+	s.writeLimiter.SetLimit(write, 0)
+}
+
+// limitedWriter returns w bounded by the write limit, if there is one
+func (s *Store) limitedWriter(w io.Writer) io.Writer {
+	if s.writeLimiter.Limit() == 0 {
+		return w
+	}
+	return ratelimit.NewWriter(context.Background(), w, s.writeLimiter)
+}
+
+// limitedFile is a file whose reads wait for the read limit.
+// It hides the other methods of the file, so io.Copy cannot get around the limit.
+type limitedFile struct {
+	io.Reader
+	file *os.File
+}
+
+// Close closes the file
+func (f *limitedFile) Close() error {
+	return f.file.Close()
+}
+
+// Has checks if a file exists for the given node ID and key
+func (s *Store) Has(id string, key string) bool {
+	pathKey := s.PathTransformFunc(key)
+	fullPathWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FullPath())
+
+	_, err := os.Stat(fullPathWithRoot)
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+// Clear deletes all files and directories under the root
+func (s *Store) Clear() error {
+	s.usageLock.Lock()
+	s.usage = make(map[string]int64)
+	s.usageLock.Unlock()
+
+	return os.RemoveAll(s.Root)
+}
+
+// ClearID deletes every file of a node ID
+func (s *Store) ClearID(id string) error {
+	s.usageLock.Lock()
+	delete(s.usage, id)
+	s.usageLock.Unlock()
+
+	return os.RemoveAll(fmt.Sprintf("%s/%s", s.Root, id))
+}
+
+// Delete removes the file for the given node ID and key, along with the directories it leaves empty.
+// Directories still holding other files are kept.
+func (s *Store) Delete(id string, key string) error {
+	pathKey := s.PathTransformFunc(key)
+
+	idRoot := fmt.Sprintf("%s/%s", s.Root, id)
+	fullPathWithRoot := fmt.Sprintf("%s/%s", idRoot, pathKey.FullPath())
+	size := s.Size(id, key)
+	if err := os.Remove(fullPathWithRoot); err != nil {
+		return err
+	}
+	s.addUsage(id, -size)
+	removeEmptyDirs(idRoot, fullPathWithRoot)
+
+	s.Logger.Debug("deleted from disk", "key", key, "path", pathKey.FullPath())
+
+	return nil
+}
+
+// Rename moves the file of a node ID from one key to another, replacing any file already under the new key
+func (s *Store) Rename(id string, from string, to string) error {
+	idRoot := fmt.Sprintf("%s/%s", s.Root, id)
+	fromPath := fmt.Sprintf("%s/%s", idRoot, s.PathTransformFunc(from).FullPath())
+	toKey := s.PathTransformFunc(to)
+	if err := os.MkdirAll(fmt.Sprintf("%s/%s", idRoot, toKey.PathName), os.ModePerm); err != nil {
+		return err
+	}
+
+	replaced := s.Size(id, to)
+	if err := os.Rename(fromPath, fmt.Sprintf("%s/%s", idRoot, toKey.FullPath())); err != nil {
+		return err
+	}
+	s.addUsage(id, -replaced)
+	removeEmptyDirs(idRoot, fromPath)
+
+	s.Logger.Debug("renamed on disk", "from", from, "to", to)
+
+	return nil
+}
+
+// removeEmptyDirs removes the directories above path up to idRoot that are left empty
+func removeEmptyDirs(idRoot string, path string) {
+	for dir := filepath.Dir(path); dir != filepath.Clean(idRoot) && dir != "."; dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break // Not empty
+		}
+	}
+}
+
+// Write saves a file stream to disk for the given node ID and key
+func (s *Store) Write(id string, key string, r io.Reader) (int64, error) {
+	return s.writeStream(id, key, r)
+}
+
+// WriteDecrypt decrypts and writes an encrypted file stream to disk
+func (s *Store) WriteDecrypt(encKey []byte, id string, key string, r io.Reader) (int64, error) {
+	old := s.Size(id, key)
+	f, err := s.openFileForWriting(id, key)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close() // Ensure file is closed after writing
+	n, err := crypto.CopyDecrypt(encKey, r, s.limitedWriter(f))
+	s.addUsage(id, fileSize(f)-old)
+	return int64(n), err
+}
+
+// openFileForWriting creates all necessary directories and opens the file for writing
+func (s *Store) openFileForWriting(id string, key string) (*os.File, error) {
+	pathKey := s.PathTransformFunc(key)
+	pathNameWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.PathName)
+	if err := os.MkdirAll(pathNameWithRoot, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	fullPathWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FullPath())
+
+	return os.Create(fullPathWithRoot)
+}
+
+// writeStream writes a file stream to disk, ensuring the file is closed after writing
+func (s *Store) writeStream(id string, key string, r io.Reader) (int64, error) {
+	old := s.Size(id, key)
+	f, err := s.openFileForWriting(id, key)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close() // Ensure file is closed after writing
+	n, err := io.Copy(s.limitedWriter(f), r)
+	s.addUsage(id, fileSize(f)-old)
+	return n, err
+}
+
+// fileSize returns the size of a file being written, as far as it got
+func fileSize(f *os.File) int64 {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// Read returns a file stream and its size for the given node ID and key
+func (s *Store) Read(id string, key string) (int64, io.Reader, error) {
+	return s.readStream(id, key)
+}
+
+// readStream opens a file for reading and returns its size and stream
+func (s *Store) readStream(id string, key string) (int64, io.ReadCloser, error) {
+	pathKey := s.PathTransformFunc(key)
+	fullPathWithRoot := fmt.Sprintf("%s/%s/%s", s.Root, id, pathKey.FullPath())
+
+	file, err := os.Open(fullPathWithRoot)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return 0, nil, err
+	}
+
+	if s.readLimiter.Limit() > 0 {
+		return fi.Size(), &limitedFile{Reader: ratelimit.NewReader(context.Background(), file, s.readLimiter), file: file}, nil
+	}
+	return fi.Size(), file, nil
+}
+
+// DiskUsage returns the total size of the files under the root
+func (s *Store) DiskUsage() (int64, error) {
+	var total int64
+	err := filepath.WalkDir(s.Root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil // Removed while walking, or no files yet
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			total += fi.Size()
+		}
+		return nil
+	})
+	return total, err
+}
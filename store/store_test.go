@@ -0,0 +1,200 @@
+// Unit tests for the Store (content-addressable storage) in GoVaultFS
+// These tests verify path transformation, file writing, reading, existence checks, and deletion.
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/AnshSinghSonkhia/GoVaultFS/crypto"
+)
+
+// TestPathTransformFunc checks that CASPathTransformFunc correctly transforms a key
+// into the expected hierarchical path and filename using SHA-1 hashing.
+// This ensures content-addressable storage works as designed.
+func TestPathTransformFunc(t *testing.T) {
+	key := "momsbestpicture"
+	pathKey := CASPathTransformFunc(key)
+	expectedFilename := "6804429f74181a63c50c3d81d733a12f14a353ff"
+	expectedPathName := "68044/29f74/181a6/3c50c/3d81d/733a1/2f14a/353ff"
+	if pathKey.PathName != expectedPathName {
+		t.Errorf("have %s want %s", pathKey.PathName, expectedPathName)
+	}
+
+	if pathKey.Filename != expectedFilename {
+		t.Errorf("have %s want %s", pathKey.Filename, expectedFilename)
+	}
+}
+
+// TestStore validates the Store's core functionality:
+// writing, reading, checking existence, and deleting files.
+// It runs multiple iterations to ensure reliability and correctness.
+func TestStore(t *testing.T) {
+	s := newStore()
+	id := crypto.GenerateID()
+	defer teardown(t, s)
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("foo_%d", i)
+		data := []byte("some jpg bytes")
+
+		// Write data to the store
+		if _, err := s.writeStream(id, key, bytes.NewReader(data)); err != nil {
+			t.Error(err)
+		}
+
+		// Check if the file exists
+		if ok := s.Has(id, key); !ok {
+			t.Errorf("expected to have key %s", key)
+		}
+
+		// Read the file and verify its contents
+		_, r, err := s.Read(id, key)
+		if err != nil {
+			t.Error(err)
+		}
+
+		b, _ := ioutil.ReadAll(r)
+		if string(b) != string(data) {
+			t.Errorf("want %s have %s", data, b)
+		}
+
+		// Delete the file
+		if err := s.Delete(id, key); err != nil {
+			t.Error(err)
+		}
+
+		// Ensure the file no longer exists
+		if ok := s.Has(id, key); ok {
+			t.Errorf("expected to NOT have key %s", key)
+		}
+	}
+}
+
+// TestStoreRates checks that disk reads and writes are bounded by their rates until the limits are lifted
+func TestStoreRates(t *testing.T) {
+	s := NewStore(StoreOpts{
+		Root:              t.TempDir(),
+		PathTransformFunc: CASPathTransformFunc,
+		ReadRate:          256 * 1024,
+		WriteRate:         256 * 1024,
+	})
+	id := crypto.GenerateID()
+	data := bytes.Repeat([]byte("x"), 384*1024)
+
+	// The first 256 KiB are the burst, the rest take half a second
+	start := time.Now()
+	if _, err := s.Write(id, "big", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("write took %s, want at least 400ms", elapsed)
+	}
+
+	start = time.Now()
+	_, r, err := s.Read(id, "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	r.(io.Closer).Close()
+	if !bytes.Equal(b, data) {
+		t.Errorf("read %d bytes, want %d", len(b), len(data))
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("read took %s, want at least 400ms", elapsed)
+	}
+
+	s.SetRates(0, 0)
+	start = time.Now()
+	if _, err := s.Write(id, "big", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("unlimited write took %s", elapsed)
+	}
+}
+
+// TestStoreUsage checks that the bytes stored under each node ID follow writes, overwrites and deletes,
+// and are counted again when a store is reopened
+func TestStoreUsage(t *testing.T) {
+	root := t.TempDir()
+	s := NewStore(StoreOpts{Root: root, PathTransformFunc: CASPathTransformFunc})
+	a, b := crypto.GenerateID(), crypto.GenerateID()
+
+	s.Write(a, "one", bytes.NewReader(make([]byte, 100)))
+	s.Write(a, "two", bytes.NewReader(make([]byte, 50)))
+	s.Write(b, "one", bytes.NewReader(make([]byte, 10)))
+	if have := s.Usage(a); have != 150 {
+		t.Errorf("usage of a: have %d want 150", have)
+	}
+
+	// An overwrite only counts the difference
+	s.Write(a, "one", bytes.NewReader(make([]byte, 20)))
+	if have := s.Usage(a); have != 70 {
+		t.Errorf("usage of a after overwrite: have %d want 70", have)
+	}
+	if have := s.Size(a, "one"); have != 20 {
+		t.Errorf("size: have %d want 20", have)
+	}
+
+	if err := s.Delete(a, "two"); err != nil {
+		t.Fatal(err)
+	}
+	if have := s.TotalUsage(); have != 30 {
+		t.Errorf("total usage: have %d want 30", have)
+	}
+	if have := s.Usages(); len(have) != 2 || have[a] != 20 || have[b] != 10 {
+		t.Errorf("usages: have %v want a 20 and b 10", have)
+	}
+
+	// A rename moves the file and drops the one it replaces
+	s.Write(a, "three", bytes.NewReader(make([]byte, 5)))
+	if err := s.Rename(a, "one", "three"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(a, "one") || s.Size(a, "three") != 20 {
+		t.Errorf("rename: one still there or three has %d bytes, want 20", s.Size(a, "three"))
+	}
+	if have := s.Usage(a); have != 20 {
+		t.Errorf("usage of a after rename: have %d want 20", have)
+	}
+
+	reopened := NewStore(StoreOpts{Root: root, PathTransformFunc: CASPathTransformFunc})
+	if have := reopened.Usage(a); have != 20 {
+		t.Errorf("usage of a after reopening: have %d want 20", have)
+	}
+	if have := reopened.Usage(b); have != 10 {
+		t.Errorf("usage of b after reopening: have %d want 10", have)
+	}
+
+	// Clearing a node ID leaves the others alone
+	if err := reopened.ClearID(b); err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Has(b, "one") || reopened.Usage(b) != 0 || reopened.Usage(a) != 20 {
+		t.Errorf("after clearing b: b has one %v, usage of b %d and a %d, want false, 0 and 20",
+			reopened.Has(b, "one"), reopened.Usage(b), reopened.Usage(a))
+	}
+}
+
+// newStore creates a new Store instance with CAS path transformation.
+// Used for test setup.
+func newStore() *Store {
+	opts := StoreOpts{
+		PathTransformFunc: CASPathTransformFunc,
+	}
+	return NewStore(opts)
+}
+
+// teardown cleans up the store after each test by clearing all files.
+// Ensures test isolation and prevents leftover data between tests.
+func teardown(t *testing.T, s *Store) {
+	if err := s.Clear(); err != nil {
+		t.Error(err)
+	}
+}
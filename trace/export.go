@@ -0,0 +1,94 @@
+// Span exporters
+// The in-memory exporter keeps spans for tests to inspect, the file exporter appends them to a file as JSON lines
+// that ReadSpans, jq or an OpenTelemetry collector's file receiver can read back.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"sync"
+)
+
+// InMemoryExporter keeps every exported span
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryExporter creates an empty in-memory exporter
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// ExportSpan records a span (Exporter interface)
+func (e *InMemoryExporter) ExportSpan(d SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, d)
+}
+
+// Spans returns the spans exported so far, in the order they ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.spans)
+}
+
+// Reset forgets every span
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// FileExporter appends spans to a file, one JSON object per line
+type FileExporter struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewFileExporter opens the file at path for appending, creating it if needed
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// ExportSpan writes a span to the file (Exporter interface).
+// Spans that cannot be written are dropped, tracing never fails the traced operation.
+func (e *FileExporter) ExportSpan(d SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(d)
+}
+
+// Close closes the file
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.f.Close()
+}
+
+// ReadSpans reads spans written by a FileExporter
+func ReadSpans(r io.Reader) ([]SpanData, error) {
+	spans := []SpanData{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var d SpanData
+		if err := json.Unmarshal(sc.Bytes(), &d); err != nil {
+			return spans, err
+		}
+		spans = append(spans, d)
+	}
+	return spans, sc.Err()
+}
@@ -0,0 +1,290 @@
+// Package trace records spans of work across the nodes of a cluster.
+// It follows the OpenTelemetry data model closely enough to be read by its tools: W3C trace context IDs and
+// traceparent strings, OpenTelemetry span kinds and status codes, and spans exported as JSON with the field names
+// of OTLP. Like the metrics package it needs no client library, so nodes and tests get tracing without dependencies.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidTraceparent is returned for a malformed W3C traceparent
+var ErrInvalidTraceparent = errors.New("trace: invalid traceparent")
+
+// TraceID identifies a trace, the tree of spans of one operation
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the ID in hex
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// MarshalText encodes the ID in hex (encoding.TextMarshaler)
+func (t TraceID) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes a hex ID (encoding.TextUnmarshaler)
+func (t *TraceID) UnmarshalText(b []byte) error {
+	return decodeID(t[:], b)
+}
+
+// String returns the ID in hex
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// MarshalText encodes the ID in hex, empty for the zero ID (encoding.TextMarshaler)
+func (s SpanID) MarshalText() ([]byte, error) {
+	if s == (SpanID{}) {
+		return nil, nil
+	}
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a hex ID (encoding.TextUnmarshaler)
+func (s *SpanID) UnmarshalText(b []byte) error {
+	if len(b) == 0 {
+		*s = SpanID{}
+		return nil
+	}
+	return decodeID(s[:], b)
+}
+
+// decodeID decodes a hex ID of exactly len(dst) bytes
+func decodeID(dst []byte, b []byte) error {
+	if hex.DecodedLen(len(b)) != len(dst) {
+		return fmt.Errorf("trace: want %d hex encoded bytes, got %q", len(dst), b)
+	}
+	_, err := hex.Decode(dst, b)
+	return err
+}
+
+// SpanContext identifies a span, locally or in another process
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid reports whether both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent returns the span context as a W3C traceparent, empty if it is not valid
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-01"
+}
+
+// ParseTraceparent parses a W3C traceparent such as "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	if sc.TraceID.UnmarshalText([]byte(parts[1])) != nil || sc.SpanID.UnmarshalText([]byte(parts[2])) != nil || !sc.IsValid() {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	return sc, nil
+}
+
+// Kind is the role of a span in a request, as in OpenTelemetry
+type Kind int
+
+// Span kinds
+const (
+	KindInternal Kind = iota // Work inside a process
+	KindServer               // Handling a request from another process
+	KindClient               // A request to another process
+)
+
+// String returns the name of the kind
+func (k Kind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// MarshalText encodes the kind by name (encoding.TextMarshaler)
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText decodes a kind by name (encoding.TextUnmarshaler)
+func (k *Kind) UnmarshalText(b []byte) error {
+	for _, kind := range []Kind{KindInternal, KindServer, KindClient} {
+		if kind.String() == string(b) {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("trace: unknown span kind %q", b)
+}
+
+// Status is the outcome of a span, as in OpenTelemetry
+type Status struct {
+	Code    string `json:"code"`              // "unset", "ok" or "error"
+	Message string `json:"message,omitempty"` // Description of the error
+}
+
+// SpanData is a finished span as exporters receive it
+type SpanData struct {
+	TraceID      TraceID        `json:"trace_id"`
+	SpanID       SpanID         `json:"span_id"`
+	ParentSpanID SpanID         `json:"parent_span_id,omitzero"` // Empty for the root span of a trace
+	Name         string         `json:"name"`
+	Kind         Kind           `json:"kind"`
+	Service      string         `json:"service"`
+	Start        time.Time      `json:"start_time"`
+	End          time.Time      `json:"end_time"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Status       Status         `json:"status"`
+}
+
+// Duration returns how long the span took
+func (d SpanData) Duration() time.Duration {
+	return d.End.Sub(d.Start)
+}
+
+// Exporter receives every span when it ends. It must be safe for concurrent use.
+type Exporter interface {
+	ExportSpan(SpanData)
+}
+
+// Tracer starts spans and hands them to an exporter once they end
+type Tracer struct {
+	service  string
+	exporter Exporter
+}
+
+// NewTracer creates a tracer for the named service
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{service: service, exporter: exporter}
+}
+
+// Start starts a span as a child of the span in ctx, or of the remote span in ctx, or as the root of a new trace.
+// It returns a context carrying the new span. A nil tracer traces nothing and returns a nil span,
+// whose methods do nothing.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{tracer: t, data: SpanData{Name: name, Kind: kind, Service: t.service, Start: time.Now()}}
+	if parent := SpanContextFromContext(ctx); parent.IsValid() {
+		span.data.TraceID = parent.TraceID
+		span.data.ParentSpanID = parent.SpanID
+	} else {
+		rand.Read(span.data.TraceID[:])
+	}
+	rand.Read(span.data.SpanID[:])
+	span.data.Status.Code = "unset"
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Span is an operation in progress. All methods are safe on a nil span.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SpanContext returns the IDs of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID}
+}
+
+// SetAttr records an attribute of the span
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any)
+	}
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks the span as failed with err, if err is not nil
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status = Status{Code: "error", Message: err.Error()}
+}
+
+// End finishes the span and exports it. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	data.Attributes = maps.Clone(s.data.Attributes)
+	s.mu.Unlock()
+
+	if s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(data)
+	}
+}
+
+// spanKey and remoteKey are the context keys of the current span and of a span in another process
+type (
+	spanKey   struct{}
+	remoteKey struct{}
+)
+
+// SpanFromContext returns the span in ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemote returns a context whose next span is a child of a span in another process
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the span in ctx, or of the remote span, or the zero value
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
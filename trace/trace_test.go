@@ -0,0 +1,114 @@
+// Tests for the trace package
+// These check traceparent parsing, parent-child links across contexts and the round trip through a span file.
+package trace
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTraceparent parses valid and malformed W3C traceparents
+func TestTraceparent(t *testing.T) {
+	t.Parallel()
+
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Nil(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(s)
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, s)
+	}
+	assert.Empty(t, SpanContext{}.Traceparent())
+}
+
+// TestSpans checks that spans join the trace of the span or remote span in their context
+func TestSpans(t *testing.T) {
+	t.Parallel()
+
+	exp := NewInMemoryExporter()
+	tracer := NewTracer("test", exp)
+
+	ctx, root := tracer.Start(context.Background(), "root", KindInternal)
+	_, child := tracer.Start(ctx, "child", KindClient)
+	child.SetAttr("key", "a.txt")
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End()
+	root.End()
+
+	// A span in another process, reached through the root's traceparent
+	sc, err := ParseTraceparent(root.SpanContext().Traceparent())
+	assert.Nil(t, err)
+	_, remote := tracer.Start(ContextWithRemote(context.Background(), sc), "remote", KindServer)
+	remote.End()
+
+	spans := exp.Spans()
+	if assert.Len(t, spans, 3) {
+		c, r, rm := spans[0], spans[1], spans[2]
+		assert.Equal(t, "child", c.Name)
+		assert.Equal(t, r.TraceID, c.TraceID)
+		assert.Equal(t, r.SpanID, c.ParentSpanID)
+		assert.Equal(t, SpanID{}, r.ParentSpanID)
+		assert.Equal(t, Status{Code: "error", Message: "boom"}, c.Status)
+		assert.Equal(t, "a.txt", c.Attributes["key"])
+		assert.Equal(t, "unset", r.Status.Code)
+		assert.Equal(t, "test", r.Service)
+		assert.Equal(t, r.TraceID, rm.TraceID)
+		assert.Equal(t, r.SpanID, rm.ParentSpanID)
+		assert.Equal(t, KindServer, rm.Kind)
+	}
+
+	// A nil tracer traces nothing
+	var off *Tracer
+	ctx, span := off.Start(context.Background(), "off", KindInternal)
+	span.SetAttr("key", "a.txt")
+	span.End()
+	assert.Nil(t, SpanFromContext(ctx))
+	assert.False(t, SpanContextFromContext(ctx).IsValid())
+}
+
+// TestFileExporter appends spans to a file and reads them back
+func TestFileExporter(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exp, err := NewFileExporter(path)
+	assert.Nil(t, err)
+	tracer := NewTracer("test", exp)
+
+	ctx, root := tracer.Start(context.Background(), "root", KindServer)
+	_, child := tracer.Start(ctx, "child", KindInternal)
+	child.SetAttr("bytes", 42)
+	child.End()
+	root.End()
+	assert.Nil(t, exp.Close())
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	spans, err := ReadSpans(f)
+	assert.Nil(t, err)
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "child", spans[0].Name)
+		assert.Equal(t, root.SpanContext().SpanID, spans[0].ParentSpanID)
+		assert.Equal(t, float64(42), spans[0].Attributes["bytes"])
+		assert.Equal(t, KindServer, spans[1].Kind)
+		assert.Equal(t, SpanID{}, spans[1].ParentSpanID)
+		assert.True(t, spans[1].Duration() >= spans[0].Duration())
+	}
+}